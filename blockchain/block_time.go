@@ -0,0 +1,23 @@
+package blockchain
+
+import (
+	"math"
+	"math/big"
+	"time"
+)
+
+// EstimateBlockTime returns estimated wall-clock time when the block with
+// the given number is mined. Estimation is based on the current block number,
+// current time and average time between blocks. It is used to display block
+// based deadlines only, deadlines are still checked using block numbers.
+func EstimateBlockTime(block *big.Int, currentBlock *big.Int, now time.Time, averageBlockTime time.Duration) time.Time {
+	blocks := new(big.Int).Sub(block, currentBlock)
+	delta := new(big.Int).Mul(blocks, big.NewInt(int64(averageBlockTime)))
+	if !delta.IsInt64() {
+		if delta.Sign() > 0 {
+			return now.Add(time.Duration(math.MaxInt64))
+		}
+		return now.Add(time.Duration(math.MinInt64))
+	}
+	return now.Add(time.Duration(delta.Int64()))
+}
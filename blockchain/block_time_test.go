@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateBlockTime(t *testing.T) {
+	now := time.Unix(1000000, 0)
+
+	estimated := EstimateBlockTime(big.NewInt(1240), big.NewInt(1000), now, 15*time.Second)
+
+	assert.WithinDuration(t, now.Add(time.Hour), estimated, time.Second)
+}
+
+func TestEstimateBlockTimeOfPastBlock(t *testing.T) {
+	now := time.Unix(1000000, 0)
+
+	estimated := EstimateBlockTime(big.NewInt(996), big.NewInt(1000), now, 15*time.Second)
+
+	assert.WithinDuration(t, now.Add(-time.Minute), estimated, time.Second)
+}
+
+func TestEstimateBlockTimeTooFar(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	block, _ := new(big.Int).SetString("100000000000000000000000", 10)
+
+	estimated := EstimateBlockTime(block, big.NewInt(1000), now, 15*time.Second)
+
+	assert.True(t, estimated.After(now.Add(100*365*24*time.Hour)))
+}
@@ -38,6 +38,7 @@ type Processor struct {
 	escrowContractAddress   common.Address
 	registryContractAddress common.Address
 	multiPartyEscrow        *MultiPartyEscrow
+	currentBlockCache       *CurrentBlockCache
 }
 
 // NewProcessor creates a new blockchain processor
@@ -79,7 +80,16 @@ func NewProcessor(metadata *ServiceMetadata) (Processor, error) {
 		return crypto.Keccak256(HashPrefix32Bytes, crypto.Keccak256(i))
 	}
 
-
+	// warm up current block cache if polling is enabled
+	if pollInterval := config.GetDuration(config.BlockNumberPollInterval); pollInterval > 0 {
+		p.currentBlockCache = NewCurrentBlockCache(p.currentBlockFromRPC, pollInterval,
+			config.GetDuration(config.BlockNumberMaxStaleness))
+		p.currentBlockCache.Start()
+	} else if ttl := config.GetDuration(config.BlockNumberCacheTTL); ttl > 0 {
+		// without poller cached value is refreshed by the first call after
+		// ttl is expired
+		p.currentBlockCache = NewCurrentBlockCache(p.currentBlockFromRPC, 0, ttl)
+	}
 
 	return p, nil
 }
@@ -96,11 +106,42 @@ func (processor *Processor) MultiPartyEscrow() *MultiPartyEscrow {
 	return processor.multiPartyEscrow
 }
 
+// CurrentBlock returns the current Ethereum block number. It is served from
+// the cache when block number polling is enabled.
 func (processor *Processor) CurrentBlock() (currentBlock *big.Int, err error) {
+	if processor.currentBlockCache != nil {
+		return processor.currentBlockCache.CurrentBlock()
+	}
+	return processor.currentBlockFromRPC()
+}
+
+// CurrentBlockContext returns the current Ethereum block number as
+// CurrentBlock does, live call is cancelled when ctx is done.
+func (processor *Processor) CurrentBlockContext(ctx context.Context) (currentBlock *big.Int, err error) {
+	cache := processor.currentBlockCache
+	if cache != nil {
+		if currentBlock, ok := cache.cached(); ok {
+			return currentBlock, nil
+		}
+	}
+	if currentBlock, err = processor.currentBlockFromRPCContext(ctx); err != nil {
+		return
+	}
+	if cache != nil {
+		cache.set(currentBlock)
+	}
+	return
+}
+
+func (processor *Processor) currentBlockFromRPC() (currentBlock *big.Int, err error) {
+	return processor.currentBlockFromRPCContext(context.Background())
+}
+
+func (processor *Processor) currentBlockFromRPCContext(ctx context.Context) (currentBlock *big.Int, err error) {
 	// We have to do a raw call because the standard method of ethClient.HeaderByNumber(ctx, nil) errors on
 	// unmarshaling the response currently. See https://github.com/ethereum/go-ethereum/issues/3230
 	var currentBlockHex string
-	if err = processor.rawClient.CallContext(context.Background(), &currentBlockHex, "eth_blockNumber"); err != nil {
+	if err = processor.rawClient.CallContext(ctx, &currentBlockHex, "eth_blockNumber"); err != nil {
 		log.WithError(err).Error("error determining current block")
 		return nil, fmt.Errorf("error determining current block: %v", err)
 	}
@@ -111,11 +152,24 @@ func (processor *Processor) CurrentBlock() (currentBlock *big.Int, err error) {
 	return
 }
 
+// HealthCheck checks that Ethereum node is reachable by requesting the
+// current block number
+func (processor *Processor) HealthCheck(ctx context.Context) error {
+	var currentBlockHex string
+	if err := processor.rawClient.CallContext(ctx, &currentBlockHex, "eth_blockNumber"); err != nil {
+		return fmt.Errorf("ethereum node is not available: %v", err)
+	}
+	return nil
+}
+
 func (processor *Processor) HasIdentity() bool {
 	return processor.address != ""
 }
 
 func (processor *Processor) Close() {
+	if processor.currentBlockCache != nil {
+		processor.currentBlockCache.Stop()
+	}
 	processor.ethClient.Close()
 	processor.rawClient.Close()
 }
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CurrentBlockCache keeps the latest known Ethereum block number in memory.
+// Cached value is refreshed by background poller each pollInterval. When the
+// cached value is older than maxStaleness (for instance poller cannot reach
+// the Ethereum node) the cache falls back to the live call.
+type CurrentBlockCache struct {
+	currentBlock func() (*big.Int, error)
+	pollInterval time.Duration
+	maxStaleness time.Duration
+
+	mutex   sync.RWMutex
+	block   *big.Int
+	updated time.Time
+
+	stop chan struct{}
+}
+
+// NewCurrentBlockCache returns new cache instance which wraps passed
+// currentBlock function. Poller is not started until Start() is called.
+// Cache which is not started works as a TTL cache, maxStaleness is the TTL.
+func NewCurrentBlockCache(currentBlock func() (*big.Int, error), pollInterval time.Duration, maxStaleness time.Duration) *CurrentBlockCache {
+	return &CurrentBlockCache{
+		currentBlock: currentBlock,
+		pollInterval: pollInterval,
+		maxStaleness: maxStaleness,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start refreshes the cached value and starts background poller
+func (cache *CurrentBlockCache) Start() {
+	if err := cache.Refresh(); err != nil {
+		log.WithError(err).Warn("Unable to warm up current block cache")
+	}
+	go cache.poll()
+}
+
+// Stop stops background poller
+func (cache *CurrentBlockCache) Stop() {
+	close(cache.stop)
+}
+
+func (cache *CurrentBlockCache) poll() {
+	ticker := time.NewTicker(cache.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := cache.Refresh(); err != nil {
+				log.WithError(err).Warn("Unable to refresh current block cache")
+			}
+		case <-cache.stop:
+			return
+		}
+	}
+}
+
+// Refresh reads current block using live call and updates cached value
+func (cache *CurrentBlockCache) Refresh() (err error) {
+	block, err := cache.currentBlock()
+	if err != nil {
+		return
+	}
+	cache.set(block)
+	return nil
+}
+
+func (cache *CurrentBlockCache) set(block *big.Int) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.block = new(big.Int).Set(block)
+	cache.updated = time.Now()
+}
+
+// cached returns copy of cached block number, ok is false if it is not set
+// or stale
+func (cache *CurrentBlockCache) cached() (currentBlock *big.Int, ok bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if cache.block == nil || time.Since(cache.updated) > cache.maxStaleness {
+		return nil, false
+	}
+	return new(big.Int).Set(cache.block), true
+}
+
+// CurrentBlock returns cached block number if it is fresh enough and makes
+// live call otherwise.
+func (cache *CurrentBlockCache) CurrentBlock() (currentBlock *big.Int, err error) {
+	if currentBlock, ok := cache.cached(); ok {
+		return currentBlock, nil
+	}
+
+	log.WithField("maxStaleness", cache.maxStaleness).Debug("Cached current block is stale, fall back to live call")
+	currentBlock, err = cache.currentBlock()
+	if err != nil {
+		return nil, err
+	}
+	cache.set(currentBlock)
+	return
+}
@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type currentBlockMock struct {
+	block *big.Int
+	err   error
+	calls int
+}
+
+func (mock *currentBlockMock) currentBlock() (*big.Int, error) {
+	mock.calls++
+	return mock.block, mock.err
+}
+
+func TestCurrentBlockCacheReturnsCachedValue(t *testing.T) {
+	mock := &currentBlockMock{block: big.NewInt(42)}
+	cache := NewCurrentBlockCache(mock.currentBlock, time.Hour, time.Hour)
+	assert.Nil(t, cache.Refresh())
+	mock.block = big.NewInt(43)
+
+	block, err := cache.CurrentBlock()
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(42), block)
+	assert.Equal(t, 1, mock.calls)
+}
+
+func TestCurrentBlockCacheFallsBackWhenStale(t *testing.T) {
+	mock := &currentBlockMock{block: big.NewInt(42)}
+	cache := NewCurrentBlockCache(mock.currentBlock, time.Hour, 10*time.Millisecond)
+	assert.Nil(t, cache.Refresh())
+	mock.block = big.NewInt(43)
+	time.Sleep(20 * time.Millisecond)
+
+	block, err := cache.CurrentBlock()
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(43), block)
+	assert.Equal(t, 2, mock.calls)
+}
+
+func TestCurrentBlockCacheFallbackError(t *testing.T) {
+	mock := &currentBlockMock{err: errors.New("node is not available")}
+	cache := NewCurrentBlockCache(mock.currentBlock, time.Hour, time.Hour)
+
+	block, err := cache.CurrentBlock()
+
+	assert.Equal(t, errors.New("node is not available"), err)
+	assert.Nil(t, block)
+}
+
+func TestCurrentBlockCachePollerRefreshesValue(t *testing.T) {
+	var calls int64
+	cache := NewCurrentBlockCache(func() (*big.Int, error) {
+		return big.NewInt(atomic.AddInt64(&calls, 1)), nil
+	}, 10*time.Millisecond, time.Hour)
+	cache.Start()
+	defer cache.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	block, err := cache.CurrentBlock()
+
+	assert.Nil(t, err)
+	assert.True(t, block.Cmp(big.NewInt(1)) > 0, "block number was not refreshed: %v", block)
+}
+
+func TestCurrentBlockCacheWithoutPollerRefreshesAfterTTL(t *testing.T) {
+	mock := &currentBlockMock{block: big.NewInt(42)}
+	cache := NewCurrentBlockCache(mock.currentBlock, 0, 10*time.Millisecond)
+
+	blockA, errA := cache.CurrentBlock()
+	mock.block = big.NewInt(43)
+	blockB, errB := cache.CurrentBlock()
+	time.Sleep(20 * time.Millisecond)
+	blockC, errC := cache.CurrentBlock()
+
+	assert.Nil(t, errA)
+	assert.Nil(t, errB)
+	assert.Nil(t, errC)
+	assert.Equal(t, big.NewInt(42), blockA)
+	assert.Equal(t, big.NewInt(42), blockB)
+	assert.Equal(t, big.NewInt(43), blockC)
+	assert.Equal(t, 2, mock.calls)
+}
+
+func TestCurrentBlockCacheWithoutPollerDoesNotCacheError(t *testing.T) {
+	mock := &currentBlockMock{err: errors.New("blockchain error")}
+	cache := NewCurrentBlockCache(mock.currentBlock, 0, time.Hour)
+
+	_, errA := cache.CurrentBlock()
+	mock.block, mock.err = big.NewInt(42), nil
+	block, errB := cache.CurrentBlock()
+
+	assert.Equal(t, errors.New("blockchain error"), errA)
+	assert.Nil(t, errB)
+	assert.Equal(t, big.NewInt(42), block)
+	assert.Equal(t, 2, mock.calls)
+}
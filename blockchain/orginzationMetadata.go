@@ -90,6 +90,18 @@ type PaymentChannelStorageClient struct {
 	ConnectionTimeout string `json:"connection_timeout" mapstructure:"connection_timeout"`
 	RequestTimeout    string `json:"request_timeout" mapstructure:"request_timeout"`
 	Endpoints         []string `json:"endpoints"`
+	// ReadConsistency is a consistency level of the reads: "linearizable"
+	// or "serializable", empty means linearizable
+	ReadConsistency   string `json:"read_consistency" mapstructure:"read_consistency"`
+	// MaxRetries is a number of retries of the write failed because of
+	// transient error, nil means default number of retries
+	MaxRetries        *int `json:"max_retries,omitempty" mapstructure:"max_retries"`
+	// RetryBackoffMs is a delay before the first retry in milliseconds, it
+	// is doubled before each next retry, zero means default delay
+	RetryBackoffMs    int `json:"retry_backoff_ms" mapstructure:"retry_backoff_ms"`
+	// EndpointCooldownMs is a time in milliseconds during which endpoint
+	// which failed a health probe is not used, zero means default cooldown
+	EndpointCooldownMs int `json:"endpoint_cooldown_ms" mapstructure:"endpoint_cooldown_ms"`
 }
 
 //Construct the Organization metadata from the JSON Passed
@@ -128,9 +140,13 @@ func setDerivedAttributes(metaData *OrganizationMetaData) (err error) {
 	if metaData.daemonGroup, err = getDaemonGroup(*metaData); err != nil {
 		return err
 	}
-	metaData.daemonGroupID, err = ConvertBase64Encoding(metaData.daemonGroup.GroupID)
+	if metaData.daemonGroupID, err = ConvertBase64Encoding(metaData.daemonGroup.GroupID); err != nil {
+		return err
+	}
 	//use the checksum address ( convert the address in to a checksum address and set it back)
-	metaData.daemonGroup.PaymentDetails.PaymentAddress = ToChecksumAddress(metaData.daemonGroup.PaymentDetails.PaymentAddress)
+	if metaData.daemonGroup.PaymentDetails.PaymentAddress, err = NormalizeAddress(metaData.daemonGroup.PaymentDetails.PaymentAddress); err != nil {
+		return fmt.Errorf("incorrect payment address of the group %v: %v", metaData.daemonGroup.GroupName, err)
+	}
 
 	metaData.recipientPaymentAddress = common.HexToAddress(metaData.daemonGroup.PaymentDetails.PaymentAddress)
 
@@ -214,6 +230,29 @@ func (metaData OrganizationMetaData) GetPaymentStorageEndPoints() []string {
 }
 
 
+//Get the read consistency level of the payment channel storage client
+func (metaData OrganizationMetaData) GetReadConsistency() string {
+	return metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.ReadConsistency
+}
+
+//Get the number of retries of the failed writes, ok is false if it is not set
+func (metaData OrganizationMetaData) GetMaxRetries() (maxRetries int, ok bool) {
+	if metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.MaxRetries == nil {
+		return 0, false
+	}
+	return *metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.MaxRetries, true
+}
+
+//Get the delay before the first retry of the failed write
+func (metaData OrganizationMetaData) GetRetryBackoff() time.Duration {
+	return time.Duration(metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.RetryBackoffMs) * time.Millisecond
+}
+
+//Get the time during which failed endpoint of the payment channel storage is not used
+func (metaData OrganizationMetaData) GetEndpointCooldown() time.Duration {
+	return time.Duration(metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.EndpointCooldownMs) * time.Millisecond
+}
+
 //Get the connection time out defined
 func (metaData OrganizationMetaData) GetConnectionTimeOut() ( connectionTimeOut time.Duration) {
 	 connectionTimeOut, err := time.ParseDuration(metaData.daemonGroup.PaymentDetails.PaymentChannelStorageClient.ConnectionTimeout);
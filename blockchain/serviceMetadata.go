@@ -152,8 +152,7 @@ func setDerivedFields(metaData *ServiceMetadata) (err error) {
 	if err= setDefaultPricing(metaData); err != nil {
 		return err
 	}
-	setMultiPartyEscrowAddress(metaData)
-	return nil
+	return setMultiPartyEscrowAddress(metaData)
 
 }
 
@@ -186,10 +185,12 @@ func setDefaultPricing(metaData *ServiceMetadata) (err error) {
 	return err
 }
 
-func setMultiPartyEscrowAddress(metaData *ServiceMetadata) {
-	metaData.MpeAddress = ToChecksumAddress(metaData.MpeAddress)
+func setMultiPartyEscrowAddress(metaData *ServiceMetadata) (err error) {
+	if metaData.MpeAddress, err = NormalizeAddress(metaData.MpeAddress); err != nil {
+		return fmt.Errorf("incorrect MPE address in service metadata: %v", err)
+	}
 	metaData.multiPartyEscrowAddress = common.HexToAddress(metaData.MpeAddress)
-
+	return nil
 }
 
 
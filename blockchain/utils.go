@@ -81,6 +81,24 @@ func FormatHash(ipfsHash string) string {
 }
 
 
+// NormalizeAddress validates hex address and returns it in EIP-55 checksum
+// form. Address in lower or upper case is accepted as is, address in mixed
+// case should have valid checksum.
+func NormalizeAddress(hexAddress string) (checksumAddress string, err error) {
+	if !common.IsHexAddress(hexAddress) {
+		return "", fmt.Errorf("incorrect Ethereum address: \"%v\"", hexAddress)
+	}
+	checksumAddress = common.HexToAddress(hexAddress).Hex()
+	digits := hexAddress
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits = digits[2:]
+	}
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && digits != checksumAddress[2:] {
+		return "", fmt.Errorf("incorrect checksum of Ethereum address: \"%v\", expected: \"%v\"", hexAddress, checksumAddress)
+	}
+	return checksumAddress, nil
+}
+
 func ToChecksumAddress(hexAddress string) string {
 	address := common.HexToAddress(hexAddress)
 	mixedAddress := common.NewMixedcaseAddress(address)
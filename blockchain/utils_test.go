@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -36,3 +37,28 @@ func TestToChecksumAddress(t *testing.T) {
 }
 
 
+
+func TestNormalizeAddress(t *testing.T) {
+	lowercase, errLowercase := NormalizeAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+	uppercase, errUppercase := NormalizeAddress("0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED")
+	mixedcase, errMixedcase := NormalizeAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	assert.Nil(t, errLowercase)
+	assert.Nil(t, errUppercase)
+	assert.Nil(t, errMixedcase)
+	assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", lowercase)
+	assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", uppercase)
+	assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", mixedcase)
+}
+
+func TestNormalizeAddressIncorrectChecksum(t *testing.T) {
+	_, err := NormalizeAddress("0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	assert.Equal(t, errors.New("incorrect checksum of Ethereum address: \"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed\", expected: \"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed\""), err)
+}
+
+func TestNormalizeAddressMalformed(t *testing.T) {
+	_, err := NormalizeAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA")
+
+	assert.Equal(t, errors.New("incorrect Ethereum address: \"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA\""), err)
+}
@@ -0,0 +1,112 @@
+// Package cache contains in-memory caches which are bounded by the number of
+// entries and report their efficiency via Prometheus metrics.
+package cache
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/singnet/snet-daemon/metrics"
+)
+
+// LRU is a cache of the fixed size which evicts the least recently used
+// entry when it is full. It is safe for concurrent use.
+type LRU struct {
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries map[interface{}]*list.Element
+	// order keeps entries from the most to the least recently used
+	order *list.List
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+}
+
+type lruEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// NewLRU returns cache which keeps up to maxEntries entries, name is used as
+// a label of the cache metrics. maxEntries should be positive.
+func NewLRU(name string, maxEntries int) *LRU {
+	if maxEntries <= 0 {
+		maxEntries = 1
+	}
+	return &LRU{
+		maxEntries: maxEntries,
+		entries:    make(map[interface{}]*list.Element),
+		order:      list.New(),
+		hits:       metrics.CacheHits.WithLabelValues(name),
+		misses:     metrics.CacheMisses.WithLabelValues(name),
+		evictions:  metrics.CacheEvictions.WithLabelValues(name),
+	}
+}
+
+// Get returns value by key and marks the entry as recently used
+func (cache *LRU) Get(key interface{}) (value interface{}, ok bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.misses.Inc()
+		return nil, false
+	}
+	cache.hits.Inc()
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// Add puts value into cache, the least recently used entry is evicted if
+// cache is full
+func (cache *LRU) Add(key interface{}, value interface{}) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	for cache.order.Len() >= cache.maxEntries {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+		cache.evictions.Inc()
+	}
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key: key, value: value})
+}
+
+// Remove removes the entry by key, removal is not counted as eviction
+func (cache *LRU) Remove(key interface{}) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+// Purge removes all entries
+func (cache *LRU) Purge() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[interface{}]*list.Element)
+	cache.order.Init()
+}
+
+// Len returns number of entries in the cache
+func (cache *LRU) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.order.Len()
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/metrics"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRU("test_evicts", 2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Get("a")
+
+	cache.Add("c", 3)
+
+	_, okA := cache.Get("a")
+	_, okB := cache.Get("b")
+	_, okC := cache.Get("c")
+	assert.True(t, okA)
+	assert.False(t, okB)
+	assert.True(t, okC)
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.CacheEvictions.WithLabelValues("test_evicts")))
+}
+
+func TestLRUHitsAndMisses(t *testing.T) {
+	cache := NewLRU("test_hits", 2)
+	cache.Add("a", 1)
+
+	value, ok := cache.Get("a")
+	cache.Get("b")
+
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.CacheHits.WithLabelValues("test_hits")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.CacheMisses.WithLabelValues("test_hits")))
+}
+
+func TestLRUAddExistingKeyDoesNotEvict(t *testing.T) {
+	cache := NewLRU("test_update", 2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+
+	cache.Add("a", 3)
+
+	value, _ := cache.Get("a")
+	assert.Equal(t, 3, value)
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.CacheEvictions.WithLabelValues("test_update")))
+}
+
+func TestLRURemoveAndPurge(t *testing.T) {
+	cache := NewLRU("test_remove", 3)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Add("c", 3)
+
+	cache.Remove("a")
+	_, okA := cache.Get("a")
+	assert.False(t, okA)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.CacheEvictions.WithLabelValues("test_remove")))
+}
@@ -23,6 +23,9 @@ const (
 	AutoSSLCacheDirKey   = "auto_ssl_cache_dir"
 	BlockchainEnabledKey = "blockchain_enabled"
 	BlockChainNetworkSelected      = "blockchain_network_selected"
+	BlockNumberPollInterval        = "block_number_poll_interval"
+	BlockNumberMaxStaleness        = "block_number_max_staleness"
+	BlockNumberCacheTTL            = "block_number_cache_ttl"
 	BurstSize            = "burst_size"
 	ConfigPathKey        = "config_path"
 
@@ -31,6 +34,9 @@ const (
 	DaemonEndPoint                 = "daemon_end_point"
 	ExecutablePathKey              = "executable_path"
 	FreeCallSignerAddress          = "free_call_signer_address"
+	FreeCallAllowedBlockSkew       = "free_call_allowed_block_skew"
+	FreeCallQuota                  = "free_call_quota"
+	FreeCallQuotaWindow            = "free_call_quota_window"
 	IpfsEndPoint                   = "ipfs_end_point"
 	IpfsTimeout                    = "ipfs_timeout"
 	LogKey                         = "log"
@@ -42,6 +48,8 @@ const (
 	PassthroughEnabledKey          = "passthrough_enabled"
 	PassthroughEndpointKey         = "passthrough_endpoint"
 	RateLimitPerMinute             = "rate_limit_per_minute"
+	RetryBudgetMaxAttempts         = "retry_budget_max_attempts"
+	RetryBudgetMaxDuration         = "retry_budget_max_duration"
 	SSLCertPathKey                 = "ssl_cert"
 	SSLKeyPathKey                  = "ssl_key"
     PaymentChannelCertPath         = "payent_channel_cert_path"
@@ -50,6 +58,86 @@ const (
 	PaymentChannelStorageTypeKey   = "payment_channel_storage_type"
 	PaymentChannelStorageClientKey = "payment_channel_storage_client"
 	PaymentChannelStorageServerKey = "payment_channel_storage_server"
+	PaymentChannelMpeCheckEnabled  = "payment_channel_mpe_check_enabled"
+	PaymentChannelMaxRemainingLifetime = "payment_channel_max_remaining_lifetime"
+	PaymentChannelGraceAmount      = "payment_channel_grace_amount"
+	PaymentMinIncrement            = "payment_min_increment"
+	PaymentChannelRateLimitPerMinute = "payment_channel_rate_limit_per_minute"
+	PaymentChannelRateLimitStorage = "payment_channel_rate_limit_storage"
+	PaymentSenderSpendingLimitPerMinute = "payment_sender_spending_limit_per_minute"
+	PaymentSenderRateLimitPerMinute = "payment_sender_rate_limit_per_minute"
+	PaymentSenderRateLimitBurst    = "payment_sender_rate_limit_burst"
+	PaymentSenderRateLimitIdleTimeout = "payment_sender_rate_limit_idle_timeout"
+	MethodRateLimits               = "method_rate_limits"
+	MethodPaymentTypes             = "method_payment_types"
+	PaymentSanctionsListFile       = "payment_sanctions_list_file"
+	PaymentChannelClaimSignatureCheckEnabled = "payment_channel_claim_signature_check_enabled"
+	PaymentChannelExpiryWarningBlocks = "payment_channel_expiry_warning_blocks"
+	DrainingSlotEnabled            = "draining_slot_enabled"
+	AdminClientCaPath              = "admin_client_ca_path"
+	AdminClientCertSubjects        = "admin_client_cert_subjects"
+	AdminEndPoint                  = "admin_end_point"
+	PaymentHoldEnabled             = "payment_hold_enabled"
+	PaymentHoldMaxTTL              = "payment_hold_max_ttl"
+	PaymentChannelMaxConcurrentStreams = "payment_channel_max_concurrent_streams"
+	AverageBlockTime               = "average_block_time"
+	ValidationDecisionsExportKey   = "validation_decisions_export"
+	PaymentInvalidSignatureThreshold = "payment_invalid_signature_threshold"
+	PaymentInvalidSignatureWindow  = "payment_invalid_signature_window"
+	PaymentInvalidSignatureCooldown = "payment_invalid_signature_cooldown"
+	PaymentReplayCacheMaxEntries   = "payment_replay_cache_max_entries"
+	PaymentReplayCacheTTL          = "payment_replay_cache_ttl"
+	PaymentMeteringHook            = "payment_metering_hook"
+	PaymentMeteringPricePerUnit    = "payment_metering_price_per_unit"
+	PaymentSignatureScheme         = "payment_signature_scheme"
+	PaymentChannelCloseEnabled     = "payment_channel_close_enabled"
+	PaymentDiscountTiers           = "payment_discount_tiers"
+	PaymentExpirationThresholds    = "payment_expiration_thresholds"
+	PaymentBlacklistWebhookSecret  = "payment_blacklist_webhook_secret"
+	DrainingSlotTimeout            = "draining_slot_timeout"
+	DrainingSlotLeaseTTL           = "draining_slot_lease_ttl"
+	PaymentDrainTimeout            = "payment_drain_timeout"
+	ReadOnlyModeEnabled            = "read_only_mode_enabled"
+	PaymentSanctionsListRefreshInterval = "payment_sanctions_list_refresh_interval"
+	PaymentMetadataPresenceCheckEnabled = "payment_metadata_presence_check_enabled"
+	PaymentMetadataMaxValueSize    = "payment_metadata_max_value_size"
+	PaymentMetadataMaxValueCount   = "payment_metadata_max_value_count"
+	PaymentSignatureFormatCheckEnabled = "payment_signature_format_check_enabled"
+	PaymentMessageV2Enabled        = "payment_message_v2_enabled"
+	PaymentDaemonId                = "payment_daemon_id"
+	PaymentAuditLogFile            = "payment_audit_log_file"
+	PaymentAuditLogKey             = "payment_audit_log_key"
+	PaymentRequestContentCheckEnabled = "payment_request_content_check_enabled"
+	PaymentChannelCacheEnabled     = "payment_channel_cache_enabled"
+	PaymentChannelCacheMaxEntries  = "payment_channel_cache_max_entries"
+	PaymentChannelCompressionThreshold = "payment_channel_compression_threshold"
+	PriceSanityRanges              = "price_sanity_ranges"
+	ClientVersionRules             = "client_version_rules"
+	ClientVersionCheckMode         = "client_version_check_mode"
+	MetricsAmountUnit              = "metrics_amount_unit"
+	MetricsTokenDecimals           = "metrics_token_decimals"
+	TokenSymbol                    = "token_symbol"
+	PaymentChannelOperationLogEnabled   = "payment_channel_operation_log_enabled"
+	PaymentChannelOperationLogMaxLength = "payment_channel_operation_log_max_length"
+	PaymentChannelOperationLogTTL       = "payment_channel_operation_log_ttl"
+	PaymentChannelOperationLogQueueSize = "payment_channel_operation_log_queue_size"
+	EtcdVersionCheckMode           = "etcd_version_check_mode"
+	EtcdMinVersion                 = "etcd_min_version"
+	EtcdMaxVersion                 = "etcd_max_version"
+	EtcdConnectionAttempts         = "etcd_connection_attempts"
+	EtcdConnectionRetryDelay       = "etcd_connection_retry_delay"
+	FeatureFlagsFile               = "feature_flags_file"
+	FeatureFlagsRefreshInterval    = "feature_flags_refresh_interval"
+	PaymentSignatureProtocolVersion = "payment_signature_protocol_version"
+	PaymentSignatureEncodings      = "payment_signature_encodings"
+	PaymentClaimIdempotencyEnabled = "payment_claim_idempotency_enabled"
+	PaymentClaimIdempotencyPendingTimeout = "payment_claim_idempotency_pending_timeout"
+	PaymentClaimIdempotencyRetention = "payment_claim_idempotency_retention"
+	Tenants                        = "tenants"
+	TenantQuotaStaleTimeout        = "tenant_quota_stale_timeout"
+	PaymentAuthorizedAmountRebuildEnabled = "payment_authorized_amount_rebuild_enabled"
+	PrometheusMetricsEnabled       = "prometheus_metrics_enabled"
+	PaymentValidationMetricsNamespace = "payment_validation_metrics_namespace"
 	//configs for Daemon Monitoring and Notification
 	AlertsEMail                 = "alerts_email"
 	HeartbeatServiceEndpoint    = "heartbeat_svc_end_point"
@@ -65,9 +153,15 @@ const (
 	"auto_ssl_cache_dir": ".certs",
 	"blockchain_enabled": true,
 	"blockchain_network_selected": "local",
+	"block_number_poll_interval": "5s",
+	"block_number_max_staleness": "30s",
+	"block_number_cache_ttl": "5s",
 	"daemon_end_point": "127.0.0.1:8080",
 	"daemon_group_name":"default_group",
 	"daemon_type": "grpc",
+	"free_call_allowed_block_skew": 5,
+	"free_call_quota": 0,
+	"free_call_quota_window": "24h",
 	"hdwallet_index": 0,
 	"hdwallet_mnemonic": "",
 	"ipfs_end_point": "http://localhost:5002/", 
@@ -79,6 +173,8 @@ const (
 	"passthrough_enabled": false,
 	"service_id": "ExampleServiceId", 
 	"private_key": "",
+	"retry_budget_max_attempts": 5,
+	"retry_budget_max_duration": "10s",
 	"ssl_cert": "",
 	"ssl_key": "",
 	"log":  {
@@ -118,6 +214,95 @@ const (
 		"log_level": "info",
 		"enabled": false
 	},
+	"payment_channel_mpe_check_enabled": true,
+	"payment_channel_max_remaining_lifetime": 0,
+	"payment_channel_grace_amount": 0,
+	"payment_min_increment": 0,
+	"payment_channel_rate_limit_per_minute": 0,
+	"payment_channel_rate_limit_storage": "local",
+	"payment_sender_spending_limit_per_minute": 0,
+	"payment_sender_rate_limit_per_minute": 0,
+	"payment_sender_rate_limit_burst": 10,
+	"payment_sender_rate_limit_idle_timeout": "10m",
+	"method_rate_limits": [],
+	"method_payment_types": [],
+	"payment_sanctions_list_file": "",
+	"payment_channel_claim_signature_check_enabled": true,
+	"payment_channel_expiry_warning_blocks": 0,
+	"draining_slot_enabled": false,
+	"admin_client_ca_path": "",
+	"admin_client_cert_subjects": [],
+	"admin_end_point": "",
+	"payment_hold_enabled": false,
+	"payment_hold_max_ttl": "10m",
+	"payment_channel_max_concurrent_streams": 0,
+	"average_block_time": "15s",
+	"validation_decisions_export": {
+		"writer": {
+			"type": "none"
+		},
+		"batch_size": 100,
+		"flush_interval": "1s",
+		"queue_size": 10000,
+		"max_retries": 3,
+		"retry_delay": "1s"
+	},
+	"payment_invalid_signature_threshold": 0,
+	"payment_invalid_signature_window": "1m",
+	"payment_invalid_signature_cooldown": "5m",
+	"payment_replay_cache_max_entries": 0,
+	"payment_replay_cache_ttl": "10m",
+	"payment_metering_hook": "flat",
+	"payment_metering_price_per_unit": 0,
+	"payment_signature_scheme": "secp256k1",
+	"payment_channel_close_enabled": false,
+	"payment_discount_tiers": [],
+	"payment_expiration_thresholds": [],
+	"payment_blacklist_webhook_secret": "",
+	"draining_slot_timeout": "5m",
+	"draining_slot_lease_ttl": "2m",
+	"payment_drain_timeout": "30s",
+	"read_only_mode_enabled": false,
+	"payment_sanctions_list_refresh_interval": "1m",
+	"payment_metadata_presence_check_enabled": true,
+	"payment_metadata_max_value_size": 1024,
+	"payment_metadata_max_value_count": 1,
+	"payment_signature_format_check_enabled": true,
+	"payment_message_v2_enabled": false,
+	"payment_daemon_id": "",
+	"payment_audit_log_file": "",
+	"payment_audit_log_key": "",
+	"payment_request_content_check_enabled": false,
+	"payment_channel_cache_enabled": false,
+	"payment_channel_cache_max_entries": 10000,
+	"payment_channel_compression_threshold": 0,
+	"price_sanity_ranges": [],
+	"client_version_rules": [],
+	"client_version_check_mode": "warn",
+	"metrics_amount_unit": "cogs",
+	"metrics_token_decimals": 8,
+	"token_symbol": "AGIX",
+	"payment_channel_operation_log_enabled": false,
+	"payment_channel_operation_log_max_length": 1000,
+	"payment_channel_operation_log_ttl": "720h",
+	"payment_channel_operation_log_queue_size": 1000,
+	"etcd_version_check_mode": "warn",
+	"etcd_min_version": "3.3.0",
+	"etcd_max_version": "",
+	"etcd_connection_attempts": 1,
+	"etcd_connection_retry_delay": "1s",
+	"feature_flags_file": "",
+	"feature_flags_refresh_interval": "1m",
+	"payment_signature_protocol_version": "v1",
+	"payment_signature_encodings": [],
+	"payment_claim_idempotency_enabled": false,
+	"payment_claim_idempotency_pending_timeout": "1m",
+	"payment_claim_idempotency_retention": "24h",
+	"tenants": [],
+	"tenant_quota_stale_timeout": "1m",
+	"payment_authorized_amount_rebuild_enabled": false,
+	"prometheus_metrics_enabled": false,
+	"payment_validation_metrics_namespace": "snetd",
 	"alerts_email": "", 
 	"service_heartbeat_type": "http",
     "metering_end_point":"http://demo8325345.mockable.io"
@@ -193,6 +378,9 @@ func Validate() error {
 	if err != nil {
 		return err
 	}
+	if err = ValidateAdminEndpoint(daemonEndpoint, vip.GetString(AdminEndPoint)); err != nil {
+		return err
+	}
 
 	//Check if the Daemon is on the latest version or not
 	if message,err := CheckVersionOfDaemon(); err != nil {
@@ -313,4 +501,31 @@ func ValidateEndpoints(daemonEndpoint string, passthroughEndpoint string) error
 		return errors.New("passthrough endpoint can't be the same as daemon endpoint!")
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ValidateAdminEndpoint checks that admin services are not bound to the same
+// address as the public ones. Empty adminEndpoint means admin services are
+// served on the daemon endpoint.
+func ValidateAdminEndpoint(daemonEndpoint string, adminEndpoint string) error {
+	if adminEndpoint == "" {
+		return nil
+	}
+	daemonHost, daemonPort, err := net.SplitHostPort(daemonEndpoint)
+	if err != nil {
+		return errors.New("couldn't split host:post of daemon endpoint")
+	}
+	adminHost, adminPort, err := net.SplitHostPort(adminEndpoint)
+	if err != nil {
+		return errors.New("expected format of admin_end_point is <host>:<port>")
+	}
+
+	if daemonPort == adminPort &&
+		(daemonHost == adminHost || isWildcardHost(daemonHost) || isWildcardHost(adminHost)) {
+		return errors.New("admin endpoint can't be the same as daemon endpoint")
+	}
+	return nil
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
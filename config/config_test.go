@@ -114,4 +114,14 @@ func TestValidateEndpoints(t *testing.T) {
 	assert.Equal(t, nil, err)
 	err = ValidateEndpoints("1.2.3.4:8080", "http://127.0.0.1:8080")
 	assert.Equal(t, nil, err)
-}
\ No newline at end of file
+}
+
+func TestValidateAdminEndpoint(t *testing.T) {
+	assert.Nil(t, ValidateAdminEndpoint("0.0.0.0:8080", ""))
+	assert.Nil(t, ValidateAdminEndpoint("0.0.0.0:8080", "127.0.0.1:8081"))
+	assert.Nil(t, ValidateAdminEndpoint("1.2.3.4:8080", "127.0.0.1:8080"))
+	assert.NotNil(t, ValidateAdminEndpoint("127.0.0.1:8080", "127.0.0.1:8080"))
+	assert.NotNil(t, ValidateAdminEndpoint("0.0.0.0:8080", "127.0.0.1:8080"))
+	assert.NotNil(t, ValidateAdminEndpoint("127.0.0.1:8080", ":8080"))
+	assert.NotNil(t, ValidateAdminEndpoint("127.0.0.1:8080", "8081"))
+}
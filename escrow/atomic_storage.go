@@ -1,7 +1,9 @@
 package escrow
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 )
 
 // AtomicStorage is an interface to key-value storage with atomic operations.
@@ -26,6 +28,45 @@ type AtomicStorage interface {
 	Delete(key string) (err error)
 }
 
+// RangeAtomicStorage is implemented by atomic storages which can read
+// values by key prefix page by page.
+type RangeAtomicStorage interface {
+	// GetByKeyRange returns up to limit keys and values which keys have
+	// given prefix and are greater than startAfter, ordered by key. Empty
+	// startAfter means reading from the beginning of the prefix.
+	GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error)
+}
+
+// LatestAtomicStorage is implemented by atomic storages which reads can
+// return stale values depending on configured read consistency.
+type LatestAtomicStorage interface {
+	// GetLatest returns the latest value by key whatever read consistency
+	// is configured.
+	GetLatest(key string) (value string, ok bool, err error)
+}
+
+// latestAtomicStorage is decorator for atomic storage which reads values
+// using GetLatest, it is used to read the state which is going to be
+// updated.
+type latestAtomicStorage struct {
+	AtomicStorage
+	latest LatestAtomicStorage
+}
+
+// Get is implementation of AtomicStorage.Get
+func (storage *latestAtomicStorage) Get(key string) (value string, ok bool, err error) {
+	return storage.latest.GetLatest(key)
+}
+
+// GetByKeyRange is implementation of RangeAtomicStorage.GetByKeyRange
+func (storage *latestAtomicStorage) GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error) {
+	delegate, ok := storage.AtomicStorage.(RangeAtomicStorage)
+	if !ok {
+		return nil, nil, fmt.Errorf("storage doesn't support reading by key range")
+	}
+	return delegate.GetByKeyRange(prefix, startAfter, limit)
+}
+
 // PrefixedAtomicStorage is decorator for atomic storage which adds a prefix to
 // the storage keys.
 type PrefixedAtomicStorage struct {
@@ -42,6 +83,24 @@ func (storage *PrefixedAtomicStorage) GetByKeyPrefix(prefix string) (values []st
 	return storage.delegate.GetByKeyPrefix(storage.keyPrefix + "/" + prefix)
 }
 
+// GetByKeyRange is implementation of RangeAtomicStorage.GetByKeyRange, it
+// returns error if delegate doesn't support range reads. Returned keys don't
+// contain storage prefix.
+func (storage *PrefixedAtomicStorage) GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error) {
+	delegate, ok := storage.delegate.(RangeAtomicStorage)
+	if !ok {
+		return nil, nil, fmt.Errorf("storage doesn't support reading by key range")
+	}
+	if startAfter != "" {
+		startAfter = storage.keyPrefix + "/" + startAfter
+	}
+	keys, values, err = delegate.GetByKeyRange(storage.keyPrefix+"/"+prefix, startAfter, limit)
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], storage.keyPrefix+"/")
+	}
+	return
+}
+
 // Put is implementation of AtomicStorage.Put
 func (storage *PrefixedAtomicStorage) Put(key string, value string) (err error) {
 	return storage.delegate.Put(storage.keyPrefix+"/"+key, value)
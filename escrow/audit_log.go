@@ -0,0 +1,257 @@
+package escrow
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/config"
+)
+
+// AuditRecord describes the decision made on the payment
+type AuditRecord struct {
+	Timestamp time.Time
+	ChannelID *big.Int
+	Nonce     *big.Int
+	Amount    *big.Int
+	// Signer is an address recovered from the payment signature, it is
+	// recorded even if it is not a signer of the channel, nil if signature
+	// cannot be recovered
+	Signer *common.Address
+	// Err is a reason of the payment rejection, nil if payment is accepted
+	Err error
+}
+
+// Decision returns "accepted" or "rejected"
+func (record *AuditRecord) Decision() string {
+	if record.Err == nil {
+		return "accepted"
+	}
+	return "rejected"
+}
+
+// AuditLogger records decisions made on payments. It can be implemented by
+// a file, syslog or external sink.
+type AuditLogger interface {
+	// Record writes record to the audit log
+	Record(record *AuditRecord) error
+}
+
+// NoopAuditLogger doesn't record anything
+type NoopAuditLogger struct{}
+
+// Record implements AuditLogger
+func (NoopAuditLogger) Record(record *AuditRecord) error {
+	return nil
+}
+
+// auditLogEntry is a line of the file audit log. Hash of each entry is an
+// HMAC calculated over the previous entry hash and the entry itself, so
+// removing or changing an entry breaks the chain, and the chain cannot be
+// rebuilt without the key.
+type auditLogEntry struct {
+	Timestamp    string `json:"timestamp"`
+	ChannelID    string `json:"channel_id"`
+	Nonce        string `json:"nonce"`
+	Amount       string `json:"amount"`
+	Signer       string `json:"signer,omitempty"`
+	Decision     string `json:"decision"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	PreviousHash string `json:"previous_hash"`
+	Hash         string `json:"hash,omitempty"`
+}
+
+// hash returns HMAC of the entry without its Hash field
+func (entry auditLogEntry) hash(key []byte) (string, error) {
+	entry.Hash = ""
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(entry.PreviousHash))
+	mac.Write(bytes)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func newAuditLogEntry(record *AuditRecord, previousHash string) auditLogEntry {
+	entry := auditLogEntry{
+		Timestamp:    record.Timestamp.UTC().Format(time.RFC3339Nano),
+		ChannelID:    fmt.Sprint(record.ChannelID),
+		Nonce:        fmt.Sprint(record.Nonce),
+		Amount:       fmt.Sprint(record.Amount),
+		Decision:     record.Decision(),
+		PreviousHash: previousHash,
+	}
+	if record.Signer != nil {
+		entry.Signer = blockchain.AddressToHex(record.Signer)
+	}
+	if record.Err != nil {
+		entry.Error = record.Err.Error()
+		entry.ErrorCode = Internal.String()
+		if paymentErr, ok := record.Err.(*PaymentError); ok {
+			entry.ErrorCode = paymentErr.Code.String()
+			entry.Error = paymentErr.Message
+		}
+	}
+	return entry
+}
+
+// writerAuditLogger writes hash chained JSON lines to the writer
+type writerAuditLogger struct {
+	mutex        sync.Mutex
+	writer       io.Writer
+	key          []byte
+	previousHash string
+}
+
+// NewWriterAuditLogger returns audit logger which writes JSON line per
+// record to the writer, entries are chained using HMAC with the key.
+// previousHash is a hash of the last entry already written, empty for a new
+// log.
+func NewWriterAuditLogger(writer io.Writer, key []byte, previousHash string) AuditLogger {
+	return &writerAuditLogger{writer: writer, key: key, previousHash: previousHash}
+}
+
+// NewFileAuditLogger returns audit logger which appends records to the
+// file, hash chain is continued from the last valid entry of the file. If
+// the file ends with entries which break the chain, for instance the line
+// partially written before crash, they are moved to the separate file with
+// ".corrupted-<unix time>" suffix and the log is truncated.
+func NewFileAuditLogger(path string, key []byte) (AuditLogger, error) {
+	previousHash, err := repairAuditLog(path, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterAuditLogger(file, key, previousHash), nil
+}
+
+func (logger *writerAuditLogger) Record(record *AuditRecord) error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	entry := newAuditLogEntry(record, logger.previousHash)
+	hash, err := entry.hash(logger.key)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = logger.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	logger.previousHash = hash
+	return nil
+}
+
+// repairAuditLog returns hash of the last valid entry of the file audit
+// log, empty if the file doesn't exist. Entries after the first invalid one
+// are moved to the separate file.
+func repairAuditLog(path string, key []byte) (lastHash string, err error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+
+	lastHash, validLength, verifyErr := verifyAuditLog(bytes.NewReader(content), key)
+	if verifyErr == nil {
+		return lastHash, nil
+	}
+
+	corruptedPath := fmt.Sprintf("%v.corrupted-%v", path, time.Now().Unix())
+	log.WithError(verifyErr).WithField("path", path).WithField("corruptedPath", corruptedPath).Error("Payment audit log is corrupted, invalid entries are moved out of the log")
+	if err = ioutil.WriteFile(corruptedPath, content[validLength:], 0600); err != nil {
+		return
+	}
+	if err = os.Truncate(path, validLength); err != nil {
+		return
+	}
+	return lastHash, nil
+}
+
+// VerifyAuditLog checks hash chain of the audit log using the key and
+// returns hash of the last entry. Error is returned if any entry was changed
+// or removed.
+func VerifyAuditLog(reader io.Reader, key []byte) (lastHash string, err error) {
+	lastHash, _, err = verifyAuditLog(reader, key)
+	if err != nil {
+		return "", err
+	}
+	return
+}
+
+// verifyAuditLog returns hash and the end offset of the last valid entry
+// together with the error found after it
+func verifyAuditLog(reader io.Reader, key []byte) (lastHash string, validLength int64, err error) {
+	buffered := bufio.NewReader(reader)
+	for line := 1; ; line++ {
+		raw, e := buffered.ReadBytes('\n')
+		if e == io.EOF && len(raw) == 0 {
+			return lastHash, validLength, nil
+		}
+		if e != nil && e != io.EOF {
+			return lastHash, validLength, e
+		}
+		if e == io.EOF {
+			return lastHash, validLength, fmt.Errorf("audit log line %v: line is not finished", line)
+		}
+
+		var entry auditLogEntry
+		if err = json.Unmarshal(raw, &entry); err != nil {
+			return lastHash, validLength, fmt.Errorf("audit log line %v: %v", line, err)
+		}
+		if entry.PreviousHash != lastHash {
+			return lastHash, validLength, fmt.Errorf("audit log line %v: previous hash doesn't match", line)
+		}
+		hash, e := entry.hash(key)
+		if e != nil {
+			return lastHash, validLength, e
+		}
+		if hash != entry.Hash {
+			return lastHash, validLength, fmt.Errorf("audit log line %v: hash doesn't match", line)
+		}
+		lastHash = hash
+		validLength += int64(len(raw))
+	}
+}
+
+// newAuditLoggerFromConfig expects that audit log key is validated on
+// startup and that audit log file can be opened
+func newAuditLoggerFromConfig(cfg *viper.Viper) AuditLogger {
+	path := cfg.GetString(config.PaymentAuditLogFile)
+	if path == "" {
+		return NoopAuditLogger{}
+	}
+	logger, err := NewFileAuditLogger(path, []byte(cfg.GetString(config.PaymentAuditLogKey)))
+	if err != nil {
+		log.WithError(err).WithField("path", path).Panic("Unable to open payment audit log")
+	}
+	return logger
+}
@@ -0,0 +1,190 @@
+package escrow
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditLoggerMock struct {
+	records []*AuditRecord
+}
+
+func (logger *auditLoggerMock) Record(record *AuditRecord) error {
+	logger.records = append(logger.records, record)
+	return nil
+}
+
+func (suite *ValidationTestSuite) auditedValidator(logger AuditLogger) ChannelPaymentValidator {
+	validator := suite.validator
+	validator.auditLogger = logger
+	validator.now = func() time.Time { return time.Unix(1000, 0) }
+	return validator
+}
+
+func (suite *ValidationTestSuite) TestAuditAcceptedPaymentAfterCommit() {
+	logger := &auditLoggerMock{}
+	validator := suite.auditedValidator(logger)
+
+	err := validator.Validate(suite.payment(), suite.channel())
+	recordsBeforeCommit := len(logger.records)
+	validator.paymentCommitted(suite.payment())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 0, recordsBeforeCommit)
+	assert.Equal(suite.T(), []*AuditRecord{{
+		Timestamp: time.Unix(1000, 0),
+		ChannelID: big.NewInt(42),
+		Nonce:     big.NewInt(3),
+		Amount:    big.NewInt(12345),
+		Signer:    &suite.signerAddress,
+	}}, logger.records)
+}
+
+func (suite *ValidationTestSuite) TestAuditRejectedPaymentOfAnotherSigner() {
+	logger := &auditLoggerMock{}
+	validator := suite.auditedValidator(logger)
+	anotherSigner := GenerateTestPrivateKey()
+	payment := suite.payment()
+	SignTestPayment(payment, anotherSigner)
+
+	err := validator.Validate(payment, suite.channel())
+
+	expectedErr := NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender")
+	assert.Equal(suite.T(), expectedErr, err)
+	assert.Equal(suite.T(), 1, len(logger.records))
+	assert.Equal(suite.T(), crypto.PubkeyToAddress(anotherSigner.PublicKey), *logger.records[0].Signer)
+	assert.Equal(suite.T(), expectedErr, logger.records[0].Err)
+	assert.Equal(suite.T(), "rejected", logger.records[0].Decision())
+}
+
+var testAuditLogKey = []byte("audit log key")
+
+func testAuditRecord(signer *ecdsa.PrivateKey, err error) *AuditRecord {
+	address := crypto.PubkeyToAddress(signer.PublicKey)
+	return &AuditRecord{
+		Timestamp: time.Unix(1000, 0),
+		ChannelID: big.NewInt(42),
+		Nonce:     big.NewInt(3),
+		Amount:    big.NewInt(12345),
+		Signer:    &address,
+		Err:       err,
+	}
+}
+
+func TestWriterAuditLogger(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewWriterAuditLogger(buffer, testAuditLogKey, "")
+	signer := GenerateTestPrivateKey()
+
+	assert.Nil(t, logger.Record(testAuditRecord(signer, nil)))
+	assert.Nil(t, logger.Record(testAuditRecord(signer, NewPaymentError(IncorrectNonce, "incorrect payment channel nonce"))))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `"decision":"accepted"`)
+	assert.Contains(t, lines[0], `"signer":"`+crypto.PubkeyToAddress(signer.PublicKey).Hex()+`"`)
+	assert.Contains(t, lines[1], `"decision":"rejected","error_code":"IncorrectNonce","error":"incorrect payment channel nonce"`)
+	_, err := VerifyAuditLog(strings.NewReader(buffer.String()), testAuditLogKey)
+	assert.Nil(t, err)
+}
+
+func TestVerifyAuditLogDetectsChangedEntry(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewWriterAuditLogger(buffer, testAuditLogKey, "")
+	signer := GenerateTestPrivateKey()
+	logger.Record(testAuditRecord(signer, nil))
+	logger.Record(testAuditRecord(signer, nil))
+
+	changed := strings.Replace(buffer.String(), `"amount":"12345"`, `"amount":"1"`, 1)
+	_, err := VerifyAuditLog(strings.NewReader(changed), testAuditLogKey)
+
+	assert.Equal(t, "audit log line 1: hash doesn't match", err.Error())
+}
+
+func TestVerifyAuditLogDetectsRemovedEntry(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewWriterAuditLogger(buffer, testAuditLogKey, "")
+	signer := GenerateTestPrivateKey()
+	logger.Record(testAuditRecord(signer, nil))
+	logger.Record(testAuditRecord(signer, nil))
+
+	lines := strings.SplitAfter(buffer.String(), "\n")
+	_, err := VerifyAuditLog(strings.NewReader(lines[1]), testAuditLogKey)
+
+	assert.Equal(t, "audit log line 1: previous hash doesn't match", err.Error())
+}
+
+func TestFileAuditLoggerContinuesHashChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-log")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	signer := GenerateTestPrivateKey()
+
+	logger, err := NewFileAuditLogger(path, testAuditLogKey)
+	assert.Nil(t, err)
+	assert.Nil(t, logger.Record(testAuditRecord(signer, nil)))
+	logger, err = NewFileAuditLogger(path, testAuditLogKey)
+	assert.Nil(t, err)
+	assert.Nil(t, logger.Record(testAuditRecord(signer, nil)))
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	lastHash, err := VerifyAuditLog(file, testAuditLogKey)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", lastHash)
+}
+
+func TestVerifyAuditLogWithAnotherKey(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewWriterAuditLogger(buffer, testAuditLogKey, "")
+	logger.Record(testAuditRecord(GenerateTestPrivateKey(), nil))
+
+	_, err := VerifyAuditLog(strings.NewReader(buffer.String()), []byte("another key"))
+
+	assert.Equal(t, "audit log line 1: hash doesn't match", err.Error())
+}
+
+func TestFileAuditLoggerRepairsCorruptedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-log")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	signer := GenerateTestPrivateKey()
+	logger, err := NewFileAuditLogger(path, testAuditLogKey)
+	assert.Nil(t, err)
+	assert.Nil(t, logger.Record(testAuditRecord(signer, nil)))
+	valid, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	file.WriteString(`{"timestamp":"partially written`)
+	file.Close()
+
+	logger, err = NewFileAuditLogger(path, testAuditLogKey)
+	assert.Nil(t, err)
+	assert.Nil(t, logger.Record(testAuditRecord(signer, nil)))
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), string(valid)))
+	_, err = VerifyAuditLog(strings.NewReader(string(content)), testAuditLogKey)
+	assert.Nil(t, err)
+	corrupted, err := filepath.Glob(path + ".corrupted-*")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(corrupted))
+	tail, err := ioutil.ReadFile(corrupted[0])
+	assert.Nil(t, err)
+	assert.Equal(t, `{"timestamp":"partially written`, string(tail))
+}
@@ -0,0 +1,319 @@
+package escrow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const (
+	// BlacklistWebhookSignatureHeader contains hex encoded HMAC-SHA256 of
+	// the webhook request body keyed by the webhook secret
+	BlacklistWebhookSignatureHeader = "X-Snet-Signature"
+	// BlacklistAdd is a webhook action which adds entry to the blacklist
+	BlacklistAdd = "add"
+	// BlacklistRemove is a webhook action which removes entry from the
+	// blacklist
+	BlacklistRemove = "remove"
+
+	// blacklistWebhookMaxClockSkew is a maximal difference between command
+	// timestamp and current time, older commands are rejected to prevent
+	// replaying them
+	blacklistWebhookMaxClockSkew  = 5 * time.Minute
+	blacklistWebhookMaxBodySize   = 64 * 1024
+	blacklistWebhookMaxNonceSize  = 64
+	blacklistNonceCleanupPageSize = 100
+)
+
+// Blacklist keeps payment channels and senders which are not allowed to pay.
+// Entries are kept in the storage shared between replicas, so entry added
+// via one replica is enforced by all of them.
+type Blacklist struct {
+	storage AtomicStorage
+}
+
+// BlacklistKeyPrefix returns prefix of the blacklist keys in the storage
+func BlacklistKeyPrefix(metadata *blockchain.ServiceMetadata) string {
+	return "/" + metadata.MpeAddress + "/payment-channel/blacklist"
+}
+
+// NewBlacklist returns blacklist which keeps entries in the storage
+func NewBlacklist(storage AtomicStorage, metadata *blockchain.ServiceMetadata) *Blacklist {
+	return &Blacklist{
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: BlacklistKeyPrefix(metadata),
+		},
+	}
+}
+
+func blacklistChannelKey(channelID *big.Int) string {
+	return "channel/" + channelID.String()
+}
+
+func blacklistSenderKey(sender common.Address) string {
+	return "sender/" + blockchain.AddressToHex(&sender)
+}
+
+// AddChannel adds payment channel to the blacklist
+func (blacklist *Blacklist) AddChannel(channelID *big.Int) error {
+	return blacklist.storage.Put(blacklistChannelKey(channelID), time.Now().UTC().Format(time.RFC3339))
+}
+
+// RemoveChannel removes payment channel from the blacklist
+func (blacklist *Blacklist) RemoveChannel(channelID *big.Int) error {
+	return blacklist.storage.Delete(blacklistChannelKey(channelID))
+}
+
+// AddSender adds channel sender to the blacklist
+func (blacklist *Blacklist) AddSender(sender common.Address) error {
+	return blacklist.storage.Put(blacklistSenderKey(sender), time.Now().UTC().Format(time.RFC3339))
+}
+
+// RemoveSender removes channel sender from the blacklist
+func (blacklist *Blacklist) RemoveSender(sender common.Address) error {
+	return blacklist.storage.Delete(blacklistSenderKey(sender))
+}
+
+// Check returns PermissionDenied error if payment channel or its sender is
+// blacklisted
+func (blacklist *Blacklist) Check(channelID *big.Int, sender common.Address) error {
+	_, ok, err := blacklist.storage.Get(blacklistChannelKey(channelID))
+	if err != nil {
+		log.WithError(err).WithField("channelID", channelID).Error("Unable to read payment channel blacklist")
+		return NewPaymentError(Internal, "cannot read payment channel blacklist")
+	}
+	if ok {
+		log.WithField("channelID", channelID).Warn("Payment channel is blacklisted")
+		return NewPaymentError(PermissionDenied, "payment channel %v is blacklisted", channelID)
+	}
+
+	_, ok, err = blacklist.storage.Get(blacklistSenderKey(sender))
+	if err != nil {
+		log.WithError(err).WithField("sender", blockchain.AddressToHex(&sender)).Error("Unable to read payment channel blacklist")
+		return NewPaymentError(Internal, "cannot read payment channel blacklist")
+	}
+	if ok {
+		log.WithField("sender", blockchain.AddressToHex(&sender)).Warn("Payment channel sender is blacklisted")
+		return NewPaymentError(PermissionDenied, "payment channel sender %v is blacklisted", blockchain.AddressToHex(&sender))
+	}
+	return nil
+}
+
+// BlacklistCommand is a body of the blacklist webhook request. Exactly one
+// of ChannelID and Sender should be set.
+type BlacklistCommand struct {
+	// Action is either BlacklistAdd or BlacklistRemove
+	Action string `json:"action"`
+	// ChannelID is a decimal id of the payment channel
+	ChannelID string `json:"channel_id,omitempty"`
+	// Sender is a hex address of the channel sender
+	Sender string `json:"sender,omitempty"`
+	// Timestamp is a Unix time of the command in seconds
+	Timestamp int64 `json:"timestamp"`
+	// Nonce is a unique string of the command, command with already used
+	// nonce is rejected
+	Nonce string `json:"nonce"`
+}
+
+type blacklistWebhook struct {
+	blacklist *Blacklist
+	secret    []byte
+	now       func() time.Time
+
+	mutex       sync.Mutex
+	lastCleanup time.Time
+}
+
+// NewBlacklistWebhook returns HTTP handler which applies BlacklistCommand
+// sent in the POST request body. Request is authenticated by
+// BlacklistWebhookSignatureHeader which should contain HMAC-SHA256 of the
+// body keyed by secret. Used nonces are kept in the blacklist storage, so
+// command cannot be replayed via another replica; they are deleted when
+// command timestamp is out of the allowed clock skew.
+func NewBlacklistWebhook(blacklist *Blacklist, secret string) http.Handler {
+	return &blacklistWebhook{
+		blacklist: blacklist,
+		secret:    []byte(secret),
+		now:       time.Now,
+	}
+}
+
+func (webhook *blacklistWebhook) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, blacklistWebhookMaxBodySize))
+	if err != nil {
+		http.Error(resp, "cannot read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !webhook.verify(body, req.Header.Get(BlacklistWebhookSignatureHeader)) {
+		log.WithField("remoteAddr", req.RemoteAddr).Warn("Blacklist command signature is not valid")
+		http.Error(resp, "signature is not valid", http.StatusUnauthorized)
+		return
+	}
+
+	command := &BlacklistCommand{}
+	if err = json.Unmarshal(body, command); err != nil {
+		http.Error(resp, "cannot parse command: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = webhook.apply(command); err != nil {
+		log.WithError(err).WithField("command", command).Warn("Blacklist command is rejected")
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.WithField("command", command).Info("Blacklist command is applied")
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (webhook *blacklistWebhook) verify(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, webhook.secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func (webhook *blacklistWebhook) apply(command *BlacklistCommand) error {
+	skew := webhook.now().Sub(time.Unix(command.Timestamp, 0))
+	if skew > blacklistWebhookMaxClockSkew || skew < -blacklistWebhookMaxClockSkew {
+		return fmt.Errorf("command timestamp %v is too far from current time", command.Timestamp)
+	}
+	if (command.ChannelID == "") == (command.Sender == "") {
+		return fmt.Errorf("exactly one of channel_id and sender should be set")
+	}
+	if command.Nonce == "" || len(command.Nonce) > blacklistWebhookMaxNonceSize {
+		return fmt.Errorf("nonce should be set and not longer than %v", blacklistWebhookMaxNonceSize)
+	}
+	if command.Action != BlacklistAdd && command.Action != BlacklistRemove {
+		return fmt.Errorf("unexpected action: \"%v\"", command.Action)
+	}
+
+	if command.ChannelID != "" {
+		channelID, ok := new(big.Int).SetString(command.ChannelID, 10)
+		if !ok {
+			return fmt.Errorf("incorrect channel id: \"%v\"", command.ChannelID)
+		}
+		if err := webhook.useNonce(command); err != nil {
+			return err
+		}
+		switch command.Action {
+		case BlacklistAdd:
+			return webhook.blacklist.AddChannel(channelID)
+		case BlacklistRemove:
+			return webhook.blacklist.RemoveChannel(channelID)
+		}
+		return fmt.Errorf("unexpected action: \"%v\"", command.Action)
+	}
+
+	if !common.IsHexAddress(command.Sender) {
+		return fmt.Errorf("incorrect sender address: \"%v\"", command.Sender)
+	}
+	sender := common.HexToAddress(command.Sender)
+	if err := webhook.useNonce(command); err != nil {
+		return err
+	}
+	switch command.Action {
+	case BlacklistAdd:
+		return webhook.blacklist.AddSender(sender)
+	case BlacklistRemove:
+		return webhook.blacklist.RemoveSender(sender)
+	}
+	return fmt.Errorf("unexpected action: \"%v\"", command.Action)
+}
+
+// useNonce returns error if nonce of the command is already used
+func (webhook *blacklistWebhook) useNonce(command *BlacklistCommand) error {
+	webhook.deleteExpiredNoncesIfDue()
+
+	ok, err := webhook.blacklist.storage.PutIfAbsent(blacklistNonceKey(command.Nonce), strconv.FormatInt(command.Timestamp, 10))
+	if err != nil {
+		return fmt.Errorf("cannot store command nonce: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("command nonce \"%v\" is already used", command.Nonce)
+	}
+	return nil
+}
+
+func blacklistNonceKey(nonce string) string {
+	return "nonce/" + url.PathEscape(nonce)
+}
+
+// deleteExpiredNoncesIfDue deletes nonces of the commands which would be
+// rejected because of their timestamp anyway, storage is scanned not more
+// often than once per allowed clock skew
+func (webhook *blacklistWebhook) deleteExpiredNoncesIfDue() {
+	webhook.mutex.Lock()
+	now := webhook.now()
+	due := now.Sub(webhook.lastCleanup) >= blacklistWebhookMaxClockSkew
+	if due {
+		webhook.lastCleanup = now
+	}
+	webhook.mutex.Unlock()
+
+	if !due {
+		return
+	}
+	if err := webhook.deleteExpiredNonces(now.Add(-blacklistWebhookMaxClockSkew)); err != nil {
+		log.WithError(err).Warn("Unable to delete expired blacklist command nonces")
+	}
+}
+
+func (webhook *blacklistWebhook) deleteExpiredNonces(oldest time.Time) error {
+	rangeStorage, ok := webhook.blacklist.storage.(RangeAtomicStorage)
+	if !ok {
+		return fmt.Errorf("storage doesn't support reading by key range")
+	}
+	startAfter := ""
+	for {
+		keys, values, err := rangeStorage.GetByKeyRange("nonce/", startAfter, blacklistNonceCleanupPageSize)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			timestamp, e := strconv.ParseInt(values[i], 10, 64)
+			if e == nil && !time.Unix(timestamp, 0).Before(oldest) {
+				continue
+			}
+			if err = webhook.blacklist.storage.Delete(key); err != nil {
+				return err
+			}
+		}
+		if len(keys) < blacklistNonceCleanupPageSize {
+			return nil
+		}
+		startAfter = keys[len(keys)-1]
+	}
+}
+
+// String is used in logs
+func (command *BlacklistCommand) String() string {
+	target := "channel " + command.ChannelID
+	if command.Sender != "" {
+		target = "sender " + command.Sender
+	}
+	return command.Action + " " + target + " at " + strconv.FormatInt(command.Timestamp, 10) + " nonce " + command.Nonce
+}
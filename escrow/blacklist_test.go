@@ -0,0 +1,125 @@
+package escrow
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const testBlacklistSecret = "test-secret"
+
+func (suite *ValidationTestSuite) blacklistValidator() (ChannelPaymentValidator, http.Handler) {
+	blacklist := NewBlacklist(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	validator := suite.validator
+	validator.blacklist = blacklist
+	return validator, NewBlacklistWebhook(blacklist, testBlacklistSecret)
+}
+
+func pushBlacklistCommand(webhook http.Handler, secret string, body string) *httptest.ResponseRecorder {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	req := httptest.NewRequest(http.MethodPost, "/blacklist", bytes.NewBufferString(body))
+	req.Header.Set(BlacklistWebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	resp := httptest.NewRecorder()
+	webhook.ServeHTTP(resp, req)
+	return resp
+}
+
+func (suite *ValidationTestSuite) TestBlacklistChannelByWebhook() {
+	validator, webhook := suite.blacklistValidator()
+	channelID := suite.payment().ChannelID
+
+	errA := validator.Validate(suite.payment(), suite.channel())
+	respA := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-1"}`, channelID, time.Now().Unix()))
+	errB := validator.Validate(suite.payment(), suite.channel())
+	respB := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "remove", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-2"}`, channelID, time.Now().Unix()))
+	errC := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), http.StatusOK, respA.Code)
+	assert.Equal(suite.T(), NewPaymentError(PermissionDenied, "payment channel %v is blacklisted", channelID), errB)
+	assert.Equal(suite.T(), http.StatusOK, respB.Code)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+}
+
+func (suite *ValidationTestSuite) TestBlacklistSenderByWebhook() {
+	validator, webhook := suite.blacklistValidator()
+	sender := blockchain.AddressToHex(&suite.channel().Sender)
+
+	resp := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "sender": "%v", "timestamp": %v, "nonce": "nonce-3"}`, sender, time.Now().Unix()))
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), http.StatusOK, resp.Code)
+	assert.Equal(suite.T(), NewPaymentError(PermissionDenied, "payment channel sender %v is blacklisted", sender), err)
+}
+
+func (suite *ValidationTestSuite) TestBlacklistWebhookIncorrectSignature() {
+	validator, webhook := suite.blacklistValidator()
+
+	resp := pushBlacklistCommand(webhook, "another-secret", fmt.Sprintf(`{"action": "add", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-4"}`, suite.payment().ChannelID, time.Now().Unix()))
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), http.StatusUnauthorized, resp.Code)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestBlacklistWebhookStaleCommand() {
+	validator, webhook := suite.blacklistValidator()
+
+	resp := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-5"}`, suite.payment().ChannelID, time.Now().Add(-time.Hour).Unix()))
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestBlacklistWebhookReplayedNonce() {
+	validator, webhook := suite.blacklistValidator()
+	channelID := suite.payment().ChannelID
+	add := fmt.Sprintf(`{"action": "add", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-a"}`, channelID, time.Now().Unix())
+
+	respA := pushBlacklistCommand(webhook, testBlacklistSecret, add)
+	respB := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "remove", "channel_id": "%v", "timestamp": %v, "nonce": "nonce-b"}`, channelID, time.Now().Unix()))
+	respReplayed := pushBlacklistCommand(webhook, testBlacklistSecret, add)
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), http.StatusOK, respA.Code)
+	assert.Equal(suite.T(), http.StatusOK, respB.Code)
+	assert.Equal(suite.T(), http.StatusBadRequest, respReplayed.Code)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestBlacklistWebhookCommandWithoutNonce() {
+	_, webhook := suite.blacklistValidator()
+
+	resp := pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "channel_id": "%v", "timestamp": %v}`, suite.payment().ChannelID, time.Now().Unix()))
+
+	assert.Equal(suite.T(), http.StatusBadRequest, resp.Code)
+}
+
+func TestBlacklistWebhookDeletesExpiredNonces(t *testing.T) {
+	storage := NewMemStorage()
+	blacklist := NewBlacklist(storage, &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	now := time.Unix(10000, 0)
+	webhook := NewBlacklistWebhook(blacklist, testBlacklistSecret).(*blacklistWebhook)
+	webhook.now = func() time.Time { return now }
+
+	pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "channel_id": "1", "timestamp": %v, "nonce": "nonce-a"}`, now.Unix()))
+	now = now.Add(2 * blacklistWebhookMaxClockSkew)
+	pushBlacklistCommand(webhook, testBlacklistSecret, fmt.Sprintf(`{"action": "add", "channel_id": "2", "timestamp": %v, "nonce": "nonce-b"}`, now.Unix()))
+
+	_, okA, _ := blacklist.storage.Get(blacklistNonceKey("nonce-a"))
+	_, okB, _ := blacklist.storage.Get(blacklistNonceKey("nonce-b"))
+	assert.False(t, okA)
+	assert.True(t, okB)
+}
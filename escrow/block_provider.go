@@ -0,0 +1,55 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+)
+
+// BlockProvider returns the current Ethereum block number which is used to
+// check channel expiration. blockchain.Processor and
+// blockchain.CurrentBlockCache implement it.
+type BlockProvider interface {
+	CurrentBlock() (currentBlock *big.Int, err error)
+}
+
+// ContextBlockProvider is implemented by the block providers which cancel
+// block lookup when context is done, blockchain.Processor implements it.
+type ContextBlockProvider interface {
+	CurrentBlockContext(ctx context.Context) (currentBlock *big.Int, err error)
+}
+
+// currentBlockContext returns the current block of the provider. Lookup via
+// provider which doesn't support context is not interrupted, caller checks
+// ctx after it returns.
+func currentBlockContext(ctx context.Context, provider BlockProvider) (currentBlock *big.Int, err error) {
+	if contextProvider, ok := provider.(ContextBlockProvider); ok {
+		return contextProvider.CurrentBlockContext(ctx)
+	}
+	return provider.CurrentBlock()
+}
+
+// BlockProviderFunc is an adapter to use ordinary function as a
+// BlockProvider
+type BlockProviderFunc func() (currentBlock *big.Int, err error)
+
+// CurrentBlock calls provider function
+func (provider BlockProviderFunc) CurrentBlock() (currentBlock *big.Int, err error) {
+	return provider()
+}
+
+// FixedBlockProvider always returns the same block number or the same
+// error, it is used in tests
+type FixedBlockProvider struct {
+	// Block is returned as the current block when Err is nil
+	Block *big.Int
+	// Err is returned instead of the block when it is not nil
+	Err error
+}
+
+// CurrentBlock returns a copy of the fixed block or the fixed error
+func (provider *FixedBlockProvider) CurrentBlock() (currentBlock *big.Int, err error) {
+	if provider.Err != nil {
+		return nil, provider.Err
+	}
+	return new(big.Int).Set(provider.Block), nil
+}
@@ -0,0 +1,172 @@
+package escrow
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/cache"
+)
+
+// StorageWatcher notifies about keys changed in the storage by any process
+// which shares it.
+type StorageWatcher interface {
+	// WatchKeyPrefix calls onChange for each changed key which has the
+	// prefix. onReset is called when changes could be missed, for example
+	// when watch history is compacted while watcher reconnects. Watching is
+	// stopped by calling returned stop function.
+	WatchKeyPrefix(prefix string, onChange func(key string), onReset func()) (stop func())
+}
+
+// CachingAtomicStorage is a read-through cache of the values which keys have
+// the given prefix. Cached values are invalidated by local writes and by
+// watching changes made by other replicas. Invalidation is asynchronous, so
+// cached value can be stale: callers which update the value should read it
+// bypassing cache, see PaymentChannelStorage.GetLatest.
+type CachingAtomicStorage struct {
+	delegate  AtomicStorage
+	keyPrefix string
+	// cacheAbsent enables caching of the keys which are absent in the
+	// storage, it is useful when most of the lookups find nothing
+	cacheAbsent bool
+
+	mutex   sync.Mutex
+	entries *cache.LRU
+	// version is incremented on each invalidation, value read from delegate
+	// is not cached if invalidation happened during the read
+	version uint64
+	stop    func()
+}
+
+// absentValue is cached for the keys which are absent in the storage
+type absentValue struct{}
+
+// NewCachingAtomicStorage returns storage which caches up to maxEntries
+// values of keys with keyPrefix and invalidates them using watcher. The
+// least recently used value is evicted when cache is full, name is used in
+// cache metrics. When cacheAbsent is true absence of the key is cached as
+// well.
+func NewCachingAtomicStorage(delegate AtomicStorage, watcher StorageWatcher, keyPrefix string, name string, maxEntries int, cacheAbsent bool) *CachingAtomicStorage {
+	storage := &CachingAtomicStorage{
+		delegate:    delegate,
+		keyPrefix:   keyPrefix,
+		cacheAbsent: cacheAbsent,
+		entries:     cache.NewLRU(name, maxEntries),
+	}
+	storage.stop = watcher.WatchKeyPrefix(keyPrefix, storage.invalidate, storage.invalidateAll)
+	return storage
+}
+
+// uncachedAtomicStorage returns storage which reads the actual values
+// bypassing cache and stale reads of the storage
+func uncachedAtomicStorage(storage AtomicStorage) AtomicStorage {
+	if caching, ok := storage.(*CachingAtomicStorage); ok {
+		storage = caching.delegate
+	}
+	if latest, ok := storage.(LatestAtomicStorage); ok {
+		return &latestAtomicStorage{AtomicStorage: storage, latest: latest}
+	}
+	return storage
+}
+
+// Close stops watching the storage changes
+func (storage *CachingAtomicStorage) Close() {
+	storage.stop()
+}
+
+// Get is implementation of AtomicStorage.Get
+func (storage *CachingAtomicStorage) Get(key string) (value string, ok bool, err error) {
+	if !strings.HasPrefix(key, storage.keyPrefix) {
+		return storage.delegate.Get(key)
+	}
+
+	storage.mutex.Lock()
+	cached, ok := storage.entries.Get(key)
+	version := storage.version
+	storage.mutex.Unlock()
+	if ok {
+		if _, absent := cached.(absentValue); absent {
+			return "", false, nil
+		}
+		return cached.(string), true, nil
+	}
+
+	value, ok, err = storage.delegate.Get(key)
+	if err != nil || (!ok && !storage.cacheAbsent) {
+		return
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if storage.version != version {
+		return
+	}
+	if ok {
+		storage.entries.Add(key, value)
+	} else {
+		storage.entries.Add(key, absentValue{})
+	}
+	return
+}
+
+// GetByKeyPrefix is implementation of AtomicStorage.GetByKeyPrefix, it is
+// not cached
+func (storage *CachingAtomicStorage) GetByKeyPrefix(prefix string) (values []string, err error) {
+	return storage.delegate.GetByKeyPrefix(prefix)
+}
+
+// GetByKeyRange is implementation of RangeAtomicStorage.GetByKeyRange, it
+// is not cached
+func (storage *CachingAtomicStorage) GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error) {
+	delegate, ok := storage.delegate.(RangeAtomicStorage)
+	if !ok {
+		return nil, nil, fmt.Errorf("storage doesn't support reading by key range")
+	}
+	return delegate.GetByKeyRange(prefix, startAfter, limit)
+}
+
+// Put is implementation of AtomicStorage.Put
+func (storage *CachingAtomicStorage) Put(key string, value string) (err error) {
+	defer storage.invalidate(key)
+	return storage.delegate.Put(key, value)
+}
+
+// PutIfAbsent is implementation of AtomicStorage.PutIfAbsent
+func (storage *CachingAtomicStorage) PutIfAbsent(key string, value string) (ok bool, err error) {
+	defer storage.invalidate(key)
+	return storage.delegate.PutIfAbsent(key, value)
+}
+
+// CompareAndSwap is implementation of AtomicStorage.CompareAndSwap
+func (storage *CachingAtomicStorage) CompareAndSwap(key string, prevValue string, newValue string) (ok bool, err error) {
+	defer storage.invalidate(key)
+	return storage.delegate.CompareAndSwap(key, prevValue, newValue)
+}
+
+// Delete is implementation of AtomicStorage.Delete
+func (storage *CachingAtomicStorage) Delete(key string) (err error) {
+	defer storage.invalidate(key)
+	return storage.delegate.Delete(key)
+}
+
+func (storage *CachingAtomicStorage) invalidate(key string) {
+	if !strings.HasPrefix(key, storage.keyPrefix) {
+		return
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.version++
+	storage.entries.Remove(key)
+}
+
+func (storage *CachingAtomicStorage) invalidateAll() {
+	log.WithField("keyPrefix", storage.keyPrefix).Info("Storage changes could be missed, drop all cached values")
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.version++
+	storage.entries.Purge()
+}
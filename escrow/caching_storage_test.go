@@ -0,0 +1,215 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+type storageWatcherMock struct {
+	prefix   string
+	onChange func(key string)
+	onReset  func()
+	stopped  bool
+}
+
+func (watcher *storageWatcherMock) WatchKeyPrefix(prefix string, onChange func(key string), onReset func()) (stop func()) {
+	watcher.prefix = prefix
+	watcher.onChange = onChange
+	watcher.onReset = onReset
+	return func() { watcher.stopped = true }
+}
+
+func newTestCachingStorage(maxEntries int) (storage *CachingAtomicStorage, delegate *memoryStorage, watcher *storageWatcherMock) {
+	delegate = NewMemStorage()
+	watcher = &storageWatcherMock{}
+	storage = NewCachingAtomicStorage(delegate, watcher, "/channels/", "test_channels", maxEntries, false)
+	return
+}
+
+func TestCachingStorageReturnsCachedValue(t *testing.T) {
+	storage, delegate, _ := newTestCachingStorage(10)
+	delegate.Put("/channels/1", "a")
+
+	value, ok, err := storage.Get("/channels/1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+
+	delegate.Put("/channels/1", "b")
+	value, _, _ = storage.Get("/channels/1")
+	assert.Equal(t, "a", value)
+}
+
+func TestCachingStorageInvalidatedByWatcher(t *testing.T) {
+	storage, delegate, watcher := newTestCachingStorage(10)
+	delegate.Put("/channels/1", "a")
+	storage.Get("/channels/1")
+
+	delegate.Put("/channels/1", "b")
+	watcher.onChange("/channels/1")
+
+	value, _, _ := storage.Get("/channels/1")
+	assert.Equal(t, "b", value)
+}
+
+func TestCachingStorageInvalidatedByReset(t *testing.T) {
+	storage, delegate, watcher := newTestCachingStorage(10)
+	delegate.Put("/channels/1", "a")
+	storage.Get("/channels/1")
+
+	delegate.Put("/channels/1", "b")
+	watcher.onReset()
+
+	value, _, _ := storage.Get("/channels/1")
+	assert.Equal(t, "b", value)
+}
+
+func TestCachingStorageInvalidatedByFailedCompareAndSwap(t *testing.T) {
+	storage, delegate, _ := newTestCachingStorage(10)
+	delegate.Put("/channels/1", "a")
+	storage.Get("/channels/1")
+	delegate.Put("/channels/1", "b")
+
+	ok, err := storage.CompareAndSwap("/channels/1", "a", "c")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	value, _, _ := storage.Get("/channels/1")
+	assert.Equal(t, "b", value)
+}
+
+func TestCachingStorageIsBounded(t *testing.T) {
+	storage, delegate, _ := newTestCachingStorage(2)
+	delegate.Put("/channels/1", "a")
+	delegate.Put("/channels/2", "b")
+	delegate.Put("/channels/3", "c")
+
+	storage.Get("/channels/1")
+	storage.Get("/channels/2")
+	storage.Get("/channels/3")
+
+	assert.Equal(t, 2, storage.entries.Len())
+}
+
+func TestCachingStorageDoesNotCacheOtherKeys(t *testing.T) {
+	storage, delegate, _ := newTestCachingStorage(10)
+	delegate.Put("/other/1", "a")
+	storage.Get("/other/1")
+
+	delegate.Put("/other/1", "b")
+
+	value, _, _ := storage.Get("/other/1")
+	assert.Equal(t, "b", value)
+	assert.Equal(t, 0, storage.entries.Len())
+}
+
+func TestCachingStorageClose(t *testing.T) {
+	storage, _, watcher := newTestCachingStorage(10)
+
+	storage.Close()
+
+	assert.Equal(t, "/channels/", watcher.prefix)
+	assert.True(t, watcher.stopped)
+}
+
+func TestPaymentChannelStorageGetLatestBypassesCache(t *testing.T) {
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	delegate := NewMemStorage()
+	cachingStorage := NewCachingAtomicStorage(delegate, &storageWatcherMock{}, PaymentChannelStorageKeyPrefix(metadata)+"/", "test_channels", 10, false)
+	storage := NewPaymentChannelStorage(cachingStorage, metadata)
+	otherReplica := NewPaymentChannelStorage(delegate, metadata)
+	channel := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: channel.ChannelID}
+	storage.Put(key, channel)
+	storage.Get(key)
+	updated := *channel
+	updated.AuthorizedAmount = big.NewInt(10)
+	otherReplica.Put(key, &updated)
+
+	cached, _, _ := storage.Get(key)
+	latest, ok, err := storage.GetLatest(key)
+
+	assert.Equal(t, channel, cached)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &updated, latest)
+}
+
+// staleReadStorage returns values of stale storage from Get and the latest
+// ones from GetLatest
+type staleReadStorage struct {
+	*memoryStorage
+	stale *memoryStorage
+}
+
+func (storage *staleReadStorage) Get(key string) (value string, ok bool, err error) {
+	return storage.stale.Get(key)
+}
+
+func (storage *staleReadStorage) GetLatest(key string) (value string, ok bool, err error) {
+	return storage.memoryStorage.Get(key)
+}
+
+func TestPaymentChannelStorageGetLatestBypassesStaleReads(t *testing.T) {
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	delegate := &staleReadStorage{memoryStorage: NewMemStorage(), stale: NewMemStorage()}
+	storage := NewPaymentChannelStorage(delegate, metadata)
+	channel := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: channel.ChannelID}
+	NewPaymentChannelStorage(delegate.stale, metadata).Put(key, channel)
+	updated := *channel
+	updated.AuthorizedAmount = big.NewInt(10)
+	storage.Put(key, &updated)
+
+	stale, _, _ := storage.Get(key)
+	latest, ok, err := storage.GetLatest(key)
+
+	assert.Equal(t, channel, stale)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &updated, latest)
+}
+
+func TestLockerBypassesStaleReads(t *testing.T) {
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	delegate := &staleReadStorage{memoryStorage: NewMemStorage(), stale: NewMemStorage()}
+	NewLockerStorage(delegate.stale, metadata).Put("42", locked)
+	NewLockerStorage(delegate.memoryStorage, metadata).Put("42", unlocked)
+
+	_, ok, err := NewEtcdLocker(delegate, metadata).Lock("42")
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestCachingStorageDoesNotCacheAbsentKeyByDefault(t *testing.T) {
+	storage, delegate, _ := newTestCachingStorage(10)
+	storage.Get("/channels/1")
+
+	delegate.Put("/channels/1", "a")
+	_, ok, _ := storage.Get("/channels/1")
+
+	assert.True(t, ok)
+}
+
+func TestCachingStorageCachesAbsentKey(t *testing.T) {
+	delegate := NewMemStorage()
+	watcher := &storageWatcherMock{}
+	storage := NewCachingAtomicStorage(delegate, watcher, "/channels/", "test_channels", 10, true)
+
+	_, okA, errA := storage.Get("/channels/1")
+	delegate.Put("/channels/1", "a")
+	_, okB, _ := storage.Get("/channels/1")
+	watcher.onChange("/channels/1")
+	value, okC, _ := storage.Get("/channels/1")
+
+	assert.Nil(t, errA)
+	assert.False(t, okA)
+	assert.False(t, okB)
+	assert.True(t, okC)
+	assert.Equal(t, "a", value)
+}
@@ -0,0 +1,41 @@
+package escrow
+
+import (
+	"math/big"
+)
+
+// ChannelAmounts contains amounts summed over payment channels
+type ChannelAmounts struct {
+	// Channels is a number of channels summed
+	Channels int
+	// Authorized is a sum of the amounts authorized by channel senders
+	Authorized *big.Int
+	// Unclaimed is a sum of the amounts which are deposited but not
+	// authorized yet, see PaymentChannelData.UnclaimedAmount
+	Unclaimed *big.Int
+}
+
+// SumChannelAmounts walks all channels of the payment group in the storage
+// and returns their total authorized and unclaimed amounts
+func SumChannelAmounts(storage *PaymentChannelStorage, groupID [32]byte) (amounts *ChannelAmounts, err error) {
+	channels, err := storage.GetAll()
+	if err != nil {
+		return
+	}
+
+	amounts = &ChannelAmounts{
+		Authorized: big.NewInt(0),
+		Unclaimed:  big.NewInt(0),
+	}
+	for _, channel := range channels {
+		if channel.GroupID != groupID {
+			continue
+		}
+		amounts.Channels++
+		if channel.AuthorizedAmount != nil {
+			amounts.Authorized.Add(amounts.Authorized, channel.AuthorizedAmount)
+		}
+		amounts.Unclaimed.Add(amounts.Unclaimed, channel.UnclaimedAmount())
+	}
+	return amounts, nil
+}
@@ -0,0 +1,37 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func TestUnclaimedAmount(t *testing.T) {
+	channel := &PaymentChannelData{FullAmount: big.NewInt(100), AuthorizedAmount: big.NewInt(30)}
+
+	assert.Equal(t, big.NewInt(70), channel.UnclaimedAmount())
+}
+
+func TestUnclaimedAmountOfLegacyChannel(t *testing.T) {
+	channel := &PaymentChannelData{FullAmount: big.NewInt(100)}
+
+	assert.Equal(t, big.NewInt(100), channel.UnclaimedAmount())
+}
+
+func TestSumChannelAmounts(t *testing.T) {
+	storage := NewPaymentChannelStorage(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	storage.Put(&PaymentChannelKey{ID: big.NewInt(1)}, &PaymentChannelData{ChannelID: big.NewInt(1), GroupID: [32]byte{123},
+		FullAmount: big.NewInt(100), AuthorizedAmount: big.NewInt(30)})
+	storage.Put(&PaymentChannelKey{ID: big.NewInt(2)}, &PaymentChannelData{ChannelID: big.NewInt(2), GroupID: [32]byte{123},
+		FullAmount: big.NewInt(50)})
+	storage.Put(&PaymentChannelKey{ID: big.NewInt(3)}, &PaymentChannelData{ChannelID: big.NewInt(3), GroupID: [32]byte{124},
+		FullAmount: big.NewInt(1000), AuthorizedAmount: big.NewInt(10)})
+
+	amounts, err := SumChannelAmounts(storage, [32]byte{123})
+
+	assert.Nil(t, err, "Unexpected error: %v", err)
+	assert.Equal(t, &ChannelAmounts{Channels: 2, Authorized: big.NewInt(30), Unclaimed: big.NewInt(120)}, amounts)
+}
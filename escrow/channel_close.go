@@ -0,0 +1,137 @@
+package escrow
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/authutils"
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+// ChannelClosure is a close of the payment channel acknowledged by the
+// channel sender.
+type ChannelClosure struct {
+	// ChannelID is an id of the payment channel
+	ChannelID *big.Int `json:"channelId"`
+	// Nonce is a nonce of the channel at the moment of close
+	Nonce *big.Int `json:"nonce"`
+	// FinalAmount is an amount authorized by sender at the moment of close
+	FinalAmount *big.Int `json:"finalAmount"`
+	// Signature is a signature of the close acknowledgement
+	Signature []byte `json:"signature"`
+	// ClosedAt is a time the close was acknowledged
+	ClosedAt time.Time `json:"closedAt"`
+}
+
+// ChannelClosures keeps close acknowledgements in the storage alongside the
+// payment channel state. Payments via closed channel are rejected.
+type ChannelClosures struct {
+	service    PaymentChannelService
+	storage    AtomicStorage
+	locker     Locker
+	mpeAddress func() common.Address
+	now        func() time.Time
+}
+
+// NewChannelClosures returns new instance which keeps closures in the
+// storage. locker should be the same as one used by payment transactions.
+func NewChannelClosures(service PaymentChannelService, storage AtomicStorage, locker Locker, metadata *blockchain.ServiceMetadata) *ChannelClosures {
+	return &ChannelClosures{
+		service: service,
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/close",
+		},
+		locker:     locker,
+		mpeAddress: func() common.Address { return metadata.GetMpeAddress() },
+		now:        time.Now,
+	}
+}
+
+// Close verifies close acknowledgement against the stored channel state and
+// marks the channel closed. Channel is locked while acknowledgement is
+// verified, so payment in progress cannot change the final amount.
+func (closures *ChannelClosures) Close(channelID, nonce, finalAmount *big.Int, signature []byte) (closure *ChannelClosure, err error) {
+	key := &PaymentChannelKey{ID: channelID}
+	lock, ok, err := closures.locker.Lock(key.String())
+	if err != nil {
+		return nil, NewPaymentError(Internal, "cannot get mutex for channel: %v", key)
+	}
+	if !ok {
+		return nil, NewPaymentError(FailedPrecondition, "another transaction on channel: %v is in progress", key)
+	}
+	defer func() {
+		if e := lock.Unlock(); e != nil {
+			log.WithError(e).WithField("key", key).Error("Channel cannot be unlocked because of error. All other transactions on this channel will be blocked until unlock. Please unlock channel manually.")
+		}
+	}()
+
+	channel, ok, err := closures.service.PaymentChannel(key)
+	if err != nil {
+		return nil, NewPaymentError(Internal, "payment channel error: %v", err)
+	}
+	if !ok {
+		return nil, NewPaymentError(Unauthenticated, "payment channel \"%v\" not found", channelID)
+	}
+
+	signer, err := authutils.GetSignerAddressFromMessage(closeChannelMessage(closures.mpeAddress(), channelID, nonce, finalAmount), signature)
+	if err != nil {
+		return nil, NewPaymentError(Unauthenticated, "incorrect signature")
+	}
+	if *signer != channel.Signer && *signer != channel.Sender {
+		return nil, NewPaymentError(Unauthenticated, "only channel signer or sender can close payment channel")
+	}
+	if nonce.Cmp(channel.Nonce) != 0 {
+		return nil, NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: %v, sent: %v", channel.Nonce, nonce)
+	}
+	if finalAmount.Cmp(channel.AuthorizedAmount) != 0 {
+		return nil, NewPaymentError(Unauthenticated, "final amount doesn't match the authorized amount, authorized amount: %v, final amount: %v", channel.AuthorizedAmount, finalAmount)
+	}
+
+	closure = &ChannelClosure{
+		ChannelID:   channelID,
+		Nonce:       nonce,
+		FinalAmount: finalAmount,
+		Signature:   signature,
+		ClosedAt:    closures.now(),
+	}
+	value, err := json.Marshal(closure)
+	if err != nil {
+		return nil, NewPaymentError(Internal, "cannot serialize channel closure")
+	}
+	if err = closures.storage.Put(channelID.String(), string(value)); err != nil {
+		log.WithError(err).WithField("closure", closure).Error("Unable to store channel closure")
+		return nil, NewPaymentError(Internal, "cannot store channel closure")
+	}
+
+	log.WithField("closure", closure).Info("Payment channel is closed by sender")
+	return closure, nil
+}
+
+// CheckOpen returns error if close of the channel is acknowledged.
+func (closures *ChannelClosures) CheckOpen(channelID *big.Int) error {
+	_, ok, err := closures.storage.Get(channelID.String())
+	if err != nil {
+		log.WithError(err).WithField("channelID", channelID).Error("Unable to read channel closure")
+		return NewPaymentError(Internal, "cannot read channel closure")
+	}
+	if ok {
+		return NewPaymentError(ChannelClosed, "payment channel %v is closed by sender", channelID)
+	}
+	return nil
+}
+
+func closeChannelMessage(mpeAddress common.Address, channelID, nonce, finalAmount *big.Int) []byte {
+	return bytes.Join([][]byte{
+		[]byte("__close_payment_channel"),
+		mpeAddress.Bytes(),
+		bigIntToBytes(channelID),
+		bigIntToBytes(nonce),
+		bigIntToBytes(finalAmount),
+	}, nil)
+}
@@ -0,0 +1,28 @@
+//go:generate protoc -I . ./channel_close.proto --go_out=plugins=grpc:.
+
+package escrow
+
+import (
+	"golang.org/x/net/context"
+)
+
+// ChannelCloseService is an implementation of ChannelCloseServiceServer
+// gRPC interface
+type ChannelCloseService struct {
+	closures *ChannelClosures
+}
+
+// NewChannelCloseService returns new instance of ChannelCloseService
+func NewChannelCloseService(closures *ChannelClosures) *ChannelCloseService {
+	return &ChannelCloseService{closures: closures}
+}
+
+// AcknowledgeClose marks the channel closed
+func (service *ChannelCloseService) AcknowledgeClose(ctx context.Context, request *CloseAcknowledgementRequest) (reply *CloseAcknowledgementReply, err error) {
+	closure, err := service.closures.Close(bytesToBigInt(request.GetChannelId()), bytesToBigInt(request.GetChannelNonce()),
+		bytesToBigInt(request.GetFinalAmount()), request.GetSignature())
+	if err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+	return &CloseAcknowledgementReply{ClosedAt: closure.ClosedAt.Unix()}, nil
+}
@@ -0,0 +1,76 @@
+package escrow
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/handler"
+)
+
+func (suite *PaymentChannelServiceSuite) channelClosures() *ChannelClosures {
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	closures := NewChannelClosures(suite.service, suite.memoryStorage, NewEtcdLocker(suite.memoryStorage, metadata), metadata)
+	closures.now = func() time.Time { return time.Unix(1000, 0) }
+	return closures
+}
+
+func (suite *PaymentChannelServiceSuite) closeAcknowledgement(closures *ChannelClosures, amount int64) []byte {
+	return getSignature(closeChannelMessage(closures.mpeAddress(), big.NewInt(42), big.NewInt(3), big.NewInt(amount)), suite.signerPrivateKey)
+}
+
+func (suite *PaymentChannelServiceSuite) closingPaymentHandler(closures *ChannelClosures) *paymentChannelPaymentHandler {
+	return &paymentChannelPaymentHandler{
+		service:            suite.service,
+		mpeContractAddress: func() common.Address { return suite.mpeContractAddress },
+		incomeValidator:    &incomeValidatorMockType{},
+		closures:           closures,
+	}
+}
+
+func (suite *PaymentChannelServiceSuite) TestChannelCloseRejectsFurtherPayments() {
+	closures := suite.channelClosures()
+	paymentHandler := suite.closingPaymentHandler(closures)
+	suite.putStaleChannel(100)
+
+	closure, errA := closures.Close(big.NewInt(42), big.NewInt(3), big.NewInt(100), suite.closeAcknowledgement(closures, 100))
+	payment, errB := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(110)))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), big.NewInt(100), closure.FinalAmount)
+	assert.Equal(suite.T(), time.Unix(1000, 0), closure.ClosedAt)
+	assert.Nil(suite.T(), payment)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.FailedPrecondition, "payment channel 42 is closed by sender"), errB)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestChannelCloseIncorrectFinalAmount() {
+	closures := suite.channelClosures()
+	paymentHandler := suite.closingPaymentHandler(closures)
+	suite.putStaleChannel(100)
+
+	closure, errA := closures.Close(big.NewInt(42), big.NewInt(3), big.NewInt(90), suite.closeAcknowledgement(closures, 90))
+	payment, errB := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(110)))
+	paymentHandler.Complete(payment)
+
+	assert.Nil(suite.T(), closure)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "final amount doesn't match the authorized amount, authorized amount: 100, final amount: 90"), errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func (suite *PaymentChannelServiceSuite) TestChannelCloseIncorrectSigner() {
+	closures := suite.channelClosures()
+	suite.putStaleChannel(100)
+	signature := getSignature(closeChannelMessage(closures.mpeAddress(), big.NewInt(42), big.NewInt(3), big.NewInt(100)), GenerateTestPrivateKey())
+
+	closure, err := closures.Close(big.NewInt(42), big.NewInt(3), big.NewInt(100), signature)
+
+	assert.Nil(suite.T(), closure)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "only channel signer or sender can close payment channel"), err)
+	assert.Nil(suite.T(), closures.CheckOpen(big.NewInt(42)))
+}
@@ -0,0 +1,197 @@
+package escrow
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const (
+	// maxExportedChannelLine is a maximal length of the line which
+	// ImportChannels accepts
+	maxExportedChannelLine = 1024 * 1024
+	// channelExportPageSize is a number of channels read from the storage
+	// at once by ExportChannels
+	channelExportPageSize = 100
+)
+
+// exportedChannel is a JSON representation of PaymentChannelData used by
+// ExportChannels. Amounts are written as decimal strings to avoid precision
+// loss in JSON tools which parse numbers as floats.
+type exportedChannel struct {
+	MpeContractAddress string `json:"mpe_contract_address"`
+	ChannelID          string `json:"channel_id"`
+	Nonce              string `json:"nonce"`
+	State              string `json:"state"`
+	Sender             string `json:"sender"`
+	Recipient          string `json:"recipient"`
+	GroupID            string `json:"group_id"`
+	FullAmount         string `json:"full_amount"`
+	Expiration         string `json:"expiration"`
+	Signer             string `json:"signer"`
+	AuthorizedAmount   string `json:"authorized_amount"`
+	Signature          string `json:"signature,omitempty"`
+	SignedAmount       string `json:"signed_amount,omitempty"`
+	DaemonId           string `json:"daemon_id,omitempty"`
+	MessageType        string `json:"message_type,omitempty"`
+}
+
+// ExportChannels writes all channels of the storage as JSON Lines: one JSON
+// object per channel. Storage is read page by page and each page is written
+// before the next one is read, so memory is not spent on the list of all
+// channels.
+func ExportChannels(w io.Writer, storage *PaymentChannelStorage) (err error) {
+	return exportChannels(w, storage, channelExportPageSize)
+}
+
+func exportChannels(w io.Writer, storage *PaymentChannelStorage, pageSize int) (err error) {
+	rangeStorage, ok := storage.atomicStorage.(RangeAtomicStorage)
+	if !ok {
+		return fmt.Errorf("payment channel storage doesn't support reading by key range")
+	}
+
+	encoder := json.NewEncoder(w)
+	startAfter := ""
+	for {
+		keys, values, err := rangeStorage.GetByKeyRange("", startAfter, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			channel := &PaymentChannelData{}
+			if err = storage.deserializePaymentChannelData(value, channel); err != nil {
+				return err
+			}
+			if err = encoder.Encode(toExportedChannel(channel)); err != nil {
+				return err
+			}
+		}
+		if len(keys) < pageSize {
+			return nil
+		}
+		startAfter = keys[len(keys)-1]
+	}
+}
+
+// ImportChannels reads channels written by ExportChannels and puts them into
+// the storage replacing channels with the same id. Returns number of
+// imported channels.
+func ImportChannels(r io.Reader, storage *PaymentChannelStorage) (imported int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxExportedChannelLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		exported := &exportedChannel{}
+		if err = json.Unmarshal(scanner.Bytes(), exported); err != nil {
+			return imported, fmt.Errorf("cannot parse channel at line %v: %v", line, err)
+		}
+		channel, e := fromExportedChannel(exported)
+		if e != nil {
+			return imported, fmt.Errorf("incorrect channel at line %v: %v", line, e)
+		}
+		if err = storage.Put(&PaymentChannelKey{ID: channel.ChannelID}, channel); err != nil {
+			return
+		}
+		imported++
+	}
+	return imported, scanner.Err()
+}
+
+func toExportedChannel(channel *PaymentChannelData) *exportedChannel {
+	exported := &exportedChannel{
+		MpeContractAddress: blockchain.AddressToHex(&channel.MpeContractAddress),
+		ChannelID:          decimalString(channel.ChannelID),
+		Nonce:              decimalString(channel.Nonce),
+		State:              channel.State.String(),
+		Sender:             blockchain.AddressToHex(&channel.Sender),
+		Recipient:          blockchain.AddressToHex(&channel.Recipient),
+		GroupID:            blockchain.BytesToBase64(channel.GroupID[:]),
+		FullAmount:         decimalString(channel.FullAmount),
+		Expiration:         decimalString(channel.Expiration),
+		Signer:             blockchain.AddressToHex(&channel.Signer),
+		AuthorizedAmount:   decimalString(channel.AuthorizedAmount),
+		DaemonId:           channel.DaemonId,
+		MessageType:        channel.MessageType,
+	}
+	if channel.Signature != nil {
+		exported.Signature = blockchain.BytesToBase64(channel.Signature)
+	}
+	if channel.SignedAmount != nil {
+		exported.SignedAmount = channel.SignedAmount.String()
+	}
+	return exported
+}
+
+func fromExportedChannel(exported *exportedChannel) (channel *PaymentChannelData, err error) {
+	channel = &PaymentChannelData{
+		MpeContractAddress: common.HexToAddress(exported.MpeContractAddress),
+		Sender:             common.HexToAddress(exported.Sender),
+		Recipient:          common.HexToAddress(exported.Recipient),
+		Signer:             common.HexToAddress(exported.Signer),
+		DaemonId:           exported.DaemonId,
+		MessageType:        exported.MessageType,
+	}
+
+	switch exported.State {
+	case Open.String():
+		channel.State = Open
+	case Closed.String():
+		channel.State = Closed
+	default:
+		return nil, fmt.Errorf("unexpected channel state: \"%v\"", exported.State)
+	}
+
+	groupID, err := base64.StdEncoding.DecodeString(exported.GroupID)
+	if err != nil || len(groupID) != 32 {
+		return nil, fmt.Errorf("incorrect group id: \"%v\"", exported.GroupID)
+	}
+	copy(channel.GroupID[:], groupID)
+
+	if exported.Signature != "" {
+		if channel.Signature, err = base64.StdEncoding.DecodeString(exported.Signature); err != nil {
+			return nil, fmt.Errorf("incorrect signature: \"%v\"", exported.Signature)
+		}
+	}
+
+	fields := []struct {
+		name     string
+		value    string
+		target   **big.Int
+		optional bool
+	}{
+		{"channel_id", exported.ChannelID, &channel.ChannelID, false},
+		{"nonce", exported.Nonce, &channel.Nonce, false},
+		{"full_amount", exported.FullAmount, &channel.FullAmount, false},
+		{"expiration", exported.Expiration, &channel.Expiration, false},
+		{"authorized_amount", exported.AuthorizedAmount, &channel.AuthorizedAmount, false},
+		{"signed_amount", exported.SignedAmount, &channel.SignedAmount, true},
+	}
+	for _, field := range fields {
+		if field.value == "" && field.optional {
+			continue
+		}
+		value, ok := new(big.Int).SetString(field.value, 10)
+		if !ok {
+			return nil, fmt.Errorf("incorrect %v: \"%v\"", field.name, field.value)
+		}
+		*field.target = value
+	}
+
+	return channel, nil
+}
+
+func decimalString(value *big.Int) string {
+	if value == nil {
+		return "0"
+	}
+	return value.String()
+}
@@ -0,0 +1,79 @@
+package escrow
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func newExportTestStorage() *PaymentChannelStorage {
+	return NewPaymentChannelStorage(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+}
+
+func TestExportImportChannels(t *testing.T) {
+	src := newExportTestStorage()
+	first := testChannelSerializerData()
+	second := testChannelSerializerData()
+	second.ChannelID = big.NewInt(43)
+	second.State = Open
+	second.Signature = []byte{4, 5, 6}
+	second.SignedAmount = big.NewInt(7)
+	second.DaemonId = ""
+	for _, channel := range []*PaymentChannelData{first, second} {
+		assert.Nil(t, src.Put(&PaymentChannelKey{ID: channel.ChannelID}, channel))
+	}
+	var exported bytes.Buffer
+
+	err := ExportChannels(&exported, src)
+
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, exported.String(), `"full_amount":"115792089237316195423570985008687907853269984665640564039457584007913129639935"`)
+	assert.Contains(t, exported.String(), `"sender":"`+first.Sender.Hex()+`"`)
+
+	dst := newExportTestStorage()
+	imported, err := ImportChannels(&exported, dst)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, imported)
+	for _, expected := range []*PaymentChannelData{first, second} {
+		actual, ok, err := dst.Get(&PaymentChannelKey{ID: expected.ChannelID})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestExportChannelsByPages(t *testing.T) {
+	storage := newExportTestStorage()
+	for id := int64(40); id < 45; id++ {
+		channel := testChannelSerializerData()
+		channel.ChannelID = big.NewInt(id)
+		assert.Nil(t, storage.Put(&PaymentChannelKey{ID: channel.ChannelID}, channel))
+	}
+	var exported bytes.Buffer
+
+	err := exportChannels(&exported, storage, 2)
+
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	assert.Equal(t, 5, len(lines))
+	for i, id := range []string{"40", "41", "42", "43", "44"} {
+		assert.Contains(t, lines[i], `"channel_id":"`+id+`"`)
+	}
+}
+
+func TestImportChannelsIncorrectAmount(t *testing.T) {
+	input := `{"channel_id":"42","nonce":"1.5","state":"Open","group_id":"AQIDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=","full_amount":"1","expiration":"1","authorized_amount":"0"}`
+
+	imported, err := ImportChannels(strings.NewReader(input), newExportTestStorage())
+
+	assert.Equal(t, 0, imported)
+	assert.Equal(t, "incorrect channel at line 1: incorrect nonce: \"1.5\"", err.Error())
+}
@@ -0,0 +1,113 @@
+package escrow
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultChannelPageSize is a number of channels returned by ListChannels
+// when page size is not set
+const DefaultChannelPageSize = 100
+
+// ChannelFilter selects channels returned by ListChannels, nil fields are
+// not checked
+type ChannelFilter struct {
+	// Sender selects channels opened by the sender
+	Sender *common.Address
+	// Recipient selects channels which funds are paid to the recipient
+	Recipient *common.Address
+	// GroupID selects channels of the payment group
+	GroupID *[32]byte
+	// MinExpiration selects channels which expire at this block or later
+	MinExpiration *big.Int
+	// MaxExpiration selects channels which expire at this block or earlier
+	MaxExpiration *big.Int
+	// PageSize is a maximal number of channels returned at once, zero
+	// means DefaultChannelPageSize
+	PageSize int
+}
+
+func (filter *ChannelFilter) matches(channel *PaymentChannelData) bool {
+	if filter.Sender != nil && *filter.Sender != channel.Sender {
+		return false
+	}
+	if filter.Recipient != nil && *filter.Recipient != channel.Recipient {
+		return false
+	}
+	if filter.GroupID != nil && *filter.GroupID != channel.GroupID {
+		return false
+	}
+	if filter.MinExpiration != nil && (channel.Expiration == nil || channel.Expiration.Cmp(filter.MinExpiration) < 0) {
+		return false
+	}
+	if filter.MaxExpiration != nil && (channel.Expiration == nil || channel.Expiration.Cmp(filter.MaxExpiration) > 0) {
+		return false
+	}
+	return true
+}
+
+func (filter *ChannelFilter) pageSize() int {
+	if filter.PageSize <= 0 {
+		return DefaultChannelPageSize
+	}
+	return filter.PageSize
+}
+
+// PageToken is an opaque token to continue listing of the channels, empty
+// token means the first page when passed and no more pages when returned
+type PageToken string
+
+func newPageToken(lastKey string) PageToken {
+	return PageToken(base64.RawURLEncoding.EncodeToString([]byte(lastKey)))
+}
+
+func (token PageToken) lastKey() (string, error) {
+	lastKey, err := base64.RawURLEncoding.DecodeString(string(token))
+	if err != nil {
+		return "", fmt.Errorf("incorrect page token: \"%v\"", token)
+	}
+	return string(lastKey), nil
+}
+
+// ListChannels returns the page of channels selected by filter in the order
+// of storage keys and the token to get the next page. Storage is scanned by
+// pages of the same size until the page of the selected channels is filled,
+// so sparse filters can read many storage pages.
+func (storage *PaymentChannelStorage) ListChannels(filter ChannelFilter, page PageToken) (channels []*PaymentChannelData, next PageToken, err error) {
+	rangeStorage, ok := storage.atomicStorage.(RangeAtomicStorage)
+	if !ok {
+		return nil, "", fmt.Errorf("payment channel storage doesn't support reading by key range")
+	}
+	startAfter, err := page.lastKey()
+	if err != nil {
+		return
+	}
+
+	pageSize := filter.pageSize()
+	for {
+		keys, values, e := rangeStorage.GetByKeyRange("", startAfter, pageSize)
+		if e != nil {
+			return nil, "", e
+		}
+		for i := range keys {
+			channel := &PaymentChannelData{}
+			if err = storage.deserializePaymentChannelData(values[i], channel); err != nil {
+				return nil, "", err
+			}
+			startAfter = keys[i]
+			if !filter.matches(channel) {
+				continue
+			}
+			channels = append(channels, channel)
+			if len(channels) == pageSize {
+				return channels, newPageToken(startAfter), nil
+			}
+		}
+		if len(keys) < pageSize {
+			return channels, "", nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newListingTestStorage(channels ...*PaymentChannelData) *PaymentChannelStorage {
+	storage := newExportTestStorage()
+	for _, channel := range channels {
+		storage.Put(&PaymentChannelKey{ID: channel.ChannelID}, channel)
+	}
+	return storage
+}
+
+func testListingChannel(id int64, sender common.Address, expiration int64) *PaymentChannelData {
+	channel := testChannelSerializerData()
+	channel.ChannelID = big.NewInt(id)
+	channel.Sender = sender
+	channel.Expiration = big.NewInt(expiration)
+	return channel
+}
+
+func TestListChannelsFilterBySender(t *testing.T) {
+	alice := common.HexToAddress("0xa")
+	bob := common.HexToAddress("0xb")
+	storage := newListingTestStorage(
+		testListingChannel(1, alice, 100),
+		testListingChannel(2, bob, 100),
+		testListingChannel(3, alice, 200),
+	)
+
+	channels, next, err := storage.ListChannels(ChannelFilter{Sender: &alice}, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, PageToken(""), next)
+	assert.Equal(t, 2, len(channels))
+	for _, channel := range channels {
+		assert.Equal(t, alice, channel.Sender)
+	}
+}
+
+func TestListChannelsFilterByExpiration(t *testing.T) {
+	alice := common.HexToAddress("0xa")
+	storage := newListingTestStorage(
+		testListingChannel(1, alice, 100),
+		testListingChannel(2, alice, 150),
+		testListingChannel(3, alice, 200),
+	)
+
+	channels, _, err := storage.ListChannels(ChannelFilter{MinExpiration: big.NewInt(120), MaxExpiration: big.NewInt(200)}, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(channels))
+	for _, channel := range channels {
+		assert.True(t, channel.Expiration.Int64() >= 120)
+	}
+}
+
+func TestListChannelsContinuation(t *testing.T) {
+	alice := common.HexToAddress("0xa")
+	bob := common.HexToAddress("0xb")
+	storage := newListingTestStorage(
+		testListingChannel(1, alice, 100),
+		testListingChannel(2, bob, 100),
+		testListingChannel(3, alice, 100),
+		testListingChannel(4, bob, 100),
+		testListingChannel(5, alice, 100),
+	)
+	filter := ChannelFilter{Sender: &alice, PageSize: 2}
+
+	first, next, errA := storage.ListChannels(filter, "")
+	second, last, errB := storage.ListChannels(filter, next)
+
+	assert.Nil(t, errA)
+	assert.Nil(t, errB)
+	assert.Equal(t, 2, len(first))
+	assert.NotEqual(t, PageToken(""), next)
+	assert.Equal(t, 1, len(second))
+	assert.Equal(t, PageToken(""), last)
+	ids := map[int64]bool{}
+	for _, channel := range append(first, second...) {
+		assert.Equal(t, alice, channel.Sender)
+		ids[channel.ChannelID.Int64()] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 3: true, 5: true}, ids)
+}
+
+func TestListChannelsIncorrectPageToken(t *testing.T) {
+	storage := newListingTestStorage()
+
+	_, _, err := storage.ListChannels(ChannelFilter{}, "not base64!")
+
+	assert.Equal(t, "incorrect page token: \"not base64!\"", err.Error())
+}
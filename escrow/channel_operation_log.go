@@ -0,0 +1,162 @@
+package escrow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const channelOperationLogMaxAttempts = 10
+
+// ChannelOperationRecord is an accepted payment recorded in the channel
+// operation log.
+type ChannelOperationRecord struct {
+	// Nonce is a nonce of the channel the payment was made with
+	Nonce *big.Int `json:"nonce"`
+	// Amount is an amount authorized by the payment
+	Amount *big.Int `json:"amount"`
+	// Timestamp is a time when payment was accepted
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type queuedChannelOperation struct {
+	channelID *big.Int
+	operation ChannelOperationRecord
+}
+
+// ChannelOperationLog keeps ordered history of accepted payments of each
+// channel to resolve disputes about charges. Payments are recorded
+// asynchronously, so the log doesn't slow the payment processing; when
+// recording queue is full operations are dropped with a warning.
+type ChannelOperationLog struct {
+	storage AtomicStorage
+	// maxLength is a maximal number of operations kept per channel, zero
+	// means no limit
+	maxLength int
+	// ttl is a time operation is kept, zero means forever
+	ttl   time.Duration
+	queue chan queuedChannelOperation
+	done  chan struct{}
+	now   func() time.Time
+}
+
+// NewChannelOperationLog returns operation log which keeps operations in the
+// storage and starts background recording.
+func NewChannelOperationLog(storage AtomicStorage, metadata *blockchain.ServiceMetadata, maxLength int, ttl time.Duration, queueSize int) *ChannelOperationLog {
+	operationLog := &ChannelOperationLog{
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/operation-log",
+		},
+		maxLength: maxLength,
+		ttl:       ttl,
+		queue:     make(chan queuedChannelOperation, queueSize),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+	go operationLog.recordLoop()
+	return operationLog
+}
+
+// Record queues the accepted payment to be added to the log, it doesn't
+// block.
+func (operationLog *ChannelOperationLog) Record(channelID *big.Int, nonce *big.Int, amount *big.Int) {
+	record := queuedChannelOperation{
+		channelID: channelID,
+		operation: ChannelOperationRecord{Nonce: nonce, Amount: amount, Timestamp: operationLog.now()},
+	}
+	select {
+	case operationLog.queue <- record:
+	default:
+		log.WithField("channelID", channelID).WithField("nonce", nonce).WithField("amount", amount).
+			Warn("Channel operation log queue is full, operation is not recorded")
+	}
+}
+
+// Close stops recording after all queued operations are recorded
+func (operationLog *ChannelOperationLog) Close() {
+	close(operationLog.queue)
+	<-operationLog.done
+}
+
+func (operationLog *ChannelOperationLog) recordLoop() {
+	defer close(operationLog.done)
+	for record := range operationLog.queue {
+		if err := operationLog.append(record.channelID, record.operation); err != nil {
+			log.WithError(err).WithField("channelID", record.channelID).Error("Unable to record channel operation")
+		}
+	}
+}
+
+// Operations returns recorded operations of the channel, oldest first
+func (operationLog *ChannelOperationLog) Operations(channelID *big.Int) (operations []ChannelOperationRecord, err error) {
+	value, ok, err := operationLog.storage.Get(channelID.String())
+	if err != nil || !ok {
+		return
+	}
+	operations, err = parseChannelOperations(value)
+	if err != nil {
+		return
+	}
+	return operationLog.trim(operations), nil
+}
+
+func (operationLog *ChannelOperationLog) append(channelID *big.Int, operation ChannelOperationRecord) (err error) {
+	key := channelID.String()
+	for attempt := 0; attempt < channelOperationLogMaxAttempts; attempt++ {
+		value, ok, err := operationLog.storage.Get(key)
+		if err != nil {
+			return err
+		}
+
+		var operations []ChannelOperationRecord
+		if ok {
+			if operations, err = parseChannelOperations(value); err != nil {
+				log.WithError(err).WithField("channelID", channelID).Warn("Incorrect channel operation log in storage, reset it")
+				operations = nil
+			}
+		}
+		operations = operationLog.trim(append(operations, operation))
+
+		newValue, err := json.Marshal(operations)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			ok, err = operationLog.storage.PutIfAbsent(key, string(newValue))
+		} else {
+			ok, err = operationLog.storage.CompareAndSwap(key, value, string(newValue))
+		}
+		if err != nil || ok {
+			return err
+		}
+	}
+	return fmt.Errorf("cannot update operation log of channel %v", channelID)
+}
+
+// trim removes expired operations and operations which exceed maximal
+// length
+func (operationLog *ChannelOperationLog) trim(operations []ChannelOperationRecord) []ChannelOperationRecord {
+	if operationLog.ttl > 0 {
+		oldest := operationLog.now().Add(-operationLog.ttl)
+		first := 0
+		for first < len(operations) && operations[first].Timestamp.Before(oldest) {
+			first++
+		}
+		operations = operations[first:]
+	}
+	if operationLog.maxLength > 0 && len(operations) > operationLog.maxLength {
+		operations = operations[len(operations)-operationLog.maxLength:]
+	}
+	return operations
+}
+
+func parseChannelOperations(value string) (operations []ChannelOperationRecord, err error) {
+	err = json.Unmarshal([]byte(value), &operations)
+	return
+}
@@ -0,0 +1,85 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+var operationLogTestMetadata = &blockchain.ServiceMetadata{MpeAddress: "0xf25186b5081ff5ce73482ad761db0eb0d25abfbf"}
+
+func newTestChannelOperationLog(maxLength int, ttl time.Duration, now *time.Time) *ChannelOperationLog {
+	operationLog := NewChannelOperationLog(NewMemStorage(), operationLogTestMetadata, maxLength, ttl, 10)
+	operationLog.now = func() time.Time { return *now }
+	return operationLog
+}
+
+func TestChannelOperationLogRecordsPaymentsInOrder(t *testing.T) {
+	now := time.Unix(1000, 0)
+	operationLog := newTestChannelOperationLog(0, 0, &now)
+
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(10))
+	now = now.Add(time.Second)
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(20))
+	now = now.Add(time.Second)
+	operationLog.Record(big.NewInt(43), big.NewInt(1), big.NewInt(5))
+	operationLog.Record(big.NewInt(42), big.NewInt(4), big.NewInt(7))
+	operationLog.Close()
+
+	operations, err := operationLog.Operations(big.NewInt(42))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(operations))
+	assert.Equal(t, []*big.Int{big.NewInt(10), big.NewInt(20), big.NewInt(7)},
+		[]*big.Int{operations[0].Amount, operations[1].Amount, operations[2].Amount})
+	assert.Equal(t, []*big.Int{big.NewInt(3), big.NewInt(3), big.NewInt(4)},
+		[]*big.Int{operations[0].Nonce, operations[1].Nonce, operations[2].Nonce})
+	assert.Equal(t, time.Unix(1000, 0).Unix(), operations[0].Timestamp.Unix())
+	assert.Equal(t, time.Unix(1002, 0).Unix(), operations[2].Timestamp.Unix())
+}
+
+func TestChannelOperationLogMaxLength(t *testing.T) {
+	now := time.Unix(1000, 0)
+	operationLog := newTestChannelOperationLog(2, 0, &now)
+
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(10))
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(20))
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(30))
+	operationLog.Close()
+
+	operations, err := operationLog.Operations(big.NewInt(42))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(operations))
+	assert.Equal(t, big.NewInt(20), operations[0].Amount)
+	assert.Equal(t, big.NewInt(30), operations[1].Amount)
+}
+
+func TestChannelOperationLogTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	operationLog := newTestChannelOperationLog(0, time.Minute, &now)
+
+	operationLog.Record(big.NewInt(42), big.NewInt(3), big.NewInt(10))
+	operationLog.Close()
+	now = now.Add(2 * time.Minute)
+
+	operations, err := operationLog.Operations(big.NewInt(42))
+
+	assert.Nil(t, err)
+	assert.Empty(t, operations)
+}
+
+func TestChannelOperationLogUnknownChannel(t *testing.T) {
+	now := time.Unix(1000, 0)
+	operationLog := newTestChannelOperationLog(0, 0, &now)
+	defer operationLog.Close()
+
+	operations, err := operationLog.Operations(big.NewInt(42))
+
+	assert.Nil(t, err)
+	assert.Empty(t, operations)
+}
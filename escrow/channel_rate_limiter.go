@@ -0,0 +1,134 @@
+package escrow
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// LocalChannelRateLimiter keeps rate limit state in daemon memory, so
+	// each replica limits calls independently.
+	LocalChannelRateLimiter = "local"
+	// EtcdChannelRateLimiter keeps rate limit state in the shared storage, so
+	// limit is enforced across all replicas of the group.
+	EtcdChannelRateLimiter = "etcd"
+
+	channelRateLimitWindow = time.Minute
+)
+
+// ChannelRateLimiter limits rate of calls paid via the same payment channel
+type ChannelRateLimiter interface {
+	// Allow returns true if one more call via the channel is allowed
+	Allow(channelID *big.Int) (allowed bool, err error)
+}
+
+type localChannelRateLimiter struct {
+	buckets *keyedRateLimiter
+}
+
+// NewLocalChannelRateLimiter returns rate limiter which allows
+// callsPerMinute calls per channel and keeps its state in memory.
+func NewLocalChannelRateLimiter(callsPerMinute int) ChannelRateLimiter {
+	// bucket which is not used during the window is full again, so evicting
+	// it doesn't change the limit
+	return &localChannelRateLimiter{
+		buckets: newKeyedRateLimiter(rate.Every(channelRateLimitWindow/time.Duration(callsPerMinute)),
+			callsPerMinute, channelRateLimitWindow),
+	}
+}
+
+func (limiter *localChannelRateLimiter) Allow(channelID *big.Int) (allowed bool, err error) {
+	return limiter.buckets.Allow(channelID.String()), nil
+}
+
+// keyedRateLimiter keeps token bucket per key in memory. Buckets which are
+// not used during idle timeout are evicted to bound memory.
+type keyedRateLimiter struct {
+	mutex        sync.Mutex
+	limit        rate.Limit
+	burst        int
+	idleTimeout  time.Duration
+	buckets      map[string]*rateBucket
+	lastEviction time.Time
+	now          func() time.Time
+}
+
+type rateBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newKeyedRateLimiter(limit rate.Limit, burst int, idleTimeout time.Duration) *keyedRateLimiter {
+	return &keyedRateLimiter{
+		limit:       limit,
+		burst:       burst,
+		idleTimeout: idleTimeout,
+		buckets:     make(map[string]*rateBucket),
+		now:         time.Now,
+	}
+}
+
+// Allow returns true if one more call for the key is allowed
+func (limiter *keyedRateLimiter) Allow(key string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.evictIdle(now)
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &rateBucket{limiter: rate.NewLimiter(limiter.limit, limiter.burst)}
+		limiter.buckets[key] = bucket
+	}
+	bucket.lastUsed = now
+	return bucket.limiter.AllowN(now, 1)
+}
+
+// evictIdle removes buckets which are not used during idle timeout, map is
+// scanned not more often than once per idle timeout
+func (limiter *keyedRateLimiter) evictIdle(now time.Time) {
+	if now.Sub(limiter.lastEviction) < limiter.idleTimeout {
+		return
+	}
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.lastUsed) >= limiter.idleTimeout {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastEviction = now
+}
+
+// size returns number of keys which are tracked
+func (limiter *keyedRateLimiter) size() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return len(limiter.buckets)
+}
+
+// storageChannelRateLimiter counts calls in fixed time windows using counter
+// kept in the storage, so it can be shared between daemon replicas.
+type storageChannelRateLimiter struct {
+	counter *windowCounter
+	limit   *big.Int
+}
+
+// NewEtcdChannelRateLimiter returns rate limiter which allows callsPerMinute
+// calls per channel and keeps its state in the storage shared by replicas.
+func NewEtcdChannelRateLimiter(storage AtomicStorage, metadata *blockchain.ServiceMetadata, callsPerMinute int) ChannelRateLimiter {
+	return &storageChannelRateLimiter{
+		counter: newWindowCounter(&PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/rate-limit",
+		}, channelRateLimitWindow),
+		limit: big.NewInt(int64(callsPerMinute)),
+	}
+}
+
+func (limiter *storageChannelRateLimiter) Allow(channelID *big.Int) (allowed bool, err error) {
+	return limiter.counter.Add(channelID.String(), big.NewInt(1), limiter.limit)
+}
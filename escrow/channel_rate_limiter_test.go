@@ -0,0 +1,79 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func TestLocalChannelRateLimiter(t *testing.T) {
+	limiter := NewLocalChannelRateLimiter(2)
+
+	allowedA, _ := limiter.Allow(big.NewInt(42))
+	allowedB, _ := limiter.Allow(big.NewInt(42))
+	allowedC, _ := limiter.Allow(big.NewInt(42))
+	allowedOther, _ := limiter.Allow(big.NewInt(43))
+
+	assert.True(t, allowedA)
+	assert.True(t, allowedB)
+	assert.False(t, allowedC)
+	assert.True(t, allowedOther)
+}
+
+func TestLocalChannelRateLimiterEvictsIdleChannels(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewLocalChannelRateLimiter(1).(*localChannelRateLimiter)
+	limiter.buckets.now = func() time.Time { return now }
+
+	allowedA, _ := limiter.Allow(big.NewInt(42))
+	now = now.Add(30 * time.Second)
+	limiter.Allow(big.NewInt(43))
+	sizeBefore := limiter.buckets.size()
+	now = now.Add(45 * time.Second)
+	allowedB, _ := limiter.Allow(big.NewInt(43))
+
+	assert.True(t, allowedA)
+	assert.False(t, allowedB)
+	assert.Equal(t, 2, sizeBefore)
+	assert.Equal(t, 1, limiter.buckets.size())
+}
+
+func TestEtcdChannelRateLimiterSharedBetweenInstances(t *testing.T) {
+	storage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	limiterA := NewEtcdChannelRateLimiter(storage, metadata, 3)
+	limiterB := NewEtcdChannelRateLimiter(storage, metadata, 3)
+
+	allowed1, err1 := limiterA.Allow(big.NewInt(42))
+	allowed2, err2 := limiterB.Allow(big.NewInt(42))
+	allowed3, err3 := limiterA.Allow(big.NewInt(42))
+	allowed4, err4 := limiterB.Allow(big.NewInt(42))
+
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Nil(t, err3)
+	assert.Nil(t, err4)
+	assert.True(t, allowed1)
+	assert.True(t, allowed2)
+	assert.True(t, allowed3)
+	assert.False(t, allowed4)
+}
+
+func TestEtcdChannelRateLimiterNextWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewEtcdChannelRateLimiter(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}, 1).(*storageChannelRateLimiter)
+	limiter.counter.now = func() time.Time { return now }
+
+	allowedA, _ := limiter.Allow(big.NewInt(42))
+	allowedB, _ := limiter.Allow(big.NewInt(42))
+	now = now.Add(time.Minute)
+	allowedC, _ := limiter.Allow(big.NewInt(42))
+
+	assert.True(t, allowedA)
+	assert.False(t, allowedB)
+	assert.True(t, allowedC)
+}
@@ -0,0 +1,124 @@
+package escrow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+)
+
+// compressedChannelFlag is set in the serializer id byte of the stored
+// value header when payload is gzip compressed, so ids of serializers
+// should be less than it
+const compressedChannelFlag byte = 0x80
+
+const (
+	// gobChannelSerializerID identifies values encoded by GobChannelSerializer
+	gobChannelSerializerID byte = 0
+	// jsonChannelSerializerID identifies values encoded by
+	// JSONChannelSerializer
+	jsonChannelSerializerID byte = 1
+)
+
+// channelSerializerByID returns built-in serializer by its id
+func channelSerializerByID(id byte) (serializer ChannelSerializer, ok bool) {
+	switch id {
+	case gobChannelSerializerID:
+		return GobChannelSerializer{}, true
+	case jsonChannelSerializerID:
+		return JSONChannelSerializer{}, true
+	}
+	return nil, false
+}
+
+// ChannelSerializer encodes PaymentChannelData to keep it in the payment
+// channel storage. Implementation should keep all big.Int fields without
+// precision loss and distinguish nil values from zero ones. Serializer id is
+// written into the stored value header, so values written by other replicas
+// are decoded by the serializer which encoded them.
+type ChannelSerializer interface {
+	// ID returns byte which identifies encoding in the stored value, it
+	// should be less than compressedChannelFlag, ids of built-in serializers
+	// start from 0
+	ID() byte
+	// Serialize encodes channel data
+	Serialize(data *PaymentChannelData) ([]byte, error)
+	// Deserialize decodes channel data encoded by Serialize
+	Deserialize(serialized []byte) (*PaymentChannelData, error)
+}
+
+// GobChannelSerializer encodes channel data using gob, it is a default
+// serializer of the payment channel storage
+type GobChannelSerializer struct{}
+
+// ID implements ChannelSerializer
+func (GobChannelSerializer) ID() byte {
+	return gobChannelSerializerID
+}
+
+// Serialize implements ChannelSerializer
+func (GobChannelSerializer) Serialize(data *PaymentChannelData) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Deserialize implements ChannelSerializer
+func (GobChannelSerializer) Deserialize(serialized []byte) (*PaymentChannelData, error) {
+	data := &PaymentChannelData{}
+	if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// JSONChannelSerializer encodes channel data using JSON, big.Int values are
+// written as JSON numbers of arbitrary length. It makes stored values human
+// readable using etcdctl.
+type JSONChannelSerializer struct{}
+
+// ID implements ChannelSerializer
+func (JSONChannelSerializer) ID() byte {
+	return jsonChannelSerializerID
+}
+
+// Serialize implements ChannelSerializer
+func (JSONChannelSerializer) Serialize(data *PaymentChannelData) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// Deserialize implements ChannelSerializer
+func (JSONChannelSerializer) Deserialize(serialized []byte) (*PaymentChannelData, error) {
+	data := &PaymentChannelData{}
+	if err := json.Unmarshal(serialized, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// compressChannelValue gzips encoded channel data
+func compressChannelValue(serialized []byte) ([]byte, error) {
+	var b bytes.Buffer
+	writer := gzip.NewWriter(&b)
+	if _, err := writer.Write(serialized); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decompressChannelValue returns channel data compressed by
+// compressChannelValue
+func decompressChannelValue(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,174 @@
+package escrow
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func testChannelSerializerData() *PaymentChannelData {
+	huge, _ := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	return &PaymentChannelData{
+		MpeContractAddress: blockchain.HexToAddress("0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"),
+		ChannelID:          big.NewInt(42),
+		Nonce:              big.NewInt(3),
+		State:              Closed,
+		Sender:             common.HexToAddress("0x1"),
+		Recipient:          common.HexToAddress("0x2"),
+		GroupID:            [32]byte{1, 2, 3},
+		FullAmount:         huge,
+		Expiration:         big.NewInt(100),
+		Signer:             common.HexToAddress("0x3"),
+		AuthorizedAmount:   new(big.Int).Sub(huge, big.NewInt(1)),
+		Signature:          nil,
+		SignedAmount:       nil,
+		DaemonId:           "daemon-1",
+	}
+}
+
+func testChannelSerializerRoundTrip(t *testing.T, serializer ChannelSerializer) {
+	expected := testChannelSerializerData()
+
+	serialized, err := serializer.Serialize(expected)
+	assert.Nil(t, err)
+	actual, err := serializer.Deserialize(serialized)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	assert.Nil(t, actual.Signature)
+	assert.Nil(t, actual.SignedAmount)
+
+	expected.Signature = []byte{4, 5, 6}
+	expected.SignedAmount = big.NewInt(7)
+	serialized, err = serializer.Serialize(expected)
+	assert.Nil(t, err)
+	actual, err = serializer.Deserialize(serialized)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestGobChannelSerializerRoundTrip(t *testing.T) {
+	testChannelSerializerRoundTrip(t, GobChannelSerializer{})
+}
+
+func TestJSONChannelSerializerRoundTrip(t *testing.T) {
+	testChannelSerializerRoundTrip(t, JSONChannelSerializer{})
+}
+
+func TestPaymentChannelStorageWithSerializer(t *testing.T) {
+	storage := NewPaymentChannelStorageWithSerializer(NewMemStorage(),
+		&blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"},
+		JSONChannelSerializer{}, 0)
+	expected := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+
+	err := storage.Put(key, expected)
+	assert.Nil(t, err)
+	actual, ok, err := storage.Get(key)
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, expected, actual)
+}
+
+func TestPaymentChannelStorageReadsValueOfAnotherSerializer(t *testing.T) {
+	memoryStorage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	expected := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+	NewPaymentChannelStorageWithSerializer(memoryStorage, metadata, JSONChannelSerializer{}, 0).Put(key, expected)
+
+	actual, ok, err := NewPaymentChannelStorage(memoryStorage, metadata).Get(key)
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, expected, actual)
+}
+
+func TestPaymentChannelStorageUnknownSerializer(t *testing.T) {
+	memoryStorage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	key := &PaymentChannelKey{ID: big.NewInt(42)}
+	keyString, _ := serialize(key)
+	memoryStorage.Put(PaymentChannelStorageKeyPrefix(metadata)+"/"+keyString, string([]byte{versionMarker + paymentChannelDataVersion, 0x7F}))
+
+	_, _, err := NewPaymentChannelStorage(memoryStorage, metadata).Get(key)
+
+	assert.Equal(t, errors.New("unknown payment channel serializer id: 127"), err)
+}
+
+func TestPaymentChannelStorageSmallValueIsNotCompressed(t *testing.T) {
+	memoryStorage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	storage := NewPaymentChannelStorageWithSerializer(memoryStorage, metadata, GobChannelSerializer{}, 4096)
+	expected := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+
+	err := storage.Put(key, expected)
+	assert.Nil(t, err)
+	actual, _, err := storage.Get(key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	uncompressed, _ := GobChannelSerializer{}.Serialize(expected)
+	values, _ := memoryStorage.GetByKeyPrefix("")
+	assert.Equal(t, []string{string([]byte{versionMarker + gobPaymentChannelDataVersion}) + string(uncompressed)}, values)
+}
+
+func TestPaymentChannelStorageLargeValueIsCompressed(t *testing.T) {
+	memoryStorage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	storage := NewPaymentChannelStorageWithSerializer(memoryStorage, metadata, GobChannelSerializer{}, 4096)
+	expected := testChannelSerializerData()
+	expected.Signature = make([]byte, 8192)
+	expected.SignedAmount = big.NewInt(7)
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+
+	err := storage.Put(key, expected)
+	assert.Nil(t, err)
+	actual, _, err := storage.Get(key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	values, _ := memoryStorage.GetByKeyPrefix("")
+	assert.Equal(t, versionMarker+paymentChannelDataVersion, values[0][0])
+	assert.Equal(t, gobChannelSerializerID|compressedChannelFlag, values[0][1])
+	assert.True(t, len(values[0]) < 4096)
+}
+
+func TestPaymentChannelStorageWithoutCompressionReadsCompressedValue(t *testing.T) {
+	memoryStorage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	expected := testChannelSerializerData()
+	expected.Signature = make([]byte, 8192)
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+	NewPaymentChannelStorageWithSerializer(memoryStorage, metadata, JSONChannelSerializer{}, 1).Put(key, expected)
+
+	actual, _, err := NewPaymentChannelStorageWithSerializer(memoryStorage, metadata, JSONChannelSerializer{}, 0).Get(key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestPaymentChannelStorageUpdatesCompressedValue(t *testing.T) {
+	storage := NewPaymentChannelStorageWithSerializer(NewMemStorage(),
+		&blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"},
+		GobChannelSerializer{}, 1)
+	expected := testChannelSerializerData()
+	key := &PaymentChannelKey{ID: expected.ChannelID}
+	storage.Put(key, expected)
+	updated := *expected
+	updated.Nonce = big.NewInt(4)
+
+	err := storage.UpdateChannel(key, expected, &updated)
+
+	assert.Nil(t, err)
+	actual, _, _ := storage.Get(key)
+	assert.Equal(t, &updated, actual)
+}
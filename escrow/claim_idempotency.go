@@ -0,0 +1,234 @@
+package escrow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const (
+	claimStatePending = "pending"
+	claimStateStarted = "started"
+
+	claimIdempotencyCleanupPageSize = 100
+)
+
+// claimIdempotencyRecord keeps state of the claim of one channel generation
+type claimIdempotencyRecord struct {
+	State        string    `json:"state"`
+	Timestamp    time.Time `json:"timestamp"`
+	Nonce        *big.Int  `json:"nonce,omitempty"`
+	Amount       *big.Int  `json:"amount,omitempty"`
+	Signature    []byte    `json:"signature,omitempty"`
+	ActualAmount *big.Int  `json:"actual_amount,omitempty"`
+}
+
+// ClaimIdempotency makes sure that the claim of each channel generation is
+// started at most once. Generation is identified by channel id and nonce;
+// authorized amount is not a part of the key because it can grow between
+// two claim requests of the same generation. Retried claim requests receive
+// the payment of the claim started first. Records are deleted after
+// retention time, storage is scanned for them not more often than once per
+// half of retention.
+type ClaimIdempotency struct {
+	storage AtomicStorage
+	// pendingTimeout is a time after which pending claim is considered
+	// abandoned and can be started again
+	pendingTimeout time.Duration
+	// retention is a time record is kept, zero means forever
+	retention time.Duration
+	now       func() time.Time
+
+	mutex       sync.Mutex
+	lastCleanup time.Time
+}
+
+// NewClaimIdempotency returns new instance which keeps records in the
+// storage.
+func NewClaimIdempotency(storage AtomicStorage, metadata *blockchain.ServiceMetadata, pendingTimeout time.Duration, retention time.Duration) *ClaimIdempotency {
+	return &ClaimIdempotency{
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/claim-idempotency",
+		},
+		pendingTimeout: pendingTimeout,
+		retention:      retention,
+		now:            time.Now,
+	}
+}
+
+// StartClaim calls start only if the claim of the channel generation was
+// not started before and returns its payment. If the claim was started
+// already its payment is returned without calling start. If the claim is
+// being started concurrently an error is returned.
+func (idempotency *ClaimIdempotency) StartClaim(channelID *big.Int, nonce *big.Int, start func() (*Payment, error)) (payment *Payment, err error) {
+	idempotency.cleanupIfDue()
+
+	key := claimIdempotencyKey(channelID, nonce)
+	pending, err := idempotency.marshal(&claimIdempotencyRecord{State: claimStatePending, Timestamp: idempotency.now()})
+	if err != nil {
+		return
+	}
+
+	ok, err := idempotency.storage.PutIfAbsent(key, pending)
+	if err != nil {
+		return
+	}
+	if !ok {
+		value, ok, err := idempotency.storage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("claim of channel %v nonce %v is finished concurrently, please retry", channelID, nonce)
+		}
+		record, err := parseClaimIdempotencyRecord(value)
+		if err != nil {
+			return nil, err
+		}
+		if record.State == claimStateStarted {
+			log.WithField("channelID", channelID).WithField("nonce", nonce).Info("Claim is already started, payment of the started claim is returned")
+			return record.payment(channelID), nil
+		}
+		if idempotency.now().Sub(record.Timestamp) < idempotency.pendingTimeout {
+			return nil, fmt.Errorf("claim of channel %v nonce %v is already in progress", channelID, nonce)
+		}
+		log.WithField("channelID", channelID).WithField("nonce", nonce).Warn("Pending claim is abandoned, start it again")
+		if ok, err = idempotency.storage.CompareAndSwap(key, value, pending); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("claim of channel %v nonce %v is already in progress", channelID, nonce)
+		}
+	}
+
+	payment, err = start()
+	if err != nil {
+		if e := idempotency.storage.Delete(key); e != nil {
+			log.WithError(e).WithField("channelID", channelID).WithField("nonce", nonce).Error("Unable to remove pending claim record")
+		}
+		return nil, err
+	}
+
+	started, err := idempotency.marshal(&claimIdempotencyRecord{
+		State:        claimStateStarted,
+		Timestamp:    idempotency.now(),
+		Nonce:        payment.ChannelNonce,
+		Amount:       payment.Amount,
+		Signature:    payment.Signature,
+		ActualAmount: payment.ActualAmount,
+	})
+	if err == nil {
+		_, err = idempotency.storage.CompareAndSwap(key, pending, started)
+	}
+	if err != nil {
+		log.WithError(err).WithField("payment", payment).Error("Claim is started but claim record is not updated")
+	}
+	return payment, nil
+}
+
+// StartedClaim returns payment of the claim of the channel generation
+// which was started before, ok is false if there is no such claim.
+func (idempotency *ClaimIdempotency) StartedClaim(channelID *big.Int, nonce *big.Int) (payment *Payment, ok bool, err error) {
+	value, ok, err := idempotency.storage.Get(claimIdempotencyKey(channelID, nonce))
+	if err != nil || !ok {
+		return
+	}
+	record, err := parseClaimIdempotencyRecord(value)
+	if err != nil {
+		return
+	}
+	if record.State != claimStateStarted {
+		return nil, false, nil
+	}
+	return record.payment(channelID), true, nil
+}
+
+// cleanupIfDue deletes expired records if they were not deleted during the
+// last half of retention
+func (idempotency *ClaimIdempotency) cleanupIfDue() {
+	if idempotency.retention <= 0 {
+		return
+	}
+
+	idempotency.mutex.Lock()
+	now := idempotency.now()
+	due := now.Sub(idempotency.lastCleanup) >= idempotency.retention/2
+	if due {
+		idempotency.lastCleanup = now
+	}
+	idempotency.mutex.Unlock()
+
+	if !due {
+		return
+	}
+	deleted, err := idempotency.DeleteExpired()
+	if err != nil {
+		log.WithError(err).Warn("Unable to delete expired claim records")
+		return
+	}
+	log.WithField("deleted", deleted).Debug("Expired claim records are deleted")
+}
+
+// DeleteExpired deletes records which are older than retention, returns
+// number of deleted records
+func (idempotency *ClaimIdempotency) DeleteExpired() (deleted int, err error) {
+	rangeStorage := idempotency.storage.(RangeAtomicStorage)
+	oldest := idempotency.now().Add(-idempotency.retention)
+	startAfter := ""
+	for {
+		keys, values, err := rangeStorage.GetByKeyRange("", startAfter, claimIdempotencyCleanupPageSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		for i, key := range keys {
+			record, e := parseClaimIdempotencyRecord(values[i])
+			if e == nil && !record.Timestamp.Before(oldest) {
+				continue
+			}
+			if err = idempotency.storage.Delete(key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+
+		if len(keys) < claimIdempotencyCleanupPageSize {
+			return deleted, nil
+		}
+		startAfter = keys[len(keys)-1]
+	}
+}
+
+func (record *claimIdempotencyRecord) payment(channelID *big.Int) *Payment {
+	return &Payment{
+		ChannelID:    channelID,
+		ChannelNonce: record.Nonce,
+		Amount:       record.Amount,
+		Signature:    record.Signature,
+		ActualAmount: record.ActualAmount,
+	}
+}
+
+func (idempotency *ClaimIdempotency) marshal(record *claimIdempotencyRecord) (string, error) {
+	value, err := json.Marshal(record)
+	return string(value), err
+}
+
+func parseClaimIdempotencyRecord(value string) (record *claimIdempotencyRecord, err error) {
+	record = &claimIdempotencyRecord{}
+	if err = json.Unmarshal([]byte(value), record); err != nil {
+		return nil, fmt.Errorf("incorrect claim record: %v", err)
+	}
+	return
+}
+
+func claimIdempotencyKey(channelID *big.Int, nonce *big.Int) string {
+	return channelID.String() + "/" + nonce.String()
+}
@@ -0,0 +1,165 @@
+package escrow
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClaimIdempotency(now *time.Time) *ClaimIdempotency {
+	idempotency := NewClaimIdempotency(NewMemStorage(), operationLogTestMetadata, time.Minute, time.Hour)
+	idempotency.now = func() time.Time { return *now }
+	return idempotency
+}
+
+func testClaimPayment(nonce int64) *Payment {
+	return &Payment{
+		ChannelID:    big.NewInt(42),
+		ChannelNonce: big.NewInt(nonce),
+		Amount:       big.NewInt(12300),
+		Signature:    []byte{1, 2, 3},
+	}
+}
+
+func TestClaimIdempotencyConcurrentClaimsStartOnce(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+	var submissions int32
+	release := make(chan struct{})
+	start := func() (*Payment, error) {
+		atomic.AddInt32(&submissions, 1)
+		<-release
+		return testClaimPayment(3), nil
+	}
+
+	var wait sync.WaitGroup
+	payments := make([]*Payment, 2)
+	errs := make([]error, 2)
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		payments[0], errs[0] = idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+	}()
+	for atomic.LoadInt32(&submissions) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	payments[1], errs[1] = idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+	close(release)
+	wait.Wait()
+
+	assert.Equal(t, int32(1), submissions)
+	assert.Nil(t, errs[0])
+	assert.Equal(t, testClaimPayment(3), payments[0])
+	assert.Equal(t, errors.New("claim of channel 42 nonce 3 is already in progress"), errs[1])
+	assert.Nil(t, payments[1])
+}
+
+func TestClaimIdempotencyRetryReturnsStartedClaim(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+	submissions := 0
+	start := func() (*Payment, error) {
+		submissions++
+		return testClaimPayment(3), nil
+	}
+
+	first, errFirst := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+	retry, errRetry := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+	started, ok, errStarted := idempotency.StartedClaim(big.NewInt(42), big.NewInt(3))
+
+	assert.Equal(t, 1, submissions)
+	assert.Nil(t, errFirst)
+	assert.Nil(t, errRetry)
+	assert.Nil(t, errStarted)
+	assert.True(t, ok)
+	assert.Equal(t, first, retry)
+	assert.Equal(t, first, started)
+}
+
+func TestClaimIdempotencyFailedClaimCanBeRetried(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+
+	_, errFirst := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), func() (*Payment, error) {
+		return nil, errors.New("storage error")
+	})
+	payment, errRetry := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), func() (*Payment, error) {
+		return testClaimPayment(3), nil
+	})
+
+	assert.Equal(t, errors.New("storage error"), errFirst)
+	assert.Nil(t, errRetry)
+	assert.Equal(t, testClaimPayment(3), payment)
+}
+
+func TestClaimIdempotencyAbandonedClaimIsRestarted(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+	pending, _ := idempotency.marshal(&claimIdempotencyRecord{State: claimStatePending, Timestamp: now})
+	idempotency.storage.Put(claimIdempotencyKey(big.NewInt(42), big.NewInt(3)), pending)
+	start := func() (*Payment, error) {
+		return testClaimPayment(3), nil
+	}
+
+	_, errPending := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+	now = now.Add(time.Minute)
+	payment, errAbandoned := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), start)
+
+	assert.Equal(t, errors.New("claim of channel 42 nonce 3 is already in progress"), errPending)
+	assert.Nil(t, errAbandoned)
+	assert.Equal(t, testClaimPayment(3), payment)
+}
+
+func TestClaimIdempotencyStartedClaimNotFound(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+
+	payment, ok, err := idempotency.StartedClaim(big.NewInt(42), big.NewInt(3))
+
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, payment)
+}
+
+func TestClaimIdempotencyDeletesExpiredRecords(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+	idempotency.StartClaim(big.NewInt(42), big.NewInt(3), func() (*Payment, error) {
+		return testClaimPayment(3), nil
+	})
+	now = now.Add(30 * time.Minute)
+	idempotency.StartClaim(big.NewInt(42), big.NewInt(4), func() (*Payment, error) {
+		return testClaimPayment(4), nil
+	})
+
+	now = now.Add(31 * time.Minute)
+	deleted, err := idempotency.DeleteExpired()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	_, ok, _ := idempotency.StartedClaim(big.NewInt(42), big.NewInt(3))
+	assert.False(t, ok)
+	_, ok, _ = idempotency.StartedClaim(big.NewInt(42), big.NewInt(4))
+	assert.True(t, ok)
+}
+
+func TestClaimIdempotencyCleanupOnStartClaim(t *testing.T) {
+	now := time.Unix(1000, 0)
+	idempotency := newTestClaimIdempotency(&now)
+	idempotency.StartClaim(big.NewInt(42), big.NewInt(3), func() (*Payment, error) {
+		return testClaimPayment(3), nil
+	})
+
+	now = now.Add(2 * time.Hour)
+	idempotency.StartClaim(big.NewInt(43), big.NewInt(1), func() (*Payment, error) {
+		return testClaimPayment(1), nil
+	})
+
+	_, ok, _ := idempotency.StartedClaim(big.NewInt(42), big.NewInt(3))
+	assert.False(t, ok)
+}
@@ -0,0 +1,64 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	// ClaimCheckNonce fails when channel nonce is unknown
+	ClaimCheckNonce = "nonce"
+	// ClaimCheckAmount fails when there is nothing to claim
+	ClaimCheckAmount = "amount"
+	// ClaimCheckFunds fails when authorized amount exceeds channel funds
+	ClaimCheckFunds = "funds"
+	// ClaimCheckSignature fails when authorized amount and nonce are not
+	// signed by channel signer or sender
+	ClaimCheckSignature = "signature"
+)
+
+// ClaimSimulationError describes the first check which claim of the channel
+// would fail
+type ClaimSimulationError struct {
+	// ChannelID is an id of the channel
+	ChannelID *big.Int
+	// Check is one of ClaimCheck* constants
+	Check string
+	// Message describes the failure
+	Message string
+}
+
+func (err *ClaimSimulationError) Error() string {
+	return fmt.Sprintf("claim of channel %v fails %v check: %v", err.ChannelID, err.Check, err.Message)
+}
+
+// SimulateClaim checks that the claim of the channel stored authorized
+// amount would be accepted by MultiPartyEscrow contract. Checks use only
+// stored channel state; blockchain is not called, so channel nonce and value
+// are assumed to be in sync with the contract. Returns *ClaimSimulationError
+// for the first failed check.
+func SimulateClaim(channel *PaymentChannelData) error {
+	fail := func(check string, format string, args ...interface{}) error {
+		return &ClaimSimulationError{ChannelID: channel.ChannelID, Check: check, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if channel.Nonce == nil {
+		return fail(ClaimCheckNonce, "channel nonce is unknown")
+	}
+	if channel.AuthorizedAmount == nil || channel.AuthorizedAmount.Sign() <= 0 {
+		return fail(ClaimCheckAmount, "authorized amount is zero, nothing to claim")
+	}
+	if channel.FullAmount == nil || channel.AuthorizedAmount.Cmp(channel.FullAmount) > 0 {
+		return fail(ClaimCheckFunds, "authorized amount %v exceeds channel amount %v", channel.AuthorizedAmount, channel.FullAmount)
+	}
+	if channel.SignedAmount != nil && channel.SignedAmount.Cmp(channel.AuthorizedAmount) < 0 {
+		return fail(ClaimCheckAmount, "signed amount %v is less than authorized amount %v", channel.SignedAmount, channel.AuthorizedAmount)
+	}
+	if len(channel.Signature) == 0 {
+		return fail(ClaimCheckSignature, "authorized amount is not signed")
+	}
+	if err := verifyClaimSignature(channel); err != nil {
+		return fail(ClaimCheckSignature, "%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package escrow
+
+import (
+	"math/big"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ValidationTestSuite) claimableChannel() *PaymentChannelData {
+	payment := suite.paymentWithAmount(12300)
+	channel := suite.channel()
+	channel.MpeContractAddress = suite.mpeContractAddress
+	channel.Signature = payment.Signature
+	return channel
+}
+
+func (suite *ValidationTestSuite) TestSimulateClaim() {
+	err := SimulateClaim(suite.claimableChannel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestSimulateClaimZeroAmount() {
+	channel := suite.claimableChannel()
+	channel.AuthorizedAmount = big.NewInt(0)
+
+	err := SimulateClaim(channel)
+
+	assert.Equal(suite.T(), &ClaimSimulationError{ChannelID: big.NewInt(42), Check: ClaimCheckAmount, Message: "authorized amount is zero, nothing to claim"}, err)
+}
+
+func (suite *ValidationTestSuite) TestSimulateClaimAmountExceedsFunds() {
+	channel := suite.claimableChannel()
+	channel.FullAmount = big.NewInt(12299)
+
+	err := SimulateClaim(channel)
+
+	assert.Equal(suite.T(), &ClaimSimulationError{ChannelID: big.NewInt(42), Check: ClaimCheckFunds, Message: "authorized amount 12300 exceeds channel amount 12299"}, err)
+}
+
+func (suite *ValidationTestSuite) TestSimulateClaimNonceIsNotSigned() {
+	channel := suite.claimableChannel()
+	channel.Nonce = big.NewInt(4)
+
+	err := SimulateClaim(channel)
+
+	assert.Equal(suite.T(), &ClaimSimulationError{ChannelID: big.NewInt(42), Check: ClaimCheckSignature,
+		Message: "authorized amount 12300 of channel 42 is not signed by channel signer/sender for nonce 4"}, err)
+}
+
+func (suite *ValidationTestSuite) TestSimulateClaimIsNotSigned() {
+	channel := suite.claimableChannel()
+	channel.Signature = nil
+
+	err := SimulateClaim(channel)
+
+	assert.Equal(suite.T(), "claim of channel 42 fails signature check: authorized amount is not signed", err.Error())
+}
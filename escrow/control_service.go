@@ -20,6 +20,12 @@ type ProviderControlService struct {
 	serviceMetaData *blockchain.ServiceMetadata
 	organizationMetaData *blockchain.OrganizationMetaData
 	mpeAddress common.Address
+	// operationLog is a history of accepted payments, nil if it is not
+	// recorded
+	operationLog *ChannelOperationLog
+	// claimIdempotency makes sure that claim of the channel generation is
+	// started once, nil if retried claims are not deduplicated
+	claimIdempotency *ClaimIdempotency
 }
 
 
@@ -39,13 +45,19 @@ func (service *BlockChainDisabledProviderControlService) StartClaim(ctx context.
 	return &PaymentReply{},nil
 }
 
+func (service *BlockChainDisabledProviderControlService) GetChannelOperationLog(ctx context.Context, request *GetChannelOperationLogRequest) (reply *ChannelOperationLogReply, err error) {
+	return &ChannelOperationLogReply{}, nil
+}
+
 func NewProviderControlService(channelService PaymentChannelService, serMetaData *blockchain.ServiceMetadata,
-	orgMetadata *blockchain.OrganizationMetaData) *ProviderControlService {
+	orgMetadata *blockchain.OrganizationMetaData, operationLog *ChannelOperationLog, claimIdempotency *ClaimIdempotency) *ProviderControlService {
 	return &ProviderControlService{
 		channelService:  channelService,
 		serviceMetaData: serMetaData,
 		organizationMetaData:orgMetadata,
 		mpeAddress: common.HexToAddress(serMetaData.MpeAddress),
+		operationLog: operationLog,
+		claimIdempotency: claimIdempotency,
 	}
 }
 
@@ -114,6 +126,9 @@ func (service *ProviderControlService) StartClaim(ctx context.Context, startClai
 	//Verify signature , check if “payment_address” matches to what is there in metadata
 	err = service.verifySignerForStartClaim(startClaim)
 	if err != nil {
+		if reply, ok := service.startedClaimReply(startClaim); ok {
+			return reply, nil
+		}
 		return nil, err
 	}
 	//Remove any payments already claimed on block chain
@@ -125,6 +140,51 @@ func (service *ProviderControlService) StartClaim(ctx context.Context, startClai
 	return service.beginClaimOnChannel(bytesToBigInt(startClaim.GetChannelId()))
 }
 
+//Get the history of payments accepted via the channel to resolve disputes about charges.
+//Verify that mpe_address is correct
+//Verify that actual block_number is not very different (+-5 blocks) from the current_block_number from the signature
+//Verify that message was signed by the service provider (“payment_address” in metadata should match to the signer).
+func (service *ProviderControlService) GetChannelOperationLog(ctx context.Context, request *GetChannelOperationLogRequest) (reply *ChannelOperationLogReply, err error) {
+	if err := service.checkMpeAddress(request.GetMpeAddress()); err != nil {
+		return nil, err
+	}
+	if err := authutils.CompareWithLatestBlockNumber(big.NewInt(int64(request.CurrentBlock))); err != nil {
+		return nil, err
+	}
+	if err := service.verifySignerForGetChannelOperationLog(request); err != nil {
+		return nil, err
+	}
+	if service.operationLog == nil {
+		return nil, fmt.Errorf("channel operation log is disabled")
+	}
+
+	operations, err := service.operationLog.Operations(bytesToBigInt(request.GetChannelId()))
+	if err != nil {
+		log.WithError(err).Error("unable to get channel operation log")
+		return nil, err
+	}
+	output := make([]*ChannelOperation, 0, len(operations))
+	for _, operation := range operations {
+		output = append(output, &ChannelOperation{
+			ChannelNonce: bigIntToBytes(operation.Nonce),
+			SignedAmount: bigIntToBytes(operation.Amount),
+			Timestamp:    operation.Timestamp.Unix(),
+		})
+	}
+	return &ChannelOperationLogReply{Operations: output}, nil
+}
+
+//message used to sign is of the form ("__get_channel_operation_log", mpe_address, channel_id, current_block_number)
+func (service *ProviderControlService) verifySignerForGetChannelOperationLog(request *GetChannelOperationLogRequest) error {
+	message := bytes.Join([][]byte{
+		[]byte("__get_channel_operation_log"),
+		service.serviceMetaData.GetMpeAddress().Bytes(),
+		bigIntToBytes(bytesToBigInt(request.GetChannelId())),
+		abi.U256(big.NewInt(int64(request.CurrentBlock))),
+	}, nil)
+	return service.verifySigner(message, request.GetSignature())
+}
+
 //get the list of channels in progress which have some amount to be claimed.
 func (service *ProviderControlService) listChannels() (*PaymentsListReply, error) {
 	//get the list of channels in progress which have some amount to be claimed.
@@ -189,18 +249,75 @@ func (service *ProviderControlService) beginClaimOnChannel(channelId *big.Int) (
 		err = fmt.Errorf("authorized amount is zero , hence nothing to claim on the channel Id: %v", channelId)
 		return nil, err
 	}
+	var payment *Payment
+	if service.claimIdempotency != nil {
+		payment, err = service.claimIdempotency.StartClaim(channelId, latestChannel.Nonce, func() (*Payment, error) {
+			return service.startClaimOnChannel(channelId, latestChannel.Nonce)
+		})
+	} else {
+		payment, err = service.startClaimOnChannel(channelId, latestChannel.Nonce)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return paymentReply(channelId, payment), nil
+}
+
+// startClaimOnChannel starts claim only if channel nonce is still equal to
+// the nonce the claim is requested for
+func (service *ProviderControlService) startClaimOnChannel(channelId *big.Int, nonce *big.Int) (*Payment, error) {
+	channel, ok, err := service.channelService.PaymentChannel(&PaymentChannelKey{ID: channelId})
+	if err != nil {
+		return nil, err
+	}
+	if !ok || channel.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("nonce of the channel %v is changed, claim for nonce %v is already started", channelId, nonce)
+	}
 	claim, err := service.channelService.StartClaim(&PaymentChannelKey{ID: channelId}, IncrementChannelNonce)
 	if err != nil {
 		return nil, err
 	}
-	payment := claim.Payment()
-	paymentReply := &PaymentReply{
+	return claim.Payment(), nil
+}
+
+func paymentReply(channelId *big.Int, payment *Payment) *PaymentReply {
+	reply := &PaymentReply{
 		ChannelId:    bigIntToBytes(channelId),
 		ChannelNonce: bigIntToBytes(payment.ChannelNonce),
 		Signature:    payment.Signature,
 		SignedAmount: bigIntToBytes(payment.Amount),
 	}
-	return paymentReply, nil
+	if payment.ActualAmount != nil {
+		reply.ActualAmount = bigIntToBytes(payment.ActualAmount)
+	}
+	return reply
+}
+
+// startedClaimReply returns the payment of the claim which is already
+// started when the request is a retry signed using the nonce before the
+// claim
+func (service *ProviderControlService) startedClaimReply(startClaim *StartClaimRequest) (*PaymentReply, bool) {
+	if service.claimIdempotency == nil {
+		return nil, false
+	}
+	channelId := bytesToBigInt(startClaim.GetChannelId())
+	latestChannel, ok, err := service.channelService.PaymentChannel(&PaymentChannelKey{ID: channelId})
+	if !ok || err != nil || latestChannel.Nonce.Sign() <= 0 {
+		return nil, false
+	}
+	previousNonce := new(big.Int).Sub(latestChannel.Nonce, big.NewInt(1))
+	if service.verifySigner(startClaimMessage(service.serviceMetaData.GetMpeAddress(), channelId, previousNonce), startClaim.Signature) != nil {
+		return nil, false
+	}
+	payment, ok, err := service.claimIdempotency.StartedClaim(channelId, previousNonce)
+	if err != nil {
+		log.WithError(err).WithField("channelId", channelId).Error("Unable to get started claim")
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	return paymentReply(channelId, payment), true
 }
 
 //Verify if the signer is same as the payment address in metadata
@@ -212,13 +329,17 @@ func (service *ProviderControlService) verifySignerForStartClaim(startClaim *Sta
 	if !ok || err != nil {
 		return err
 	}
-	message := bytes.Join([][]byte{
+	message := startClaimMessage(service.serviceMetaData.GetMpeAddress(), channelId, latestChannel.Nonce)
+	return service.verifySigner(message, signature)
+}
+
+func startClaimMessage(mpeAddress common.Address, channelId *big.Int, nonce *big.Int) []byte {
+	return bytes.Join([][]byte{
 		[]byte("__start_claim"),
-		service.serviceMetaData.GetMpeAddress().Bytes(),
+		mpeAddress.Bytes(),
 		bigIntToBytes(channelId),
-		bigIntToBytes(latestChannel.Nonce),
+		bigIntToBytes(nonce),
 	}, nil)
-	return service.verifySigner(message, signature)
 }
 
 func (service *ProviderControlService) listClaims() (*PaymentsListReply, error) {
@@ -236,13 +357,7 @@ func (service *ProviderControlService) listClaims() (*PaymentsListReply, error)
 				" Channel Id:%v , Nonce:%v", payment.ChannelID, payment.ChannelNonce)
 			continue
 		}
-		paymentReply := &PaymentReply{
-			ChannelId:    bigIntToBytes(payment.ChannelID),
-			ChannelNonce: bigIntToBytes(payment.ChannelNonce),
-			SignedAmount: bigIntToBytes(payment.Amount),
-			Signature:    payment.Signature,
-		}
-		output = append(output, paymentReply)
+		output = append(output, paymentReply(payment.ChannelID, payment))
 	}
 	reply := &PaymentsListReply{
 		Payments: output,
@@ -0,0 +1,202 @@
+package escrow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const channelSpendingMaxAttempts = 10
+
+// DiscountTier is a discount of the price for the channels which spent at
+// least MinSpend cogs in total.
+type DiscountTier struct {
+	// MinSpend is a cumulative spend of the channel in cogs the discount
+	// starts from
+	MinSpend uint64 `json:"min_spend" mapstructure:"min_spend"`
+	// Percent is a discount in percents of the price
+	Percent uint64 `json:"percent" mapstructure:"percent"`
+}
+
+// ValidateDiscountTiers returns error if discount schedule is incorrect
+func ValidateDiscountTiers(tiers []DiscountTier) error {
+	for _, tier := range tiers {
+		if tier.Percent > 100 {
+			return fmt.Errorf("incorrect discount tier %+v, percent should not exceed 100", tier)
+		}
+	}
+	return nil
+}
+
+// ChannelSpending keeps cumulative amount spent via each payment channel
+// across channel nonces. Spent amount is derived from the committed channel
+// state: together with the amount the record keeps the channel nonce and
+// authorized amount it was accounted for, so accounting the same state again
+// doesn't change it and state which failed to be accounted is caught up by
+// the next commit of the channel. Record is kept in the storage and updated
+// using compare and swap, so it is shared between replicas.
+type ChannelSpending struct {
+	storage AtomicStorage
+}
+
+type channelSpendingRecord struct {
+	Spent            *big.Int `json:"spent"`
+	Nonce            *big.Int `json:"nonce"`
+	AuthorizedAmount *big.Int `json:"authorized_amount"`
+}
+
+// NewChannelSpending returns new instance which keeps spent amounts in the
+// storage
+func NewChannelSpending(storage AtomicStorage, metadata *blockchain.ServiceMetadata) *ChannelSpending {
+	return &ChannelSpending{
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/spending",
+		},
+	}
+}
+
+// Spent returns amount spent via the channel, zero for the new channel
+func (spending *ChannelSpending) Spent(channelID *big.Int) (spent *big.Int, err error) {
+	record, _, err := spending.get(channelID)
+	if err != nil || record == nil {
+		return big.NewInt(0), err
+	}
+	return record.Spent, nil
+}
+
+// Account accounts committed state of the channel: authorized amount at the
+// nonce. Whole authorized amount is counted for the channel which was not
+// accounted before or when the nonce is incremented, amount authorized at
+// the previous nonce which was not accounted before the increment is not
+// counted.
+func (spending *ChannelSpending) Account(channelID *big.Int, nonce *big.Int, authorizedAmount *big.Int) error {
+	key := channelID.String()
+	for attempt := 0; attempt < channelSpendingMaxAttempts; attempt++ {
+		record, stored, err := spending.get(channelID)
+		if err != nil {
+			return err
+		}
+
+		spent := big.NewInt(0)
+		charged := authorizedAmount
+		if record != nil {
+			spent = record.Spent
+			switch record.Nonce.Cmp(nonce) {
+			case 0:
+				charged = new(big.Int).Sub(authorizedAmount, record.AuthorizedAmount)
+			case 1:
+				charged = big.NewInt(0)
+			}
+		}
+		if charged.Sign() <= 0 {
+			return nil
+		}
+
+		next, err := json.Marshal(&channelSpendingRecord{
+			Spent:            new(big.Int).Add(spent, charged),
+			Nonce:            nonce,
+			AuthorizedAmount: authorizedAmount,
+		})
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if record == nil {
+			ok, err = spending.storage.PutIfAbsent(key, string(next))
+		} else {
+			ok, err = spending.storage.CompareAndSwap(key, stored, string(next))
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.WithField("channelID", channelID).Debug("Channel spending is updated concurrently, retry")
+	}
+	return fmt.Errorf("cannot update spending of channel %v", channelID)
+}
+
+// get returns nil record for the new channel
+func (spending *ChannelSpending) get(channelID *big.Int) (record *channelSpendingRecord, stored string, err error) {
+	stored, ok, err := spending.storage.Get(channelID.String())
+	if err != nil || !ok {
+		return
+	}
+	record = &channelSpendingRecord{}
+	if err = json.Unmarshal([]byte(stored), record); err != nil || record.Spent == nil || record.Nonce == nil || record.AuthorizedAmount == nil {
+		return nil, "", fmt.Errorf("incorrect spending of channel %v: %v", channelID, stored)
+	}
+	return record, stored, nil
+}
+
+type discountIncomeValidator struct {
+	delegate IncomeValidator
+	tiers    []DiscountTier
+	spending *ChannelSpending
+}
+
+// NewDiscountIncomeValidator returns validator which expects the price of
+// the delegate reduced by the discount of the highest tier the channel
+// cumulative spend reaches. Discounted price is price * (100 - percent) /
+// 100 rounded down, so it is the same on each replica.
+func NewDiscountIncomeValidator(delegate IncomeValidator, tiers []DiscountTier, spending *ChannelSpending) IncomeValidator {
+	sorted := append([]DiscountTier(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].MinSpend < sorted[j].MinSpend })
+	return &discountIncomeValidator{
+		delegate: delegate,
+		tiers:    sorted,
+		spending: spending,
+	}
+}
+
+func (validator *discountIncomeValidator) Price(data *IncomeData) (price *big.Int, err error) {
+	price, err = validator.delegate.Price(data)
+	if err != nil || data.ChannelID == nil {
+		return
+	}
+
+	spent, err := validator.spending.Spent(data.ChannelID)
+	if err != nil {
+		log.WithError(err).WithField("channelID", data.ChannelID).Error("Unable to read channel spending")
+		return nil, NewPaymentError(Internal, "cannot read payment channel spending")
+	}
+	tier := validator.tier(spent)
+	if tier == nil {
+		return price, nil
+	}
+
+	discounted := new(big.Int).Mul(price, big.NewInt(int64(100-tier.Percent)))
+	discounted.Div(discounted, big.NewInt(100))
+	log.WithField("channelID", data.ChannelID).WithField("spent", spent).WithField("tier", *tier).
+		WithField("price", price).WithField("discountedPrice", discounted).Info("Discount is applied to the price")
+	return discounted, nil
+}
+
+// tier returns the highest tier reached by the spent amount, nil if no tier
+// is reached
+func (validator *discountIncomeValidator) tier(spent *big.Int) (tier *DiscountTier) {
+	for i := range validator.tiers {
+		if spent.Cmp(new(big.Int).SetUint64(validator.tiers[i].MinSpend)) >= 0 {
+			tier = &validator.tiers[i]
+		}
+	}
+	return
+}
+
+func (validator *discountIncomeValidator) Validate(data *IncomeData) (err error) {
+	price, err := validator.Price(data)
+	if err != nil {
+		return err
+	}
+	if data.Income.Cmp(price) != 0 {
+		return NewPaymentError(Unauthenticated, "income %d does not equal to price %d", data.Income, price)
+	}
+	return nil
+}
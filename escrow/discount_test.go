@@ -0,0 +1,115 @@
+package escrow
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/handler"
+)
+
+var testDiscountTiers = []DiscountTier{
+	{MinSpend: 10000, Percent: 20},
+	{MinSpend: 1000, Percent: 5},
+}
+
+func newTestChannelSpending(storage AtomicStorage) *ChannelSpending {
+	return NewChannelSpending(storage, &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+}
+
+func TestDiscountIncomeValidatorPrice(t *testing.T) {
+	spending := newTestChannelSpending(NewMemStorage())
+	validator := NewDiscountIncomeValidator(&incomeValidatorMockType{price: big.NewInt(999)}, testDiscountTiers, spending)
+	spending.Account(big.NewInt(1), big.NewInt(0), big.NewInt(999))
+	spending.Account(big.NewInt(2), big.NewInt(0), big.NewInt(1000))
+	spending.Account(big.NewInt(3), big.NewInt(0), big.NewInt(6000))
+	spending.Account(big.NewInt(3), big.NewInt(1), big.NewInt(6000))
+
+	priceNew, _ := validator.Price(&IncomeData{ChannelID: big.NewInt(0)})
+	priceBelowTier, _ := validator.Price(&IncomeData{ChannelID: big.NewInt(1)})
+	priceFirstTier, _ := validator.Price(&IncomeData{ChannelID: big.NewInt(2)})
+	priceSecondTier, _ := validator.Price(&IncomeData{ChannelID: big.NewInt(3)})
+	priceNoChannel, _ := validator.Price(&IncomeData{})
+
+	assert.Equal(t, big.NewInt(999), priceNew)
+	assert.Equal(t, big.NewInt(999), priceBelowTier)
+	assert.Equal(t, big.NewInt(949), priceFirstTier)
+	assert.Equal(t, big.NewInt(799), priceSecondTier)
+	assert.Equal(t, big.NewInt(999), priceNoChannel)
+}
+
+func TestChannelSpendingAccountsStateOnce(t *testing.T) {
+	spending := newTestChannelSpending(NewMemStorage())
+
+	spending.Account(big.NewInt(1), big.NewInt(0), big.NewInt(100))
+	spending.Account(big.NewInt(1), big.NewInt(0), big.NewInt(100))
+	spentSameState, _ := spending.Spent(big.NewInt(1))
+	spending.Account(big.NewInt(1), big.NewInt(0), big.NewInt(130))
+	spentMissedCommit, _ := spending.Spent(big.NewInt(1))
+	spending.Account(big.NewInt(1), big.NewInt(1), big.NewInt(20))
+	spentNextNonce, _ := spending.Spent(big.NewInt(1))
+	spending.Account(big.NewInt(1), big.NewInt(0), big.NewInt(200))
+	spentStaleNonce, err := spending.Spent(big.NewInt(1))
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), spentSameState)
+	assert.Equal(t, big.NewInt(130), spentMissedCommit)
+	assert.Equal(t, big.NewInt(150), spentNextNonce)
+	assert.Equal(t, big.NewInt(150), spentStaleNonce)
+}
+
+func TestValidateDiscountTiers(t *testing.T) {
+	assert.Nil(t, ValidateDiscountTiers(testDiscountTiers))
+	assert.Equal(t, errors.New("incorrect discount tier {MinSpend:100 Percent:101}, percent should not exceed 100"),
+		ValidateDiscountTiers([]DiscountTier{{MinSpend: 100, Percent: 101}}))
+}
+
+func (suite *PaymentChannelServiceSuite) discountPaymentHandler() *paymentChannelPaymentHandler {
+	spending := newTestChannelSpending(suite.memoryStorage)
+	return &paymentChannelPaymentHandler{
+		service:            suite.service,
+		mpeContractAddress: func() common.Address { return suite.mpeContractAddress },
+		incomeValidator:    NewDiscountIncomeValidator(&incomeValidatorMockType{price: big.NewInt(100)}, testDiscountTiers, spending),
+		spending:           spending,
+	}
+}
+
+func (suite *PaymentChannelServiceSuite) TestDiscountNewChannelPaysFullPrice() {
+	paymentHandler := suite.discountPaymentHandler()
+	suite.putStaleChannel(100)
+
+	_, errDiscounted := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(195)))
+	payment, errA := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(200)))
+	errB := paymentHandler.Complete(payment)
+	spent, errC := paymentHandler.spending.Spent(big.NewInt(42))
+
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "income 95 does not equal to price 100"), errDiscounted)
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	// channel which was not accounted before is charged its whole
+	// authorized amount
+	assert.Equal(suite.T(), big.NewInt(200), spent)
+}
+
+func (suite *PaymentChannelServiceSuite) TestDiscountHighVolumeChannelPaysDiscountedPrice() {
+	paymentHandler := suite.discountPaymentHandler()
+	paymentHandler.spending.Account(big.NewInt(42), big.NewInt(2), big.NewInt(9900))
+	paymentHandler.spending.Account(big.NewInt(42), big.NewInt(3), big.NewInt(100))
+	suite.putStaleChannel(100)
+
+	_, errFull := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(200)))
+	payment, errA := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(180)))
+	errB := paymentHandler.Complete(payment)
+	spent, _ := paymentHandler.spending.Spent(big.NewInt(42))
+
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "income 100 does not equal to price 80"), errFull)
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), big.NewInt(10080), spent)
+}
@@ -0,0 +1,169 @@
+package escrow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	drainingSlotKey          = "slot"
+	drainingSlotPollInterval = time.Second
+)
+
+// DrainingSlot coordinates graceful shutdown of the replicas which share the
+// storage, so only one replica is draining at a time during rolling upgrade.
+// Slot is a lease: it is kept in the storage as "<holder>|<expiration>", and
+// replica which crashed while draining doesn't block others longer than
+// leaseTTL. Lease is renewed while slot is held, so draining may take longer
+// than leaseTTL.
+type DrainingSlot struct {
+	storage      AtomicStorage
+	holder       string
+	leaseTTL     time.Duration
+	pollInterval time.Duration
+	now          func() time.Time
+	stopRenewal  chan struct{}
+}
+
+// NewDrainingSlot returns draining slot shared by all replicas which use the
+// same storage and MPE contract. holder should be unique for each replica.
+func NewDrainingSlot(storage AtomicStorage, metadata *blockchain.ServiceMetadata, holder string, leaseTTL time.Duration) *DrainingSlot {
+	return &DrainingSlot{
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/daemon/draining",
+		},
+		holder:       holder,
+		leaseTTL:     leaseTTL,
+		pollInterval: drainingSlotPollInterval,
+		now:          time.Now,
+	}
+}
+
+// Acquire waits until slot is free and takes it. acquired is false if slot
+// was not taken during timeout.
+func (slot *DrainingSlot) Acquire(timeout time.Duration) (acquired bool, err error) {
+	deadline := slot.now().Add(timeout)
+	for {
+		acquired, err = slot.tryAcquire()
+		if err != nil {
+			return
+		}
+		if acquired {
+			slot.keepAlive()
+			return
+		}
+		if !slot.now().Before(deadline) {
+			return false, nil
+		}
+		log.WithField("holder", slot.holder).Debug("Draining slot is held by another replica, wait")
+		time.Sleep(slot.pollInterval)
+	}
+}
+
+func (slot *DrainingSlot) tryAcquire() (acquired bool, err error) {
+	newValue := formatDrainingSlot(slot.holder, slot.now().Add(slot.leaseTTL))
+
+	value, ok, err := slot.storage.Get(drainingSlotKey)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return slot.storage.PutIfAbsent(drainingSlotKey, newValue)
+	}
+
+	holder, expiration, err := parseDrainingSlot(value)
+	if err != nil {
+		log.WithError(err).WithField("value", value).Warn("Incorrect draining slot value in storage, reset it")
+	} else if holder != "" && holder != slot.holder && slot.now().Before(expiration) {
+		return false, nil
+	}
+	return slot.storage.CompareAndSwap(drainingSlotKey, value, newValue)
+}
+
+// keepAlive renews the lease each third of leaseTTL until slot is released
+// or taken by another replica
+func (slot *DrainingSlot) keepAlive() {
+	interval := slot.leaseTTL / 3
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	slot.stopRenewal = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renewed, err := slot.renew()
+				if err != nil {
+					log.WithError(err).Warn("Unable to renew draining slot lease")
+					continue
+				}
+				if !renewed {
+					log.WithField("holder", slot.holder).Warn("Draining slot is not held by this replica anymore, stop renewing lease")
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (slot *DrainingSlot) renew() (renewed bool, err error) {
+	value, ok, err := slot.storage.Get(drainingSlotKey)
+	if err != nil || !ok {
+		return
+	}
+	if holder, _, e := parseDrainingSlot(value); e != nil || holder != slot.holder {
+		return false, nil
+	}
+	return slot.storage.CompareAndSwap(drainingSlotKey, value, formatDrainingSlot(slot.holder, slot.now().Add(slot.leaseTTL)))
+}
+
+// Release stops lease renewal and frees the slot if it is still held by this
+// replica
+func (slot *DrainingSlot) Release() (err error) {
+	if slot.stopRenewal != nil {
+		close(slot.stopRenewal)
+		slot.stopRenewal = nil
+	}
+
+	value, ok, err := slot.storage.Get(drainingSlotKey)
+	if err != nil || !ok {
+		return
+	}
+	if holder, _, e := parseDrainingSlot(value); e != nil || holder != slot.holder {
+		log.WithField("holder", slot.holder).Warn("Draining slot is not held by this replica anymore")
+		return nil
+	}
+	_, err = slot.storage.CompareAndSwap(drainingSlotKey, value, "")
+	return
+}
+
+func formatDrainingSlot(holder string, expiration time.Time) string {
+	return fmt.Sprintf("%v|%v", holder, expiration.UnixNano())
+}
+
+func parseDrainingSlot(value string) (holder string, expiration time.Time, err error) {
+	if value == "" {
+		return
+	}
+	separator := strings.LastIndex(value, "|")
+	if separator < 0 {
+		return "", time.Time{}, fmt.Errorf("unexpected draining slot format: %v", value)
+	}
+	nanos, err := strconv.ParseInt(value[separator+1:], 10, 64)
+	if err != nil {
+		return
+	}
+	return value[:separator], time.Unix(0, nanos), nil
+}
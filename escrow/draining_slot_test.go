@@ -0,0 +1,103 @@
+package escrow
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+var drainingSlotTestMetadata = &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+
+func newTestDrainingSlot(storage AtomicStorage, holder string) *DrainingSlot {
+	slot := NewDrainingSlot(storage, drainingSlotTestMetadata, holder, time.Minute)
+	slot.pollInterval = time.Millisecond
+	return slot
+}
+
+func TestDrainingSlotSerializesReplicas(t *testing.T) {
+	storage := NewMemStorage()
+	replicaA := newTestDrainingSlot(storage, "replica-a")
+	replicaB := newTestDrainingSlot(storage, "replica-b")
+	var mutex sync.Mutex
+	events := make([]string, 0)
+	addEvent := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+
+	acquiredA, errA := replicaA.Acquire(time.Second)
+	addEvent("a drains")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var acquiredB bool
+	var errB error
+	go func() {
+		defer wg.Done()
+		acquiredB, errB = replicaB.Acquire(time.Second)
+		addEvent("b drains")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	addEvent("a is stopped")
+	errRelease := replicaA.Release()
+	wg.Wait()
+
+	assert.Nil(t, errA)
+	assert.Nil(t, errB)
+	assert.Nil(t, errRelease)
+	assert.True(t, acquiredA)
+	assert.True(t, acquiredB)
+	assert.Equal(t, []string{"a drains", "a is stopped", "b drains"}, events)
+}
+
+func TestDrainingSlotTimeout(t *testing.T) {
+	storage := NewMemStorage()
+	replicaA := newTestDrainingSlot(storage, "replica-a")
+	replicaB := newTestDrainingSlot(storage, "replica-b")
+
+	replicaA.Acquire(time.Second)
+	acquired, err := replicaB.Acquire(10 * time.Millisecond)
+
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+}
+
+func TestDrainingSlotExpiredLease(t *testing.T) {
+	storage := NewMemStorage()
+	now := time.Unix(1000, 0)
+	replicaA := newTestDrainingSlot(storage, "replica-a")
+	replicaA.now = func() time.Time { return now }
+	replicaB := newTestDrainingSlot(storage, "replica-b")
+	replicaB.now = func() time.Time { return now }
+
+	acquiredA, _ := replicaA.Acquire(0)
+	now = now.Add(time.Minute)
+	acquiredB, errB := replicaB.Acquire(0)
+	errRelease := replicaA.Release()
+	value, _, _ := storage.Get("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/daemon/draining/slot")
+
+	assert.True(t, acquiredA)
+	assert.Nil(t, errB)
+	assert.True(t, acquiredB)
+	assert.Nil(t, errRelease)
+	assert.Equal(t, "replica-b|1120000000000", value)
+}
+
+func TestDrainingSlotLeaseIsRenewed(t *testing.T) {
+	storage := NewMemStorage()
+	replicaA := NewDrainingSlot(storage, drainingSlotTestMetadata, "replica-a", 60*time.Millisecond)
+	replicaB := newTestDrainingSlot(storage, "replica-b")
+
+	acquiredA, _ := replicaA.Acquire(0)
+	acquiredB, errB := replicaB.Acquire(200 * time.Millisecond)
+	errRelease := replicaA.Release()
+
+	assert.True(t, acquiredA)
+	assert.Nil(t, errB)
+	assert.False(t, acquiredB)
+	assert.Nil(t, errRelease)
+}
@@ -1,8 +1,15 @@
 package escrow
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/config"
+	"github.com/singnet/snet-daemon/metrics"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
 )
 
 // lockingPaymentChannelService implements PaymentChannelService interface
@@ -15,16 +22,35 @@ type lockingPaymentChannelService struct {
 	locker           Locker
 	validator        *ChannelPaymentValidator
 	replicaGroupID   func() ([32]byte, error)
+	// checkClaimSignature enables verification that stored signature
+	// authorizes stored amount before claim is started
+	checkClaimSignature bool
+	// operationLog records accepted payments, nil if log is disabled
+	operationLog *ChannelOperationLog
+	// shutdown tracks in-flight payment transactions, nil disables
+	// tracking
+	shutdown *ShutdownCoordinator
+	// readOnly refuses payments which change channel state when it is
+	// enabled, nil means that daemon is never read-only
+	readOnly *ReadOnlyMode
+	// persistHooks are called around writing of the updated channel state
+	// on each commit, nil if there are no hooks
+	persistHooks *PersistHooks
 }
 
 // NewPaymentChannelService returns instance of PaymentChannelService to work
-// with payments via MultiPartyEscrow contract.
+// with payments via MultiPartyEscrow contract. persistHooks can be nil if no
+// hooks are registered.
 func NewPaymentChannelService(
 	storage *PaymentChannelStorage,
 	paymentStorage *PaymentStorage,
 	blockchainReader *BlockchainChannelReader,
 	locker Locker,
-	channelPaymentValidator *ChannelPaymentValidator, groupIdReader func() ([32]byte, error)) PaymentChannelService {
+	channelPaymentValidator *ChannelPaymentValidator, groupIdReader func() ([32]byte, error),
+	operationLog *ChannelOperationLog,
+	shutdown *ShutdownCoordinator,
+	readOnly *ReadOnlyMode,
+	persistHooks *PersistHooks) PaymentChannelService {
 
 	return &lockingPaymentChannelService{
 		storage:          storage,
@@ -33,6 +59,12 @@ func NewPaymentChannelService(
 		locker:           locker,
 		validator:        channelPaymentValidator,
 		replicaGroupID:   groupIdReader,
+		operationLog:     operationLog,
+		shutdown:         shutdown,
+		readOnly:         readOnly,
+		persistHooks:     persistHooks,
+
+		checkClaimSignature: config.GetBool(config.PaymentChannelClaimSignatureCheckEnabled),
 	}
 }
 
@@ -41,10 +73,22 @@ func (h *lockingPaymentChannelService) PaymentChannelFromBlockChain(key *Payment
 }
 
 func (h *lockingPaymentChannelService) PaymentChannel(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error) {
-	storageChannel, storageOk, err := h.storage.Get(key)
+	channel, _, ok, err = h.paymentChannel(key, h.storage.Get)
+	return
+}
+
+// paymentChannel merges channel state read from the storage by get and
+// channel state from blockchain, stored is the state read from the storage
+// or nil if channel is not stored yet
+func (h *lockingPaymentChannelService) paymentChannel(key *PaymentChannelKey, get func(key *PaymentChannelKey) (*PaymentChannelData, bool, error)) (channel *PaymentChannelData, stored *PaymentChannelData, ok bool, err error) {
+	storageChannel, storageOk, err := get(key)
 	if err != nil {
 		return
 	}
+	if storageOk {
+		copied := *storageChannel
+		stored = &copied
+	}
 
 	blockchainChannel, blockchainOk, err := h.blockchainReader.GetChannelStateFromBlockchain(key)
 
@@ -54,16 +98,46 @@ func (h *lockingPaymentChannelService) PaymentChannel(key *PaymentChannelKey) (c
 		if blockchainChannel != nil {
 			blockChainGroupID, err := h.replicaGroupID()
 			if err = h.verifyGroupId(blockChainGroupID, blockchainChannel.GroupID); err != nil {
-				return nil, false, err
+				return nil, nil, false, err
 			}
 		}
-		return blockchainChannel, blockchainOk, err
+		return blockchainChannel, nil, blockchainOk, err
 	}
 	if err != nil || !blockchainOk {
-		return storageChannel, storageOk, nil
+		return storageChannel, stored, storageOk, nil
 	}
 
-	return MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel), true, nil
+	return MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel), stored, true, nil
+}
+
+// RefreshChannelState re-reads the channel from blockchain and updates
+// nonce, full amount and expiration of the stored channel. When on-chain
+// nonce is ahead of the stored one the channel was claimed, so stored state
+// is replaced by the on-chain one. Caller should hold the channel lock.
+func (h *lockingPaymentChannelService) RefreshChannelState(channelID *big.Int) (channel *PaymentChannelData, err error) {
+	key := &PaymentChannelKey{ID: channelID}
+	blockchainChannel, ok, err := h.blockchainReader.GetChannelStateFromBlockchain(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("payment channel %v is not found on blockchain", channelID)
+	}
+
+	storageChannel, ok, err := h.storage.GetLatest(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return blockchainChannel, nil
+	}
+
+	channel = MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel)
+	if err = h.storage.UpdateChannel(key, storageChannel, channel); err != nil {
+		return nil, err
+	}
+	log.WithField("channelID", channelID).WithField("nonce", channel.Nonce).WithField("fullAmount", channel.FullAmount).Debug("Payment channel state is refreshed from blockchain")
+	return channel, nil
 }
 
 //Check if the channel belongs to the same group Id
@@ -107,7 +181,7 @@ func (h *lockingPaymentChannelService) StartClaim(key *PaymentChannelKey, update
 		}
 	}()
 
-	channel, ok, err := h.storage.Get(key)
+	channel, ok, err := h.storage.GetLatest(key)
 	if err != nil {
 		return
 	}
@@ -115,6 +189,12 @@ func (h *lockingPaymentChannelService) StartClaim(key *PaymentChannelKey, update
 		return nil, fmt.Errorf("Channel is not found by key: %v", key)
 	}
 
+	if h.checkClaimSignature {
+		if err = verifyClaimSignature(channel); err != nil {
+			return nil, err
+		}
+	}
+
 	nextChannel := *channel
 	update(&nextChannel)
 
@@ -155,22 +235,62 @@ func (h *lockingPaymentChannelService) ListClaims() (claims []Claim, err error)
 	return
 }
 
+// verifyClaimSignature checks that authorized amount and nonce stored in the
+// channel are signed by the channel signer or sender, so amount which is
+// written incorrectly is never claimed.
+func verifyClaimSignature(channel *PaymentChannelData) error {
+	signer, err := getSignerAddressFromPayment(getPaymentFromChannel(channel))
+	if err == nil && (*signer == channel.Signer || *signer == channel.Sender) {
+		return nil
+	}
+
+	entry := log.WithField("channel", channel)
+	if signer != nil {
+		entry = entry.WithField("signerAddress", blockchain.AddressToHex(signer))
+	}
+	entry.Error("Stored authorized amount is not backed by a valid signature, claim is refused")
+	return fmt.Errorf("authorized amount %v of channel %v is not signed by channel signer/sender for nonce %v", channel.AuthorizedAmount, channel.ChannelID, channel.Nonce)
+}
+
+// getPaymentFromChannel returns the last payment of the channel, when the
+// last call was charged less than client signed the payment amount is the
+// signed amount and the authorized amount is the amount to claim
 func getPaymentFromChannel(channel *PaymentChannelData) *Payment {
-	return &Payment{
-		// TODO: add MpeContractAddress to channel state
-		//MpeContractAddress: channel.MpeContractAddress,
-		ChannelID:    channel.ChannelID,
-		ChannelNonce: channel.Nonce,
-		Amount:       channel.AuthorizedAmount,
-		Signature:    channel.Signature,
+	payment := &Payment{
+		MpeContractAddress: channel.MpeContractAddress,
+		ChannelID:          channel.ChannelID,
+		ChannelNonce:       channel.Nonce,
+		Amount:             channel.AuthorizedAmount,
+		Signature:          channel.Signature,
+		DaemonId:           channel.DaemonId,
+		MessageType:        channel.MessageType,
+	}
+	if channel.MessageType == PaymentMessageTypeV2 {
+		payment.GroupID = channel.GroupID
+	}
+	if channel.SignedAmount != nil {
+		payment.Amount = channel.SignedAmount
+		payment.ActualAmount = channel.AuthorizedAmount
 	}
+	return payment
 }
 
 type paymentTransaction struct {
 	payment Payment
 	channel *PaymentChannelData
+	// stored is the channel state in the storage which is replaced on
+	// commit, nil if channel is not stored yet
+	stored  *PaymentChannelData
 	service *lockingPaymentChannelService
 	lock    Lock
+	// trailer is added to the response, it contains validation
+	// warnings
+	trailer metadata.MD
+	// done is called when transaction is finished, can be nil
+	done func()
+	// readOnly is set when transaction is started in read-only mode, such
+	// transaction replays the authorized state of the channel
+	readOnly bool
 }
 
 func (payment *paymentTransaction) String() string {
@@ -181,9 +301,27 @@ func (payment *paymentTransaction) Channel() *PaymentChannelData {
 	return payment.channel
 }
 
-func (h *lockingPaymentChannelService) StartPaymentTransaction(payment *Payment) (transaction PaymentTransaction, err error) {
+// Trailer implements handler.TrailerProvider
+func (payment *paymentTransaction) Trailer() metadata.MD {
+	return payment.trailer
+}
+
+func (h *lockingPaymentChannelService) StartPaymentTransaction(ctx context.Context, payment *Payment) (transaction PaymentTransaction, err error) {
 	channelKey := &PaymentChannelKey{ID: payment.ChannelID}
 
+	var done func()
+	if h.shutdown != nil {
+		if done, err = h.shutdown.Begin(); err != nil {
+			log.WithField("payment", payment).Info("Payment is refused because daemon is shutting down")
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				done()
+			}
+		}()
+	}
+
 	lock, ok, err := h.locker.Lock(channelKey.String())
 	if err != nil {
 		return nil, NewPaymentError(Internal, "cannot get mutex for channel: %v", channelKey)
@@ -200,7 +338,8 @@ func (h *lockingPaymentChannelService) StartPaymentTransaction(payment *Payment)
 		}
 	}(lock)
 
-	channel, ok, err := h.PaymentChannel(channelKey)
+	// cached state can be stale, so the actual one is read under the lock
+	channel, stored, ok, err := h.paymentChannel(channelKey, h.storage.GetLatest)
 	if err != nil {
 		return nil, NewPaymentError(Internal, "payment channel error:"+err.Error())
 	}
@@ -209,20 +348,59 @@ func (h *lockingPaymentChannelService) StartPaymentTransaction(payment *Payment)
 		return nil, NewPaymentError(Unauthenticated, "payment channel \"%v\" not found", channelKey)
 	}
 
-	err = h.validator.Validate(payment, channel)
+	// in read-only mode only the replay of the authorized state is served,
+	// payment with the newer nonce would refresh the stored channel
+	readOnly := h.readOnly.Enabled()
+	if readOnly && (payment.Amount.Cmp(channel.AuthorizedAmount) != 0 || payment.ChannelNonce.Cmp(channel.Nonce) > 0) {
+		log.WithField("payment", payment).Info("Payment is refused because daemon is in read-only mode")
+		return nil, errReadOnly()
+	}
+
+	nonce := channel.Nonce
+	result, err := h.validator.validateWithWarnings(ctx, payment, channel, readOnly)
 	if err != nil {
 		return
 	}
+	// validator refreshes the channel when payment nonce is ahead, refreshed
+	// state is written to the storage if the channel is stored
+	if stored != nil && channel.Nonce.Cmp(nonce) != 0 {
+		refreshed := *channel
+		stored = &refreshed
+	}
 
 	return &paymentTransaction{
-		payment: *payment,
-		channel: channel,
-		lock:    lock,
-		service: h,
+		payment:  *payment,
+		channel:  channel,
+		stored:   stored,
+		lock:     lock,
+		service:  h,
+		trailer:  result.Trailer(),
+		done:     done,
+		readOnly: readOnly,
 	}, nil
 }
 
 func (payment *paymentTransaction) Commit() error {
+	return payment.commit(payment.payment.Amount)
+}
+
+// income returns amount authorized by the payment for the call
+func (payment *paymentTransaction) income() *big.Int {
+	return new(big.Int).Sub(payment.payment.Amount, payment.channel.AuthorizedAmount)
+}
+
+// commitCharge commits the payment charging only the part of the amount
+// authorized for the call, charge should not exceed income
+func (payment *paymentTransaction) commitCharge(charge *big.Int) error {
+	return payment.commit(new(big.Int).Add(payment.channel.AuthorizedAmount, charge))
+}
+
+// commit stores authorized amount which can be less than the payment
+// amount, in such case the payment amount is kept as signed amount to claim
+// the authorized amount using the payment signature. Persist hooks of the
+// service are called around the write.
+func (payment *paymentTransaction) commit(authorizedAmount *big.Int) error {
+	defer payment.finish()
 	defer func(payment *paymentTransaction) {
 		err := payment.lock.Unlock()
 		if err != nil {
@@ -232,32 +410,72 @@ func (payment *paymentTransaction) Commit() error {
 		}
 	}(payment)
 
-	e := payment.service.storage.Put(
-		&PaymentChannelKey{ID: payment.payment.ChannelID},
-		&PaymentChannelData{
-			ChannelID:        payment.channel.ChannelID,
-			Nonce:            payment.channel.Nonce,
-			State:            payment.channel.State,
-			Sender:           payment.channel.Sender,
-			Recipient:        payment.channel.Recipient,
-			FullAmount:       payment.channel.FullAmount,
-			Expiration:       payment.channel.Expiration,
-			Signer:           payment.channel.Signer,
-			AuthorizedAmount: payment.payment.Amount,
-			Signature:        payment.payment.Signature,
-			GroupID:          payment.channel.GroupID,
-		},
-	)
+	// read-only mode can be switched on while transaction is in progress
+	if payment.service.readOnly.Enabled() {
+		if authorizedAmount.Cmp(payment.channel.AuthorizedAmount) != 0 {
+			log.WithField("payment", payment).Warn("Payment channel state is not stored because daemon is in read-only mode")
+			return errReadOnly()
+		}
+		log.Debug("Payment completed without update in read-only mode")
+		return nil
+	}
+
+	var signedAmount *big.Int
+	if authorizedAmount.Cmp(payment.payment.Amount) != 0 {
+		signedAmount = payment.payment.Amount
+	}
+	updated := &PaymentChannelData{
+		MpeContractAddress: payment.channel.MpeContractAddress,
+		ChannelID:          payment.channel.ChannelID,
+		Nonce:              payment.channel.Nonce,
+		State:              payment.channel.State,
+		Sender:             payment.channel.Sender,
+		Recipient:          payment.channel.Recipient,
+		FullAmount:         payment.channel.FullAmount,
+		Expiration:         payment.channel.Expiration,
+		Signer:             payment.channel.Signer,
+		AuthorizedAmount:   authorizedAmount,
+		SignedAmount:       signedAmount,
+		Signature:          payment.payment.Signature,
+		DaemonId:           payment.payment.DaemonId,
+		GroupID:            payment.channel.GroupID,
+		MessageType:        payment.payment.MessageType,
+	}
+	if e := payment.service.persistHooks.beforePersist(&payment.payment, payment.channel, updated); e != nil {
+		log.WithError(e).WithField("payment", payment).Error("Payment channel state is not stored because pre-persist hook failed")
+		return NewPaymentError(Internal, "unable to store new payment channel state")
+	}
+	// lock can expire or be broken, so the state is replaced only if it is
+	// not changed since it was read
+	key := &PaymentChannelKey{ID: payment.payment.ChannelID}
+	var ok bool
+	var e error
+	if payment.stored == nil {
+		ok, e = payment.service.storage.PutIfAbsent(key, updated)
+	} else {
+		ok, e = payment.service.storage.CompareAndSwap(key, payment.stored, updated)
+	}
 	if e != nil {
 		log.WithError(e).Error("Unable to store new payment channel state")
 		return NewPaymentError(Internal, "unable to store new payment channel state")
 	}
+	if !ok {
+		log.WithField("payment", payment).Error("Payment channel state is not stored because it was changed concurrently")
+		return NewPaymentError(FailedPrecondition, "payment channel state was changed concurrently")
+	}
+	payment.service.persistHooks.afterPersist(&payment.payment, payment.channel, updated)
+	payment.service.validator.paymentCommitted(&payment.payment)
 
+	metrics.Revenue().Add(new(big.Int).Sub(authorizedAmount, payment.channel.AuthorizedAmount))
+	if payment.service.operationLog != nil {
+		payment.service.operationLog.Record(payment.payment.ChannelID, payment.payment.ChannelNonce, authorizedAmount)
+	}
 	log.Debug("Payment completed")
 	return nil
 }
 
 func (payment *paymentTransaction) Rollback() error {
+	defer payment.finish()
 	defer func(payment *paymentTransaction) {
 		err := payment.lock.Unlock()
 		if err != nil {
@@ -268,3 +486,10 @@ func (payment *paymentTransaction) Rollback() error {
 	}(payment)
 	return nil
 }
+
+// finish notifies shutdown coordinator that transaction is finished
+func (payment *paymentTransaction) finish() {
+	if payment.done != nil {
+		payment.done()
+	}
+}
@@ -1,18 +1,23 @@
 package escrow
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/handler"
 )
 
 type paymentChannelServiceMock struct {
@@ -48,7 +53,7 @@ func (p *paymentChannelServiceMock) Clear() {
 	p.err = nil
 }
 
-func (p *paymentChannelServiceMock) StartPaymentTransaction(payment *Payment) (PaymentTransaction, error) {
+func (p *paymentChannelServiceMock) StartPaymentTransaction(ctx context.Context, payment *Payment) (PaymentTransaction, error) {
 	if p.err != nil {
 		return nil, p.err
 	}
@@ -117,19 +122,27 @@ func (suite *PaymentChannelServiceSuite) SetupSuite() {
 			recipientPaymentAddress: func() common.Address {
 				return suite.recipientAddress
 			},
+			mpeContractAddress: func() common.Address {
+				return suite.mpeContractAddress
+			},
 		},
 		NewEtcdLocker(suite.memoryStorage,&blockchain.ServiceMetadata{MpeAddress:"0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}),
 		&ChannelPaymentValidator{
-			currentBlock:               func() (*big.Int, error) { return big.NewInt(99), nil },
+			blockProvider:              &FixedBlockProvider{Block: big.NewInt(99)},
 			paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) },
 		}, func() ([32]byte, error) {
 			return [32]byte{123}, nil
 		},
+		nil,
+		nil,
+		NewReadOnlyMode(false),
+		nil,
 	)
 }
 
 func (suite *PaymentChannelServiceSuite) SetupTest() {
 	suite.memoryStorage.Clear()
+	suite.service.(*lockingPaymentChannelService).persistHooks = nil
 }
 
 func TestPaymentChannelServiceSuite(t *testing.T) {
@@ -153,7 +166,7 @@ func (suite *PaymentChannelServiceSuite) payment() *Payment {
 		Amount:       big.NewInt(12300),
 		ChannelID:    big.NewInt(42),
 		ChannelNonce: big.NewInt(3),
-		//MpeContractAddress: suite.mpeContractAddress,
+		MpeContractAddress: suite.mpeContractAddress,
 	}
 	SignTestPayment(payment, suite.signerPrivateKey)
 	return payment
@@ -167,16 +180,17 @@ func (suite *PaymentChannelServiceSuite) channelKey() *PaymentChannelKey {
 
 func (suite *PaymentChannelServiceSuite) channel() *PaymentChannelData {
 	return &PaymentChannelData{
-		ChannelID:        big.NewInt(42),
-		Nonce:            big.NewInt(3),
-		Sender:           suite.senderAddress,
-		Recipient:        suite.recipientAddress,
-		GroupID:          [32]byte{123},
-		FullAmount:       big.NewInt(12345),
-		Expiration:       big.NewInt(100),
-		Signer:           suite.signerAddress,
-		AuthorizedAmount: big.NewInt(0),
-		Signature:        nil,
+		MpeContractAddress: suite.mpeContractAddress,
+		ChannelID:          big.NewInt(42),
+		Nonce:              big.NewInt(3),
+		Sender:             suite.senderAddress,
+		Recipient:          suite.recipientAddress,
+		GroupID:            [32]byte{123},
+		FullAmount:         big.NewInt(12345),
+		Expiration:         big.NewInt(100),
+		Signer:             suite.signerAddress,
+		AuthorizedAmount:   big.NewInt(0),
+		Signature:          nil,
 	}
 }
 
@@ -190,7 +204,7 @@ func (suite *PaymentChannelServiceSuite) channelPlusPayment(payment *Payment) *P
 func (suite *PaymentChannelServiceSuite) TestPaymentTransaction() {
 	payment := suite.payment()
 
-	transaction, errA := suite.service.StartPaymentTransaction(payment)
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), payment)
 	errB := transaction.Commit()
 	channel, ok, errC := suite.storage.Get(suite.channelKey())
 
@@ -209,8 +223,8 @@ func (suite *PaymentChannelServiceSuite) TestPaymentParallelTransaction() {
 	paymentB.Amount = big.NewInt(17)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errC := transactionA.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -231,9 +245,9 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransaction() {
 	paymentB.Amount = big.NewInt(17)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
 	errAC := transactionA.Commit()
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errBC := transactionB.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -254,9 +268,9 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransactionAfterRo
 	paymentB.Amount = big.NewInt(13)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
 	errAC := transactionA.Rollback()
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errBC := transactionB.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -269,8 +283,223 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransactionAfterRo
 	assert.Equal(suite.T(), suite.channelPlusPayment(paymentB), channel)
 }
 
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionExpiryWarning() {
+	validator := suite.service.(*lockingPaymentChannelService).validator
+	validator.expiryWarningBlocks = big.NewInt(5)
+	defer func() { validator.expiryWarningBlocks = nil }()
+
+	transaction, err := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	transaction.Rollback()
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), metadata.Pairs(
+		handler.PaymentChannelExpirationTrailer, "100",
+		handler.PaymentChannelRemainingBlocksTrailer, "1",
+	), transaction.(*paymentTransaction).Trailer())
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionNoExpiryWarning() {
+	transaction, err := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	transaction.Rollback()
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Nil(suite.T(), transaction.(*paymentTransaction).Trailer())
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionReadOnlyNoIncrement() {
+	authorized := suite.payment()
+	authorized.Amount = big.NewInt(13)
+	SignTestPayment(authorized, suite.signerPrivateKey)
+	suite.storage.Put(suite.channelKey(), suite.channelPlusPayment(authorized))
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), authorized)
+	errB := transaction.Commit()
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channelPlusPayment(authorized), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionReadOnlyNoIncrementWithReplayCache() {
+	validator := suite.service.(*lockingPaymentChannelService).validator
+	validator.replayCache = NewReplayCache(10, time.Minute)
+	defer func() { validator.replayCache = nil }()
+	suite.storage.Put(suite.channelKey(), suite.channel())
+	committed, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	errB := committed.Commit()
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+
+	transaction, errC := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	errD := transaction.Commit()
+	channel, ok, errE := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Nil(suite.T(), errD, "Unexpected error: %v", errD)
+	assert.Nil(suite.T(), errE, "Unexpected error: %v", errE)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), big.NewInt(12300), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionReadOnlyNoIncrementWithMinIncrement() {
+	validator := suite.service.(*lockingPaymentChannelService).validator
+	validator.minPaymentIncrement = big.NewInt(10)
+	defer func() { validator.minPaymentIncrement = nil }()
+	authorized := suite.payment()
+	authorized.Amount = big.NewInt(13)
+	SignTestPayment(authorized, suite.signerPrivateKey)
+	suite.storage.Put(suite.channelKey(), suite.channelPlusPayment(authorized))
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), authorized)
+	errB := transaction.Commit()
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channelPlusPayment(authorized), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionReadOnlyIncrement() {
+	authorized := suite.payment()
+	authorized.Amount = big.NewInt(13)
+	SignTestPayment(authorized, suite.signerPrivateKey)
+	suite.storage.Put(suite.channelKey(), suite.channelPlusPayment(authorized))
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	channel, ok, errB := suite.storage.Get(suite.channelKey())
+
+	assert.Equal(suite.T(), NewPaymentError(Unavailable, "daemon in read-only mode"), errA)
+	assert.Nil(suite.T(), transaction)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channelPlusPayment(authorized), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionReadOnlyAfterStart() {
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+
+	errB := transaction.Commit()
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), NewPaymentError(Unavailable, "daemon in read-only mode"), errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.False(suite.T(), ok)
+	assert.Nil(suite.T(), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHandlerServesReplayInReadOnlyMode() {
+	suite.putStaleChannel(100)
+	readOnly := suite.service.(*lockingPaymentChannelService).readOnly
+	readOnly.Set(true)
+	defer readOnly.Set(false)
+	paymentHandler := suite.meteringPaymentHandler(10, nil)
+
+	transaction, errA := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(100)))
+	errB := paymentHandler.Complete(transaction)
+	_, errC := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(110)))
+	channel, _, errD := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unavailable, "daemon in read-only mode"), errC)
+	assert.Nil(suite.T(), errD, "Unexpected error: %v", errD)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionContextCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, errA := suite.service.StartPaymentTransaction(ctx, suite.payment())
+	transaction, errB := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+
+	assert.Equal(suite.T(), NewPaymentError(DeadlineExceeded, "payment validation is stopped: context canceled"), errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	transaction.Rollback()
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionChannelChangedConcurrently() {
+	suite.storage.Put(suite.channelKey(), suite.channel())
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	changed := suite.channel()
+	changed.AuthorizedAmount = big.NewInt(7)
+	suite.storage.Put(suite.channelKey(), changed)
+
+	errB := transaction.Commit()
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), NewPaymentError(FailedPrecondition, "payment channel state was changed concurrently"), errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), changed, channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionChannelAddedConcurrently() {
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	suite.storage.Put(suite.channelKey(), suite.channel())
+
+	errB := transaction.Commit()
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), NewPaymentError(FailedPrecondition, "payment channel state was changed concurrently"), errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channel(), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestRefreshChannelStateAfterClaim() {
+	stale := suite.channel()
+	stale.Nonce = big.NewInt(2)
+	stale.FullAmount = big.NewInt(1000)
+	stale.AuthorizedAmount = big.NewInt(100)
+	suite.storage.Put(suite.channelKey(), stale)
+
+	channel, errA := suite.service.RefreshChannelState(big.NewInt(42))
+	stored, ok, errB := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channel(), channel)
+	assert.Equal(suite.T(), suite.channel(), stored)
+}
+
+func (suite *PaymentChannelServiceSuite) TestRefreshChannelStateKeepsNewerStoredNonce() {
+	stored := suite.channel()
+	stored.Nonce = big.NewInt(4)
+	suite.storage.Put(suite.channelKey(), stored)
+
+	channel, err := suite.service.RefreshChannelState(big.NewInt(42))
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), stored, channel)
+}
+
 func (suite *PaymentChannelServiceSuite) TestStartClaim() {
-	transaction, _ := suite.service.StartPaymentTransaction(suite.payment())
+	transaction, _ := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
 	transaction.Commit()
 
 	claim, errA := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
@@ -282,6 +511,25 @@ func (suite *PaymentChannelServiceSuite) TestStartClaim() {
 	assert.Equal(suite.T(), []*Payment{suite.payment()}, claims)
 }
 
+func (suite *PaymentChannelServiceSuite) TestStartClaimTamperedAmount() {
+	transaction, _ := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	transaction.Commit()
+	tamperedChannel := suite.channelPlusPayment(suite.payment())
+	tamperedChannel.AuthorizedAmount = big.NewInt(12345)
+	suite.storage.Put(suite.channelKey(), tamperedChannel)
+
+	claim, errA := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
+	claims, errB := suite.paymentStorage.GetAll()
+	channel, _, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Equal(suite.T(), errors.New("authorized amount 12345 of channel 42 is not signed by channel signer/sender for nonce 3"), errA)
+	assert.Nil(suite.T(), claim)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), []*Payment{}, claims)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Equal(suite.T(), tamperedChannel, channel)
+}
+
 func (suite *PaymentChannelServiceSuite) TestVerifyGroupId() {
 
 	service := suite.service
@@ -308,3 +556,94 @@ func (suite *PaymentChannelServiceSuite) TestVerifyGroupId() {
 	assert.NotNil(suite.T(), channel)
 	assert.Nil(suite.T(), err)
 }
+
+func (suite *PaymentChannelServiceSuite) paymentContext(payment *Payment) *handler.GrpcStreamContext {
+	return &handler.GrpcStreamContext{
+		MD: metadata.Pairs(
+			handler.PaymentChannelIDHeader, payment.ChannelID.String(),
+			handler.PaymentChannelNonceHeader, payment.ChannelNonce.String(),
+			handler.PaymentChannelAmountHeader, payment.Amount.String(),
+			handler.PaymentChannelSignatureHeader, string(payment.Signature),
+		),
+	}
+}
+
+func (suite *PaymentChannelServiceSuite) rebuildingPaymentHandler(price int64) *paymentChannelPaymentHandler {
+	return &paymentChannelPaymentHandler{
+		service:                 suite.service,
+		mpeContractAddress:      func() common.Address { return suite.mpeContractAddress },
+		incomeValidator:         &incomeValidatorMockType{price: big.NewInt(price)},
+		rebuildAuthorizedAmount: true,
+	}
+}
+
+func (suite *PaymentChannelServiceSuite) putStaleChannel(authorizedAmount int64) {
+	stale := suite.payment()
+	stale.Amount = big.NewInt(authorizedAmount)
+	SignTestPayment(stale, suite.signerPrivateKey)
+	suite.storage.Put(suite.channelKey(), suite.channelPlusPayment(stale))
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentRebuildsStaleAuthorizedAmount() {
+	// storage is restored from backup made when client authorized 100,
+	// after that client authorized 120 and now pays 10 more
+	suite.putStaleChannel(100)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(130)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	paymentHandler := suite.rebuildingPaymentHandler(10)
+
+	transaction, errA := paymentHandler.Payment(suite.paymentContext(payment))
+	errB := paymentHandler.Complete(transaction)
+	channel, ok, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channelPlusPayment(payment), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentRebuildDoesNotExceedFullAmount() {
+	suite.putStaleChannel(100)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(12346)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	paymentHandler := suite.rebuildingPaymentHandler(10)
+
+	transaction, err := paymentHandler.Payment(suite.paymentContext(payment))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), transaction)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12346 (0.00012346 AGIX)"), err)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentRebuildRequiresValidSignature() {
+	suite.putStaleChannel(100)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(130)
+	SignTestPayment(payment, GenerateTestPrivateKey())
+	paymentHandler := suite.rebuildingPaymentHandler(10)
+
+	transaction, err := paymentHandler.Payment(suite.paymentContext(payment))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), transaction)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "payment is not signed by channel signer/sender"), err)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentStaleAuthorizedAmountRejectedWhenRebuildDisabled() {
+	suite.putStaleChannel(100)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(130)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	paymentHandler := suite.rebuildingPaymentHandler(10)
+	paymentHandler.rebuildAuthorizedAmount = false
+
+	transaction, err := paymentHandler.Payment(suite.paymentContext(payment))
+
+	assert.Nil(suite.T(), transaction)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "income 30 does not equal to price 10"), err)
+}
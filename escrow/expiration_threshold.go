@@ -0,0 +1,73 @@
+package escrow
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/spf13/viper"
+
+	"github.com/singnet/snet-daemon/config"
+)
+
+// defaultExpirationThresholdGroup is a name of the group reported when
+// global expiration threshold is applied
+const defaultExpirationThresholdGroup = "default"
+
+// GroupExpirationThreshold overrides payment expiration threshold for the
+// channels of the payment group.
+type GroupExpirationThreshold struct {
+	// GroupID is a base64 encoded id of the payment group
+	GroupID string `json:"group_id" mapstructure:"group_id"`
+	// Threshold is a number of blocks before channel expiration when
+	// payments via channel are not accepted anymore
+	Threshold uint64 `json:"threshold" mapstructure:"threshold"`
+}
+
+// ParseGroupExpirationThresholds returns thresholds by group id, returns
+// error if group id is incorrect or duplicated
+func ParseGroupExpirationThresholds(overrides []GroupExpirationThreshold) (thresholds map[[32]byte]*big.Int, err error) {
+	thresholds = make(map[[32]byte]*big.Int, len(overrides))
+	for _, override := range overrides {
+		data, err := base64.StdEncoding.DecodeString(override.GroupID)
+		if err != nil || len(data) != 32 {
+			return nil, fmt.Errorf("incorrect group id of payment expiration threshold: \"%v\"", override.GroupID)
+		}
+		var groupID [32]byte
+		copy(groupID[:], data)
+		if _, ok := thresholds[groupID]; ok {
+			return nil, fmt.Errorf("duplicate payment expiration threshold for group \"%v\"", override.GroupID)
+		}
+		thresholds[groupID] = new(big.Int).SetUint64(override.Threshold)
+	}
+	return thresholds, nil
+}
+
+// newGroupExpirationThresholdsFromConfig expects that thresholds are
+// validated on startup
+func newGroupExpirationThresholdsFromConfig(cfg *viper.Viper) func(groupID [32]byte) (threshold *big.Int, ok bool) {
+	var overrides []GroupExpirationThreshold
+	if err := cfg.UnmarshalKey(config.PaymentExpirationThresholds, &overrides); err != nil || len(overrides) == 0 {
+		return nil
+	}
+	thresholds, err := ParseGroupExpirationThresholds(overrides)
+	if err != nil {
+		return nil
+	}
+	return func(groupID [32]byte) (threshold *big.Int, ok bool) {
+		threshold, ok = thresholds[groupID]
+		return
+	}
+}
+
+// expirationThreshold returns expiration threshold of the channel group and
+// the name of the group it is configured for, global threshold is returned
+// for the "default" group when there is no override
+func (validator *ChannelPaymentValidator) expirationThreshold(channel *PaymentChannelData) (threshold *big.Int, group string) {
+	if validator.groupExpirationThreshold != nil {
+		if threshold, ok := validator.groupExpirationThreshold(channel.GroupID); ok {
+			return threshold, base64.StdEncoding.EncodeToString(channel.GroupID[:])
+		}
+	}
+	return validator.paymentExpirationThreshold(), defaultExpirationThresholdGroup
+}
@@ -25,16 +25,21 @@ const (
 type freeCallPaymentHandler struct {
 	freeCallPaymentValidator *FreeCallPaymentValidator
 	orgMetadata *blockchain.OrganizationMetaData
+	// quota counts free calls of users in daemon storage, nil means that
+	// metering service is asked whether free call is allowed
+	quota *FreeCallQuota
 }
 
 
 // NewPaymentHandler retuns new MultiPartyEscrow contract payment handler.
 func FreeCallPaymentHandler(
-	processor *blockchain.Processor,metadata *blockchain.OrganizationMetaData) handler.PaymentHandler {
+	processor *blockchain.Processor,metadata *blockchain.OrganizationMetaData, quota *FreeCallQuota) handler.PaymentHandler {
 	return &freeCallPaymentHandler{
 		orgMetadata:metadata,
+		quota:       quota,
 		freeCallPaymentValidator: NewFreeCallPaymentValidator(processor.CurrentBlock,
-			common.HexToAddress(blockchain.ToChecksumAddress(config.GetString(config.FreeCallSignerAddress)))),
+			common.HexToAddress(blockchain.ToChecksumAddress(config.GetString(config.FreeCallSignerAddress))),
+			uint64(config.GetInt(config.FreeCallAllowedBlockSkew))),
 	}
 }
 
@@ -42,6 +47,15 @@ func (h *freeCallPaymentHandler) Type() (typ string) {
 	return FreeCallPaymentType
 }
 
+// RequiredMetadata returns list of metadata keys required to make a free call
+func (h *freeCallPaymentHandler) RequiredMetadata() []string {
+	return []string{
+		handler.FreeCallUserIdHeader,
+		handler.CurrentBlockNumberHeader,
+		handler.PaymentChannelSignatureHeader,
+	}
+}
+
 func (h *freeCallPaymentHandler) Payment(context *handler.GrpcStreamContext) (payment handler.Payment, err *handler.GrpcError) {
 	internalPayment, err := h.getPaymentFromContext(context)
 	if err != nil {
@@ -53,14 +67,34 @@ func (h *freeCallPaymentHandler) Payment(context *handler.GrpcStreamContext) (pa
 		return nil, paymentErrorToGrpcError(e)
 	}
 
-	allowed,_ := h.checkIfFreeCallsAreAllowed(internalPayment.UserId)
-	if !allowed {
-		return nil,paymentErrorToGrpcError(fmt.Errorf("free call limit has been exceeded."))
+	if h.quota != nil {
+		// user id is signed together with the free call, so it cannot be
+		// replaced by the caller
+		if e = h.quota.Acquire(internalPayment.UserId); e != nil {
+			metrics.FreeCallsRejected.WithLabelValues(h.metricLabels(context)...).Inc()
+			return nil, paymentErrorToGrpcError(e)
+		}
+	} else {
+		allowed,_ := h.checkIfFreeCallsAreAllowed(internalPayment.UserId)
+		if !allowed {
+			metrics.FreeCallsRejected.WithLabelValues(h.metricLabels(context)...).Inc()
+			return nil,paymentErrorToGrpcError(fmt.Errorf("free call limit has been exceeded."))
+		}
 	}
 
+	metrics.FreeCallsGranted.WithLabelValues(h.metricLabels(context)...).Inc()
 	return internalPayment, nil
 }
 
+// metricLabels returns method and group labels for free call metrics
+func (h *freeCallPaymentHandler) metricLabels(context *handler.GrpcStreamContext) []string {
+	method := ""
+	if context.Info != nil {
+		method = context.Info.FullMethod
+	}
+	return []string{method, h.orgMetadata.GetGroupIdString()}
+}
+
 func (h *freeCallPaymentHandler) getPaymentFromContext(context *handler.GrpcStreamContext) (payment *FreeCallPayment, err *handler.GrpcError) {
 
 	organizationId := config.GetString(config.OrganizationId)
@@ -3,16 +3,25 @@ package escrow
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"fmt"
 	"github.com/singnet/snet-daemon/blockchain"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/singnet/snet-daemon/authutils"
 	"github.com/singnet/snet-daemon/config"
 	"github.com/singnet/snet-daemon/handler"
+	"github.com/singnet/snet-daemon/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 )
 var testJsonOrgGroupData = "{   \"org_name\": \"organization_name\",   \"org_id\": \"org_id1\",   \"groups\": [     {       \"group_name\": \"default_group2\",       \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\",       \"payment\": {         \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\",         \"payment_expiration_threshold\": 40320,         \"payment_channel_storage_type\": \"etcd\",         \"payment_channel_storage_client\": {           \"connection_timeout\": \"15s\",           \"request_timeout\": \"13s\",           \"endpoints\": [             \"http://127.0.0.1:2379\"           ]         }       }     },      {       \"group_name\": \"default_group\",       \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\",       \"payment\": {         \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\",         \"payment_expiration_threshold\": 40320,         \"payment_channel_storage_type\": \"etcd\",         \"payment_channel_storage_client\": {           \"connection_timeout\": \"15s\",           \"request_timeout\": \"13s\",           \"endpoints\": [             \"http://127.0.0.1:2379\"           ]         }       }     }   ] }"
@@ -31,7 +40,7 @@ func (suite *FreeCallPaymentHandlerTestSuite) SetupSuite() {
 		orgMetadata:metadata,
 		freeCallPaymentValidator: NewFreeCallPaymentValidator(func() (*big.Int, error) {
 			return big.NewInt(99), nil
-		}, crypto.PubkeyToAddress(suite.privateKey.PublicKey)),
+		}, crypto.PubkeyToAddress(suite.privateKey.PublicKey), authutils.AllowedBlockChainDifference),
 	}
 	config.Vip().Set(config.MeteringEndPoint,"http://demo8325345.mockable.io")
 }
@@ -89,3 +98,73 @@ func (suite *FreeCallPaymentHandlerTestSuite) Test_checkResponse() {
 	allowed , err := checkResponse(response)
 	assert.True(suite.T(),allowed)
 }
+
+func (suite *FreeCallPaymentHandlerTestSuite) TestFreeCallMetrics() {
+	totalCallsMade := 0
+	meteringServer := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(resp, `{"username":"user1","total_calls_made":%v,"free_calls_allowed":1}`, totalCallsMade)
+	}))
+	defer meteringServer.Close()
+	meteringEndpoint := config.GetString(config.MeteringEndPoint)
+	config.Vip().Set(config.MeteringEndPoint, meteringServer.URL)
+	defer config.Vip().Set(config.MeteringEndPoint, meteringEndpoint)
+
+	context := suite.grpcContextForFreeCall(func(md *metadata.MD) {})
+	context.Info = &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/add"}
+	group := suite.paymentHandler.orgMetadata.GetGroupIdString()
+	granted := metrics.FreeCallsGranted.WithLabelValues("/example_service.Calculator/add", group)
+	rejected := metrics.FreeCallsRejected.WithLabelValues("/example_service.Calculator/add", group)
+	grantedBefore, rejectedBefore := testutil.ToFloat64(granted), testutil.ToFloat64(rejected)
+
+	_, err := suite.paymentHandler.Payment(context)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), grantedBefore+1, testutil.ToFloat64(granted))
+	assert.Equal(suite.T(), rejectedBefore, testutil.ToFloat64(rejected))
+
+	totalCallsMade = 1
+	_, err = suite.paymentHandler.Payment(context)
+
+	assert.NotNil(suite.T(), err)
+	assert.Equal(suite.T(), grantedBefore+1, testutil.ToFloat64(granted))
+	assert.Equal(suite.T(), rejectedBefore+1, testutil.ToFloat64(rejected))
+}
+
+func (suite *FreeCallPaymentHandlerTestSuite) TestFreeCallQuotaIsExhausted() {
+	paymentHandler := suite.paymentHandler
+	paymentHandler.quota = NewFreeCallQuota(NewMemStorage(), "org_id1", "service_id1", 2, time.Hour)
+
+	_, errA := paymentHandler.Payment(suite.grpcContextForFreeCall(func(md *metadata.MD) {}))
+	_, errB := paymentHandler.Payment(suite.grpcContextForFreeCall(func(md *metadata.MD) {}))
+	_, errC := paymentHandler.Payment(suite.grpcContextForFreeCall(func(md *metadata.MD) {}))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.ResourceExhausted, "free call limit reached"), errC)
+}
+
+func (suite *FreeCallPaymentHandlerTestSuite) TestFreeCallQuotaIsCountedPerUser() {
+	paymentHandler := suite.paymentHandler
+	paymentHandler.quota = NewFreeCallQuota(NewMemStorage(), "org_id1", "service_id1", 1, time.Hour)
+
+	_, errA := paymentHandler.Payment(suite.grpcContextForFreeCall(func(md *metadata.MD) {}))
+	_, errB := paymentHandler.Payment(&handler.GrpcStreamContext{MD: suite.grpcMetadataForFreeCall("user2", 99)})
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func TestFreeCallQuotaIsResetAfterWindow(t *testing.T) {
+	now := time.Unix(3600, 0)
+	quota := NewFreeCallQuota(NewMemStorage(), "org_id1", "service_id1", 1, time.Hour)
+	quota.calls.now = func() time.Time { return now }
+
+	errA := quota.Acquire("user1")
+	errB := quota.Acquire("user1")
+	now = now.Add(time.Hour)
+	errC := quota.Acquire("user1")
+
+	assert.Nil(t, errA, "Unexpected error: %v", errA)
+	assert.Equal(t, NewPaymentError(ResourceExhausted, "free call limit reached"), errB)
+	assert.Nil(t, errC, "Unexpected error: %v", errC)
+}
@@ -0,0 +1,49 @@
+package escrow
+
+import (
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FreeCallQuota limits number of free calls of each user within the reset
+// window. Counters are kept in the storage, so they are shared between
+// daemon replicas.
+type FreeCallQuota struct {
+	calls *windowCounter
+	limit *big.Int
+}
+
+// FreeCallQuotaKeyPrefix returns prefix of the free call counters of the
+// service in the storage
+func FreeCallQuotaKeyPrefix(organizationId string, serviceId string) string {
+	return "/free-call-quota/" + organizationId + "/" + serviceId
+}
+
+// NewFreeCallQuota returns quota which allows up to limit free calls of
+// each user of the service per window
+func NewFreeCallQuota(storage AtomicStorage, organizationId string, serviceId string, limit int64, window time.Duration) *FreeCallQuota {
+	return &FreeCallQuota{
+		calls: newWindowCounter(&PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: FreeCallQuotaKeyPrefix(organizationId, serviceId),
+		}, window),
+		limit: big.NewInt(limit),
+	}
+}
+
+// Acquire accounts one free call of the user, returns ResourceExhausted
+// error if user already made all free calls of the current window
+func (quota *FreeCallQuota) Acquire(userId string) error {
+	added, err := quota.calls.Add(userId, big.NewInt(1), quota.limit)
+	if err != nil {
+		log.WithError(err).WithField("userId", userId).Error("Unable to update free call counter")
+		return NewPaymentError(Internal, "cannot update free call counter")
+	}
+	if !added {
+		log.WithField("userId", userId).WithField("limit", quota.limit).Warn("Free call limit is reached")
+		return NewPaymentError(ResourceExhausted, "free call limit reached")
+	}
+	return nil
+}
@@ -17,6 +17,9 @@ type IncomeData struct {
 	// GrpcContext contains gRPC stream context information. For instance
 	// metadata could be used to pass invoice id to check pricing.
 	GrpcContext *handler.GrpcStreamContext
+	// ChannelID is an id of the payment channel the call is paid from, it
+	// can be used to apply channel specific pricing
+	ChannelID *big.Int
 }
 
 // IncomeValidator uses pricing information to check that call was payed
@@ -29,6 +32,8 @@ type IncomeValidator interface {
 	// Validate returns nil if validation is successful or correct PaymentError
 	// status to be sent to client in case of validation error.
 	Validate(*IncomeData) (err error)
+	// Price returns income expected from the call
+	Price(*IncomeData) (price *big.Int, err error)
 }
 
 type incomeValidator struct {
@@ -40,6 +45,10 @@ func NewIncomeValidator(pricing *pricing.PricingStrategy) (validator IncomeValid
 	return &incomeValidator{priceStrategy: pricing}
 }
 
+func (validator *incomeValidator) Price(data *IncomeData) (price *big.Int, err error) {
+	return validator.priceStrategy.GetPrice(data.GrpcContext)
+}
+
 func (validator *incomeValidator) Validate(data *IncomeData) (err error) {
 //TO DO, the user request information from IncomeData needs to be passed here !!!!
 	price,err := validator.priceStrategy.GetPrice(data.GrpcContext)
@@ -12,12 +12,24 @@ import (
 
 type incomeValidatorMockType struct {
 	err error
+	// price is checked against income if it is set
+	price *big.Int
 }
 
 func (incomeValidator *incomeValidatorMockType) Validate(income *IncomeData) (err error) {
+	if incomeValidator.price != nil && income.Income.Cmp(incomeValidator.price) != 0 {
+		return NewPaymentError(Unauthenticated, "income %d does not equal to price %d", income.Income, incomeValidator.price)
+	}
 	return incomeValidator.err
 }
 
+func (incomeValidator *incomeValidatorMockType) Price(income *IncomeData) (price *big.Int, err error) {
+	if incomeValidator.price == nil {
+		return big.NewInt(0), incomeValidator.err
+	}
+	return incomeValidator.price, incomeValidator.err
+}
+
 type  MockPriceType struct{
 
 }
@@ -24,10 +24,10 @@ func NewEtcdLocker(storage AtomicStorage,metadata *blockchain.ServiceMetadata) L
 	}
 }
 
-// returns new prefixed storage
+// returns new prefixed storage, lock state is read bypassing stale reads
 func NewLockerStorage(storage AtomicStorage,metadata *blockchain.ServiceMetadata) *PrefixedAtomicStorage {
 	return &PrefixedAtomicStorage{
-		delegate:  storage,
+		delegate:  uncachedAtomicStorage(storage),
 		keyPrefix:  "/"+metadata.MpeAddress+"/payment-channel/lock",
 	}
 }
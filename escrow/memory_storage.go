@@ -1,6 +1,7 @@
 package escrow
 
 import (
+	"sort"
 	"strings"
 	"sync"
 )
@@ -50,6 +51,26 @@ func (storage *memoryStorage) GetByKeyPrefix(prefix string) (values []string, er
 	return
 }
 
+func (storage *memoryStorage) GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for key := range storage.data {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	for _, key := range keys {
+		values = append(values, storage.data[key])
+	}
+
+	return
+}
+
 func (storage *memoryStorage) unsafeGet(key string) (value string, ok bool, err error) {
 	value, ok = storage.data[key]
 	if !ok {
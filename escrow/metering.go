@@ -0,0 +1,54 @@
+package escrow
+
+import (
+	"math/big"
+
+	"github.com/singnet/snet-daemon/handler"
+)
+
+const (
+	// FlatPriceMeteringHook charges the whole amount authorized for the
+	// call, it is a default
+	FlatPriceMeteringHook = "flat"
+	// PerUnitMeteringHook charges fixed price for each output unit reported
+	// by the service
+	PerUnitMeteringHook = "per_unit"
+)
+
+// MeteringHook calculates amount to charge for the call using the output
+// delivered to the client. It enables usage based billing of streaming
+// calls: client authorizes the maximal price of the call and is charged for
+// the output which was actually delivered.
+type MeteringHook interface {
+	// Charge returns amount to charge for the call, authorized is an amount
+	// client authorized for the call. Amount greater than authorized is
+	// reduced to authorized.
+	Charge(stats *handler.StreamStats, authorized *big.Int) (amount *big.Int, err error)
+}
+
+type flatPriceMeteringHook struct {
+}
+
+// NewFlatPriceMeteringHook returns hook which charges the whole authorized
+// amount regardless of the delivered output.
+func NewFlatPriceMeteringHook() MeteringHook {
+	return &flatPriceMeteringHook{}
+}
+
+func (hook *flatPriceMeteringHook) Charge(stats *handler.StreamStats, authorized *big.Int) (amount *big.Int, err error) {
+	return authorized, nil
+}
+
+type perUnitMeteringHook struct {
+	pricePerUnit *big.Int
+}
+
+// NewPerUnitMeteringHook returns hook which charges pricePerUnit for each
+// output unit reported by the service via handler.MeteringUnitsTrailer.
+func NewPerUnitMeteringHook(pricePerUnit *big.Int) MeteringHook {
+	return &perUnitMeteringHook{pricePerUnit: pricePerUnit}
+}
+
+func (hook *perUnitMeteringHook) Charge(stats *handler.StreamStats, authorized *big.Int) (amount *big.Int, err error) {
+	return new(big.Int).Mul(big.NewInt(stats.Units), hook.pricePerUnit), nil
+}
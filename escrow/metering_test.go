@@ -0,0 +1,148 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/handler"
+)
+
+func (suite *PaymentChannelServiceSuite) meteringPaymentHandler(maxPrice int64, hook MeteringHook) *paymentChannelPaymentHandler {
+	return &paymentChannelPaymentHandler{
+		service:            suite.service,
+		mpeContractAddress: func() common.Address { return suite.mpeContractAddress },
+		incomeValidator:    &incomeValidatorMockType{price: big.NewInt(maxPrice)},
+		meteringHook:       hook,
+	}
+}
+
+func (suite *PaymentChannelServiceSuite) TestMeteringHookChargesPerToken() {
+	// client authorizes up to 1000 for the streaming call, 7 tokens are
+	// delivered by 10 per token
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(1100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewPerUnitMeteringHook(big.NewInt(10)))
+
+	transaction, errA := paymentHandler.Payment(suite.paymentContext(payment))
+	errB := paymentHandler.CompleteWithStats(transaction, &handler.StreamStats{Messages: 7, Bytes: 42, Units: 7})
+	channel, _, errC := suite.storage.Get(suite.channelKey())
+	claim, errD := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Equal(suite.T(), big.NewInt(170), channel.AuthorizedAmount)
+	assert.Equal(suite.T(), big.NewInt(1100), channel.SignedAmount)
+	assert.Equal(suite.T(), payment.Signature, channel.Signature)
+	assert.Nil(suite.T(), verifyClaimSignature(channel))
+	assert.Nil(suite.T(), errD, "Unexpected error: %v", errD)
+	assert.Equal(suite.T(), big.NewInt(1100), claim.Payment().Amount)
+	assert.Equal(suite.T(), big.NewInt(170), claim.Payment().ActualAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestMeteringHookClaimRetryKeepsActualAmount() {
+	suite.putStaleChannel(100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewPerUnitMeteringHook(big.NewInt(10)))
+	transaction, _ := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(1100)))
+	paymentHandler.CompleteWithStats(transaction, &handler.StreamStats{Units: 7})
+	idempotency := NewClaimIdempotency(NewMemStorage(), operationLogTestMetadata, time.Minute, time.Hour)
+	startClaim := func() (*Payment, error) {
+		claim, err := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
+		if err != nil {
+			return nil, err
+		}
+		return claim.Payment(), nil
+	}
+
+	_, errA := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), startClaim)
+	retried, errB := idempotency.StartClaim(big.NewInt(42), big.NewInt(3), startClaim)
+	started, ok, errC := idempotency.StartedClaim(big.NewInt(42), big.NewInt(3))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), big.NewInt(1100), retried.Amount)
+	assert.Equal(suite.T(), big.NewInt(170), retried.ActualAmount)
+	assert.Equal(suite.T(), big.NewInt(170), started.ActualAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestMeteringHookNextCallAfterPartialCharge() {
+	suite.putStaleChannel(100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewPerUnitMeteringHook(big.NewInt(10)))
+	first, _ := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(1100)))
+	paymentHandler.CompleteWithStats(first, &handler.StreamStats{Units: 7})
+
+	// next call is authorized on top of the charged amount
+	second, errA := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(1170)))
+	errB := paymentHandler.CompleteWithStats(second, &handler.StreamStats{Units: 100})
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), big.NewInt(1170), channel.AuthorizedAmount)
+	assert.Nil(suite.T(), channel.SignedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestMeteringHookNextCallUsesChannelStateAuthorizedAmount() {
+	suite.putStaleChannel(100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewPerUnitMeteringHook(big.NewInt(10)))
+	first, _ := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(1100)))
+	paymentHandler.CompleteWithStats(first, &handler.StreamStats{Units: 7})
+	stateService := &PaymentChannelStateService{
+		channelService: suite.service,
+		paymentStorage: suite.paymentStorage,
+		mpeAddress:     func() common.Address { return suite.mpeContractAddress },
+	}
+
+	// client computes the next amount on top of the authorized amount
+	// returned by the state service
+	state, errA := stateService.GetChannelState(context.Background(), &ChannelStateRequest{
+		ChannelId: bigIntToBytes(big.NewInt(42)),
+		Signature: getSignature(bigIntToBytes(big.NewInt(42)), suite.signerPrivateKey),
+	})
+	next := new(big.Int).Add(bytesToBigInt(state.CurrentAuthorizedAmount), big.NewInt(1000))
+	_, errB := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(1100 + 1000)))
+	second, errC := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(next.Int64())))
+	errD := paymentHandler.CompleteWithStats(second, &handler.StreamStats{Units: 100})
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), big.NewInt(1100), bytesToBigInt(state.CurrentSignedAmount))
+	assert.Equal(suite.T(), big.NewInt(170), bytesToBigInt(state.CurrentAuthorizedAmount))
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unauthenticated, "income 1930 does not equal to price 1000"), errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Nil(suite.T(), errD, "Unexpected error: %v", errD)
+	assert.Equal(suite.T(), big.NewInt(1170), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestMeteringHookChargeIsBoundedByAuthorizedAmount() {
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(1100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewPerUnitMeteringHook(big.NewInt(10)))
+
+	transaction, _ := paymentHandler.Payment(suite.paymentContext(payment))
+	err := paymentHandler.CompleteWithStats(transaction, &handler.StreamStats{Units: 500})
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), suite.channelPlusPayment(payment), channel)
+}
+
+func (suite *PaymentChannelServiceSuite) TestFlatPriceMeteringHook() {
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(1100)
+	paymentHandler := suite.meteringPaymentHandler(1000, NewFlatPriceMeteringHook())
+
+	transaction, _ := paymentHandler.Payment(suite.paymentContext(payment))
+	err := paymentHandler.CompleteWithStats(transaction, &handler.StreamStats{Units: 7})
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), suite.channelPlusPayment(payment), channel)
+}
@@ -1,12 +1,16 @@
 package escrow
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/handler"
 )
 
 // Payment contains MultiPartyEscrow payment details
@@ -22,6 +26,27 @@ type Payment struct {
 	Amount *big.Int
 	// Signature is a signature of the payment.
 	Signature []byte
+	// DaemonId is an optional id of the daemon the payment is bound to, it
+	// is a part of the signed message when it is not empty.
+	DaemonId string
+	// RequestHash is a hash of the request content calculated by daemon, it
+	// is set only when request content check is enabled.
+	RequestHash []byte
+	// RequestSignature is a signature of the client which binds the payment
+	// to the request content.
+	RequestSignature []byte
+	// ActualAmount is an amount to claim when it is less than the signed
+	// Amount, nil means that the whole Amount is claimed.
+	ActualAmount *big.Int
+	// MessageType selects layout of the message signed by client, empty
+	// value means PaymentMessageTypeV1.
+	MessageType string
+	// GroupID is an id of the payment group, it is a part of the signed
+	// message of PaymentMessageTypeV2.
+	GroupID [32]byte
+	// Source is a network address of the client which sent the payment, it
+	// is set by daemon and is not a part of the signed message.
+	Source string
 }
 
 // To Support Free calls
@@ -83,6 +108,9 @@ func (state PaymentChannelState) String() string {
 
 // PaymentChannelData is to keep all channel related information.
 type PaymentChannelData struct {
+	// MpeContractAddress is an address of the MultiPartyEscrow contract
+	// which was used to open the channel.
+	MpeContractAddress common.Address
 	// ChannelID is an id of the channel
 	ChannelID *big.Int
 	// Nonce is a nonce of this channel state
@@ -114,11 +142,36 @@ type PaymentChannelData struct {
 	// Signature is a signature of last message containing Authorized amount.
 	// It is required to claim tokens from channel.
 	Signature []byte
+	// SignedAmount is an amount signed by Signature when it is greater than
+	// AuthorizedAmount because the last call was charged less than client
+	// authorized, nil otherwise. MultiPartyEscrow allows claiming the part
+	// of the signed amount.
+	SignedAmount *big.Int
+	// DaemonId is an id of the daemon which the last payment was bound to,
+	// it is a part of the signed message when it is not empty.
+	DaemonId string
+	// MessageType is a layout of the message signed by Signature, empty
+	// value means PaymentMessageTypeV1. GroupID is a part of the message of
+	// PaymentMessageTypeV2.
+	MessageType string
 }
 
 func (data *PaymentChannelData) String() string {
-	return fmt.Sprintf("{ChannelID: %v, Nonce: %v, State: %v, Sender: %v, Recipient: %v, GroupId: %v, FullAmount: %v, Expiration: %v, Signer: %v, AuthorizedAmount: %v, Signature: %v",
-		data.ChannelID, data.Nonce, data.State, blockchain.AddressToHex(&data.Sender), blockchain.AddressToHex(&data.Recipient), data.GroupID, data.FullAmount, data.Expiration, data.Signer, data.AuthorizedAmount, blockchain.BytesToBase64(data.Signature))
+	return fmt.Sprintf("{MpeContractAddress: %v, ChannelID: %v, Nonce: %v, State: %v, Sender: %v, Recipient: %v, GroupId: %v, FullAmount: %v, Expiration: %v, Signer: %v, AuthorizedAmount: %v, Signature: %v, DaemonId: %v}",
+		blockchain.AddressToHex(&data.MpeContractAddress), data.ChannelID, data.Nonce, data.State, blockchain.AddressToHex(&data.Sender), blockchain.AddressToHex(&data.Recipient), data.GroupID, data.FullAmount, data.Expiration, data.Signer, data.AuthorizedAmount, blockchain.BytesToBase64(data.Signature), data.DaemonId)
+}
+
+// UnclaimedAmount returns part of the FullAmount which is not authorized by
+// sender yet. nil amounts of the legacy records are treated as zero.
+func (data *PaymentChannelData) UnclaimedAmount() *big.Int {
+	unclaimed := new(big.Int)
+	if data.FullAmount != nil {
+		unclaimed.Set(data.FullAmount)
+	}
+	if data.AuthorizedAmount != nil {
+		unclaimed.Sub(unclaimed, data.AuthorizedAmount)
+	}
+	return unclaimed
 }
 
 // PaymentChannelService interface is API for payment channel functionality.
@@ -137,11 +190,16 @@ type PaymentChannelService interface {
 	// ListClaims returns list of payment claims in progress
 	ListClaims() (claim []Claim, err error)
 
-	// StartPaymentTransaction validates payment and starts payment transaction
-	StartPaymentTransaction(payment *Payment) (transaction PaymentTransaction, err error)
+	// StartPaymentTransaction validates payment and starts payment
+	// transaction, validation is stopped when ctx is done
+	StartPaymentTransaction(ctx context.Context, payment *Payment) (transaction PaymentTransaction, err error)
 
 	//Get Channel from BlockChain
 	PaymentChannelFromBlockChain(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error)
+
+	// RefreshChannelState re-reads channel from blockchain and updates the
+	// stored channel nonce, full amount and expiration
+	RefreshChannelState(channelID *big.Int) (channel *PaymentChannelData, err error)
 }
 
 // PaymentErrorCode contains all types of errors which we need to handle on the
@@ -160,8 +218,80 @@ const (
 	FailedPrecondition PaymentErrorCode = 3
 	// IncorrectNonce is returned when nonce value sent by client is incorrect.
 	IncorrectNonce PaymentErrorCode = 4
+	// ResourceExhausted means that client exceeded some limit and should
+	// repeat request later.
+	ResourceExhausted PaymentErrorCode = 5
+	// PermissionDenied means that client is not allowed to pay, for
+	// instance because sender is on the sanctions list.
+	PermissionDenied PaymentErrorCode = 6
+	// RequestContentMismatch means that request content is not the one
+	// client signed the payment for.
+	RequestContentMismatch PaymentErrorCode = 7
+	// ChannelClosed means that channel sender acknowledged close of the
+	// channel and it cannot be used to pay anymore.
+	ChannelClosed PaymentErrorCode = 8
+	// Unavailable means that daemon cannot accept payment at the moment,
+	// for instance because it is shutting down, client should retry.
+	Unavailable PaymentErrorCode = 9
+	// DeadlineExceeded means that request deadline is exceeded or request
+	// is cancelled before payment is validated.
+	DeadlineExceeded PaymentErrorCode = 10
+	// InvalidArgument means that payment metadata is absent or malformed.
+	InvalidArgument PaymentErrorCode = 11
 )
 
+var paymentErrorCodeNames = map[PaymentErrorCode]string{
+	Internal:               "Internal",
+	Unauthenticated:        "Unauthenticated",
+	FailedPrecondition:     "FailedPrecondition",
+	IncorrectNonce:         "IncorrectNonce",
+	ResourceExhausted:      "ResourceExhausted",
+	PermissionDenied:       "PermissionDenied",
+	RequestContentMismatch: "RequestContentMismatch",
+	ChannelClosed:          "ChannelClosed",
+	Unavailable:            "Unavailable",
+	DeadlineExceeded:       "DeadlineExceeded",
+	InvalidArgument:        "InvalidArgument",
+}
+
+func (code PaymentErrorCode) String() string {
+	if name, ok := paymentErrorCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("PaymentErrorCode(%d)", int(code))
+}
+
+// GrpcCode returns gRPC status code which is reported to the client for the
+// payment error code
+func (code PaymentErrorCode) GrpcCode() codes.Code {
+	switch code {
+	case Internal:
+		return codes.Internal
+	case Unauthenticated:
+		return codes.Unauthenticated
+	case FailedPrecondition:
+		return codes.FailedPrecondition
+	case IncorrectNonce:
+		return handler.IncorrectNonce
+	case ResourceExhausted:
+		return codes.ResourceExhausted
+	case PermissionDenied:
+		return codes.PermissionDenied
+	case RequestContentMismatch:
+		return codes.Unauthenticated
+	case ChannelClosed:
+		return codes.FailedPrecondition
+	case Unavailable:
+		return codes.Unavailable
+	case DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case InvalidArgument:
+		return codes.InvalidArgument
+	default:
+		return codes.Internal
+	}
+}
+
 // PaymentError contains error code and message and implements Error interface.
 type PaymentError struct {
 	// Code is error code
@@ -176,8 +306,16 @@ func NewPaymentError(code PaymentErrorCode, format string, msg ...interface{}) *
 	return &PaymentError{Code: code, Message: fmt.Sprintf(format, msg...)}
 }
 
+// Error returns message prefixed by the name of the error code, gRPC status
+// returned to the client contains message only
 func (err *PaymentError) Error() string {
-	return err.Message
+	return err.Code.String() + ": " + err.Message
+}
+
+// GRPCStatus converts payment error to gRPC status, it is used by
+// status.FromError and by gRPC server when error is returned by handler
+func (err *PaymentError) GRPCStatus() *status.Status {
+	return status.New(err.Code.GrpcCode(), err.Message)
 }
 
 // PaymentTransaction is a payment transaction in progress.
@@ -222,6 +360,7 @@ var (
 		channel.Nonce = (&big.Int{}).Add(channel.Nonce, big.NewInt(1))
 		channel.FullAmount = (&big.Int{}).Sub(channel.FullAmount, channel.AuthorizedAmount)
 		channel.AuthorizedAmount = big.NewInt(0)
+		channel.SignedAmount = nil
 		channel.Signature = nil
 	}
 )
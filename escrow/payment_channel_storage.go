@@ -3,6 +3,7 @@ package escrow
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	log "github.com/sirupsen/logrus"
@@ -13,28 +14,90 @@ import (
 	"github.com/singnet/snet-daemon/blockchain"
 )
 
+const (
+	// paymentChannelDataVersion is a current schema version of stored
+	// PaymentChannelData. It should be incremented each time the structure
+	// is changed in a way which requires an upgrade of the stored values.
+	// Version 2 adds serializer id byte after the version byte.
+	paymentChannelDataVersion byte = 2
+	// gobPaymentChannelDataVersion is a version of gob encoded values.
+	// They are written without serializer id, so daemons which know only
+	// version 1 can read them.
+	gobPaymentChannelDataVersion byte = 1
+	// versionMarker is added to the schema version to get the first byte of
+	// the stored value. gob encoded value starts either from byte less than
+	// 0x80 or from byte greater than 0xF7, so legacy values which are stored
+	// without version byte can be distinguished.
+	versionMarker byte = 0x80
+
+	migrateChannelMaxAttempts = 10
+)
+
 // PaymentChannelStorage is a storage for PaymentChannelData by
 // PaymentChannelKey based on TypedAtomicStorage implementation
 type PaymentChannelStorage struct {
-	delegate TypedAtomicStorage
+	delegate           TypedAtomicStorage
+	atomicStorage      AtomicStorage
+	mpeContractAddress common.Address
+	serializer         ChannelSerializer
+
+	// latest and latestAtomicStorage read the actual values bypassing cache
+	latest              TypedAtomicStorage
+	latestAtomicStorage AtomicStorage
+
+	// compressionThreshold is a size of encoded value starting from which
+	// it is gzip compressed, zero disables compression
+	compressionThreshold int
 }
 
 // NewPaymentChannelStorage returns new instance of PaymentChannelStorage
-// implementation
+// implementation, values are gob encoded without compression but compressed
+// values are still read
 func NewPaymentChannelStorage(atomicStorage AtomicStorage,metadata *blockchain.ServiceMetadata) *PaymentChannelStorage {
-	return &PaymentChannelStorage{
-		delegate: &TypedAtomicStorageImpl{
-			atomicStorage: &PrefixedAtomicStorage{
-				delegate:  atomicStorage,
-				//Add the MPE Network address as the prefix on the key for storage
-				keyPrefix: "/"+metadata.MpeAddress+"/payment-channel/storage",
-			},
-			keySerializer:     serialize,
-			valueSerializer:   serialize,
-			valueDeserializer: deserialize,
-			valueType:         reflect.TypeOf(PaymentChannelData{}),
-		},
+	return NewPaymentChannelStorageWithSerializer(atomicStorage, metadata, GobChannelSerializer{}, 0)
+}
+
+// NewPaymentChannelStorageWithSerializer returns new instance of
+// PaymentChannelStorage which encodes channels using passed serializer and
+// compresses values which are not shorter than compressionThreshold. Zero
+// threshold disables compression while compressed values are still read.
+func NewPaymentChannelStorageWithSerializer(atomicStorage AtomicStorage, metadata *blockchain.ServiceMetadata, serializer ChannelSerializer, compressionThreshold int) *PaymentChannelStorage {
+	prefixedStorage := &PrefixedAtomicStorage{
+		delegate:  atomicStorage,
+		//Add the MPE Network address as the prefix on the key for storage
+		keyPrefix: PaymentChannelStorageKeyPrefix(metadata),
+	}
+	storage := &PaymentChannelStorage{
+		atomicStorage:        prefixedStorage,
+		mpeContractAddress:   blockchain.HexToAddress(metadata.MpeAddress),
+		serializer:           serializer,
+		compressionThreshold: compressionThreshold,
+	}
+	storage.delegate = &TypedAtomicStorageImpl{
+		atomicStorage:     prefixedStorage,
+		keySerializer:     serialize,
+		valueSerializer:   storage.serializePaymentChannelData,
+		valueDeserializer: storage.deserializePaymentChannelData,
+		valueType:         reflect.TypeOf(PaymentChannelData{}),
+	}
+	storage.latestAtomicStorage = &PrefixedAtomicStorage{
+		delegate:  uncachedAtomicStorage(atomicStorage),
+		keyPrefix: prefixedStorage.keyPrefix,
 	}
+	storage.latest = &TypedAtomicStorageImpl{
+		atomicStorage:     storage.latestAtomicStorage,
+		keySerializer:     serialize,
+		valueSerializer:   storage.serializePaymentChannelData,
+		valueDeserializer: storage.deserializePaymentChannelData,
+		valueType:         reflect.TypeOf(PaymentChannelData{}),
+	}
+	return storage
+}
+
+// PaymentChannelStorageKeyPrefix returns prefix of the storage keys which
+// keep payment channel states
+func PaymentChannelStorageKeyPrefix(metadata *blockchain.ServiceMetadata) string {
+	return "/" + metadata.MpeAddress + "/payment-channel/storage"
 }
 
 func serialize(value interface{}) (slice string, err error) {
@@ -56,6 +119,150 @@ func deserialize(slice string, value interface{}) (err error) {
 	return
 }
 
+// serializePaymentChannelData writes schema version byte and serializer id
+// before value encoded by storage serializer. Compressed values are flagged
+// in the serializer id byte.
+func (storage *PaymentChannelStorage) serializePaymentChannelData(value interface{}) (slice string, err error) {
+	serialized, err := storage.serializer.Serialize(value.(*PaymentChannelData))
+	if err != nil {
+		return
+	}
+	id := storage.serializer.ID()
+	if storage.compressionThreshold > 0 && len(serialized) >= storage.compressionThreshold {
+		if serialized, err = compressChannelValue(serialized); err != nil {
+			return
+		}
+		id |= compressedChannelFlag
+	}
+	if id == gobChannelSerializerID {
+		return string([]byte{versionMarker + gobPaymentChannelDataVersion}) + string(serialized), nil
+	}
+	return string([]byte{versionMarker + paymentChannelDataVersion, id}) + string(serialized), nil
+}
+
+// deserializePaymentChannelData decodes value of any known schema version
+// and upgrades it to the current one
+func (storage *PaymentChannelStorage) deserializePaymentChannelData(slice string, value interface{}) (err error) {
+	version, payload := parseSchemaVersion(slice)
+	if version > paymentChannelDataVersion {
+		return fmt.Errorf("unsupported payment channel data schema version: %v, latest known version: %v", version, paymentChannelDataVersion)
+	}
+
+	serializer, compressed, payload, err := storage.payloadSerializer(version, payload)
+	if err != nil {
+		return
+	}
+	serialized := []byte(payload)
+	if compressed {
+		if serialized, err = decompressChannelValue(serialized); err != nil {
+			return
+		}
+	}
+	data, err := serializer.Deserialize(serialized)
+	if err != nil {
+		return
+	}
+	*value.(*PaymentChannelData) = *data
+
+	return storage.upgradePaymentChannelData(version, value.(*PaymentChannelData))
+}
+
+// payloadSerializer returns serializer which decodes value of the schema
+// version and the rest of payload. Values of version 0 and 1 are always gob
+// encoded and uncompressed, starting from version 2 serializer id and
+// compression flag follow the version byte.
+func (storage *PaymentChannelStorage) payloadSerializer(version byte, payload string) (serializer ChannelSerializer, compressed bool, rest string, err error) {
+	if version < 2 {
+		return GobChannelSerializer{}, false, payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, false, "", fmt.Errorf("serializer id is absent in stored value")
+	}
+
+	compressed = payload[0]&compressedChannelFlag != 0
+	id := payload[0] &^ compressedChannelFlag
+	if id == storage.serializer.ID() {
+		return storage.serializer, compressed, payload[1:], nil
+	}
+	serializer, ok := channelSerializerByID(id)
+	if !ok {
+		return nil, false, "", fmt.Errorf("unknown payment channel serializer id: %v", id)
+	}
+	return serializer, compressed, payload[1:], nil
+}
+
+// parseSchemaVersion returns schema version and payload of the stored value,
+// values stored without version byte have version 0
+func parseSchemaVersion(slice string) (version byte, payload string) {
+	if len(slice) > 0 && slice[0] >= versionMarker && slice[0] < 0xF8 {
+		return slice[0] - versionMarker, slice[1:]
+	}
+	return 0, slice
+}
+
+// upgradePaymentChannelData fills fields which are absent in the values of
+// the previous schema versions by safe defaults
+func (storage *PaymentChannelStorage) upgradePaymentChannelData(version byte, data *PaymentChannelData) (err error) {
+	if version < 1 {
+		// storage keys are prefixed by MPE address, so all legacy values
+		// belong to the same MPE contract
+		if data.MpeContractAddress == (common.Address{}) {
+			data.MpeContractAddress = storage.mpeContractAddress
+		}
+		if data.ChannelID == nil {
+			return fmt.Errorf("payment channel id is absent in stored value")
+		}
+		for _, field := range []**big.Int{&data.Nonce, &data.FullAmount, &data.Expiration, &data.AuthorizedAmount} {
+			if *field == nil {
+				*field = big.NewInt(0)
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate rewrites all values stored in the legacy format without version
+// byte to the current one. Values which are updated concurrently are skipped
+// as they are already written using the current version. Returns number of
+// rewritten values.
+func (storage *PaymentChannelStorage) Migrate() (migrated int, err error) {
+	values, err := storage.atomicStorage.GetByKeyPrefix("")
+	if err != nil {
+		return
+	}
+
+	for _, prevValue := range values {
+		if version, _ := parseSchemaVersion(prevValue); version > 0 {
+			continue
+		}
+
+		data := &PaymentChannelData{}
+		if err = storage.deserializePaymentChannelData(prevValue, data); err != nil {
+			return
+		}
+		key, e := serialize(&PaymentChannelKey{ID: data.ChannelID})
+		if e != nil {
+			return migrated, e
+		}
+		newValue, e := storage.serializePaymentChannelData(data)
+		if e != nil {
+			return migrated, e
+		}
+
+		ok, e := storage.atomicStorage.CompareAndSwap(key, prevValue, newValue)
+		if e != nil {
+			return migrated, e
+		}
+		if !ok {
+			log.WithField("channelID", data.ChannelID).Info("Payment channel is updated concurrently, skip it")
+			continue
+		}
+		migrated++
+	}
+
+	return
+}
+
 // Get returns payment channel by key
 func (storage *PaymentChannelStorage) Get(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
 	value, ok, err := storage.delegate.Get(key)
@@ -65,6 +272,17 @@ func (storage *PaymentChannelStorage) Get(key *PaymentChannelKey) (state *Paymen
 	return value.(*PaymentChannelData), ok, err
 }
 
+// GetLatest returns payment channel by key reading it bypassing cache. It
+// should be used by caller which holds the channel lock and is going to
+// update the channel, because cached state can be stale.
+func (storage *PaymentChannelStorage) GetLatest(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
+	value, ok, err := storage.latest.Get(key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return value.(*PaymentChannelData), ok, err
+}
+
 // GetAll returns all channels from the storage
 func (storage *PaymentChannelStorage) GetAll() (states []*PaymentChannelData, err error) {
 	values, err := storage.delegate.GetAll()
@@ -85,9 +303,115 @@ func (storage *PaymentChannelStorage) PutIfAbsent(key *PaymentChannelKey, state
 	return storage.delegate.PutIfAbsent(key, state)
 }
 
-// CompareAndSwap compares previous storage value and set new value by key
+// CompareAndSwap compares previous storage value and set new value by key.
+// Stored value is decoded and compared with prevState, then swap is made
+// against the raw stored bytes. Thus values which are written using previous
+// schema version or another serializer don't fail the comparison.
 func (storage *PaymentChannelStorage) CompareAndSwap(key *PaymentChannelKey, prevState *PaymentChannelData, newState *PaymentChannelData) (ok bool, err error) {
-	return storage.delegate.CompareAndSwap(key, prevState, newState)
+	keyString, err := serialize(key)
+	if err != nil {
+		return
+	}
+
+	storedValue, ok, err := storage.latestAtomicStorage.Get(keyString)
+	if err != nil || !ok {
+		return
+	}
+	stored := &PaymentChannelData{}
+	if err = storage.deserializePaymentChannelData(storedValue, stored); err != nil {
+		return
+	}
+
+	// both states are encoded by the same serializer to compare them
+	storedString, err := storage.serializePaymentChannelData(stored)
+	if err != nil {
+		return
+	}
+	prevString, err := storage.serializePaymentChannelData(prevState)
+	if err != nil {
+		return
+	}
+	if storedString != prevString {
+		return false, nil
+	}
+
+	newValue, err := storage.serializePaymentChannelData(newState)
+	if err != nil {
+		return
+	}
+	return storage.atomicStorage.CompareAndSwap(keyString, storedValue, newValue)
+}
+
+// ErrStaleChannelState is returned by UpdateChannel when stored channel
+// state differs from the expected one, caller should reload the channel and
+// retry the update
+var ErrStaleChannelState = errors.New("payment channel state is changed concurrently")
+
+// UpdateChannel replaces channel state by updated one if and only if stored
+// state is equal to the expected one. It returns ErrStaleChannelState if
+// state was changed concurrently or channel is not found.
+func (storage *PaymentChannelStorage) UpdateChannel(key *PaymentChannelKey, expected *PaymentChannelData, updated *PaymentChannelData) (err error) {
+	ok, err := storage.CompareAndSwap(key, expected, updated)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return ErrStaleChannelState
+	}
+	return nil
+}
+
+// MigrateChannels copies payment channels from src storage to dst storage.
+// Channels which are already kept in dst with the equal or newer nonce are
+// skipped, so migration can be safely restarted after partial failure.
+// Returns number of channels written into dst.
+func MigrateChannels(src, dst *PaymentChannelStorage) (migrated int, err error) {
+	channels, err := src.GetAll()
+	if err != nil {
+		return
+	}
+
+	for _, channel := range channels {
+		ok, e := migrateChannel(channel, dst)
+		if e != nil {
+			return migrated, e
+		}
+		if ok {
+			migrated++
+		}
+	}
+
+	return
+}
+
+func migrateChannel(channel *PaymentChannelData, dst *PaymentChannelStorage) (ok bool, err error) {
+	key := &PaymentChannelKey{ID: channel.ChannelID}
+	for attempt := 0; attempt < migrateChannelMaxAttempts; attempt++ {
+		ok, err = dst.PutIfAbsent(key, channel)
+		if err != nil || ok {
+			return
+		}
+
+		current, found, e := dst.Get(key)
+		if e != nil {
+			return false, e
+		}
+		if !found {
+			continue
+		}
+		if current.Nonce.Cmp(channel.Nonce) >= 0 {
+			log.WithField("channelID", channel.ChannelID).WithField("nonce", current.Nonce).Info("Destination storage keeps channel with equal or newer nonce, skip it")
+			return false, nil
+		}
+
+		err = dst.UpdateChannel(key, current, channel)
+		if err == ErrStaleChannelState {
+			continue
+		}
+		return err == nil, err
+	}
+
+	return false, fmt.Errorf("cannot migrate payment channel %v, it is updated concurrently", channel.ChannelID)
 }
 
 // BlockchainChannelReader reads channel state from blockchain
@@ -95,6 +419,7 @@ type BlockchainChannelReader struct {
 
 	readChannelFromBlockchain func(channelID *big.Int) (channel *blockchain.MultiPartyEscrowChannel, ok bool, err error)
 	recipientPaymentAddress   func() common.Address
+	mpeContractAddress        func() common.Address
 }
 
 // NewBlockchainChannelReader returns new instance of blockchain channel reader
@@ -106,6 +431,7 @@ func NewBlockchainChannelReader(processor *blockchain.Processor, cfg *viper.Vipe
 			address := orgMetadata.GetPaymentAddress()
 			return address
 		},
+		mpeContractAddress: processor.EscrowContractAddress,
 	}
 }
 
@@ -128,17 +454,18 @@ func (reader *BlockchainChannelReader) GetChannelStateFromBlockchain(key *Paymen
 		return nil, false, fmt.Errorf("recipient Address from service metadata does not Match on what was retrieved from Channel")
 	}
 	return &PaymentChannelData{
-		ChannelID:        key.ID,
-		Nonce:            ch.Nonce,
-		State:            Open,
-		Sender:           ch.Sender,
-		Recipient:        ch.Recipient,
-		GroupID:          ch.GroupId,
-		FullAmount:       ch.Value,
-		Expiration:       ch.Expiration,
-		Signer:           ch.Signer,
-		AuthorizedAmount: big.NewInt(0),
-		Signature:        nil,
+		MpeContractAddress: reader.mpeContractAddress(),
+		ChannelID:          key.ID,
+		Nonce:              ch.Nonce,
+		State:              Open,
+		Sender:             ch.Sender,
+		Recipient:          ch.Recipient,
+		GroupID:            ch.GroupId,
+		FullAmount:         ch.Value,
+		Expiration:         ch.Expiration,
+		Signer:             ch.Signer,
+		AuthorizedAmount:   big.NewInt(0),
+		Signature:          nil,
 	}, true, nil
 }
 
@@ -157,6 +484,10 @@ func MergeStorageAndBlockchainChannelState(storage, blockchain *PaymentChannelDa
 	merged = &tmp
 	merged.FullAmount = blockchain.FullAmount
 	merged.Expiration = blockchain.Expiration
+	// channels stored by previous daemon versions have no MPE address
+	if merged.MpeContractAddress == (common.Address{}) {
+		merged.MpeContractAddress = blockchain.MpeContractAddress
+	}
 
 	return
 }
@@ -3,6 +3,7 @@ package escrow
 import (
 	"errors"
 	"math/big"
+	"sync"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -91,6 +92,223 @@ func (suite *PaymentChannelStorageSuite) TestGetChannel() {
 	assert.Equal(suite.T(), expectedChannel, channel)
 }
 
+// legacyPaymentChannelData is a PaymentChannelData as it was stored before
+// schema version was introduced
+type legacyPaymentChannelData struct {
+	ChannelID        *big.Int
+	Nonce            *big.Int
+	State            PaymentChannelState
+	Sender           common.Address
+	Recipient        common.Address
+	GroupID          [32]byte
+	FullAmount       *big.Int
+	Expiration       *big.Int
+	Signer           common.Address
+	AuthorizedAmount *big.Int
+	Signature        []byte
+}
+
+func (suite *PaymentChannelStorageSuite) putLegacyChannel(channelID int64) {
+	key, _ := serialize(suite.key(channelID))
+	value, _ := serialize(&legacyPaymentChannelData{
+		ChannelID:  big.NewInt(channelID),
+		Nonce:      big.NewInt(3),
+		Sender:     suite.senderAddress,
+		Recipient:  suite.recipientAddress,
+		GroupID:    [32]byte{123},
+		FullAmount: big.NewInt(12345),
+		Expiration: big.NewInt(100),
+		Signer:     suite.signerAddress,
+	})
+	suite.memoryStorage.Put("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/payment-channel/storage/"+key, value)
+}
+
+func (suite *PaymentChannelStorageSuite) TestGetLegacyChannel() {
+	suite.putLegacyChannel(42)
+	expectedChannel := suite.channel()
+	expectedChannel.MpeContractAddress = blockchain.HexToAddress("0xf65186b5081ff5ce73482ad761db0eb0d25abfbf")
+
+	channel, ok, err := suite.storage.Get(suite.key(42))
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), true, ok)
+	assert.Equal(suite.T(), expectedChannel, channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestGetChannelOfUnknownVersion() {
+	key, _ := serialize(suite.key(42))
+	value, _ := serialize(suite.channel())
+	suite.memoryStorage.Put("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/payment-channel/storage/"+key, string([]byte{versionMarker + paymentChannelDataVersion + 1})+value)
+
+	channel, ok, err := suite.storage.Get(suite.key(42))
+
+	assert.Equal(suite.T(), errors.New("unsupported payment channel data schema version: 3, latest known version: 2"), err)
+	assert.False(suite.T(), ok)
+	assert.Nil(suite.T(), channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestMigrate() {
+	suite.putLegacyChannel(41)
+	suite.putLegacyChannel(42)
+	suite.storage.Put(suite.key(43), suite.channel())
+
+	migrated, err := suite.storage.Migrate()
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 2, migrated)
+	values, _ := suite.memoryStorage.GetByKeyPrefix("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/payment-channel/storage/")
+	assert.Equal(suite.T(), 3, len(values))
+	for _, value := range values {
+		version, _ := parseSchemaVersion(value)
+		assert.Equal(suite.T(), gobPaymentChannelDataVersion, version)
+	}
+	migrated, err = suite.storage.Migrate()
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 0, migrated)
+}
+
+func (suite *PaymentChannelStorageSuite) TestUpdateChannel() {
+	expected := suite.channel()
+	suite.storage.Put(suite.key(42), expected)
+	updated := suite.channel()
+	updated.AuthorizedAmount = big.NewInt(10)
+
+	err := suite.storage.UpdateChannel(suite.key(42), expected, updated)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	channel, _, _ := suite.storage.Get(suite.key(42))
+	assert.Equal(suite.T(), updated, channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestUpdateChannelStale() {
+	stale := suite.channel()
+	current := suite.channel()
+	current.AuthorizedAmount = big.NewInt(10)
+	suite.storage.Put(suite.key(42), current)
+	updated := suite.channel()
+	updated.AuthorizedAmount = big.NewInt(20)
+
+	err := suite.storage.UpdateChannel(suite.key(42), stale, updated)
+
+	assert.Equal(suite.T(), ErrStaleChannelState, err)
+	channel, _, _ := suite.storage.Get(suite.key(42))
+	assert.Equal(suite.T(), current, channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestUpdateLegacyChannel() {
+	suite.putLegacyChannel(42)
+	expected, _, _ := suite.storage.Get(suite.key(42))
+	updated := *expected
+	updated.AuthorizedAmount = big.NewInt(10)
+
+	err := suite.storage.UpdateChannel(suite.key(42), expected, &updated)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	channel, _, _ := suite.storage.Get(suite.key(42))
+	assert.Equal(suite.T(), &updated, channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestUpdateChannelConcurrently() {
+	const goroutines = 10
+	const updates = 20
+	suite.storage.Put(suite.key(42), suite.channel())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; {
+				expected, _, err := suite.storage.Get(suite.key(42))
+				if err != nil {
+					errs <- err
+					return
+				}
+				updated := *expected
+				updated.AuthorizedAmount = new(big.Int).Add(expected.AuthorizedAmount, big.NewInt(1))
+				err = suite.storage.UpdateChannel(suite.key(42), expected, &updated)
+				if err == ErrStaleChannelState {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	}
+	channel, _, _ := suite.storage.Get(suite.key(42))
+	assert.Equal(suite.T(), big.NewInt(goroutines*updates), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelStorageSuite) TestMigrateChannels() {
+	dstStorage := NewPaymentChannelStorage(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	channelA := suite.channel()
+	channelA.ChannelID = big.NewInt(41)
+	suite.storage.Put(suite.key(41), channelA)
+	channelB := suite.channel()
+	suite.storage.Put(suite.key(42), channelB)
+	newerB := suite.channel()
+	newerB.Nonce = big.NewInt(4)
+	dstStorage.Put(suite.key(42), newerB)
+
+	migrated, err := MigrateChannels(suite.storage, dstStorage)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 1, migrated)
+	channel, _, _ := dstStorage.Get(suite.key(41))
+	assert.Equal(suite.T(), channelA, channel)
+	channel, _, _ = dstStorage.Get(suite.key(42))
+	assert.Equal(suite.T(), newerB, channel)
+}
+
+func (suite *PaymentChannelStorageSuite) TestMigrateChannelsReplacesOlderNonce() {
+	dstStorage := NewPaymentChannelStorage(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	channel := suite.channel()
+	suite.storage.Put(suite.key(42), channel)
+	olderChannel := suite.channel()
+	olderChannel.Nonce = big.NewInt(2)
+	dstStorage.Put(suite.key(42), olderChannel)
+
+	migrated, err := MigrateChannels(suite.storage, dstStorage)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 1, migrated)
+	migratedChannel, _, _ := dstStorage.Get(suite.key(42))
+	assert.Equal(suite.T(), channel, migratedChannel)
+
+	migrated, err = MigrateChannels(suite.storage, dstStorage)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 0, migrated)
+}
+
+func (suite *PaymentChannelStorageSuite) TestMigrateChannelsReplacesLegacyChannel() {
+	dstMemoryStorage := NewMemStorage()
+	dstStorage := NewPaymentChannelStorage(dstMemoryStorage, &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"})
+	suite.putLegacyChannel(42)
+	key, _ := serialize(suite.key(42))
+	legacyValue, _, _ := suite.memoryStorage.Get("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/payment-channel/storage/" + key)
+	dstMemoryStorage.Put("/0xf65186b5081ff5ce73482ad761db0eb0d25abfbf/payment-channel/storage/"+key, legacyValue)
+	channel := suite.channel()
+	channel.Nonce = big.NewInt(4)
+	suite.storage.Put(suite.key(42), channel)
+
+	migrated, err := MigrateChannels(suite.storage, dstStorage)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 1, migrated)
+	migratedChannel, _, _ := dstStorage.Get(suite.key(42))
+	assert.Equal(suite.T(), channel, migratedChannel)
+}
+
 type BlockchainChannelReaderSuite struct {
 	suite.Suite
 
@@ -115,6 +333,9 @@ func (suite *BlockchainChannelReaderSuite) SetupSuite() {
 			address := suite.recipientAddress
 			return address
 		},
+		mpeContractAddress: func() common.Address {
+			return blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf")
+		},
 	}
 }
 
@@ -136,16 +357,17 @@ func (suite *BlockchainChannelReaderSuite) mpeChannel() *blockchain.MultiPartyEs
 
 func (suite *BlockchainChannelReaderSuite) channel() *PaymentChannelData {
 	return &PaymentChannelData{
-		ChannelID:        big.NewInt(42),
-		Nonce:            big.NewInt(3),
-		Sender:           suite.senderAddress,
-		Recipient:        suite.recipientAddress,
-		GroupID:          [32]byte{123},
-		FullAmount:       big.NewInt(12345),
-		Expiration:       big.NewInt(100),
-		Signer:           suite.signerAddress,
-		AuthorizedAmount: big.NewInt(0),
-		Signature:        nil,
+		MpeContractAddress: blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf"),
+		ChannelID:          big.NewInt(42),
+		Nonce:              big.NewInt(3),
+		Sender:             suite.senderAddress,
+		Recipient:          suite.recipientAddress,
+		GroupID:            [32]byte{123},
+		FullAmount:         big.NewInt(12345),
+		Expiration:         big.NewInt(100),
+		Signer:             suite.signerAddress,
+		AuthorizedAmount:   big.NewInt(0),
+		Signature:          nil,
 	}
 }
 
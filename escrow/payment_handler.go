@@ -4,9 +4,12 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/config"
 	"github.com/singnet/snet-daemon/handler"
 )
 
@@ -17,21 +20,85 @@ const (
 	EscrowPaymentType = "escrow"
 )
 
+// paymentMetadataKeys is a list of metadata keys which are checked against
+// size and count limits before payment is parsed
+var paymentMetadataKeys = []string{
+	handler.PaymentChannelIDHeader,
+	handler.PaymentChannelNonceHeader,
+	handler.PaymentChannelAmountHeader,
+	handler.PaymentChannelSignatureHeader,
+}
+
 type paymentChannelPaymentHandler struct {
 	service            PaymentChannelService
 	mpeContractAddress func() common.Address
 	incomeValidator    IncomeValidator
+	// maxMetadataValueSize is a maximal length of the payment metadata
+	// value in bytes, zero means no limit
+	maxMetadataValueSize int
+	// maxMetadataValueCount is a maximal number of values passed for the
+	// same payment metadata key, zero means no limit
+	maxMetadataValueCount int
+	// channelRateLimiter limits calls per payment channel, nil means no
+	// limit
+	channelRateLimiter ChannelRateLimiter
+	// senderRateLimiter limits calls per payment signer, nil means no limit
+	senderRateLimiter *SenderRateLimiter
+	// senderSpendingLimiter limits amount spent by sender across all
+	// channels, nil means no limit
+	senderSpendingLimiter SenderSpendingLimiter
+	// rebuildAuthorizedAmount enables trusting amount signed by client
+	// when stored authorized amount is behind it, for instance after
+	// storage is restored from a stale backup
+	rebuildAuthorizedAmount bool
+	// holds reduce amount available for the payments, nil if payment holds
+	// are disabled
+	holds *PaymentHolds
+	// meteringHook calculates amount charged for the successful call, nil
+	// means that the whole authorized amount is charged
+	meteringHook MeteringHook
+	// closures reject payments via channels closed by sender, nil if
+	// channel close acknowledgements are disabled
+	closures *ChannelClosures
+	// spending accounts amounts charged via each channel, nil if it is not
+	// tracked
+	spending *ChannelSpending
 }
 
 // NewPaymentHandler retuns new MultiPartyEscrow contract payment handler.
+// channelRateLimiter, senderRateLimiter and senderSpendingLimiter can be nil
+// if calls per channel, calls per sender and sender spendings are not
+// limited, holds can be nil if payment
+// holds are disabled, meteringHook can be nil if the whole authorized amount
+// is charged, closures can be nil if channel close acknowledgements are
+// disabled, spending can be nil if channel spending is not tracked.
 func NewPaymentHandler(
 	service PaymentChannelService,
 	processor *blockchain.Processor,
-	incomeValidator IncomeValidator) handler.PaymentHandler {
+	incomeValidator IncomeValidator,
+	channelRateLimiter ChannelRateLimiter,
+	senderRateLimiter *SenderRateLimiter,
+	senderSpendingLimiter SenderSpendingLimiter,
+	holds *PaymentHolds,
+	meteringHook MeteringHook,
+	closures *ChannelClosures,
+	spending *ChannelSpending) handler.PaymentHandler {
 	return &paymentChannelPaymentHandler{
-		service:            service,
-		mpeContractAddress: processor.EscrowContractAddress,
-		incomeValidator:    incomeValidator,
+		service:               service,
+		mpeContractAddress:    processor.EscrowContractAddress,
+		incomeValidator:       incomeValidator,
+		channelRateLimiter:    channelRateLimiter,
+		senderRateLimiter:     senderRateLimiter,
+		senderSpendingLimiter: senderSpendingLimiter,
+		holds:                 holds,
+		meteringHook:          meteringHook,
+		closures:              closures,
+		spending:              spending,
+
+		maxMetadataValueSize:  config.GetInt(config.PaymentMetadataMaxValueSize),
+		maxMetadataValueCount: config.GetInt(config.PaymentMetadataMaxValueCount),
+
+		rebuildAuthorizedAmount: config.GetBool(config.PaymentAuthorizedAmountRebuildEnabled),
 	}
 }
 
@@ -39,61 +106,336 @@ func (h *paymentChannelPaymentHandler) Type() (typ string) {
 	return EscrowPaymentType
 }
 
+// RequiredMetadata returns list of metadata keys required to pay via
+// payment channel
+func (h *paymentChannelPaymentHandler) RequiredMetadata() []string {
+	return paymentMetadataKeys
+}
+
 func (h *paymentChannelPaymentHandler) Payment(context *handler.GrpcStreamContext) (payment handler.Payment, err *handler.GrpcError) {
 	internalPayment, err := h.getPaymentFromContext(context)
 	if err != nil {
 		return
 	}
 
-	transaction, e := h.service.StartPaymentTransaction(internalPayment)
+	transaction, e := h.service.StartPaymentTransaction(context.RequestContext(), internalPayment)
 	if e != nil {
+		logRejectedPayment(context, internalPayment, e)
+		return nil, paymentErrorToGrpcError(e)
+	}
+
+	if e = h.checkSenderRateLimit(transaction.Channel().Sender); e != nil {
+		transaction.Rollback()
 		return nil, paymentErrorToGrpcError(e)
 	}
 
+	if h.closures != nil {
+		if e = h.closures.CheckOpen(internalPayment.ChannelID); e != nil {
+			transaction.Rollback()
+			return nil, paymentErrorToGrpcError(e)
+		}
+	}
+
+	if e = h.checkChannelRateLimit(internalPayment.ChannelID); e != nil {
+		transaction.Rollback()
+		return nil, paymentErrorToGrpcError(e)
+	}
+
+	if h.holds != nil {
+		if e = h.holds.CheckAvailableAmount(transaction.Channel(), internalPayment.Amount); e != nil {
+			transaction.Rollback()
+			return nil, paymentErrorToGrpcError(e)
+		}
+	}
+
 	income := big.NewInt(0)
 	income.Sub(internalPayment.Amount, transaction.Channel().AuthorizedAmount)
-	e = h.incomeValidator.Validate(&IncomeData{Income: income, GrpcContext: context})
+	if h.rebuildAuthorizedAmount {
+		income = h.rebuildStaleAuthorizedAmount(transaction.Channel(), internalPayment, &IncomeData{Income: income, GrpcContext: context, ChannelID: internalPayment.ChannelID})
+	}
+	if income.Sign() == 0 && isReadOnlyReplay(transaction) {
+		log.WithField("channelID", internalPayment.ChannelID).Debug("Replay of the authorized amount is served in read-only mode")
+		return transaction, nil
+	}
+	e = h.incomeValidator.Validate(&IncomeData{Income: income, GrpcContext: context, ChannelID: internalPayment.ChannelID})
 	if e != nil {
 		//Make sure the transaction is Rolled back , else this will cause a lock on the channel
 		transaction.Rollback()
 		return nil, paymentErrorToGrpcError(e)
 	}
 
+	if e = h.checkSenderSpendingLimit(transaction.Channel().Sender, income); e != nil {
+		transaction.Rollback()
+		return nil, paymentErrorToGrpcError(e)
+	}
+
 	return transaction, nil
 }
 
-func (h *paymentChannelPaymentHandler) getPaymentFromContext(context *handler.GrpcStreamContext) (payment *Payment, err *handler.GrpcError) {
-	channelID, err := handler.GetBigInt(context.MD, handler.PaymentChannelIDHeader)
-	if err != nil {
-		return
+// rebuildStaleAuthorizedAmount handles the payment which income is greater
+// than the price: client signed more than stored authorized amount plus
+// price, so stored amount is behind the client state. Payment is already
+// validated at this point: its signature is correct and amount doesn't
+// exceed channel full amount. Authorized amount of the transaction channel
+// is set to the amount signed by client minus price, storage is advanced to
+// the payment amount when transaction is committed. Returns income to
+// validate.
+func (h *paymentChannelPaymentHandler) rebuildStaleAuthorizedAmount(channel *PaymentChannelData, payment *Payment, data *IncomeData) *big.Int {
+	price, err := h.incomeValidator.Price(data)
+	if err != nil || data.Income.Cmp(price) <= 0 {
+		return data.Income
 	}
 
-	channelNonce, err := handler.GetBigInt(context.MD, handler.PaymentChannelNonceHeader)
-	if err != nil {
-		return
+	rebuilt := new(big.Int).Sub(payment.Amount, price)
+	log.WithField("channelID", payment.ChannelID).WithField("storedAuthorizedAmount", channel.AuthorizedAmount).
+		WithField("rebuiltAuthorizedAmount", rebuilt).Warn("Stored authorized amount is behind the amount signed by client, it is rebuilt")
+	channel.AuthorizedAmount = rebuilt
+	return price
+}
+
+// isReadOnlyReplay returns true if transaction is started in read-only mode,
+// payment of such transaction repeats the authorized amount which was already
+// paid, so it brings no income and is not validated by income validator
+func isReadOnlyReplay(transaction PaymentTransaction) bool {
+	paymentTransaction, ok := transaction.(*paymentTransaction)
+	return ok && paymentTransaction.readOnly
+}
+
+// logRejectedPayment logs client TLS identity together with payment signer
+// to correlate network and payment identities during investigations
+func logRejectedPayment(context *handler.GrpcStreamContext, payment *Payment, err error) {
+	entry := log.WithError(err).WithField("channelID", payment.ChannelID)
+	if context.PeerIdentity != "" {
+		entry = entry.WithField("peerIdentity", context.PeerIdentity)
+	}
+	if signer, e := getSignerAddressFromPayment(payment); e == nil {
+		entry = entry.WithField("signerAddress", blockchain.AddressToHex(signer))
+	}
+	entry.Warn("Payment via payment channel is rejected")
+}
+
+// checkChannelRateLimit is called after payment is validated, so calls with
+// incorrect payments don't consume the channel limit.
+func (h *paymentChannelPaymentHandler) checkChannelRateLimit(channelID *big.Int) error {
+	if h.channelRateLimiter == nil {
+		return nil
 	}
 
-	amount, err := handler.GetBigInt(context.MD, handler.PaymentChannelAmountHeader)
+	allowed, err := h.channelRateLimiter.Allow(channelID)
 	if err != nil {
-		return
+		log.WithError(err).WithField("channelID", channelID).Error("Unable to check payment channel rate limit")
+		return NewPaymentError(Internal, "unable to check payment channel rate limit")
+	}
+	if !allowed {
+		log.WithField("channelID", channelID).Info("Payment channel rate limit is reached")
+		return NewPaymentError(ResourceExhausted, "rate limit of payment channel %v is reached, try again later", channelID)
 	}
+	return nil
+}
 
-	signature, err := handler.GetBytes(context.MD, handler.PaymentChannelSignatureHeader)
+// checkSenderRateLimit is called after payment is validated and keyed by the
+// channel sender, so payments with forged signatures cannot consume the limit
+// of another sender.
+func (h *paymentChannelPaymentHandler) checkSenderRateLimit(sender common.Address) error {
+	if h.senderRateLimiter == nil {
+		return nil
+	}
+
+	if !h.senderRateLimiter.Allow(sender) {
+		log.WithField("sender", blockchain.AddressToHex(&sender)).Info("Sender rate limit is reached")
+		return NewPaymentError(ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+// checkSenderSpendingLimit is called after income is validated, spent amount
+// is accounted even if the call fails later as it is done for the channel
+// rate limit.
+func (h *paymentChannelPaymentHandler) checkSenderSpendingLimit(sender common.Address, income *big.Int) error {
+	if h.senderSpendingLimiter == nil {
+		return nil
+	}
+
+	allowed, err := h.senderSpendingLimiter.Allow(sender, income)
 	if err != nil {
-		return
+		log.WithError(err).WithField("sender", blockchain.AddressToHex(&sender)).Error("Unable to check sender spending limit")
+		return NewPaymentError(Internal, "unable to check sender spending limit")
 	}
+	if !allowed {
+		log.WithField("sender", blockchain.AddressToHex(&sender)).Info("Sender spending limit is reached")
+		return NewPaymentError(ResourceExhausted, "spending limit of sender %v is reached, try again later", blockchain.AddressToHex(&sender))
+	}
+	return nil
+}
 
-	return &Payment{
-		MpeContractAddress: h.mpeContractAddress(),
-		ChannelID:          channelID,
-		ChannelNonce:       channelNonce,
-		Amount:             amount,
-		Signature:          signature,
-	}, nil
+func (h *paymentChannelPaymentHandler) getPaymentFromContext(context *handler.GrpcStreamContext) (payment *Payment, err *handler.GrpcError) {
+	if e := h.checkMetadataLimits(context.MD); e != nil {
+		return nil, paymentErrorToGrpcError(e)
+	}
+
+	payment, e := ParsePaymentFromMetadata(context.MD)
+	if e != nil {
+		return nil, paymentErrorToGrpcError(e)
+	}
+	if payment.MpeContractAddress == (common.Address{}) {
+		payment.MpeContractAddress = h.mpeContractAddress()
+	}
+	payment.RequestHash = context.RequestHash
+	payment.Source = context.PeerAddress
+	return payment, nil
+}
+
+// ParsePaymentFromMetadata reads payment from the gRPC metadata. Channel id,
+// nonce, amount and signature are required, daemon id, request signature
+// and MPE contract address are optional. MpeContractAddress of the payment
+// is empty if it is not passed. Returns InvalidArgument PaymentError which
+// describes the first malformed field.
+func ParsePaymentFromMetadata(md metadata.MD) (payment *Payment, err error) {
+	payment = &Payment{}
+
+	if payment.ChannelID, err = getBigIntFromMetadata(md, handler.PaymentChannelIDHeader); err != nil {
+		return nil, err
+	}
+	if payment.ChannelNonce, err = getBigIntFromMetadata(md, handler.PaymentChannelNonceHeader); err != nil {
+		return nil, err
+	}
+	if payment.Amount, err = getBigIntFromMetadata(md, handler.PaymentChannelAmountHeader); err != nil {
+		return nil, err
+	}
+	if payment.Signature, err = getBytesFromMetadata(md, handler.PaymentChannelSignatureHeader); err != nil {
+		return nil, err
+	}
+
+	if len(md.Get(handler.PaymentDaemonIdHeader)) > 0 {
+		if payment.DaemonId, err = getSingleValueFromMetadata(md, handler.PaymentDaemonIdHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(md.Get(handler.PaymentRequestSignatureHeader)) > 0 {
+		if payment.RequestSignature, err = getBytesFromMetadata(md, handler.PaymentRequestSignatureHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(md.Get(handler.PaymentMpeContractAddressHeader)) > 0 {
+		address, e := getSingleValueFromMetadata(md, handler.PaymentMpeContractAddressHeader)
+		if e != nil {
+			return nil, e
+		}
+		if !common.IsHexAddress(address) {
+			return nil, NewPaymentError(InvalidArgument, "incorrect format of MPE contract address: %v", address)
+		}
+		payment.MpeContractAddress = common.HexToAddress(address)
+	}
+
+	if len(md.Get(handler.PaymentMessageTypeHeader)) > 0 {
+		if payment.MessageType, err = getSingleValueFromMetadata(md, handler.PaymentMessageTypeHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(md.Get(handler.PaymentGroupIdHeader)) > 0 {
+		groupID, e := getSingleValueFromMetadata(md, handler.PaymentGroupIdHeader)
+		if e != nil {
+			return nil, e
+		}
+		if payment.GroupID, e = blockchain.ConvertBase64Encoding(groupID); e != nil {
+			return nil, NewPaymentError(InvalidArgument, "incorrect format of payment group id: %v", groupID)
+		}
+	}
+
+	return payment, nil
+}
+
+func getBigIntFromMetadata(md metadata.MD, key string) (*big.Int, error) {
+	value, err := handler.GetBigInt(md, key)
+	return value, metadataErrorToPaymentError(err)
+}
+
+func getBytesFromMetadata(md metadata.MD, key string) ([]byte, error) {
+	value, err := handler.GetBytes(md, key)
+	return value, metadataErrorToPaymentError(err)
+}
+
+func getSingleValueFromMetadata(md metadata.MD, key string) (string, error) {
+	value, err := handler.GetSingleValue(md, key)
+	return value, metadataErrorToPaymentError(err)
+}
+
+// metadataErrorToPaymentError returns untyped nil if there is no error, so
+// result can be compared with nil
+func metadataErrorToPaymentError(err *handler.GrpcError) error {
+	if err == nil {
+		return nil
+	}
+	return NewPaymentError(InvalidArgument, "%v", err.Status.Message())
+}
+
+// checkMetadataLimits rejects payment metadata values which are too long or
+// repeated too many times, it is done before parsing to not waste resources
+// on malformed requests.
+func (h *paymentChannelPaymentHandler) checkMetadataLimits(md metadata.MD) error {
+	for _, key := range paymentMetadataKeys {
+		values := md.Get(key)
+		if h.maxMetadataValueCount > 0 && len(values) > h.maxMetadataValueCount {
+			return NewPaymentError(Unauthenticated, "too many values for \"%v\": %v, maximum allowed: %v", key, len(values), h.maxMetadataValueCount)
+		}
+		if h.maxMetadataValueSize <= 0 {
+			continue
+		}
+		for _, value := range values {
+			if len(value) > h.maxMetadataValueSize {
+				return NewPaymentError(Unauthenticated, "value of \"%v\" is too long: %v bytes, maximum allowed: %v", key, len(value), h.maxMetadataValueSize)
+			}
+		}
+	}
+	return nil
 }
 
 func (h *paymentChannelPaymentHandler) Complete(payment handler.Payment) (err *handler.GrpcError) {
-	return paymentErrorToGrpcError(payment.(*paymentTransaction).Commit())
+	transaction := payment.(*paymentTransaction)
+	return h.commit(transaction, transaction.income())
+}
+
+// commit commits charge and accounts it in the channel spending
+func (h *paymentChannelPaymentHandler) commit(transaction *paymentTransaction, charge *big.Int) *handler.GrpcError {
+	if e := transaction.commitCharge(charge); e != nil {
+		return paymentErrorToGrpcError(e)
+	}
+	if h.spending != nil {
+		// failed accounting is caught up by the next commit of the channel
+		authorizedAmount := new(big.Int).Add(transaction.channel.AuthorizedAmount, charge)
+		if e := h.spending.Account(transaction.payment.ChannelID, transaction.channel.Nonce, authorizedAmount); e != nil {
+			log.WithError(e).WithField("payment", transaction).WithField("charge", charge).Error("Unable to account channel spending")
+		}
+	}
+	return nil
+}
+
+// CompleteWithStats implements handler.MeteringPaymentHandler, it commits
+// the amount calculated by metering hook using the delivered output. When
+// hook fails the whole authorized amount is charged.
+func (h *paymentChannelPaymentHandler) CompleteWithStats(payment handler.Payment, stats *handler.StreamStats) (err *handler.GrpcError) {
+	if h.meteringHook == nil {
+		return h.Complete(payment)
+	}
+
+	transaction := payment.(*paymentTransaction)
+	authorized := transaction.income()
+	charge, e := h.meteringHook.Charge(stats, authorized)
+	if e != nil {
+		log.WithError(e).WithField("payment", transaction).WithField("stats", stats).Error("Unable to meter the call, authorized amount is charged")
+		charge = authorized
+	}
+	if charge.Sign() < 0 {
+		charge = big.NewInt(0)
+	}
+	if charge.Cmp(authorized) > 0 {
+		log.WithField("payment", transaction).WithField("charge", charge).Debug("Metered charge exceeds authorized amount, it is reduced")
+		charge = authorized
+	}
+	return h.commit(transaction, charge)
 }
 
 func (h *paymentChannelPaymentHandler) CompleteAfterError(payment handler.Payment, result error) (err *handler.GrpcError) {
@@ -105,23 +447,10 @@ func paymentErrorToGrpcError(err error) *handler.GrpcError {
 		return nil
 	}
 
-	if _, ok := err.(*PaymentError); !ok {
+	paymentErr, ok := err.(*PaymentError)
+	if !ok {
 		return handler.NewGrpcErrorf(codes.Internal, "internal error: %v", err)
 	}
 
-	var grpcCode codes.Code
-	switch err.(*PaymentError).Code {
-	case Internal:
-		grpcCode = codes.Internal
-	case Unauthenticated:
-		grpcCode = codes.Unauthenticated
-	case FailedPrecondition:
-		grpcCode = codes.FailedPrecondition
-	case IncorrectNonce:
-		grpcCode = handler.IncorrectNonce
-	default:
-		grpcCode = codes.Internal
-	}
-
-	return handler.NewGrpcErrorf(grpcCode, err.(*PaymentError).Message)
+	return handler.NewGrpcErrorf(paymentErr.Code.GrpcCode(), paymentErr.Message)
 }
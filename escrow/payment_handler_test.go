@@ -4,6 +4,7 @@ import (
 	"math/big"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/assert"
@@ -143,3 +144,157 @@ func (suite *PaymentHandlerTestSuite) TestValidatePaymentIncorrectIncome() {
 	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unauthenticated, "incorrect payment income: \"45\", expected \"46\""), err)
 	assert.Nil(suite.T(), payment)
 }
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentSignatureTooLong() {
+	context := suite.grpcContext(func(md *metadata.MD) {
+		md.Set(handler.PaymentChannelSignatureHeader, string(make([]byte, 66)))
+	})
+	paymentHandler := suite.paymentHandler
+	paymentHandler.maxMetadataValueSize = 65
+
+	payment, err := paymentHandler.Payment(context)
+
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unauthenticated, "value of \"snet-payment-channel-signature-bin\" is too long: 66 bytes, maximum allowed: 65"), err)
+	assert.Nil(suite.T(), payment)
+}
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentSignatureDuplicated() {
+	context := suite.grpcContext(func(md *metadata.MD) {
+		md.Append(handler.PaymentChannelSignatureHeader, string([]byte{0x1, 0x2, 0xFE, 0xFF}))
+	})
+	paymentHandler := suite.paymentHandler
+	paymentHandler.maxMetadataValueCount = 1
+
+	payment, err := paymentHandler.Payment(context)
+
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unauthenticated, "too many values for \"snet-payment-channel-signature-bin\": 2, maximum allowed: 1"), err)
+	assert.Nil(suite.T(), payment)
+}
+
+type channelRateLimiterMock struct {
+	allowed bool
+}
+
+func (limiter *channelRateLimiterMock) Allow(channelID *big.Int) (bool, error) {
+	return limiter.allowed, nil
+}
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentChannelRateLimitReached() {
+	context := suite.grpcContext(func(md *metadata.MD) {})
+	paymentHandler := suite.paymentHandler
+	paymentHandler.channelRateLimiter = &channelRateLimiterMock{allowed: false}
+
+	payment, err := paymentHandler.Payment(context)
+
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.ResourceExhausted, "rate limit of payment channel 42 is reached, try again later"), err)
+	assert.Nil(suite.T(), payment)
+}
+
+type senderSpendingLimiterMock struct {
+	allowed bool
+	amount  *big.Int
+}
+
+func (limiter *senderSpendingLimiterMock) Allow(sender common.Address, amount *big.Int) (bool, error) {
+	limiter.amount = amount
+	return limiter.allowed, nil
+}
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentSenderSpendingLimitReached() {
+	context := suite.grpcContext(func(md *metadata.MD) {})
+	paymentHandler := suite.paymentHandler
+	limiter := &senderSpendingLimiterMock{allowed: false}
+	paymentHandler.senderSpendingLimiter = limiter
+
+	payment, err := paymentHandler.Payment(context)
+
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.ResourceExhausted, "spending limit of sender 0x0000000000000000000000000000000000000000 is reached, try again later"), err)
+	assert.Nil(suite.T(), payment)
+	assert.Equal(suite.T(), big.NewInt(45), limiter.amount)
+}
+
+func (suite *PaymentHandlerTestSuite) senderService(sender common.Address) PaymentChannelService {
+	channel := suite.channel()
+	channel.Sender = sender
+	return &paymentChannelServiceMock{data: channel}
+}
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentSenderRateLimitReached() {
+	paymentHandler := suite.paymentHandler
+	paymentHandler.senderRateLimiter = NewSenderRateLimiter(1, 1, time.Minute)
+
+	paymentHandler.service = suite.senderService(common.HexToAddress("0x1"))
+	_, errA := paymentHandler.Payment(suite.grpcContext(func(md *metadata.MD) {}))
+	paymentA, errB := paymentHandler.Payment(suite.grpcContext(func(md *metadata.MD) {}))
+	paymentHandler.service = suite.senderService(common.HexToAddress("0x2"))
+	_, errC := paymentHandler.Payment(suite.grpcContext(func(md *metadata.MD) {}))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.ResourceExhausted, "rate limit exceeded"), errB)
+	assert.Nil(suite.T(), paymentA)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+}
+
+func (suite *PaymentHandlerTestSuite) TestGetPaymentSenderRateLimitIsNotConsumedByRejectedPayment() {
+	paymentHandler := suite.paymentHandler
+	paymentHandler.senderRateLimiter = NewSenderRateLimiter(1, 1, time.Minute)
+
+	paymentHandler.service = &paymentChannelServiceMock{err: NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender")}
+	_, errA := paymentHandler.Payment(suite.grpcContext(func(md *metadata.MD) {}))
+	paymentHandler.service = suite.senderService(common.Address{})
+	_, errB := paymentHandler.Payment(suite.grpcContext(func(md *metadata.MD) {}))
+
+	assert.Equal(suite.T(), handler.NewGrpcError(codes.Unauthenticated, "payment is not signed by channel signer/sender"), errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func TestParsePaymentFromMetadata(t *testing.T) {
+	md := metadata.Pairs(
+		handler.PaymentChannelIDHeader, "42",
+		handler.PaymentChannelNonceHeader, "3",
+		handler.PaymentChannelAmountHeader, "12345",
+		handler.PaymentChannelSignatureHeader, string([]byte{0x1, 0x2, 0xFE, 0xFF}),
+		handler.PaymentDaemonIdHeader, "daemon-1",
+		handler.PaymentMpeContractAddressHeader, "0xf25186b5081ff5ce73482ad761db0eb0d25abfbf",
+	)
+
+	payment, err := ParsePaymentFromMetadata(md)
+
+	assert.Nil(t, err, "Unexpected error: %v", err)
+	assert.Equal(t, &Payment{
+		MpeContractAddress: blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf"),
+		ChannelID:          big.NewInt(42),
+		ChannelNonce:       big.NewInt(3),
+		Amount:             big.NewInt(12345),
+		Signature:          []byte{0x1, 0x2, 0xFE, 0xFF},
+		DaemonId:           "daemon-1",
+	}, payment)
+}
+
+func TestParsePaymentFromMetadataNoSignature(t *testing.T) {
+	md := metadata.Pairs(
+		handler.PaymentChannelIDHeader, "42",
+		handler.PaymentChannelNonceHeader, "3",
+		handler.PaymentChannelAmountHeader, "12345",
+	)
+
+	payment, err := ParsePaymentFromMetadata(md)
+
+	assert.Equal(t, NewPaymentError(InvalidArgument, "missing \"snet-payment-channel-signature-bin\""), err)
+	assert.Nil(t, payment)
+}
+
+func TestParsePaymentFromMetadataNonNumericAmount(t *testing.T) {
+	md := metadata.Pairs(
+		handler.PaymentChannelIDHeader, "42",
+		handler.PaymentChannelNonceHeader, "3",
+		handler.PaymentChannelAmountHeader, "12abc",
+		handler.PaymentChannelSignatureHeader, string([]byte{0x1, 0x2, 0xFE, 0xFF}),
+	)
+
+	payment, err := ParsePaymentFromMetadata(md)
+
+	assert.Equal(t, NewPaymentError(InvalidArgument, "incorrect format \"snet-payment-channel-amount\": \"12abc\""), err)
+	assert.Equal(t, codes.InvalidArgument, err.(*PaymentError).Code.GrpcCode())
+	assert.Nil(t, payment)
+}
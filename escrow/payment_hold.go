@@ -0,0 +1,256 @@
+package escrow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+// PaymentHold is an amount of the payment channel reserved by client before
+// the final charge is known. Held amount reduces the amount which is
+// available for other payments via the channel until the hold is captured,
+// released or expired.
+type PaymentHold struct {
+	// ID is an id of the hold, it is unique within the channel
+	ID string `json:"id"`
+	// ChannelID is an id of the payment channel
+	ChannelID *big.Int `json:"channelId"`
+	// Amount is an amount on hold on top of the channel authorized amount
+	Amount *big.Int `json:"amount"`
+	// Expiration is a time after which hold is released automatically
+	Expiration time.Time `json:"expiration"`
+}
+
+// PaymentHolds keeps payment holds in the storage alongside the payment
+// channel state. Hold and capture are made within payment transaction, so
+// they are serialized with the other payments via the same channel.
+//
+// To put amount on hold client signs the payment for the channel authorized
+// amount plus amount to hold. Channel authorized amount is not changed by the
+// hold. To capture the hold client signs the payment for the channel
+// authorized amount plus the final charge which should not exceed the amount
+// on hold, the rest of the hold is released.
+type PaymentHolds struct {
+	service PaymentChannelService
+	storage AtomicStorage
+	// maxTTL is a maximal time the amount can be held
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// NewPaymentHolds returns new instance which keeps holds in the storage.
+func NewPaymentHolds(service PaymentChannelService, storage AtomicStorage, metadata *blockchain.ServiceMetadata, maxTTL time.Duration) *PaymentHolds {
+	return &PaymentHolds{
+		service: service,
+		storage: &PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/hold",
+		},
+		maxTTL: maxTTL,
+		now:    time.Now,
+	}
+}
+
+// Hold puts the difference between payment amount and channel authorized
+// amount on hold for ttl. Zero ttl or ttl greater than the maximal one is
+// replaced by the maximal ttl.
+func (holds *PaymentHolds) Hold(ctx context.Context, payment *Payment, ttl time.Duration) (hold *PaymentHold, err error) {
+	transaction, err := holds.service.StartPaymentTransaction(ctx, payment)
+	if err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	channel := transaction.Channel()
+	amount := new(big.Int).Sub(payment.Amount, channel.AuthorizedAmount)
+	if amount.Sign() <= 0 {
+		return nil, NewPaymentError(Unauthenticated, "amount to hold should be positive, authorized amount: %v, payment amount: %v", channel.AuthorizedAmount, payment.Amount)
+	}
+	if err = holds.checkAvailableAmount(channel, payment.Amount, ""); err != nil {
+		return
+	}
+
+	if ttl <= 0 || ttl > holds.maxTTL {
+		ttl = holds.maxTTL
+	}
+	id, err := newPaymentHoldID()
+	if err != nil {
+		log.WithError(err).Error("Unable to generate payment hold id")
+		return nil, NewPaymentError(Internal, "cannot generate payment hold id")
+	}
+	hold = &PaymentHold{
+		ID:         id,
+		ChannelID:  payment.ChannelID,
+		Amount:     amount,
+		Expiration: holds.now().Add(ttl),
+	}
+	value, err := json.Marshal(hold)
+	if err != nil {
+		return nil, NewPaymentError(Internal, "cannot serialize payment hold")
+	}
+	ok, err := holds.storage.PutIfAbsent(paymentHoldKey(payment.ChannelID, id), string(value))
+	if err != nil {
+		log.WithError(err).WithField("hold", hold).Error("Unable to store payment hold")
+		return nil, NewPaymentError(Internal, "cannot store payment hold")
+	}
+	if !ok {
+		return nil, NewPaymentError(Internal, "payment hold %v already exists", id)
+	}
+
+	log.WithField("hold", hold).Debug("Payment hold is created")
+	return hold, nil
+}
+
+// Capture charges the difference between payment amount and channel
+// authorized amount from the hold and releases the rest of it. Channel
+// authorized amount is updated to the payment amount. Returns captured
+// amount.
+func (holds *PaymentHolds) Capture(ctx context.Context, holdID string, payment *Payment) (captured *big.Int, err error) {
+	transaction, err := holds.service.StartPaymentTransaction(ctx, payment)
+	if err != nil {
+		return
+	}
+	rollback := func(err error) (*big.Int, error) {
+		transaction.Rollback()
+		return nil, err
+	}
+
+	hold, ok, err := holds.get(payment.ChannelID, holdID)
+	if err != nil {
+		return rollback(err)
+	}
+	if !ok {
+		return rollback(NewPaymentError(FailedPrecondition, "payment hold %v is not found or expired", holdID))
+	}
+
+	channel := transaction.Channel()
+	captured = new(big.Int).Sub(payment.Amount, channel.AuthorizedAmount)
+	if captured.Sign() < 0 || captured.Cmp(hold.Amount) > 0 {
+		return rollback(NewPaymentError(Unauthenticated, "captured amount should be between 0 and amount on hold, captured amount: %v, amount on hold: %v", captured, hold.Amount))
+	}
+	if err = holds.checkAvailableAmount(channel, payment.Amount, holdID); err != nil {
+		return rollback(err)
+	}
+
+	// hold is removed before payment is committed, so the same hold
+	// cannot be captured twice
+	if err = holds.storage.Delete(paymentHoldKey(payment.ChannelID, holdID)); err != nil {
+		log.WithError(err).WithField("hold", hold).Error("Unable to remove captured payment hold")
+		return rollback(NewPaymentError(Internal, "cannot remove payment hold"))
+	}
+	if err = transaction.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.WithField("hold", hold).WithField("captured", captured).Debug("Payment hold is captured")
+	return captured, nil
+}
+
+// Release releases the whole amount on hold.
+func (holds *PaymentHolds) Release(channelID *big.Int, holdID string) (err error) {
+	if err = holds.storage.Delete(paymentHoldKey(channelID, holdID)); err != nil {
+		log.WithError(err).WithField("channelID", channelID).WithField("holdID", holdID).Error("Unable to remove payment hold")
+		return NewPaymentError(Internal, "cannot remove payment hold")
+	}
+	log.WithField("channelID", channelID).WithField("holdID", holdID).Debug("Payment hold is released")
+	return nil
+}
+
+// get returns not expired hold by id
+func (holds *PaymentHolds) get(channelID *big.Int, holdID string) (hold *PaymentHold, ok bool, err error) {
+	value, ok, err := holds.storage.Get(paymentHoldKey(channelID, holdID))
+	if err != nil {
+		log.WithError(err).WithField("channelID", channelID).WithField("holdID", holdID).Error("Unable to read payment hold")
+		return nil, false, NewPaymentError(Internal, "cannot read payment hold")
+	}
+	if !ok {
+		return
+	}
+	hold, err = parsePaymentHold(value)
+	if err != nil {
+		return nil, false, NewPaymentError(Internal, "cannot read payment hold")
+	}
+	if !hold.Expiration.After(holds.now()) {
+		return nil, false, nil
+	}
+	return hold, true, nil
+}
+
+// heldAmount returns sum of the holds of the channel except the hold with
+// excludeID, expired holds are removed from the storage
+func (holds *PaymentHolds) heldAmount(channelID *big.Int, excludeID string) (held *big.Int, err error) {
+	values, err := holds.storage.GetByKeyPrefix(channelID.String() + "/")
+	if err != nil {
+		log.WithError(err).WithField("channelID", channelID).Error("Unable to read payment holds")
+		return nil, NewPaymentError(Internal, "cannot read payment holds")
+	}
+
+	held = big.NewInt(0)
+	for _, value := range values {
+		hold, err := parsePaymentHold(value)
+		if err != nil {
+			return nil, NewPaymentError(Internal, "cannot read payment holds")
+		}
+		if !hold.Expiration.After(holds.now()) {
+			log.WithField("hold", hold).Debug("Payment hold is expired and released")
+			if e := holds.storage.Delete(paymentHoldKey(channelID, hold.ID)); e != nil {
+				log.WithError(e).WithField("hold", hold).Warn("Unable to remove expired payment hold")
+			}
+			continue
+		}
+		if hold.ID != excludeID {
+			held.Add(held, hold.Amount)
+		}
+	}
+	return held, nil
+}
+
+// CheckAvailableAmount returns error if payment amount together with the
+// amounts on hold exceeds the channel full amount.
+func (holds *PaymentHolds) CheckAvailableAmount(channel *PaymentChannelData, amount *big.Int) error {
+	return holds.checkAvailableAmount(channel, amount, "")
+}
+
+func (holds *PaymentHolds) checkAvailableAmount(channel *PaymentChannelData, amount *big.Int, excludeID string) error {
+	held, err := holds.heldAmount(channel.ChannelID, excludeID)
+	if err != nil {
+		return err
+	}
+	if held.Sign() == 0 {
+		return nil
+	}
+	if new(big.Int).Add(amount, held).Cmp(channel.FullAmount) > 0 {
+		log.WithField("channelID", channel.ChannelID).WithField("amount", amount).WithField("held", held).Info("Not enough tokens on payment channel because of holds")
+		return NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: %v, payment amount: %v, amount on hold: %v", channel.FullAmount, amount, held)
+	}
+	return nil
+}
+
+func newPaymentHoldID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func parsePaymentHold(value string) (hold *PaymentHold, err error) {
+	hold = &PaymentHold{}
+	if err = json.Unmarshal([]byte(value), hold); err != nil {
+		log.WithError(err).WithField("value", value).Error("Incorrect payment hold")
+		return nil, fmt.Errorf("incorrect payment hold: %v", err)
+	}
+	return
+}
+
+func paymentHoldKey(channelID *big.Int, holdID string) string {
+	return channelID.String() + "/" + holdID
+}
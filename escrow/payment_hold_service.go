@@ -0,0 +1,104 @@
+//go:generate protoc -I . ./payment_hold.proto --go_out=plugins=grpc:.
+
+package escrow
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/net/context"
+
+	"github.com/singnet/snet-daemon/authutils"
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+// PaymentHoldService is an implementation of PaymentHoldServiceServer gRPC
+// interface
+type PaymentHoldService struct {
+	holds      *PaymentHolds
+	mpeAddress func() common.Address
+}
+
+// NewPaymentHoldService returns new instance of PaymentHoldService
+func NewPaymentHoldService(holds *PaymentHolds, metadata *blockchain.ServiceMetadata) *PaymentHoldService {
+	return &PaymentHoldService{
+		holds:      holds,
+		mpeAddress: func() common.Address { return metadata.GetMpeAddress() },
+	}
+}
+
+// Hold puts amount on hold
+func (service *PaymentHoldService) Hold(ctx context.Context, request *HoldRequest) (reply *HoldReply, err error) {
+	payment := service.payment(request.GetChannelId(), request.GetChannelNonce(), request.GetSignedAmount(), request.GetSignature())
+	hold, err := service.holds.Hold(ctx, payment, time.Duration(request.GetTtlSeconds())*time.Second)
+	if err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+	return &HoldReply{
+		HoldId:     hold.ID,
+		Amount:     bigIntToBytes(hold.Amount),
+		Expiration: hold.Expiration.Unix(),
+	}, nil
+}
+
+// Capture charges amount from the hold
+func (service *PaymentHoldService) Capture(ctx context.Context, request *CaptureRequest) (reply *CaptureReply, err error) {
+	payment := service.payment(request.GetChannelId(), request.GetChannelNonce(), request.GetSignedAmount(), request.GetSignature())
+	captured, err := service.holds.Capture(ctx, request.GetHoldId(), payment)
+	if err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+	return &CaptureReply{CapturedAmount: bigIntToBytes(captured)}, nil
+}
+
+// Release releases the hold, request should be signed by channel signer or
+// sender
+func (service *PaymentHoldService) Release(ctx context.Context, request *ReleaseRequest) (reply *ReleaseReply, err error) {
+	channelID := bytesToBigInt(request.GetChannelId())
+	if err = service.verifyReleaseSigner(channelID, request.GetHoldId(), request.GetSignature()); err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+	if err = service.holds.Release(channelID, request.GetHoldId()); err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+	return &ReleaseReply{}, nil
+}
+
+func (service *PaymentHoldService) payment(channelID, nonce, amount, signature []byte) *Payment {
+	return &Payment{
+		MpeContractAddress: service.mpeAddress(),
+		ChannelID:          bytesToBigInt(channelID),
+		ChannelNonce:       bytesToBigInt(nonce),
+		Amount:             bytesToBigInt(amount),
+		Signature:          signature,
+	}
+}
+
+func (service *PaymentHoldService) verifyReleaseSigner(channelID *big.Int, holdID string, signature []byte) error {
+	signer, err := authutils.GetSignerAddressFromMessage(releasePaymentHoldMessage(service.mpeAddress(), channelID, holdID), signature)
+	if err != nil {
+		return NewPaymentError(Unauthenticated, "incorrect signature")
+	}
+	channel, ok, err := service.holds.service.PaymentChannel(&PaymentChannelKey{ID: channelID})
+	if err != nil {
+		return NewPaymentError(Internal, "payment channel error: %v", err)
+	}
+	if !ok {
+		return NewPaymentError(Unauthenticated, "payment channel \"%v\" not found", channelID)
+	}
+	if *signer != channel.Signer && *signer != channel.Sender {
+		return NewPaymentError(Unauthenticated, "only channel signer or sender can release payment hold")
+	}
+	return nil
+}
+
+func releasePaymentHoldMessage(mpeAddress common.Address, channelID *big.Int, holdID string) []byte {
+	return bytes.Join([][]byte{
+		[]byte("__release_payment_hold"),
+		mpeAddress.Bytes(),
+		bigIntToBytes(channelID),
+		[]byte(holdID),
+	}, nil)
+}
@@ -0,0 +1,137 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/handler"
+)
+
+func (suite *PaymentChannelServiceSuite) paymentHolds(now *time.Time) *PaymentHolds {
+	holds := NewPaymentHolds(suite.service, suite.memoryStorage, &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}, time.Minute)
+	holds.now = func() time.Time { return *now }
+	return holds
+}
+
+func (suite *PaymentChannelServiceSuite) signedPayment(amount int64) *Payment {
+	payment := suite.payment()
+	payment.Amount = big.NewInt(amount)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	return payment
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldCapture() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+
+	hold, errA := holds.Hold(context.Background(), suite.signedPayment(1100), 0)
+	errB := holds.CheckAvailableAmount(suite.channel(), big.NewInt(11400))
+	captured, errC := holds.Capture(context.Background(), hold.ID, suite.signedPayment(700))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+	errD := holds.CheckAvailableAmount(channel, big.NewInt(12345))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), big.NewInt(1000), hold.Amount)
+	assert.Equal(suite.T(), now.Add(time.Minute), hold.Expiration)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345, payment amount: 11400, amount on hold: 1000"), errB)
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Equal(suite.T(), big.NewInt(600), captured)
+	assert.Equal(suite.T(), big.NewInt(700), channel.AuthorizedAmount)
+	assert.Nil(suite.T(), errD, "Unexpected error: %v", errD)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldCaptureTwice() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+
+	hold, _ := holds.Hold(context.Background(), suite.signedPayment(1100), 0)
+	_, errA := holds.Capture(context.Background(), hold.ID, suite.signedPayment(700))
+	_, errB := holds.Capture(context.Background(), hold.ID, suite.signedPayment(1100))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), NewPaymentError(FailedPrecondition, "payment hold %v is not found or expired", hold.ID), errB)
+	assert.Equal(suite.T(), big.NewInt(700), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldCaptureMoreThanHeld() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+
+	hold, _ := holds.Hold(context.Background(), suite.signedPayment(1100), 0)
+	_, err := holds.Capture(context.Background(), hold.ID, suite.signedPayment(1200))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "captured amount should be between 0 and amount on hold, captured amount: 1100, amount on hold: 1000"), err)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldRelease() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+
+	hold, _ := holds.Hold(context.Background(), suite.signedPayment(1100), 0)
+	errA := holds.Release(hold.ChannelID, hold.ID)
+	errB := holds.CheckAvailableAmount(suite.channel(), big.NewInt(12345))
+	_, errC := holds.Capture(context.Background(), hold.ID, suite.signedPayment(700))
+	channel, _, _ := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), NewPaymentError(FailedPrecondition, "payment hold %v is not found or expired", hold.ID), errC)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldExpiry() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+
+	hold, _ := holds.Hold(context.Background(), suite.signedPayment(1100), 30*time.Second)
+	now = now.Add(30 * time.Second)
+	errA := holds.CheckAvailableAmount(suite.channel(), big.NewInt(12345))
+	_, stored, _ := holds.storage.Get(paymentHoldKey(hold.ChannelID, hold.ID))
+	_, errB := holds.Capture(context.Background(), hold.ID, suite.signedPayment(700))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.False(suite.T(), stored)
+	assert.Equal(suite.T(), NewPaymentError(FailedPrecondition, "payment hold %v is not found or expired", hold.ID), errB)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHoldRequiresValidSignature() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(1100)
+	SignTestPayment(payment, GenerateTestPrivateKey())
+
+	hold, err := holds.Hold(context.Background(), payment, 0)
+
+	assert.Nil(suite.T(), hold)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), err)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentRejectedBecauseOfHold() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+	paymentHandler := suite.rebuildingPaymentHandler(10)
+	paymentHandler.rebuildAuthorizedAmount = false
+	paymentHandler.holds = holds
+
+	_, errA := holds.Hold(context.Background(), suite.signedPayment(12340), 0)
+	transaction, errB := paymentHandler.Payment(suite.paymentContext(suite.signedPayment(110)))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), transaction)
+	assert.Equal(suite.T(), handler.NewGrpcErrorf(codes.Unauthenticated, "not enough tokens on payment channel, channel amount: 12345, payment amount: 110, amount on hold: 12240"), errB)
+}
@@ -0,0 +1,67 @@
+package escrow
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// PaymentMessageTypeV1 is a layout of the message verified by the
+	// MultiPartyEscrow contract: MPE address, channel id, nonce and amount
+	PaymentMessageTypeV1 = "v1"
+	// PaymentMessageTypeV2 adds payment group id after MPE address to the
+	// v1 layout. Current MPE contract verifies v1 messages only, so v2 is
+	// reserved for the future payment schemes.
+	PaymentMessageTypeV2 = "v2"
+)
+
+// PaymentMessageEncoder returns the message which is signed by client to
+// authorize the payment, numbers are encoded using passed encoding
+type PaymentMessageEncoder interface {
+	Encode(payment *Payment, encoding SignatureEncoding) ([]byte, error)
+}
+
+// PaymentMessageEncoderFunc is an adapter to use function as
+// PaymentMessageEncoder
+type PaymentMessageEncoderFunc func(payment *Payment, encoding SignatureEncoding) ([]byte, error)
+
+// Encode implements PaymentMessageEncoder
+func (encode PaymentMessageEncoderFunc) Encode(payment *Payment, encoding SignatureEncoding) ([]byte, error) {
+	return encode(payment, encoding)
+}
+
+var paymentMessageEncoders = map[string]PaymentMessageEncoder{
+	PaymentMessageTypeV1: PaymentMessageEncoderFunc(paymentMessage),
+	PaymentMessageTypeV2: PaymentMessageEncoderFunc(paymentMessageV2),
+}
+
+// GetPaymentMessageEncoder returns encoder of the payment message type,
+// empty type means PaymentMessageTypeV1
+func GetPaymentMessageEncoder(messageType string) (PaymentMessageEncoder, error) {
+	if messageType == "" {
+		messageType = PaymentMessageTypeV1
+	}
+	encoder, ok := paymentMessageEncoders[messageType]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment message type: \"%v\"", messageType)
+	}
+	return encoder, nil
+}
+
+// paymentMessageV2 returns v1 message with payment group id inserted after
+// MPE contract address
+func paymentMessageV2(payment *Payment, encoding SignatureEncoding) ([]byte, error) {
+	numbers, err := encoding.encodePaymentNumbers(payment)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([][]byte{
+		[]byte(PrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+		payment.GroupID[:],
+	}, numbers...)
+	if payment.DaemonId != "" {
+		parts = append(parts, []byte(payment.DaemonId))
+	}
+	return bytes.Join(parts, nil), nil
+}
@@ -0,0 +1,99 @@
+package escrow
+
+import (
+	"bytes"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ValidationTestSuite) paymentV2(groupID [32]byte) *Payment {
+	payment := suite.payment()
+	payment.MessageType = PaymentMessageTypeV2
+	payment.GroupID = groupID
+	message := bytes.Join([][]byte{
+		[]byte(PrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+		groupID[:],
+		bigIntToBytes(payment.ChannelID),
+		bigIntToBytes(payment.ChannelNonce),
+		bigIntToBytes(payment.Amount),
+	}, nil)
+	payment.Signature = getSignature(message, suite.signerPrivateKey)
+	return payment
+}
+
+func (suite *ValidationTestSuite) messageV2Validator() ChannelPaymentValidator {
+	validator := suite.validator
+	validator.messageV2Enabled = true
+	return validator
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageV2IsValid() {
+	payment := suite.paymentV2([32]byte{123})
+	validator := suite.messageV2Validator()
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageV2IsDisabled() {
+	payment := suite.paymentV2([32]byte{123})
+
+	err := suite.validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(InvalidArgument, "payment message type \"v2\" is disabled"), err)
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageV1IsValid() {
+	payment := suite.payment()
+	payment.MessageType = PaymentMessageTypeV1
+
+	errExplicit := suite.validator.Validate(payment, suite.channel())
+	errDefault := suite.validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errExplicit, "Unexpected error: %v", errExplicit)
+	assert.Nil(suite.T(), errDefault, "Unexpected error: %v", errDefault)
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageV2AnotherGroup() {
+	payment := suite.paymentV2([32]byte{124})
+	validator := suite.messageV2Validator()
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is signed for another payment group"), err)
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageV2SignedAsV1() {
+	payment := suite.payment()
+	payment.MessageType = PaymentMessageTypeV2
+	payment.GroupID = [32]byte{123}
+	validator := suite.messageV2Validator()
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), err)
+}
+
+func (suite *ValidationTestSuite) TestPaymentMessageUnknownType() {
+	payment := suite.payment()
+	payment.MessageType = "v3"
+
+	err := suite.validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(InvalidArgument, "unknown payment message type: \"v3\""), err)
+}
+
+func (suite *ValidationTestSuite) TestVerifyClaimSignatureOfPaymentMessageV2() {
+	payment := suite.paymentV2([32]byte{123})
+	channel := suite.channel()
+	channel.MpeContractAddress = suite.mpeContractAddress
+	channel.AuthorizedAmount = payment.Amount
+	channel.Signature = payment.Signature
+	channel.MessageType = PaymentMessageTypeV2
+
+	err := verifyClaimSignature(channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
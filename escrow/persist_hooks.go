@@ -0,0 +1,73 @@
+package escrow
+
+import (
+	"sync"
+)
+
+// PrePersistHook is called before the payment channel state updated by the
+// payment is written to the storage. before is a channel state the payment
+// was validated against, after is a state to be written. Returning error
+// aborts the write.
+type PrePersistHook func(payment *Payment, before *PaymentChannelData, after *PaymentChannelData) error
+
+// PostPersistHook is called after the payment channel state updated by the
+// payment is written to the storage, for instance to push the accepted
+// payment to the billing system.
+type PostPersistHook func(payment *Payment, before *PaymentChannelData, after *PaymentChannelData)
+
+// PersistHooks keeps hooks which are called around writing of the payment
+// channel state updated by the payment. Hooks are called in order of
+// registration.
+type PersistHooks struct {
+	mutex sync.RWMutex
+	pre   []PrePersistHook
+	post  []PostPersistHook
+}
+
+// NewPersistHooks returns empty list of hooks
+func NewPersistHooks() *PersistHooks {
+	return &PersistHooks{}
+}
+
+// AddPrePersistHook registers hook which is called before the write
+func (hooks *PersistHooks) AddPrePersistHook(hook PrePersistHook) {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.pre = append(hooks.pre, hook)
+}
+
+// AddPostPersistHook registers hook which is called after the write
+func (hooks *PersistHooks) AddPostPersistHook(hook PostPersistHook) {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.post = append(hooks.post, hook)
+}
+
+// beforePersist calls pre-persist hooks until the first error
+func (hooks *PersistHooks) beforePersist(payment *Payment, before *PaymentChannelData, after *PaymentChannelData) error {
+	if hooks == nil {
+		return nil
+	}
+	hooks.mutex.RLock()
+	defer hooks.mutex.RUnlock()
+
+	for _, hook := range hooks.pre {
+		if err := hook(payment, before, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// afterPersist calls all post-persist hooks
+func (hooks *PersistHooks) afterPersist(payment *Payment, before *PaymentChannelData, after *PaymentChannelData) {
+	if hooks == nil {
+		return
+	}
+	hooks.mutex.RLock()
+	defer hooks.mutex.RUnlock()
+
+	for _, hook := range hooks.post {
+		hook(payment, before, after)
+	}
+}
@@ -0,0 +1,89 @@
+package escrow
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func (suite *PaymentChannelServiceSuite) persistHooksPaymentHandler(hooks *PersistHooks) *paymentChannelPaymentHandler {
+	suite.service.(*lockingPaymentChannelService).persistHooks = hooks
+	return suite.meteringPaymentHandler(100, nil)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPersistHooksAreCalledInOrder() {
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(200)
+	var calls []string
+	var before, after *PaymentChannelData
+	hooks := NewPersistHooks()
+	hooks.AddPrePersistHook(func(payment *Payment, b *PaymentChannelData, a *PaymentChannelData) error {
+		calls = append(calls, "pre1")
+		before, after = b, a
+		return nil
+	})
+	hooks.AddPrePersistHook(func(payment *Payment, b *PaymentChannelData, a *PaymentChannelData) error {
+		calls = append(calls, "pre2")
+		return nil
+	})
+	hooks.AddPostPersistHook(func(payment *Payment, b *PaymentChannelData, a *PaymentChannelData) {
+		calls = append(calls, "post")
+	})
+	paymentHandler := suite.persistHooksPaymentHandler(hooks)
+
+	transaction, errA := paymentHandler.Payment(suite.paymentContext(payment))
+	errB := paymentHandler.Complete(transaction)
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), []string{"pre1", "pre2", "post"}, calls)
+	assert.Equal(suite.T(), big.NewInt(100), before.AuthorizedAmount)
+	assert.Equal(suite.T(), big.NewInt(200), after.AuthorizedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPrePersistHookErrorPreventsWrite() {
+	suite.putStaleChannel(100)
+	payment := suite.signedPayment(200)
+	postCalled := false
+	hooks := NewPersistHooks()
+	hooks.AddPrePersistHook(func(payment *Payment, before *PaymentChannelData, after *PaymentChannelData) error {
+		return errors.New("billing system is not available")
+	})
+	hooks.AddPostPersistHook(func(payment *Payment, before *PaymentChannelData, after *PaymentChannelData) {
+		postCalled = true
+	})
+	paymentHandler := suite.persistHooksPaymentHandler(hooks)
+
+	transaction, errA := paymentHandler.Payment(suite.paymentContext(payment))
+	errB := paymentHandler.Complete(transaction)
+	channel, _, errC := suite.storage.Get(suite.channelKey())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), codes.Internal, errB.Status.Code())
+	assert.Nil(suite.T(), errC, "Unexpected error: %v", errC)
+	assert.Equal(suite.T(), big.NewInt(100), channel.AuthorizedAmount)
+	assert.False(suite.T(), postCalled)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPersistHooksAreCalledOnHoldCapture() {
+	now := time.Unix(1000, 0)
+	holds := suite.paymentHolds(&now)
+	suite.putStaleChannel(100)
+	var after *PaymentChannelData
+	hooks := NewPersistHooks()
+	hooks.AddPostPersistHook(func(payment *Payment, b *PaymentChannelData, a *PaymentChannelData) {
+		after = a
+	})
+	suite.service.(*lockingPaymentChannelService).persistHooks = hooks
+
+	hold, errA := holds.Hold(context.Background(), suite.signedPayment(1100), 0)
+	_, errB := holds.Capture(context.Background(), hold.ID, suite.signedPayment(700))
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), big.NewInt(700), after.AuthorizedAmount)
+}
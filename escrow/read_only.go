@@ -0,0 +1,45 @@
+package escrow
+
+import (
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadOnlyMode freezes payment channel state, for instance during storage
+// compaction or backup. While it is enabled payments which only repeat the
+// already authorized amount are served and payments which require writing
+// new channel state are refused.
+type ReadOnlyMode struct {
+	enabled int32
+}
+
+// NewReadOnlyMode returns read-only mode toggle in the initial state
+func NewReadOnlyMode(enabled bool) *ReadOnlyMode {
+	mode := &ReadOnlyMode{}
+	mode.Set(enabled)
+	return mode
+}
+
+// Enabled returns true if daemon is in read-only mode, nil mode is never
+// enabled
+func (mode *ReadOnlyMode) Enabled() bool {
+	return mode != nil && atomic.LoadInt32(&mode.enabled) != 0
+}
+
+// Set switches read-only mode on or off
+func (mode *ReadOnlyMode) Set(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&mode.enabled, value) != value {
+		log.WithField("enabled", enabled).Info("Read-only mode is switched")
+	}
+}
+
+// errReadOnly is returned for payments which require write in read-only
+// mode
+func errReadOnly() error {
+	return NewPaymentError(Unavailable, "daemon in read-only mode")
+}
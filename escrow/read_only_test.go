@@ -0,0 +1,27 @@
+package escrow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyModeSet(t *testing.T) {
+	mode := NewReadOnlyMode(false)
+	assert.False(t, mode.Enabled())
+
+	mode.Set(true)
+	assert.True(t, mode.Enabled())
+
+	mode.Set(false)
+	assert.False(t, mode.Enabled())
+}
+
+func TestReadOnlyModeInitiallyEnabled(t *testing.T) {
+	assert.True(t, NewReadOnlyMode(true).Enabled())
+}
+
+func TestNilReadOnlyModeIsDisabled(t *testing.T) {
+	var mode *ReadOnlyMode
+	assert.False(t, mode.Enabled())
+}
@@ -0,0 +1,71 @@
+package escrow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/singnet/snet-daemon/cache"
+	"github.com/singnet/snet-daemon/config"
+)
+
+// ReplayCache remembers recently committed payments to reject exact replays
+// of them. Payment is identified by channel id, channel nonce and amount. It
+// is safe for concurrent use.
+type ReplayCache struct {
+	mutex   sync.Mutex
+	entries *cache.LRU
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewReplayCache returns cache which keeps up to maxEntries payments for
+// ttl each
+func NewReplayCache(maxEntries int, ttl time.Duration) *ReplayCache {
+	return &ReplayCache{
+		entries: cache.NewLRU("payment-replay", maxEntries),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// newReplayCacheFromConfig returns nil when replay cache is disabled
+func newReplayCacheFromConfig(cfg *viper.Viper) *ReplayCache {
+	maxEntries := cfg.GetInt(config.PaymentReplayCacheMaxEntries)
+	if maxEntries <= 0 {
+		return nil
+	}
+	return NewReplayCache(maxEntries, cfg.GetDuration(config.PaymentReplayCacheTTL))
+}
+
+func replayCacheKey(payment *Payment) string {
+	return fmt.Sprintf("%v/%v/%v", payment.ChannelID, payment.ChannelNonce, payment.Amount)
+}
+
+// Check returns error if the same payment was remembered within ttl
+func (replayCache *ReplayCache) Check(payment *Payment) error {
+	key := replayCacheKey(payment)
+	now := replayCache.now()
+
+	replayCache.mutex.Lock()
+	defer replayCache.mutex.Unlock()
+
+	if seen, ok := replayCache.entries.Get(key); ok && now.Sub(seen.(time.Time)) < replayCache.ttl {
+		return NewPaymentError(Unauthenticated, "payment already processed")
+	}
+	return nil
+}
+
+// Remember remembers the payment, it should be called only after the
+// payment is committed, so payment of the failed call can be retried
+func (replayCache *ReplayCache) Remember(payment *Payment) {
+	key := replayCacheKey(payment)
+	now := replayCache.now()
+
+	replayCache.mutex.Lock()
+	defer replayCache.mutex.Unlock()
+
+	replayCache.entries.Add(key, now)
+}
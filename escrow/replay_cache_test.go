@@ -0,0 +1,96 @@
+package escrow
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ValidationTestSuite) replayCacheValidator(now *time.Time) ChannelPaymentValidator {
+	replayCache := NewReplayCache(10, time.Minute)
+	replayCache.now = func() time.Time { return *now }
+	validator := suite.validator
+	validator.replayCache = replayCache
+	return validator
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentReplayed() {
+	now := time.Unix(1000, 0)
+	validator := suite.replayCacheValidator(&now)
+
+	errA := validator.Validate(suite.payment(), suite.channel())
+	validator.paymentCommitted(suite.payment())
+	errB := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment already processed"), errB)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentIsNotRememberedBeforeCommit() {
+	now := time.Unix(1000, 0)
+	validator := suite.replayCacheValidator(&now)
+
+	errA := validator.Validate(suite.payment(), suite.channel())
+	errB := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentWithAnotherAmountIsNotReplayed() {
+	now := time.Unix(1000, 0)
+	validator := suite.replayCacheValidator(&now)
+
+	errA := validator.Validate(suite.paymentWithAmount(12344), suite.channel())
+	validator.paymentCommitted(suite.paymentWithAmount(12344))
+	errB := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentReplayedAfterTTL() {
+	now := time.Unix(1000, 0)
+	validator := suite.replayCacheValidator(&now)
+
+	errA := validator.Validate(suite.payment(), suite.channel())
+	validator.paymentCommitted(suite.payment())
+	now = now.Add(time.Minute)
+	errB := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func (suite *ValidationTestSuite) TestValidateInvalidPaymentIsNotRemembered() {
+	now := time.Unix(1000, 0)
+	validator := suite.replayCacheValidator(&now)
+	channel := suite.channel()
+	channel.FullAmount = suite.payment().Amount
+
+	errA := validator.Validate(suite.paymentWithAmount(12346), channel)
+	channel.FullAmount = suite.paymentWithAmount(12346).Amount
+	errB := validator.Validate(suite.paymentWithAmount(12346), channel)
+
+	assert.NotNil(suite.T(), errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentReplayIsRejectedAfterCommit() {
+	validator := suite.service.(*lockingPaymentChannelService).validator
+	validator.replayCache = NewReplayCache(10, time.Minute)
+	defer func() { validator.replayCache = nil }()
+
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	errAR := transactionA.Rollback()
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+	errBC := transactionB.Commit()
+	_, errC := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.Nil(suite.T(), errAR, "Unexpected error: %v", errAR)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Nil(suite.T(), errBC, "Unexpected error: %v", errBC)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment already processed"), errC)
+}
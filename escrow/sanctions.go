@@ -0,0 +1,91 @@
+package escrow
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// SanctionsList is checked during payment validation to refuse payments from
+// sanctioned addresses. It can be implemented by an off-chain list or by a
+// client of an on-chain oracle; implementations are responsible for caching
+// results if the underlying source is slow.
+type SanctionsList interface {
+	// IsSanctioned returns true if address is on the sanctions list
+	IsSanctioned(address common.Address) (sanctioned bool, err error)
+}
+
+// fileSanctionsList keeps the list loaded from a file in memory and reloads
+// it when the list is older than refreshInterval.
+type fileSanctionsList struct {
+	path            string
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mutex     sync.Mutex
+	addresses map[common.Address]bool
+	loaded    time.Time
+}
+
+// NewFileSanctionsList returns sanctions list which is read from the file.
+// File contains one hex address per line, empty lines and lines starting
+// from '#' are ignored.
+func NewFileSanctionsList(path string, refreshInterval time.Duration) SanctionsList {
+	return &fileSanctionsList{
+		path:            path,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+}
+
+func (list *fileSanctionsList) IsSanctioned(address common.Address) (sanctioned bool, err error) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	if list.addresses == nil || list.now().Sub(list.loaded) >= list.refreshInterval {
+		addresses, err := readSanctionsFile(list.path)
+		if err != nil {
+			if list.addresses == nil {
+				return false, err
+			}
+			log.WithError(err).WithField("path", list.path).Warn("Unable to reload sanctions list, previous version is used")
+		} else {
+			list.addresses = addresses
+		}
+		list.loaded = list.now()
+	}
+
+	return list.addresses[address], nil
+}
+
+func readSanctionsFile(path string) (addresses map[common.Address]bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	addresses = make(map[common.Address]bool)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !common.IsHexAddress(text) {
+			return nil, fmt.Errorf("incorrect address at line %v of sanctions list: %v", line, text)
+		}
+		addresses[common.HexToAddress(text)] = true
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,67 @@
+package escrow
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func writeSanctionsFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "sanctions")
+	if err != nil {
+		t.Fatalf("Cannot create sanctions file: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatalf("Cannot write sanctions file: %v", err)
+	}
+	return file.Name()
+}
+
+func TestFileSanctionsList(t *testing.T) {
+	path := writeSanctionsFile(t, "# flagged senders\n\n0x5e592F9b1d303183d963635f895f0f0C48284f4e\n")
+	defer os.Remove(path)
+	list := NewFileSanctionsList(path, time.Minute)
+
+	flagged, errFlagged := list.IsSanctioned(blockchain.HexToAddress("0x5e592f9b1d303183d963635f895f0f0c48284f4e"))
+	unflagged, errUnflagged := list.IsSanctioned(blockchain.HexToAddress("0xf25186B5081Ff5cE73482AD761DB0eB0d25abfBF"))
+
+	assert.Nil(t, errFlagged)
+	assert.Nil(t, errUnflagged)
+	assert.True(t, flagged)
+	assert.False(t, unflagged)
+}
+
+func TestFileSanctionsListRefresh(t *testing.T) {
+	path := writeSanctionsFile(t, "")
+	defer os.Remove(path)
+	now := time.Unix(1000, 0)
+	list := NewFileSanctionsList(path, time.Minute).(*fileSanctionsList)
+	list.now = func() time.Time { return now }
+	address := blockchain.HexToAddress("0x5e592F9b1d303183d963635f895f0f0C48284f4e")
+
+	before, _ := list.IsSanctioned(address)
+	ioutil.WriteFile(path, []byte("0x5e592F9b1d303183d963635f895f0f0C48284f4e\n"), 0644)
+	cached, _ := list.IsSanctioned(address)
+	now = now.Add(time.Minute)
+	after, _ := list.IsSanctioned(address)
+
+	assert.False(t, before)
+	assert.False(t, cached)
+	assert.True(t, after)
+}
+
+func TestFileSanctionsListIncorrectAddress(t *testing.T) {
+	path := writeSanctionsFile(t, "0x5e592F9b1d303183d963635f895f0f0C48284f4e\nnot-an-address\n")
+	defer os.Remove(path)
+	list := NewFileSanctionsList(path, time.Minute)
+
+	_, err := list.IsSanctioned(blockchain.HexToAddress("0x5e592F9b1d303183d963635f895f0f0C48284f4e"))
+
+	assert.EqualError(t, err, "incorrect address at line 2 of sanctions list: not-an-address")
+}
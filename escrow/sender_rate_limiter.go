@@ -0,0 +1,28 @@
+package escrow
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+)
+
+// SenderRateLimiter limits rate of payments of the same channel sender using
+// token bucket per sender address. Buckets of senders which made no calls
+// during idle timeout are evicted to bound memory.
+type SenderRateLimiter struct {
+	buckets *keyedRateLimiter
+}
+
+// NewSenderRateLimiter returns rate limiter which allows callsPerMinute
+// calls per sender with bursts up to burst calls.
+func NewSenderRateLimiter(callsPerMinute int, burst int, idleTimeout time.Duration) *SenderRateLimiter {
+	return &SenderRateLimiter{
+		buckets: newKeyedRateLimiter(rate.Every(time.Minute/time.Duration(callsPerMinute)), burst, idleTimeout),
+	}
+}
+
+// Allow returns true if one more call of the sender is allowed
+func (limiter *SenderRateLimiter) Allow(sender common.Address) bool {
+	return limiter.buckets.Allow(sender.Hex())
+}
@@ -0,0 +1,72 @@
+package escrow
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSenderRateLimiterLimitsSendersIndependently(t *testing.T) {
+	limiter := NewSenderRateLimiter(1, 3, time.Minute)
+	senderA := common.HexToAddress("0x1")
+	senderB := common.HexToAddress("0x2")
+
+	allowedA, allowedB := 0, 0
+	for i := 0; i < 5; i++ {
+		if limiter.Allow(senderA) {
+			allowedA++
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if limiter.Allow(senderB) {
+			allowedB++
+		}
+	}
+
+	assert.Equal(t, 3, allowedA)
+	assert.Equal(t, 3, allowedB)
+}
+
+func TestSenderRateLimiterConcurrentBurst(t *testing.T) {
+	limiter := NewSenderRateLimiter(1, 10, time.Minute)
+	senders := []common.Address{common.HexToAddress("0x1"), common.HexToAddress("0x2")}
+	allowed := make([]int, len(senders))
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := range senders {
+		for j := 0; j < 20; j++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if limiter.Allow(senders[i]) {
+					mutex.Lock()
+					allowed[i]++
+					mutex.Unlock()
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{10, 10}, allowed)
+}
+
+func TestSenderRateLimiterEvictsIdleSenders(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewSenderRateLimiter(1, 1, time.Minute)
+	limiter.buckets.now = func() time.Time { return now }
+
+	limiter.Allow(common.HexToAddress("0x1"))
+	now = now.Add(30 * time.Second)
+	limiter.Allow(common.HexToAddress("0x2"))
+	sizeBefore := limiter.buckets.size()
+	now = now.Add(45 * time.Second)
+	limiter.Allow(common.HexToAddress("0x2"))
+
+	assert.Equal(t, 2, sizeBefore)
+	assert.Equal(t, 1, limiter.buckets.size())
+}
@@ -0,0 +1,43 @@
+package escrow
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+const senderSpendingLimitWindow = time.Minute
+
+// SenderSpendingLimiter limits amount of tokens which sender spends across
+// all of its payment channels. It complements ChannelRateLimiter which
+// can be dodged by splitting calls between many small channels.
+type SenderSpendingLimiter interface {
+	// Allow returns true and accounts the amount if sender is allowed to
+	// spend it
+	Allow(sender common.Address, amount *big.Int) (allowed bool, err error)
+}
+
+type storageSenderSpendingLimiter struct {
+	counter *windowCounter
+	limit   *big.Int
+}
+
+// NewSenderSpendingLimiter returns limiter which allows each sender to spend
+// amountPerMinute cogs per minute. Spent amounts are kept in the storage, so
+// the limit is shared between replicas when etcd storage is used.
+func NewSenderSpendingLimiter(storage AtomicStorage, metadata *blockchain.ServiceMetadata, amountPerMinute *big.Int) SenderSpendingLimiter {
+	return &storageSenderSpendingLimiter{
+		counter: newWindowCounter(&PrefixedAtomicStorage{
+			delegate:  storage,
+			keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/sender-spending",
+		}, senderSpendingLimitWindow),
+		limit: amountPerMinute,
+	}
+}
+
+func (limiter *storageSenderSpendingLimiter) Allow(sender common.Address, amount *big.Int) (allowed bool, err error) {
+	return limiter.counter.Add(blockchain.AddressToHex(&sender), amount, limiter.limit)
+}
@@ -0,0 +1,54 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func TestSenderSpendingLimiterAcrossChannels(t *testing.T) {
+	storage := NewMemStorage()
+	metadata := &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}
+	sender := blockchain.HexToAddress("0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB")
+	other := blockchain.HexToAddress("0x5e592F9b1d303183d963635f895f0f0C48284f4e")
+	// replicas serving different channels of the same sender share the limit
+	limiterA := NewSenderSpendingLimiter(storage, metadata, big.NewInt(100))
+	limiterB := NewSenderSpendingLimiter(storage, metadata, big.NewInt(100))
+
+	allowed1, err1 := limiterA.Allow(sender, big.NewInt(40))
+	allowed2, err2 := limiterB.Allow(sender, big.NewInt(40))
+	allowed3, err3 := limiterA.Allow(sender, big.NewInt(40))
+	allowed4, err4 := limiterB.Allow(sender, big.NewInt(20))
+	allowedOther, errOther := limiterA.Allow(other, big.NewInt(40))
+
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Nil(t, err3)
+	assert.Nil(t, err4)
+	assert.Nil(t, errOther)
+	assert.True(t, allowed1)
+	assert.True(t, allowed2)
+	assert.False(t, allowed3)
+	assert.True(t, allowed4)
+	assert.True(t, allowedOther)
+}
+
+func TestSenderSpendingLimiterNextWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewSenderSpendingLimiter(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}, big.NewInt(10)).(*storageSenderSpendingLimiter)
+	limiter.counter.now = func() time.Time { return now }
+	sender := blockchain.HexToAddress("0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB")
+
+	allowedA, _ := limiter.Allow(sender, big.NewInt(10))
+	allowedB, _ := limiter.Allow(sender, big.NewInt(1))
+	now = now.Add(time.Minute)
+	allowedC, _ := limiter.Allow(sender, big.NewInt(10))
+
+	assert.True(t, allowedA)
+	assert.False(t, allowedB)
+	assert.True(t, allowedC)
+}
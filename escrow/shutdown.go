@@ -0,0 +1,60 @@
+package escrow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ShutdownCoordinator tracks in-flight payments, so daemon can finish
+// writing channel updates before exit. After draining is started new
+// payments are refused.
+type ShutdownCoordinator struct {
+	mutex    sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// NewShutdownCoordinator returns new coordinator which accepts payments
+func NewShutdownCoordinator() *ShutdownCoordinator {
+	return &ShutdownCoordinator{}
+}
+
+// Begin registers in-flight payment, caller should call done when payment
+// is committed or rolled back. Returns error if draining is started.
+func (coordinator *ShutdownCoordinator) Begin() (done func(), err error) {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+
+	if coordinator.draining {
+		return nil, NewPaymentError(Unavailable, "daemon shutting down")
+	}
+	coordinator.inFlight.Add(1)
+
+	var once sync.Once
+	return func() { once.Do(coordinator.inFlight.Done) }, nil
+}
+
+// Drain refuses new payments and waits until in-flight payments are
+// finished. Returns error if ctx is done before.
+func (coordinator *ShutdownCoordinator) Drain(ctx context.Context) error {
+	coordinator.mutex.Lock()
+	coordinator.draining = true
+	coordinator.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		coordinator.inFlight.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		log.Info("In-flight payments are finished")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight payments are not finished: %v", ctx.Err())
+	}
+}
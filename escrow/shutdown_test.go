@@ -0,0 +1,89 @@
+package escrow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitDraining waits until coordinator refuses new payments
+func waitDraining(t *testing.T, coordinator *ShutdownCoordinator) {
+	for i := 0; i < 100; i++ {
+		done, err := coordinator.Begin()
+		if err != nil {
+			return
+		}
+		done()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Fail(t, "coordinator is not draining")
+}
+
+func TestShutdownCoordinatorDrain(t *testing.T) {
+	coordinator := NewShutdownCoordinator()
+	done, err := coordinator.Begin()
+	assert.Nil(t, err)
+
+	updated := false
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		updated = true
+		done()
+	}()
+
+	err = coordinator.Drain(context.Background())
+
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	_, err = coordinator.Begin()
+	assert.Equal(t, NewPaymentError(Unavailable, "daemon shutting down"), err)
+}
+
+func TestShutdownCoordinatorDrainTimeout(t *testing.T) {
+	coordinator := NewShutdownCoordinator()
+	_, err := coordinator.Begin()
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = coordinator.Drain(ctx)
+
+	assert.Equal(t, "in-flight payments are not finished: context deadline exceeded", err.Error())
+}
+
+func TestShutdownCoordinatorDoneIsIdempotent(t *testing.T) {
+	coordinator := NewShutdownCoordinator()
+	done, _ := coordinator.Begin()
+	done()
+	done()
+
+	err := coordinator.Drain(context.Background())
+
+	assert.Nil(t, err)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionDrain() {
+	service := *suite.service.(*lockingPaymentChannelService)
+	service.shutdown = NewShutdownCoordinator()
+	payment := suite.payment()
+	inFlight, err := service.StartPaymentTransaction(context.Background(), payment)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+
+	drained := make(chan error)
+	go func() {
+		drained <- service.shutdown.Drain(context.Background())
+	}()
+	waitDraining(suite.T(), service.shutdown)
+
+	_, err = service.StartPaymentTransaction(context.Background(), suite.payment())
+	assert.Equal(suite.T(), NewPaymentError(Unavailable, "daemon shutting down"), err)
+
+	assert.Nil(suite.T(), inFlight.Commit())
+	assert.Nil(suite.T(), <-drained)
+	channel, ok, err := suite.storage.Get(suite.channelKey())
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channelPlusPayment(payment), channel)
+}
@@ -0,0 +1,91 @@
+package escrow
+
+import (
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+// SignatureCooldown rejects payments of the client for some time after the
+// client sent too many payments with invalid signatures within a window.
+// Client is identified by its network address (Payment.Source) and not by
+// the payment channel, because anyone can send invalidly signed payment via
+// any channel and so lock the channel out. Payments without source are not
+// cooled down. Counters and cooldowns are kept in the storage, so they are
+// shared between replicas.
+type SignatureCooldown struct {
+	storage  AtomicStorage
+	counter  *windowCounter
+	limit    *big.Int
+	duration time.Duration
+	now      func() time.Time
+}
+
+// NewSignatureCooldown returns new instance which starts cooldown of the
+// client for duration when threshold of invalid signatures is reached
+// within window.
+func NewSignatureCooldown(storage AtomicStorage, metadata *blockchain.ServiceMetadata, threshold int64, window time.Duration, duration time.Duration) *SignatureCooldown {
+	prefixed := &PrefixedAtomicStorage{
+		delegate:  storage,
+		keyPrefix: "/" + metadata.MpeAddress + "/payment-channel/signature-cooldown",
+	}
+	return &SignatureCooldown{
+		storage:  prefixed,
+		counter:  newWindowCounter(prefixed, window),
+		limit:    big.NewInt(threshold - 1),
+		duration: duration,
+		now:      time.Now,
+	}
+}
+
+// CooledDownUntil returns time the cooldown of the client ends, ok is false
+// if the client is not cooled down.
+func (cooldown *SignatureCooldown) CooledDownUntil(source string) (until time.Time, ok bool, err error) {
+	if source == "" {
+		return
+	}
+	value, ok, err := cooldown.storage.Get(signatureCooldownKey(source))
+	if err != nil || !ok {
+		return
+	}
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.WithError(err).WithField("value", value).Warn("Incorrect signature cooldown in storage, ignore it")
+		return time.Time{}, false, nil
+	}
+	until = time.Unix(0, nanos)
+	if !until.After(cooldown.now()) {
+		return time.Time{}, false, nil
+	}
+	return until, true, nil
+}
+
+// InvalidSignature accounts the invalid signature sent by the client and
+// starts the cooldown when threshold is reached.
+func (cooldown *SignatureCooldown) InvalidSignature(source string) (err error) {
+	if source == "" {
+		return
+	}
+	added, err := cooldown.counter.Add(invalidSignatureCounterKey(source), big.NewInt(1), cooldown.limit)
+	if err != nil || added {
+		return
+	}
+
+	until := cooldown.now().Add(cooldown.duration)
+	log.WithField("source", source).WithField("until", until).Warn("Too many invalid signatures, client is cooled down")
+	return cooldown.storage.Put(signatureCooldownKey(source), strconv.FormatInt(until.UnixNano(), 10))
+}
+
+// source is escaped because IPv6 address contains colons
+func invalidSignatureCounterKey(source string) string {
+	return "invalid/" + url.PathEscape(source)
+}
+
+func signatureCooldownKey(source string) string {
+	return "cooldown/" + url.PathEscape(source)
+}
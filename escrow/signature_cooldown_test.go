@@ -0,0 +1,90 @@
+package escrow
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func (suite *ValidationTestSuite) signatureCooldownValidator(now *time.Time) ChannelPaymentValidator {
+	cooldown := NewSignatureCooldown(NewMemStorage(), &blockchain.ServiceMetadata{MpeAddress: "0xf65186b5081ff5ce73482ad761db0eb0d25abfbf"}, 2, time.Minute, 5*time.Minute)
+	cooldown.now = func() time.Time { return *now }
+	cooldown.counter.now = func() time.Time { return *now }
+	validator := suite.validator
+	validator.signatureCooldown = cooldown
+	return validator
+}
+
+const testPaymentSource = "192.0.2.1"
+
+func (suite *ValidationTestSuite) sourcedPayment() *Payment {
+	payment := suite.payment()
+	payment.Source = testPaymentSource
+	return payment
+}
+
+func (suite *ValidationTestSuite) incorrectlySignedPayment() *Payment {
+	payment := suite.sourcedPayment()
+	SignTestPayment(payment, GenerateTestPrivateKey())
+	return payment
+}
+
+func (suite *ValidationTestSuite) TestSignatureCooldownTriggersAndLifts() {
+	now := time.Unix(1000, 0)
+	validator := suite.signatureCooldownValidator(&now)
+
+	errA := validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	errB := validator.Validate(suite.sourcedPayment(), suite.channel())
+	errC := validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	errD := validator.Validate(suite.sourcedPayment(), suite.channel())
+	now = now.Add(5 * time.Minute)
+	errE := validator.Validate(suite.sourcedPayment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), errA)
+	assert.Nil(suite.T(), errB, "Unexpected error: %v", errB)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), errC)
+	assert.Equal(suite.T(), NewPaymentError(ResourceExhausted, "client is cooled down after repeated invalid signatures, retry after 1970-01-01T00:21:40Z"), errD)
+	assert.Nil(suite.T(), errE, "Unexpected error: %v", errE)
+}
+
+func (suite *ValidationTestSuite) TestSignatureCooldownCountsWithinWindow() {
+	now := time.Unix(1000, 0)
+	validator := suite.signatureCooldownValidator(&now)
+
+	validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	now = now.Add(time.Minute)
+	validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	err := validator.Validate(suite.sourcedPayment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestSignatureCooldownIsPerSource() {
+	now := time.Unix(1000, 0)
+	validator := suite.signatureCooldownValidator(&now)
+	otherSourcePayment := suite.payment()
+	otherSourcePayment.Source = "2001:db8::1"
+
+	validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	validator.Validate(suite.incorrectlySignedPayment(), suite.channel())
+	errCooledDown := validator.Validate(suite.sourcedPayment(), suite.channel())
+	errOtherSource := validator.Validate(otherSourcePayment, suite.channel())
+
+	assert.Equal(suite.T(), ResourceExhausted, errCooledDown.(*PaymentError).Code)
+	assert.Nil(suite.T(), errOtherSource, "Unexpected error: %v", errOtherSource)
+}
+
+func (suite *ValidationTestSuite) TestSignatureCooldownIgnoresPaymentsWithoutSource() {
+	now := time.Unix(1000, 0)
+	validator := suite.signatureCooldownValidator(&now)
+	incorrectlySigned := suite.payment()
+	SignTestPayment(incorrectlySigned, GenerateTestPrivateKey())
+
+	validator.Validate(incorrectlySigned, suite.channel())
+	validator.Validate(incorrectlySigned, suite.channel())
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
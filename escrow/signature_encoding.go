@@ -0,0 +1,84 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// SignatureEncoding defines how numbers are encoded in the payment message
+// signed by client. Each number is encoded as big-endian unsigned integer
+// left padded by zeros to the width of its field.
+type SignatureEncoding struct {
+	// ProtocolVersion is a version of MPE protocol the encoding is used by
+	ProtocolVersion string `mapstructure:"protocol_version"`
+	// ChannelIdWidth is a width of the channel id in bytes
+	ChannelIdWidth int `mapstructure:"channel_id_width"`
+	// NonceWidth is a width of the channel nonce in bytes
+	NonceWidth int `mapstructure:"nonce_width"`
+	// AmountWidth is a width of the signed amount in bytes
+	AmountWidth int `mapstructure:"amount_width"`
+}
+
+// MpeV1SignatureEncoding is an encoding of the current MPE contract which
+// keeps channel id, nonce and amount as uint256
+var MpeV1SignatureEncoding = SignatureEncoding{
+	ProtocolVersion: "v1",
+	ChannelIdWidth:  32,
+	NonceWidth:      32,
+	AmountWidth:     32,
+}
+
+// GetSignatureEncoding returns the encoding of the protocol version to sign
+// and verify payments. encodings extend and override built-in encodings.
+func GetSignatureEncoding(protocolVersion string, encodings []SignatureEncoding) (selected *SignatureEncoding, err error) {
+	for i := range encodings {
+		if encodings[i].ProtocolVersion == protocolVersion {
+			selected = &encodings[i]
+		}
+	}
+	if selected == nil && protocolVersion == MpeV1SignatureEncoding.ProtocolVersion {
+		selected = &MpeV1SignatureEncoding
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("unknown signature encoding of protocol version: \"%v\"", protocolVersion)
+	}
+	if selected.ChannelIdWidth <= 0 || selected.NonceWidth <= 0 || selected.AmountWidth <= 0 {
+		return nil, fmt.Errorf("incorrect signature encoding of protocol version %v, all widths should be positive: %+v", protocolVersion, *selected)
+	}
+	return selected, nil
+}
+
+// encodePaymentNumbers returns encoded channel id, nonce and amount of the
+// payment. Numbers which don't fit into their fields are reported as error
+// instead of being truncated.
+func (encoding SignatureEncoding) encodePaymentNumbers(payment *Payment) (encoded [][]byte, err error) {
+	channelID, err := encodeUint(payment.ChannelID, encoding.ChannelIdWidth, "channel id")
+	if err != nil {
+		return
+	}
+	nonce, err := encodeUint(payment.ChannelNonce, encoding.NonceWidth, "channel nonce")
+	if err != nil {
+		return
+	}
+	amount, err := encodeUint(payment.Amount, encoding.AmountWidth, "amount")
+	if err != nil {
+		return
+	}
+	return [][]byte{channelID, nonce, amount}, nil
+}
+
+func encodeUint(value *big.Int, width int, name string) ([]byte, error) {
+	if value == nil {
+		return nil, fmt.Errorf("%v is not set", name)
+	}
+	if value.Sign() < 0 {
+		return nil, fmt.Errorf("%v is negative: %v", name, value)
+	}
+	if value.BitLen() > width*8 {
+		return nil, fmt.Errorf("%v %v doesn't fit into %v bytes", name, value, width)
+	}
+	bytes := value.Bytes()
+	padded := make([]byte, width)
+	copy(padded[width-len(bytes):], bytes)
+	return padded, nil
+}
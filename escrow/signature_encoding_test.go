@@ -0,0 +1,93 @@
+package escrow
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+func TestEncodeUintPadsValue(t *testing.T) {
+	encoded, err := encodeUint(big.NewInt(0x0102), 4, "value")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 1, 2}, encoded)
+}
+
+func TestEncodeUintFullWidth(t *testing.T) {
+	encoded, err := encodeUint(maxUint256ChannelId, 32, "value")
+
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xFF}, 32), encoded)
+}
+
+func TestEncodeUintTooWide(t *testing.T) {
+	_, err := encodeUint(big.NewInt(0x010000), 2, "value")
+
+	assert.Equal(t, "value 65536 doesn't fit into 2 bytes", err.Error())
+}
+
+func TestEncodeUintNegative(t *testing.T) {
+	_, err := encodeUint(big.NewInt(-1), 32, "value")
+
+	assert.Equal(t, "value is negative: -1", err.Error())
+}
+
+func TestGetSignatureEncodingBuiltIn(t *testing.T) {
+	encoding, err := GetSignatureEncoding("v1", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, MpeV1SignatureEncoding, *encoding)
+}
+
+func TestGetSignatureEncodingUnknownVersion(t *testing.T) {
+	_, err := GetSignatureEncoding("v3", []SignatureEncoding{})
+
+	assert.Equal(t, "unknown signature encoding of protocol version: \"v3\"", err.Error())
+}
+
+func TestGetSignatureEncodingIncorrectWidth(t *testing.T) {
+	_, err := GetSignatureEncoding("v2", []SignatureEncoding{{ProtocolVersion: "v2", ChannelIdWidth: 64}})
+
+	assert.NotNil(t, err)
+}
+
+func TestPaymentWithWideChannelIdRoundTrip(t *testing.T) {
+	encoding, err := GetSignatureEncoding("v2", []SignatureEncoding{{ProtocolVersion: "v2", ChannelIdWidth: 64, NonceWidth: 32, AmountWidth: 32}})
+	assert.Nil(t, err)
+
+	privateKey := GenerateTestPrivateKey()
+	channelID := new(big.Int).Lsh(maxUint256ChannelId, 200)
+	payment := &Payment{
+		MpeContractAddress: blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf"),
+		ChannelID:          channelID,
+		ChannelNonce:       big.NewInt(3),
+		Amount:             big.NewInt(12345),
+	}
+	channelIDBytes := make([]byte, 64)
+	copy(channelIDBytes[64-len(channelID.Bytes()):], channelID.Bytes())
+	payment.Signature = getSignature(bytes.Join([][]byte{
+		[]byte(PrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+		channelIDBytes,
+		bigIntToBytes(payment.ChannelNonce),
+		bigIntToBytes(payment.Amount),
+	}, nil), privateKey)
+
+	signer, err := recoverPaymentSigner(payment, Secp256k1Scheme, *encoding)
+
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(privateKey.PublicKey), *signer)
+}
+
+func TestEncodeUintMatchesUint256(t *testing.T) {
+	encoded, err := encodeUint(big.NewInt(1), 32, "value")
+
+	assert.Nil(t, err)
+	assert.Equal(t, bigIntToBytes(big.NewInt(1)), encoded)
+}
+
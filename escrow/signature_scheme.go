@@ -0,0 +1,63 @@
+package escrow
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/singnet/snet-daemon/authutils"
+)
+
+// Secp256k1SignatureSchemeName is a name of the default signature scheme
+const Secp256k1SignatureSchemeName = "secp256k1"
+
+// SignatureScheme recovers address of the payment signer from the signed
+// message. MultiPartyEscrow contract verifies claimed payments using
+// secp256k1 signature of Ethereum personal message, so the payments
+// accepted using another scheme should be claimable by the contract.
+type SignatureScheme interface {
+	// Recover returns address of the key the message is signed by
+	Recover(message, signature []byte) (signer common.Address, err error)
+}
+
+type secp256k1Scheme struct {
+}
+
+// Secp256k1Scheme recovers signer of the 65 bytes Ethereum ECDSA signature
+// of the message hashed as Ethereum personal message.
+var Secp256k1Scheme SignatureScheme = &secp256k1Scheme{}
+
+func (scheme *secp256k1Scheme) Recover(message, signature []byte) (signer common.Address, err error) {
+	address, err := authutils.GetSignerAddressFromMessage(message, signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return *address, nil
+}
+
+var (
+	signatureSchemesMutex sync.RWMutex
+	signatureSchemes      = map[string]SignatureScheme{
+		Secp256k1SignatureSchemeName: Secp256k1Scheme,
+	}
+)
+
+// RegisterSignatureScheme makes the scheme available to be selected by name
+// in configuration, scheme registered under the same name is replaced.
+func RegisterSignatureScheme(name string, scheme SignatureScheme) {
+	signatureSchemesMutex.Lock()
+	defer signatureSchemesMutex.Unlock()
+	signatureSchemes[name] = scheme
+}
+
+// GetSignatureScheme returns registered scheme by name
+func GetSignatureScheme(name string) (scheme SignatureScheme, err error) {
+	signatureSchemesMutex.RLock()
+	defer signatureSchemesMutex.RUnlock()
+	scheme, ok := signatureSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment signature scheme: \"%v\"", name)
+	}
+	return scheme, nil
+}
@@ -0,0 +1,62 @@
+package escrow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type signatureSchemeMock struct {
+	signer common.Address
+	err    error
+}
+
+func (scheme *signatureSchemeMock) Recover(message, signature []byte) (common.Address, error) {
+	return scheme.signer, scheme.err
+}
+
+func TestGetSignatureSchemeDefault(t *testing.T) {
+	scheme, err := GetSignatureScheme("secp256k1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Secp256k1Scheme, scheme)
+}
+
+func TestGetSignatureSchemeUnknown(t *testing.T) {
+	scheme, err := GetSignatureScheme("unknown")
+
+	assert.Equal(t, errors.New("unknown payment signature scheme: \"unknown\""), err)
+	assert.Nil(t, scheme)
+}
+
+func TestRegisterSignatureScheme(t *testing.T) {
+	mock := &signatureSchemeMock{}
+	RegisterSignatureScheme("test-scheme", mock)
+
+	scheme, err := GetSignatureScheme("test-scheme")
+
+	assert.Nil(t, err)
+	assert.Equal(t, mock, scheme)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureScheme() {
+	payment := suite.payment()
+	payment.Signature = []byte{1, 2, 3}
+	validator := suite.validator
+	validator.signatureScheme = &signatureSchemeMock{signer: suite.signerAddress}
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureSchemeError() {
+	validator := suite.validator
+	validator.signatureScheme = &signatureSchemeMock{err: errors.New("cannot recover")}
+
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid"), err)
+}
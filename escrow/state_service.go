@@ -13,6 +13,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"math/big"
+	"time"
 )
 
 // PaymentChannelStateService is an implementation of PaymentChannelStateServiceServer gRPC interface
@@ -20,6 +21,11 @@ type PaymentChannelStateService struct {
 	channelService PaymentChannelService
 	paymentStorage *PaymentStorage
 	mpeAddress func() (address common.Address)
+	// currentBlock and averageBlockTime are used to estimate channel
+	// expiration time, estimation is disabled if averageBlockTime is zero
+	currentBlock     func() (*big.Int, error)
+	averageBlockTime time.Duration
+	now              func() time.Time
 }
 
 type BlockChainDisabledStateService struct {
@@ -46,11 +52,15 @@ func (service *PaymentChannelStateService) StorageNonceMatchesWithBlockchainNonc
 }
 
 // NewPaymentChannelStateService returns new instance of PaymentChannelStateService
-func NewPaymentChannelStateService(channelService PaymentChannelService, paymentStorage *PaymentStorage,metaData *blockchain.ServiceMetadata) *PaymentChannelStateService {
+func NewPaymentChannelStateService(channelService PaymentChannelService, paymentStorage *PaymentStorage,metaData *blockchain.ServiceMetadata,
+	currentBlock func() (*big.Int, error), averageBlockTime time.Duration) *PaymentChannelStateService {
 	return &PaymentChannelStateService{
 		channelService: channelService,
 		paymentStorage: paymentStorage,
 		mpeAddress:func() common.Address { return metaData.GetMpeAddress() },
+		currentBlock:     currentBlock,
+		averageBlockTime: averageBlockTime,
+		now:              time.Now,
 	}
 }
 
@@ -144,24 +154,54 @@ func (service *PaymentChannelStateService) GetChannelState(context context.Conte
 			log.Errorf("old payment is not found in storage, nevertheless local channel nonce is not equal to the blockchain one, channel: %v", channelID)
 			return nil, errors.New("channel has different nonce in local storage and blockchain and old payment is not found in storage")
 		}
-		return &ChannelStateReply{
-			CurrentNonce:         bigIntToBytes(channel.Nonce),
-			CurrentSignedAmount:  bigIntToBytes(channel.AuthorizedAmount),
-			CurrentSignature:     channel.Signature,
-			OldNonceSignedAmount: bigIntToBytes(payment.Amount),
-			OldNonceSignature:    payment.Signature,
-		}, nil
+		return service.withExpiration(&ChannelStateReply{
+			CurrentNonce:            bigIntToBytes(channel.Nonce),
+			CurrentSignedAmount:     bigIntToBytes(channelSignedAmount(channel)),
+			CurrentSignature:        channel.Signature,
+			CurrentAuthorizedAmount: bigIntToBytes(channel.AuthorizedAmount),
+			OldNonceSignedAmount:    bigIntToBytes(payment.Amount),
+			OldNonceSignature:       payment.Signature,
+		}, channel), nil
 	}
 
 	if channel.Signature == nil {
-		return &ChannelStateReply{
+		return service.withExpiration(&ChannelStateReply{
 			CurrentNonce: bigIntToBytes(channel.Nonce),
-		}, nil
+		}, channel), nil
 	}
 
-	return &ChannelStateReply{
-		CurrentNonce:        bigIntToBytes(channel.Nonce),
-		CurrentSignedAmount: bigIntToBytes(channel.AuthorizedAmount),
-		CurrentSignature:    channel.Signature,
-	}, nil
+	return service.withExpiration(&ChannelStateReply{
+		CurrentNonce:            bigIntToBytes(channel.Nonce),
+		CurrentSignedAmount:     bigIntToBytes(channelSignedAmount(channel)),
+		CurrentSignature:        channel.Signature,
+		CurrentAuthorizedAmount: bigIntToBytes(channel.AuthorizedAmount),
+	}, channel), nil
+}
+
+// channelSignedAmount returns amount signed by the channel signature, it is
+// greater than authorized amount when the last call was charged less than
+// client signed
+func channelSignedAmount(channel *PaymentChannelData) *big.Int {
+	if channel.SignedAmount != nil {
+		return channel.SignedAmount
+	}
+	return channel.AuthorizedAmount
+}
+
+// withExpiration adds channel expiration block and its estimated time to the
+// reply. Reply is returned without expiration if estimation is disabled or
+// current block is unknown, because expiration time is informational only.
+func (service *PaymentChannelStateService) withExpiration(reply *ChannelStateReply, channel *PaymentChannelData) *ChannelStateReply {
+	if service.averageBlockTime <= 0 || service.currentBlock == nil || channel.Expiration == nil {
+		return reply
+	}
+	currentBlock, err := service.currentBlock()
+	if err != nil {
+		log.WithError(err).Warn("Unable to get current block to estimate channel expiration time")
+		return reply
+	}
+
+	reply.Expiration = bigIntToBytes(channel.Expiration)
+	reply.EstimatedExpirationTime = blockchain.EstimateBlockTime(channel.Expiration, currentBlock, service.now(), service.averageBlockTime).Unix()
+	return reply
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"math/big"
 	"testing"
+	"time"
 )
 
 type stateServiceTestType struct {
@@ -46,6 +47,9 @@ var stateServiceTest = func() stateServiceTestType {
 	channelServiceMock.blockchainReader.recipientPaymentAddress = func() common.Address {
 		return senderAddress
 	}
+	channelServiceMock.blockchainReader.mpeContractAddress = func() common.Address {
+		return blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf")
+	}
 
 	defaultSignature, err := hex.DecodeString("0504030201")
 	if err != nil {
@@ -81,9 +85,10 @@ var stateServiceTest = func() stateServiceTestType {
 			Signature: getSignature(bigIntToBytes(defaultChannelId), signerPrivateKey),
 		},
 		defaultReply: &ChannelStateReply{
-			CurrentNonce:        bigIntToBytes(big.NewInt(3)),
-			CurrentSignedAmount: bigIntToBytes(big.NewInt(12345)),
-			CurrentSignature:    defaultSignature,
+			CurrentNonce:            bigIntToBytes(big.NewInt(3)),
+			CurrentSignedAmount:     bigIntToBytes(big.NewInt(12345)),
+			CurrentSignature:        defaultSignature,
+			CurrentAuthorizedAmount: bigIntToBytes(big.NewInt(12345)),
 		},
 	}
 }()
@@ -118,6 +123,27 @@ func TestGetChannelState(t *testing.T) {
 
 }
 
+func TestGetChannelStateReturnsSignedAmountOfMeteredCall(t *testing.T) {
+	channelData := *stateServiceTest.defaultChannelData
+	channelData.AuthorizedAmount = big.NewInt(12000)
+	channelData.SignedAmount = big.NewInt(12345)
+	stateServiceTest.channelServiceMock.Put(
+		stateServiceTest.defaultChannelKey,
+		&channelData,
+	)
+	defer stateServiceTest.channelServiceMock.Clear()
+
+	reply, err := stateServiceTest.service.GetChannelState(
+		nil,
+		stateServiceTest.defaultRequest,
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, bigIntToBytes(big.NewInt(12345)), reply.CurrentSignedAmount)
+	assert.Equal(t, stateServiceTest.defaultChannelData.Signature, reply.CurrentSignature)
+	assert.Equal(t, bigIntToBytes(big.NewInt(12000)), reply.CurrentAuthorizedAmount)
+}
+
 func TestGetChannelStateWhenNonceDiffers(t *testing.T) {
 	previousSignature, _ := hex.DecodeString("0708090A0B")
 	previousChannelData := &PaymentChannelData{
@@ -252,6 +278,7 @@ func TestGetChannelStateNoOperationsOnThisChannelYet(t *testing.T) {
 	expectedReply := stateServiceTest.defaultReply
 	expectedReply.CurrentSignedAmount = nil
 	expectedReply.CurrentSignature = nil
+	expectedReply.CurrentAuthorizedAmount = nil
 	expectedReply.OldNonceSignature = nil
 	expectedReply.OldNonceSignedAmount = nil
 	assert.Equal(t, expectedReply, reply)
@@ -321,4 +348,28 @@ func TestGetChannelStateNonceIncrementedInBlockchainNoOldPayment(t *testing.T) {
 	assert.Equal(t, errors.New("channel has different nonce in local storage and blockchain and old payment is not found in storage"), err)
 }
 
-// Claim tests are already added to escrow_test.go
\ No newline at end of file
+// Claim tests are already added to escrow_test.go
+
+func TestGetChannelStateEstimatedExpirationTime(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	service := stateServiceTest.service
+	service.currentBlock = func() (*big.Int, error) { return big.NewInt(1000), nil }
+	service.averageBlockTime = 15 * time.Second
+	service.now = func() time.Time { return now }
+	channel := *stateServiceTest.defaultChannelData
+	channel.Expiration = big.NewInt(1240)
+
+	reply := service.withExpiration(&ChannelStateReply{}, &channel)
+
+	assert.Equal(t, bigIntToBytes(big.NewInt(1240)), reply.Expiration)
+	assert.InDelta(t, now.Add(time.Hour).Unix(), reply.EstimatedExpirationTime, 1)
+}
+
+func TestGetChannelStateExpirationTimeDisabled(t *testing.T) {
+	channel := *stateServiceTest.defaultChannelData
+	channel.Expiration = big.NewInt(1240)
+
+	reply := stateServiceTest.service.withExpiration(&ChannelStateReply{}, &channel)
+
+	assert.Equal(t, &ChannelStateReply{}, reply)
+}
@@ -0,0 +1,242 @@
+package escrow
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tenantQuotaWindow      = time.Minute
+	tenantQuotaMaxAttempts = 10
+)
+
+// Tenant is a set of services which share quota. Services of the tenant
+// are served by different daemons which share the payment storage.
+type Tenant struct {
+	// Name is a unique name of the tenant
+	Name string `mapstructure:"name"`
+	// Services is a list of "<organization_id>/<service_id>" of the tenant
+	Services []string `mapstructure:"services"`
+	// Groups is a list of "<organization_id>/<service_id>/<group_name>" of
+	// the tenant
+	Groups []string `mapstructure:"groups"`
+	// CallsPerMinute is a maximal number of calls to all services of the
+	// tenant per minute, zero means no limit
+	CallsPerMinute int64 `mapstructure:"calls_per_minute"`
+	// MaxConcurrentCalls is a maximal number of calls to all services of
+	// the tenant processed at once, zero means no limit
+	MaxConcurrentCalls int `mapstructure:"max_concurrent_calls"`
+}
+
+// FindTenant returns the tenant the service group belongs to or nil if
+// there is no such tenant
+func FindTenant(tenants []Tenant, organizationId string, serviceId string, groupName string) *Tenant {
+	service := organizationId + "/" + serviceId
+	group := service + "/" + groupName
+	for i := range tenants {
+		for _, member := range tenants[i].Services {
+			if member == service {
+				return &tenants[i]
+			}
+		}
+		for _, member := range tenants[i].Groups {
+			if member == group {
+				return &tenants[i]
+			}
+		}
+	}
+	return nil
+}
+
+// TenantQuota enforces limits of the tenant across all daemons which share
+// the storage. Rate is counted in fixed time windows. Concurrent calls are
+// counted per daemon replica in a single storage value which is updated
+// using compare and swap. Count of the replica is a lease: it is ignored by
+// other replicas if it is not refreshed for staleTimeout, so calls of
+// stopped replicas are not counted forever. Number of calls of this replica
+// is kept in memory and only published to the storage, so release which
+// failed to be written is corrected by the next refresh.
+type TenantQuota struct {
+	tenant       Tenant
+	storage      AtomicStorage
+	calls        *windowCounter
+	replicaId    string
+	staleTimeout time.Duration
+	now          func() time.Time
+
+	// mutex protects active only and is never held during storage calls
+	mutex sync.Mutex
+	// active is a number of concurrent calls of this replica
+	active int
+	done   chan struct{}
+}
+
+type tenantReplicaCalls struct {
+	Active    int       `json:"active"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewTenantQuota returns quota of the tenant which state is kept in the
+// storage. When concurrency is limited, counts of this replica are
+// refreshed in background every staleTimeout/2 until Close is called.
+func NewTenantQuota(tenant Tenant, storage AtomicStorage, staleTimeout time.Duration) *TenantQuota {
+	prefixed := &PrefixedAtomicStorage{
+		delegate:  storage,
+		keyPrefix: "/tenant-quota/" + tenant.Name,
+	}
+	quota := &TenantQuota{
+		tenant:       tenant,
+		storage:      prefixed,
+		calls:        newWindowCounter(prefixed, tenantQuotaWindow),
+		replicaId:    newReplicaId(),
+		staleTimeout: staleTimeout,
+		now:          time.Now,
+		done:         make(chan struct{}),
+	}
+	if tenant.MaxConcurrentCalls > 0 {
+		go quota.refreshLoop()
+	}
+	return quota
+}
+
+func newReplicaId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("cannot generate replica id: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// Tenant returns name of the tenant
+func (quota *TenantQuota) Tenant() string {
+	return quota.tenant.Name
+}
+
+// Acquire reserves one call of the tenant, ok is false if the tenant limit
+// is reached. release should be called when the call is finished.
+func (quota *TenantQuota) Acquire() (release func(), ok bool, err error) {
+	if quota.tenant.MaxConcurrentCalls > 0 {
+		quota.addActive(1)
+		if ok, err = quota.publishActive(true); err != nil || !ok {
+			quota.release()
+			return nil, ok, err
+		}
+		release = quota.release
+	} else {
+		release = func() {}
+	}
+
+	if quota.tenant.CallsPerMinute > 0 {
+		ok, err = quota.calls.Add("calls", big.NewInt(1), big.NewInt(quota.tenant.CallsPerMinute))
+		if err != nil || !ok {
+			release()
+			return nil, ok, err
+		}
+	}
+	return release, true, nil
+}
+
+func (quota *TenantQuota) release() {
+	quota.addActive(-1)
+	if _, err := quota.publishActive(false); err != nil {
+		log.WithError(err).WithField("tenant", quota.tenant.Name).Warn("Unable to release tenant concurrent call, it will be released on refresh")
+	}
+}
+
+func (quota *TenantQuota) addActive(delta int) {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+	quota.active += delta
+}
+
+func (quota *TenantQuota) getActive() int {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+	return quota.active
+}
+
+// Close stops refreshing counts of this replica
+func (quota *TenantQuota) Close() {
+	close(quota.done)
+}
+
+func (quota *TenantQuota) refreshLoop() {
+	ticker := time.NewTicker(quota.staleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := quota.publishActive(false); err != nil {
+				log.WithError(err).WithField("tenant", quota.tenant.Name).Warn("Unable to refresh tenant concurrent calls")
+			}
+		case <-quota.done:
+			return
+		}
+	}
+}
+
+// publishActive writes the current number of concurrent calls of this
+// replica to the storage, when check is true it fails if the tenant limit is
+// exceeded
+func (quota *TenantQuota) publishActive(check bool) (ok bool, err error) {
+	const key = "concurrent-calls"
+	for attempt := 0; attempt < tenantQuotaMaxAttempts; attempt++ {
+		value, found, err := quota.storage.Get(key)
+		if err != nil {
+			return false, err
+		}
+
+		replicas := make(map[string]tenantReplicaCalls)
+		if found {
+			if e := json.Unmarshal([]byte(value), &replicas); e != nil {
+				log.WithError(e).WithField("value", value).Warn("Incorrect tenant concurrent calls in storage, reset it")
+				replicas = make(map[string]tenantReplicaCalls)
+			}
+		}
+
+		now := quota.now()
+		total := 0
+		for id, calls := range replicas {
+			if id != quota.replicaId && now.Sub(calls.Timestamp) >= quota.staleTimeout {
+				delete(replicas, id)
+				continue
+			}
+			if id != quota.replicaId {
+				total += calls.Active
+			}
+		}
+		active := quota.getActive()
+		if check && total+active > quota.tenant.MaxConcurrentCalls {
+			return false, nil
+		}
+		if active > 0 {
+			replicas[quota.replicaId] = tenantReplicaCalls{Active: active, Timestamp: now}
+		} else {
+			delete(replicas, quota.replicaId)
+		}
+
+		newValue, err := json.Marshal(replicas)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			ok, err = quota.storage.CompareAndSwap(key, value, string(newValue))
+		} else {
+			ok, err = quota.storage.PutIfAbsent(key, string(newValue))
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("cannot update concurrent calls of tenant %v", quota.tenant.Name)
+}
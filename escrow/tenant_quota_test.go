@@ -0,0 +1,160 @@
+package escrow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTenants = []Tenant{
+	{
+		Name:     "tenant-a",
+		Services: []string{"org/service-1", "org/service-2"},
+		Groups:   []string{"org/service-3/group-1"},
+	},
+	{
+		Name:     "tenant-b",
+		Services: []string{"org/service-4"},
+	},
+}
+
+func newTestTenantQuotas(tenant Tenant, now *time.Time) (service1 *TenantQuota, service2 *TenantQuota) {
+	storage := NewMemStorage()
+	service1 = NewTenantQuota(tenant, storage, time.Minute)
+	service1.now = func() time.Time { return *now }
+	service1.calls.now = service1.now
+	service2 = NewTenantQuota(tenant, storage, time.Minute)
+	service2.now = func() time.Time { return *now }
+	service2.calls.now = service2.now
+	return
+}
+
+func TestFindTenant(t *testing.T) {
+	assert.Equal(t, "tenant-a", FindTenant(testTenants, "org", "service-2", "default_group").Name)
+	assert.Equal(t, "tenant-a", FindTenant(testTenants, "org", "service-3", "group-1").Name)
+	assert.Nil(t, FindTenant(testTenants, "org", "service-3", "group-2"))
+	assert.Equal(t, "tenant-b", FindTenant(testTenants, "org", "service-4", "default_group").Name)
+	assert.Nil(t, FindTenant(testTenants, "org", "service-5", "default_group"))
+}
+
+func TestTenantQuotaRateIsSharedByServices(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tenant := testTenants[0]
+	tenant.CallsPerMinute = 2
+	service1, service2 := newTestTenantQuotas(tenant, &now)
+	defer service1.Close()
+	defer service2.Close()
+
+	_, ok1, err1 := service1.Acquire()
+	_, ok2, err2 := service2.Acquire()
+	_, ok3, err3 := service1.Acquire()
+	now = now.Add(time.Minute)
+	_, ok4, err4 := service2.Acquire()
+
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Nil(t, err3)
+	assert.Nil(t, err4)
+	assert.True(t, ok1)
+	assert.True(t, ok2)
+	assert.False(t, ok3)
+	assert.True(t, ok4)
+}
+
+func TestTenantQuotaConcurrencyIsSharedByServices(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tenant := testTenants[0]
+	tenant.MaxConcurrentCalls = 1
+	service1, service2 := newTestTenantQuotas(tenant, &now)
+	defer service1.Close()
+	defer service2.Close()
+
+	release, ok1, err1 := service1.Acquire()
+	_, ok2, err2 := service2.Acquire()
+	release()
+	_, ok3, err3 := service2.Acquire()
+
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Nil(t, err3)
+	assert.True(t, ok1)
+	assert.False(t, ok2)
+	assert.True(t, ok3)
+}
+
+func TestTenantQuotaStaleReplicaIsNotCounted(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tenant := testTenants[0]
+	tenant.MaxConcurrentCalls = 1
+	service1, service2 := newTestTenantQuotas(tenant, &now)
+	defer service1.Close()
+	defer service2.Close()
+
+	_, ok1, _ := service1.Acquire()
+	now = now.Add(time.Minute)
+	_, ok2, err2 := service2.Acquire()
+
+	assert.True(t, ok1)
+	assert.Nil(t, err2)
+	assert.True(t, ok2)
+}
+
+func TestTenantQuotaRejectedCallDoesNotHoldConcurrency(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tenant := testTenants[0]
+	tenant.CallsPerMinute = 1
+	tenant.MaxConcurrentCalls = 2
+	service1, service2 := newTestTenantQuotas(tenant, &now)
+	defer service1.Close()
+	defer service2.Close()
+
+	release, ok1, _ := service1.Acquire()
+	_, ok2, _ := service1.Acquire()
+
+	assert.True(t, ok1)
+	assert.False(t, ok2)
+	assert.Equal(t, 1, service1.active)
+	release()
+	assert.Equal(t, 0, service1.active)
+}
+
+type failingCompareAndSwapStorage struct {
+	AtomicStorage
+	fail bool
+}
+
+func (storage *failingCompareAndSwapStorage) CompareAndSwap(key string, prevValue string, newValue string) (ok bool, err error) {
+	if storage.fail {
+		return false, errors.New("storage is unavailable")
+	}
+	return storage.AtomicStorage.CompareAndSwap(key, prevValue, newValue)
+}
+
+func TestTenantQuotaFailedReleaseIsCorrectedOnRefresh(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tenant := testTenants[0]
+	tenant.MaxConcurrentCalls = 1
+	storage := &failingCompareAndSwapStorage{AtomicStorage: NewMemStorage()}
+	service1 := NewTenantQuota(tenant, storage, time.Minute)
+	service1.now = func() time.Time { return now }
+	service2 := NewTenantQuota(tenant, storage, time.Minute)
+	service2.now = func() time.Time { return now }
+	defer service1.Close()
+	defer service2.Close()
+
+	release, ok1, _ := service1.Acquire()
+	storage.fail = true
+	release()
+	storage.fail = false
+	_, ok2, _ := service2.Acquire()
+	service1.publishActive(false)
+	_, ok3, err3 := service2.Acquire()
+
+	assert.True(t, ok1)
+	assert.False(t, ok2)
+	assert.Nil(t, err3)
+	assert.True(t, ok3)
+	assert.Equal(t, 0, service1.active)
+}
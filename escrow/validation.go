@@ -2,30 +2,44 @@ package escrow
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/singnet/snet-daemon/authutils"
 	"github.com/singnet/snet-daemon/blockchain"
 	"github.com/singnet/snet-daemon/config"
+	"github.com/singnet/snet-daemon/featureflag"
+	"github.com/singnet/snet-daemon/handler"
+	"github.com/singnet/snet-daemon/metrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc/metadata"
 	"math/big"
+	"time"
 )
 const (
 	PrefixInSignature = "__MPE_claim_message"
 	//Agreed constant value
 	FreeCallPrefixSignature = "__prefix_free_trial"
+	// RequestContentPrefixInSignature is a prefix of the message which binds
+	// payment to the request content
+	RequestContentPrefixInSignature = "__MPE_request_content"
 )
 
 type FreeCallPaymentValidator struct {
 	currentBlock               func() (currentBlock *big.Int, err error)
 	freeCallSigner common.Address
+	// allowedBlockSkew is a maximal difference between block number signed by
+	// client and current block number known to daemon
+	allowedBlockSkew uint64
 }
 
-func NewFreeCallPaymentValidator (funcCurrentBlock func() (currentBlock *big.Int, err error),signer common.Address) *FreeCallPaymentValidator {
+func NewFreeCallPaymentValidator (funcCurrentBlock func() (currentBlock *big.Int, err error),signer common.Address, allowedBlockSkew uint64) *FreeCallPaymentValidator {
 	return &FreeCallPaymentValidator{
 		currentBlock:funcCurrentBlock,
 		freeCallSigner: signer,
+		allowedBlockSkew: allowedBlockSkew,
 	}
 
 }
@@ -50,69 +64,700 @@ func (validator *FreeCallPaymentValidator) Validate (payment *FreeCallPayment) (
 }
 // ChannelPaymentValidator validates payment using payment channel state.
 type ChannelPaymentValidator struct {
-	currentBlock               func() (currentBlock *big.Int, err error)
+	blockProvider              BlockProvider
 	paymentExpirationThreshold func() (threshold *big.Int)
-
-
+	// groupExpirationThreshold returns threshold which overrides
+	// paymentExpirationThreshold for the channels of the group, nil means
+	// no overrides
+	groupExpirationThreshold func(groupID [32]byte) (threshold *big.Int, ok bool)
+	// daemonId is an id of this daemon instance, when it is not empty
+	// payments should be bound to this daemon
+	daemonId string
+	// checkMpeContractAddress enables check that payment channel was opened
+	// using the same MPE contract as payment is sent to
+	checkMpeContractAddress bool
+	// mpeContractAddress is the MPE contract which channels are read and
+	// stored by daemon, payments signed for other contracts are rejected
+	// because their channels are not tracked; zero address disables the
+	// check
+	mpeContractAddress common.Address
+	// maxRemainingLifetime is a maximal number of blocks before channel
+	// expiration which is accepted, zero means no limit
+	maxRemainingLifetime *big.Int
+	// graceAmount is a maximal amount by which payment may exceed channel
+	// full amount to absorb rounding differences, zero disables the grace
+	graceAmount *big.Int
+	// minPaymentIncrement is a minimal amount by which payment should
+	// exceed the authorized amount of the channel, zero disables the check
+	minPaymentIncrement *big.Int
+	// onChainChannel returns on-chain channel state, payment
+	// within the grace is accepted only when on-chain channel value covers
+	// it
+	onChainChannel func(channelID *big.Int) (channel *blockchain.MultiPartyEscrowChannel, ok bool, err error)
+	// pendingClaim returns the claim of the channel with the given nonce
+	// which is started but not finished yet, its amount is still a part of
+	// the on-chain channel value; nil means claims are not checked
+	pendingClaim func(channelID *big.Int, nonce *big.Int) (payment *Payment, ok bool, err error)
+	// refreshChannel re-reads and stores channel state when payment nonce
+	// is ahead of the channel nonce, nil disables refresh
+	refreshChannel func(channelID *big.Int) (channel *PaymentChannelData, err error)
+	// checkSignatureFormat enables cheap checks of signature values before
+	// signer is recovered
+	checkSignatureFormat bool
+	// messageV2Enabled allows payments signed using PaymentMessageTypeV2
+	messageV2Enabled bool
+	// sanctionsList is checked for channel sender and payment signer, nil
+	// means no check
+	sanctionsList SanctionsList
+	// expiryWarningBlocks is a number of blocks before payments via channel
+	// are rejected because of expiration when client starts receiving
+	// expiry warning, zero disables warning
+	expiryWarningBlocks *big.Int
+	// expiryWarningHook is called when the valid payment is sent via channel
+	// which is in expiry warning zone, nil means no hook
+	expiryWarningHook ExpiryWarningHook
+	// averageBlockTime is used to estimate expiration time shown in expiry
+	// warning, zero disables estimation
+	averageBlockTime time.Duration
+	now              func() time.Time
+	// checkRequestContent enables check that payment is signed together
+	// with the hash of the request content
+	checkRequestContent bool
+	// signatureScheme recovers payment signer, nil means Secp256k1Scheme
+	signatureScheme SignatureScheme
+	// signatureEncoding encodes numbers of the signed payment message, nil
+	// means MpeV1SignatureEncoding
+	signatureEncoding *SignatureEncoding
+	// signatureCooldown rejects payments of clients which sent too many
+	// invalid signatures, nil disables the cooldown
+	signatureCooldown *SignatureCooldown
+	// blacklist refuses payments via blacklisted channels and senders, nil
+	// disables the check
+	blacklist *Blacklist
+	// replayCache rejects exact replays of the committed payments, nil
+	// disables the check
+	replayCache *ReplayCache
+	// auditLogger records decision on each payment, nil disables audit
+	auditLogger AuditLogger
+	// validationMetrics counts validation outcomes, nil disables metrics
+	validationMetrics *metrics.PaymentValidationMetrics
+	// flags can disable checks above for a part of the traffic, nil means
+	// that all enabled checks are applied
+	flags featureflag.Flags
 }
 
-// NewChannelPaymentValidator returns new payment validator instance
-func NewChannelPaymentValidator(processor *blockchain.Processor, cfg *viper.Viper, metadata *blockchain.OrganizationMetaData) *ChannelPaymentValidator {
+// NewChannelPaymentValidator returns new payment validator instance,
+// expiryWarningHook can be nil if no action is required for channels which
+// are near to expiration, refreshChannel can be nil to not refresh the
+// channel when payment nonce is ahead, it is called while the channel lock is
+// held.
+func NewChannelPaymentValidator(processor *blockchain.Processor, cfg *viper.Viper, metadata *blockchain.OrganizationMetaData, paymentStorage *PaymentStorage, signatureCooldown *SignatureCooldown, blacklist *Blacklist, validationMetrics *metrics.PaymentValidationMetrics, expiryWarningHook ExpiryWarningHook, refreshChannel func(channelID *big.Int) (*PaymentChannelData, error)) *ChannelPaymentValidator {
 	return &ChannelPaymentValidator{
-		currentBlock: processor.CurrentBlock,
+		blockProvider: processor,
 		paymentExpirationThreshold: func() *big.Int {
 			return metadata.GetPaymentExpirationThreshold()
 		},
+		groupExpirationThreshold: newGroupExpirationThresholdsFromConfig(cfg),
+		daemonId:                 cfg.GetString(config.PaymentDaemonId),
+		checkMpeContractAddress:  cfg.GetBool(config.PaymentChannelMpeCheckEnabled),
+		mpeContractAddress:       processor.EscrowContractAddress(),
+		maxRemainingLifetime:     big.NewInt(cfg.GetInt64(config.PaymentChannelMaxRemainingLifetime)),
+		graceAmount:              big.NewInt(cfg.GetInt64(config.PaymentChannelGraceAmount)),
+		minPaymentIncrement:      big.NewInt(cfg.GetInt64(config.PaymentMinIncrement)),
+		onChainChannel:           processor.MultiPartyEscrowChannel,
+		pendingClaim:             newPendingClaimFromStorage(paymentStorage),
+		refreshChannel:           refreshChannel,
+		checkSignatureFormat:     cfg.GetBool(config.PaymentSignatureFormatCheckEnabled),
+		messageV2Enabled:         cfg.GetBool(config.PaymentMessageV2Enabled),
+		sanctionsList:            newSanctionsListFromConfig(cfg),
+		expiryWarningBlocks:      big.NewInt(cfg.GetInt64(config.PaymentChannelExpiryWarningBlocks)),
+		expiryWarningHook:        expiryWarningHook,
+		averageBlockTime:         cfg.GetDuration(config.AverageBlockTime),
+		now:                      time.Now,
+		checkRequestContent:      cfg.GetBool(config.PaymentRequestContentCheckEnabled),
+		signatureScheme:          newSignatureSchemeFromConfig(cfg),
+		signatureEncoding:        newSignatureEncodingFromConfig(cfg),
+		signatureCooldown:        signatureCooldown,
+		blacklist:                blacklist,
+		replayCache:              newReplayCacheFromConfig(cfg),
+		auditLogger:              newAuditLoggerFromConfig(cfg),
+		validationMetrics:        validationMetrics,
+		flags:                    featureflag.NewFlagsFromConfig(cfg),
+	}
+}
+
+// newPendingClaimFromStorage returns claims started by the daemon which are
+// kept in the payment storage until they are finished
+func newPendingClaimFromStorage(paymentStorage *PaymentStorage) func(channelID *big.Int, nonce *big.Int) (*Payment, bool, error) {
+	return func(channelID *big.Int, nonce *big.Int) (*Payment, bool, error) {
+		return paymentStorage.Get(PaymentID(channelID, nonce))
+	}
+}
+
+// newSignatureSchemeFromConfig expects that scheme name is validated on
+// startup
+func newSignatureSchemeFromConfig(cfg *viper.Viper) SignatureScheme {
+	scheme, err := GetSignatureScheme(cfg.GetString(config.PaymentSignatureScheme))
+	if err != nil {
+		log.WithError(err).Panic("payment signature scheme is not validated")
+	}
+	return scheme
+}
+
+// newSignatureEncodingFromConfig expects that encoding is validated on
+// startup
+func newSignatureEncodingFromConfig(cfg *viper.Viper) *SignatureEncoding {
+	var encodings []SignatureEncoding
+	if err := cfg.UnmarshalKey(config.PaymentSignatureEncodings, &encodings); err != nil {
+		log.WithError(err).Panic("payment signature encodings are not validated")
+	}
+	encoding, err := GetSignatureEncoding(cfg.GetString(config.PaymentSignatureProtocolVersion), encodings)
+	if err != nil {
+		log.WithError(err).Panic("payment signature encoding is not validated")
 	}
+	return encoding
+}
+
+func newSanctionsListFromConfig(cfg *viper.Viper) SanctionsList {
+	path := cfg.GetString(config.PaymentSanctionsListFile)
+	if path == "" {
+		return nil
+	}
+	return NewFileSanctionsList(path, cfg.GetDuration(config.PaymentSanctionsListRefreshInterval))
+}
+
+// ValidationWarning is a non-fatal condition found while payment is
+// validated, client is notified about it via response trailer.
+type ValidationWarning struct {
+	// Message is a human readable description of the warning
+	Message string
+	// Trailer is added to the response trailer, can be nil
+	Trailer metadata.MD
+}
+
+// ValidationResult contains details of the successful payment validation.
+type ValidationResult struct {
+	// Warnings is a list of non-fatal conditions found
+	Warnings []ValidationWarning
+}
+
+// Trailer returns response trailer which contains all warnings, nil if
+// there are no warnings
+func (result *ValidationResult) Trailer() (trailer metadata.MD) {
+	for _, warning := range result.Warnings {
+		trailer = metadata.Join(trailer, warning.Trailer)
+	}
+	return
 }
 
 // Validate returns instance of PaymentError as error if validation fails, nil
 // otherwise.
 func (validator *ChannelPaymentValidator) Validate(payment *Payment, channel *PaymentChannelData) (err error) {
+	return validator.ValidateContext(context.Background(), payment, channel)
+}
+
+// ValidateContext validates payment as Validate does, validation is stopped
+// with DeadlineExceeded error when ctx is done.
+func (validator *ChannelPaymentValidator) ValidateContext(ctx context.Context, payment *Payment, channel *PaymentChannelData) (err error) {
+	_, err = validator.validateWithWarnings(ctx, payment, channel, false)
+	return
+}
+
+// ValidateWithWarnings validates payment as Validate does and returns non
+// fatal warnings in result when payment is valid.
+func (validator *ChannelPaymentValidator) ValidateWithWarnings(payment *Payment, channel *PaymentChannelData) (result *ValidationResult, err error) {
+	return validator.validateWithWarnings(context.Background(), payment, channel, false)
+}
+
+// validateWithWarnings validates payment, readOnlyReplay is set for the
+// replay of the authorized amount which is served in read-only mode.
+func (validator *ChannelPaymentValidator) validateWithWarnings(ctx context.Context, payment *Payment, channel *PaymentChannelData, readOnlyReplay bool) (result *ValidationResult, err error) {
+	currentBlock, err := validator.validate(ctx, payment, channel, readOnlyReplay)
+	validator.observe(payment, err)
+	if err != nil {
+		validator.audit(payment, err)
+		return nil, err
+	}
+
+	result = &ValidationResult{}
+	if warning := validator.expiryWarning(channel, currentBlock); warning != nil {
+		result.Warnings = append(result.Warnings, *warning)
+		if validator.expiryWarningHook != nil {
+			validator.expiryWarningHook(channel, new(big.Int).Sub(channel.Expiration, currentBlock))
+		}
+	}
+	for _, warning := range result.Warnings {
+		log.WithField("payment", payment).WithField("warning", warning.Message).Debug("Payment is valid with warning")
+	}
+	return result, nil
+}
+
+// ValidateBatch validates payments sent one after another via the same
+// channel. Current block and expiration threshold are read once for the
+// whole batch. Amount of each payment should not be less than amount of the
+// previous valid payment of the batch. Payments are checked independently,
+// so invalid payment doesn't prevent checking the following ones. Returns
+// error for each payment, nil for the valid ones.
+func (validator *ChannelPaymentValidator) ValidateBatch(payments []*Payment, channel *PaymentChannelData) []error {
+	errs := make([]error, len(payments))
+
+	var currentBlock, expirationThreshold, previousAmount *big.Int
+	var thresholdGroup string
+	var blockErr error
+	for i, payment := range payments {
+		if previousAmount != nil && payment.Amount.Cmp(previousAmount) < 0 {
+			log.WithField("payment", payment).WithField("previousAmount", previousAmount).Warn("Payment amount is less than amount of the previous payment in batch")
+			errs[i] = NewPaymentError(Unauthenticated, "payment amount %v is less than amount of the previous payment %v", payment.Amount, previousAmount)
+			continue
+		}
+
+		if err := validator.validateSigned(payment, channel, false); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if currentBlock == nil && blockErr == nil {
+			if currentBlock, blockErr = validator.blockProvider.CurrentBlock(); blockErr == nil {
+				expirationThreshold, thresholdGroup = validator.expirationThreshold(channel)
+			}
+		}
+		if blockErr != nil {
+			errs[i] = NewPaymentError(Internal, "cannot determine current block")
+			continue
+		}
+		if err := validator.validateAtBlock(payment, channel, currentBlock, expirationThreshold, thresholdGroup); err != nil {
+			errs[i] = err
+			continue
+		}
+		if err := validator.checkReplay(payment); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		previousAmount = payment.Amount
+	}
+
+	// batch payments are not committed, so only rejections are audited
+	for i, payment := range payments {
+		validator.observe(payment, errs[i])
+		if errs[i] != nil {
+			validator.audit(payment, errs[i])
+		}
+	}
+	return errs
+}
+
+// validate returns current block which was used to validate the payment,
+// replay of the authorized amount in read-only mode is not checked against
+// replay cache and minimal increment because it doesn't change channel state
+func (validator *ChannelPaymentValidator) validate(ctx context.Context, payment *Payment, channel *PaymentChannelData, readOnlyReplay bool) (currentBlock *big.Int, err error) {
+	if err = checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if err = validator.validateSigned(payment, channel, readOnlyReplay); err != nil {
+		return nil, err
+	}
+
+	if err = checkContext(ctx); err != nil {
+		return nil, err
+	}
+	currentBlock, e := currentBlockContext(ctx, validator.blockProvider)
+	if err = checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if e != nil {
+		return nil, NewPaymentError(Internal, "cannot determine current block")
+	}
+	expirationThreshold, thresholdGroup := validator.expirationThreshold(channel)
+	if err = validator.validateAtBlock(payment, channel, currentBlock, expirationThreshold, thresholdGroup); err != nil {
+		return nil, err
+	}
+	if readOnlyReplay {
+		return currentBlock, nil
+	}
+	if err = validator.checkReplay(payment); err != nil {
+		return nil, err
+	}
+	return currentBlock, nil
+}
+
+// checkContext returns DeadlineExceeded error if ctx is done
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		log.WithError(err).Warn("Payment validation is stopped")
+		return NewPaymentError(DeadlineExceeded, "payment validation is stopped: %v", err)
+	}
+	return nil
+}
+
+// checkReplay rejects the payment which is already committed, payments are
+// remembered by paymentCommitted
+func (validator *ChannelPaymentValidator) checkReplay(payment *Payment) error {
+	if validator.replayCache == nil {
+		return nil
+	}
+	if err := validator.replayCache.Check(payment); err != nil {
+		log.WithField("payment", payment).Warn("Payment is replayed")
+		return err
+	}
+	return nil
+}
+
+// paymentCommitted is called after payment is committed, so replay of it is
+// rejected and its acceptance is audited
+func (validator *ChannelPaymentValidator) paymentCommitted(payment *Payment) {
+	if validator.replayCache != nil {
+		validator.replayCache.Remember(payment)
+	}
+	validator.audit(payment, nil)
+}
+
+// validateSigned checks the payment itself and its signature, checks don't
+// depend on the current block
+func (validator *ChannelPaymentValidator) validateSigned(payment *Payment, channel *PaymentChannelData, readOnlyReplay bool) (err error) {
 	var log = log.WithField("payment", payment).WithField("channel", channel)
 
-	if payment.ChannelNonce.Cmp(channel.Nonce) != 0 {
-		log.Warn("Incorrect nonce is sent by client")
-		return NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: %v, sent: %v", channel.Nonce, payment.ChannelNonce)
+	if validator.mpeContractAddress != (common.Address{}) && payment.MpeContractAddress != validator.mpeContractAddress {
+		log.Warn("Payment is signed for unknown MPE contract")
+		return NewPaymentError(Unauthenticated, "unknown MPE contract address")
+	}
+
+	// channels stored by previous daemon versions have no MPE address, they
+	// are not checked
+	if validator.checkMpeContractAddress && validator.enabled(featureflag.MpeContractCheck, payment) &&
+		channel.MpeContractAddress != (common.Address{}) &&
+		channel.MpeContractAddress != payment.MpeContractAddress {
+		log.Warn("Payment channel belongs to another MPE contract")
+		return NewPaymentError(Unauthenticated, "payment channel belongs to another MPE contract, channel MPE: %v, payment MPE: %v",
+			blockchain.AddressToHex(&channel.MpeContractAddress), blockchain.AddressToHex(&payment.MpeContractAddress))
+	}
+
+	if validator.signatureCooldown != nil {
+		until, ok, e := validator.signatureCooldown.CooledDownUntil(payment.Source)
+		if e != nil {
+			log.WithError(e).Error("Unable to read client signature cooldown")
+			return NewPaymentError(Internal, "cannot read client cooldown")
+		}
+		if ok {
+			log.WithField("until", until).Warn("Client is cooled down after repeated invalid signatures")
+			return NewPaymentError(ResourceExhausted, "client is cooled down after repeated invalid signatures, retry after %v", until.UTC().Format(time.RFC3339))
+		}
 	}
 
-	signerAddress, err := getSignerAddressFromPayment(payment)
+	if validator.blacklist != nil {
+		if err = validator.blacklist.Check(payment.ChannelID, channel.Sender); err != nil {
+			return
+		}
+	}
+
+	// channel is refreshed for the payment which nonce is ahead only after
+	// the payment signer is verified, so forged payments cannot make the
+	// daemon read blockchain and rewrite the stored channel
+	refreshRequired := validator.refreshChannel != nil && payment.ChannelNonce.Cmp(channel.Nonce) > 0
+	if !refreshRequired {
+		if err = checkChannelNonce(payment, channel); err != nil {
+			return
+		}
+	}
+
+	if _, e := GetPaymentMessageEncoder(payment.MessageType); e != nil {
+		log.WithError(e).Warn("Payment message type is unknown")
+		return NewPaymentError(InvalidArgument, "%v", e)
+	}
+	if payment.MessageType == PaymentMessageTypeV2 && !validator.messageV2Enabled {
+		log.Warn("Payment message type v2 is disabled")
+		return NewPaymentError(InvalidArgument, "payment message type \"%v\" is disabled", payment.MessageType)
+	}
+	if payment.MessageType == PaymentMessageTypeV2 && payment.GroupID != channel.GroupID {
+		log.Warn("Payment is signed for another payment group")
+		return NewPaymentError(Unauthenticated, "payment is signed for another payment group")
+	}
+
+	encoding := validator.encoding()
+	if _, e := encoding.encodePaymentNumbers(payment); e != nil {
+		log.WithError(e).Warn("Payment doesn't fit into signature encoding")
+		return NewPaymentError(Unauthenticated, "payment is not supported by MPE protocol %v: %v", encoding.ProtocolVersion, e)
+	}
+
+	if validator.checkSignatureFormat && validator.enabled(featureflag.SignatureFormatCheck, payment) {
+		if e := checkSignatureValues(payment.Signature); e != nil {
+			log.WithError(e).Warn("Payment signature has incorrect format")
+			return validator.invalidSignature(payment, NewPaymentError(Unauthenticated, "payment signature is not valid: %v", e))
+		}
+	}
+
+	signerAddress, err := recoverPaymentSigner(payment, validator.scheme(), encoding)
 	if err != nil {
-		return NewPaymentError(Unauthenticated, "payment signature is not valid")
+		return validator.invalidSignature(payment, NewPaymentError(Unauthenticated, "payment signature is not valid"))
 	}
 
 	log = log.WithField("signerAddress", blockchain.AddressToHex(signerAddress))
 	if *signerAddress != channel.Signer && *signerAddress != channel.Sender  {
 		log.WithField("signerAddress", blockchain.AddressToHex(signerAddress)).Warn("Channel signer is not equal to payment signer/sender")
-		return NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender")
+		return validator.invalidSignature(payment, NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"))
 	}
-	currentBlock, e := validator.currentBlock()
-	if e != nil {
-		return NewPaymentError(Internal, "cannot determine current block")
+
+	if refreshRequired {
+		if err = validator.refreshChannelState(payment, channel); err != nil {
+			return
+		}
+		if err = checkChannelNonce(payment, channel); err != nil {
+			return
+		}
 	}
-	expirationThreshold := validator.paymentExpirationThreshold()
+
+	if !readOnlyReplay && validator.minPaymentIncrement != nil && validator.minPaymentIncrement.Sign() > 0 {
+		increment := new(big.Int).Sub(payment.Amount, channel.AuthorizedAmount)
+		if increment.Cmp(validator.minPaymentIncrement) < 0 {
+			log.WithField("increment", increment).WithField("minPaymentIncrement", validator.minPaymentIncrement).Warn("Payment increment is below minimum")
+			return NewPaymentError(InvalidArgument, "payment increment below minimum")
+		}
+	}
+
+	if validator.checkRequestContent && validator.enabled(featureflag.RequestContentCheck, payment) {
+		if err = checkRequestContent(payment, signerAddress, encoding); err != nil {
+			log.WithError(err).Warn("Request content doesn't match the payment")
+			return err
+		}
+	}
+
+	if validator.enabled(featureflag.SanctionsCheck, payment) {
+		if err = validator.checkSanctions(channel.Sender, *signerAddress); err != nil {
+			return
+		}
+	}
+
+	if validator.daemonId != "" && payment.DaemonId != validator.daemonId {
+		log.WithField("daemonId", validator.daemonId).Warn("Payment is bound to another daemon")
+		return NewPaymentError(Unauthenticated, "payment is bound to another daemon, expected daemon id: %v, payment daemon id: %v", validator.daemonId, payment.DaemonId)
+	}
+
+	return nil
+}
+
+// validateAtBlock checks channel expiration and amount using current block
+// and expiration threshold of the thresholdGroup which are read by caller
+func (validator *ChannelPaymentValidator) validateAtBlock(payment *Payment, channel *PaymentChannelData, currentBlock *big.Int, expirationThreshold *big.Int, thresholdGroup string) (err error) {
+	var log = log.WithField("payment", payment).WithField("channel", channel)
+
 	currentBlockWithThreshold := new(big.Int).Add(currentBlock, expirationThreshold)
 	if currentBlockWithThreshold.Cmp(channel.Expiration) >= 0 {
-		log.WithField("currentBlock", currentBlock).WithField("expirationThreshold", expirationThreshold).Warn("Channel expiration time is after expiration threshold")
-		return NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: %v, current block: %v, expiration threshold: %v", channel.Expiration, currentBlock, expirationThreshold)
+		log.WithField("currentBlock", currentBlock).WithField("expirationThreshold", expirationThreshold).WithField("thresholdGroup", thresholdGroup).Warn("Channel expiration time is after expiration threshold")
+		return NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: %v, current block: %v, expiration threshold: %v (group %v)", channel.Expiration, currentBlock, expirationThreshold, thresholdGroup)
 	}
 
-	if channel.FullAmount.Cmp(payment.Amount) < 0 {
-		log.Warn("Not enough tokens on payment channel")
-		return NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: %v, payment amount: %v", channel.FullAmount, payment.Amount)
+	if validator.maxRemainingLifetime != nil && validator.maxRemainingLifetime.Sign() > 0 {
+		remainingLifetime := new(big.Int).Sub(channel.Expiration, currentBlock)
+		if remainingLifetime.Cmp(validator.maxRemainingLifetime) > 0 {
+			log.WithField("currentBlock", currentBlock).WithField("maxRemainingLifetime", validator.maxRemainingLifetime).Warn("Channel expiration time is too far in the future")
+			return NewPaymentError(Unauthenticated, "payment channel expiration time is too far, expiration time: %v, current block: %v, maximum remaining lifetime: %v", channel.Expiration, currentBlock, validator.maxRemainingLifetime)
+		}
 	}
 
-	return
+	if channel.FullAmount.Cmp(payment.Amount) < 0 && !validator.withinGraceAmount(payment, channel) {
+		log.WithField("channelAmount", metrics.FormatAmount(channel.FullAmount)).WithField("paymentAmount", metrics.FormatAmount(payment.Amount)).Warn("Not enough tokens on payment channel")
+		return NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: %v (%v), payment amount: %v (%v)",
+			channel.FullAmount, metrics.FormatAmount(channel.FullAmount), payment.Amount, metrics.FormatAmount(payment.Amount))
+	}
+
+	return nil
+}
+
+// withinGraceAmount returns true if payment exceeds channel full amount by
+// no more than grace amount and on-chain channel value is enough to claim
+// the payment
+func (validator *ChannelPaymentValidator) withinGraceAmount(payment *Payment, channel *PaymentChannelData) bool {
+	if validator.graceAmount == nil || validator.graceAmount.Sign() <= 0 {
+		return false
+	}
+	overspent := new(big.Int).Sub(payment.Amount, channel.FullAmount)
+	if overspent.Cmp(validator.graceAmount) > 0 {
+		return false
+	}
+
+	var log = log.WithField("payment", payment).WithField("channel", channel)
+	onChain, ok, err := validator.onChainChannel(channel.ChannelID)
+	if err != nil || !ok {
+		log.WithError(err).Warn("Unable to read channel from blockchain to apply grace amount")
+		return false
+	}
+	// amount of the claim which is not finished yet is going to be
+	// withdrawn from the on-chain value
+	available := onChain.Value
+	if validator.pendingClaim != nil {
+		claim, ok, err := validator.pendingClaim(channel.ChannelID, onChain.Nonce)
+		if err != nil {
+			log.WithError(err).Warn("Unable to read pending claim to apply grace amount")
+			return false
+		}
+		if ok {
+			available = new(big.Int).Sub(available, claim.Amount)
+		}
+	}
+	if available.Cmp(payment.Amount) < 0 {
+		log.WithField("onChainValue", onChain.Value).WithField("availableValue", available).Warn("On-chain channel value doesn't cover payment within grace amount")
+		return false
+	}
+	log.WithField("overspent", overspent).Info("Payment exceeds channel amount within grace amount")
+	return true
+}
+
+// invalidSignature accounts invalid signature of the payment for the
+// client cooldown and returns err
+func (validator *ChannelPaymentValidator) invalidSignature(payment *Payment, err *PaymentError) *PaymentError {
+	if validator.signatureCooldown == nil {
+		return err
+	}
+	if e := validator.signatureCooldown.InvalidSignature(payment.Source); e != nil {
+		log.WithError(e).WithField("payment", payment).Error("Unable to account invalid payment signature")
+	}
+	return err
+}
+
+// observe counts the validation outcome in metrics
+func (validator *ChannelPaymentValidator) observe(payment *Payment, err error) {
+	if err == nil {
+		validator.validationMetrics.Succeeded(payment.Amount)
+		return
+	}
+	errorType := Internal.String()
+	if paymentErr, ok := err.(*PaymentError); ok {
+		errorType = paymentErr.Code.String()
+	}
+	validator.validationMetrics.Failed(errorType, payment.Amount)
+}
+
+// audit records the decision on the payment: rejection is recorded on
+// validation and acceptance after the payment is committed. Signer is
+// recovered once more to record it even when validation failed before or
+// because of signer check.
+func (validator *ChannelPaymentValidator) audit(payment *Payment, err error) {
+	if validator.auditLogger == nil {
+		return
+	}
+	if _, noop := validator.auditLogger.(NoopAuditLogger); noop {
+		return
+	}
+
+	record := &AuditRecord{
+		Timestamp: time.Now(),
+		ChannelID: payment.ChannelID,
+		Nonce:     payment.ChannelNonce,
+		Amount:    payment.Amount,
+		Err:       err,
+	}
+	if validator.now != nil {
+		record.Timestamp = validator.now()
+	}
+	if len(payment.Signature) > 0 {
+		if signer, e := recoverPaymentSigner(payment, validator.scheme(), validator.encoding()); e == nil {
+			record.Signer = signer
+		}
+	}
+	if e := validator.auditLogger.Record(record); e != nil {
+		log.WithError(e).WithField("payment", payment).Error("Unable to write payment audit log")
+	}
+}
+
+// enabled returns true if the check is enabled by feature flags for the
+// payment, payments of the same channel get the same result
+func (validator *ChannelPaymentValidator) enabled(flag string, payment *Payment) bool {
+	if validator.flags == nil {
+		return true
+	}
+	return validator.flags.Enabled(flag, payment.ChannelID.String())
 }
 
-//Check if the block number passed is not more +- 5 from the latest block number on chain
+func checkChannelNonce(payment *Payment, channel *PaymentChannelData) error {
+	if payment.ChannelNonce.Cmp(channel.Nonce) != 0 {
+		log.WithField("payment", payment).WithField("channel", channel).Warn("Incorrect nonce is sent by client")
+		return NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: %v, sent: %v", channel.Nonce, payment.ChannelNonce)
+	}
+	return nil
+}
+
+// refreshChannelState loads channel state after the channel is claimed and
+// its nonce is incremented on-chain, channel is updated in place so the
+// payment is applied to the refreshed state
+func (validator *ChannelPaymentValidator) refreshChannelState(payment *Payment, channel *PaymentChannelData) error {
+	if validator.refreshChannel == nil {
+		return nil
+	}
+
+	refreshed, err := validator.refreshChannel(payment.ChannelID)
+	if err != nil {
+		log.WithError(err).WithField("channelID", payment.ChannelID).Error("Unable to refresh payment channel state")
+		return NewPaymentError(Internal, "cannot refresh payment channel state")
+	}
+	log.WithField("channelID", payment.ChannelID).WithField("previousNonce", channel.Nonce).WithField("nonce", refreshed.Nonce).Info("Payment nonce is ahead of the channel nonce, channel state is refreshed")
+	*channel = *refreshed
+	return nil
+}
+
+// ExpiryWarningHook is called with the channel and number of blocks before
+// channel expiration when payment is accepted via channel which is near to
+// expiration. It can be used to report metric or notify the recipient.
+type ExpiryWarningHook func(channel *PaymentChannelData, remainingBlocks *big.Int)
+
+// expiryWarning warns client that the channel will be expired soon, so
+// client can extend it. Returns nil if the channel is far from expiration or
+// warning is disabled.
+func (validator *ChannelPaymentValidator) expiryWarning(channel *PaymentChannelData, currentBlock *big.Int) *ValidationWarning {
+	if validator.expiryWarningBlocks == nil || validator.expiryWarningBlocks.Sign() <= 0 || currentBlock == nil {
+		return nil
+	}
+
+	remainingBlocks := new(big.Int).Sub(channel.Expiration, currentBlock)
+	expirationThreshold, _ := validator.expirationThreshold(channel)
+	warningZone := new(big.Int).Add(expirationThreshold, validator.expiryWarningBlocks)
+	if remainingBlocks.Cmp(warningZone) > 0 {
+		return nil
+	}
+
+	warning := &ValidationWarning{
+		Message: fmt.Sprintf("payment channel will be expired in %v blocks, expiration time: %v", remainingBlocks, channel.Expiration),
+		Trailer: metadata.Pairs(
+			handler.PaymentChannelExpirationTrailer, channel.Expiration.String(),
+			handler.PaymentChannelRemainingBlocksTrailer, remainingBlocks.String(),
+		),
+	}
+	if validator.averageBlockTime > 0 && validator.now != nil {
+		expirationTime := blockchain.EstimateBlockTime(channel.Expiration, currentBlock, validator.now(), validator.averageBlockTime).UTC().Format(time.RFC3339)
+		warning.Message += ", estimated expiration time: " + expirationTime
+		warning.Trailer.Set(handler.PaymentChannelExpirationTimeTrailer, expirationTime)
+	}
+	return warning
+}
+
+// checkSanctions refuses payments if channel sender or payment signer is on
+// the sanctions list
+func (validator *ChannelPaymentValidator) checkSanctions(addresses ...common.Address) error {
+	if validator.sanctionsList == nil {
+		return nil
+	}
+
+	for _, address := range addresses {
+		sanctioned, err := validator.sanctionsList.IsSanctioned(address)
+		if err != nil {
+			log.WithError(err).WithField("address", blockchain.AddressToHex(&address)).Error("Unable to check sanctions list")
+			return NewPaymentError(Internal, "cannot check sanctions list")
+		}
+		if sanctioned {
+			log.WithField("address", blockchain.AddressToHex(&address)).Warn("Payment from sanctioned address is rejected")
+			return NewPaymentError(PermissionDenied, "payments from address %v are not accepted", blockchain.AddressToHex(&address))
+		}
+	}
+	return nil
+}
+
+//Check if the block number passed is not more +- allowedBlockSkew from the latest block number on chain
 func (validator *FreeCallPaymentValidator) compareWithLatestBlockNumber(blockNumberPassed *big.Int) error {
 	latestBlockNumber, err := validator.currentBlock()
 	if err != nil {
 		return err
 	}
-	differenceInBlockNumber := blockNumberPassed.Sub(blockNumberPassed, latestBlockNumber)
-	if differenceInBlockNumber.Abs(differenceInBlockNumber).Uint64() > authutils.AllowedBlockChainDifference {
+	differenceInBlockNumber := new(big.Int).Sub(blockNumberPassed, latestBlockNumber)
+	if differenceInBlockNumber.Abs(differenceInBlockNumber).Uint64() > validator.allowedBlockSkew {
 		return fmt.Errorf("authentication failed as the signature passed has expired")
 	}
 	return nil
@@ -140,24 +785,129 @@ func (validator *FreeCallPaymentValidator) getSignerAddressForFreeCall(payment *
 
 
 
+func (validator *ChannelPaymentValidator) scheme() SignatureScheme {
+	if validator.signatureScheme == nil {
+		return Secp256k1Scheme
+	}
+	return validator.signatureScheme
+}
+
+func (validator *ChannelPaymentValidator) encoding() SignatureEncoding {
+	if validator.signatureEncoding == nil {
+		return MpeV1SignatureEncoding
+	}
+	return *validator.signatureEncoding
+}
+
+// getSignerAddressFromPayment recovers signer of the payment as it is done
+// by MPE contract on claim
 func getSignerAddressFromPayment(payment *Payment) (signer *common.Address, err error) {
-	message := bytes.Join([][]byte{
-		[]byte(PrefixInSignature),
-		payment.MpeContractAddress.Bytes(),
-		bigIntToBytes(payment.ChannelID),
-		bigIntToBytes(payment.ChannelNonce),
-		bigIntToBytes(payment.Amount),
-	}, nil)
+	return recoverPaymentSigner(payment, Secp256k1Scheme, MpeV1SignatureEncoding)
+}
 
-	signer, err = authutils.GetSignerAddressFromMessage(message, payment.Signature)
+func recoverPaymentSigner(payment *Payment, scheme SignatureScheme, encoding SignatureEncoding) (signer *common.Address, err error) {
+	encoder, err := GetPaymentMessageEncoder(payment.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	message, err := encoder.Encode(payment, encoding)
+	if err != nil {
+		log.WithField("payment", payment).WithError(err).Error("Cannot encode payment message")
+		return nil, err
+	}
+
+	address, err := scheme.Recover(message, payment.Signature)
 	if err != nil {
 		log.WithField("payment", payment).WithError(err).Error("Cannot get signer from payment")
 		return nil, err
 	}
 
-	return signer, err
+	return &address, nil
 }
 
+// checkSignatureValues rejects degenerate signatures before public key
+// recovery. Signatures of incorrect length are left to the recovery which
+// reports them.
+func checkSignatureValues(signature []byte) error {
+	if len(signature) != 65 {
+		return nil
+	}
+
+	secp256k1N := crypto.S256().Params().N
+	r := new(big.Int).SetBytes(signature[0:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	v := signature[64]
+
+	if r.Sign() == 0 {
+		return fmt.Errorf("r is zero")
+	}
+	if s.Sign() == 0 {
+		return fmt.Errorf("s is zero")
+	}
+	if r.Cmp(secp256k1N) >= 0 {
+		return fmt.Errorf("r is out of range")
+	}
+	if s.Cmp(secp256k1N) >= 0 {
+		return fmt.Errorf("s is out of range")
+	}
+	if v != 0 && v != 1 && v != 27 && v != 28 {
+		return fmt.Errorf("v is out of range: %v", v)
+	}
+	return nil
+}
+
+// paymentMessage returns the message signed by client. Daemon id is added to
+// the end of the message only when payment is bound to the daemon to keep
+// messages of unbound payments unchanged.
+func paymentMessage(payment *Payment, encoding SignatureEncoding) ([]byte, error) {
+	numbers, err := encoding.encodePaymentNumbers(payment)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([][]byte{
+		[]byte(PrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+	}, numbers...)
+	if payment.DaemonId != "" {
+		parts = append(parts, []byte(payment.DaemonId))
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+// checkRequestContent verifies that request signature is made by payment
+// signer over the hash of the request received by daemon
+func checkRequestContent(payment *Payment, paymentSigner *common.Address, encoding SignatureEncoding) error {
+	if len(payment.RequestHash) == 0 {
+		return NewPaymentError(Internal, "request content hash is not calculated")
+	}
+	if len(payment.RequestSignature) == 0 {
+		return NewPaymentError(RequestContentMismatch, "request content signature is missing")
+	}
+
+	message, err := requestContentMessage(payment, encoding)
+	if err != nil {
+		return NewPaymentError(Unauthenticated, "payment is not valid: %v", err)
+	}
+	signer, err := authutils.GetSignerAddressFromMessage(message, payment.RequestSignature)
+	if err != nil || *signer != *paymentSigner {
+		return NewPaymentError(RequestContentMismatch, "request content doesn't match the one signed by client")
+	}
+	return nil
+}
+
+// requestContentMessage returns the message which client signs to bind the
+// payment to the request content
+func requestContentMessage(payment *Payment, encoding SignatureEncoding) ([]byte, error) {
+	numbers, err := encoding.encodePaymentNumbers(payment)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([][]byte{
+		[]byte(RequestContentPrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+	}, numbers...)
+	return bytes.Join(append(parts, payment.RequestHash), nil), nil
+}
 
 func bigIntToBytes(value *big.Int) []byte {
 	return common.BigToHash(value).Bytes()
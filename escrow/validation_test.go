@@ -2,19 +2,31 @@ package escrow
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"github.com/singnet/snet-daemon/config"
+	"io/ioutil"
 	"math/big"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/singnet/snet-daemon/authutils"
 	"github.com/singnet/snet-daemon/blockchain"
+	"github.com/singnet/snet-daemon/featureflag"
+	"github.com/singnet/snet-daemon/handler"
+	"github.com/singnet/snet-daemon/metrics"
 )
 
 
@@ -27,10 +39,26 @@ func SignTestPayment(payment *Payment, privateKey *ecdsa.PrivateKey) {
 		bigIntToBytes(payment.ChannelNonce),
 		bigIntToBytes(payment.Amount),
 	}, nil)
+	if payment.DaemonId != "" {
+		message = append(message, []byte(payment.DaemonId)...)
+	}
 
 	payment.Signature = getSignature(message, privateKey)
 }
 
+func SignTestRequestContent(payment *Payment, request []byte, privateKey *ecdsa.PrivateKey) {
+	message := bytes.Join([][]byte{
+		[]byte(RequestContentPrefixInSignature),
+		payment.MpeContractAddress.Bytes(),
+		bigIntToBytes(payment.ChannelID),
+		bigIntToBytes(payment.ChannelNonce),
+		bigIntToBytes(payment.Amount),
+		crypto.Keccak256(request),
+	}, nil)
+
+	payment.RequestSignature = getSignature(message, privateKey)
+}
+
 func SignFreeTestPayment(payment *FreeCallPayment, privateKey *ecdsa.PrivateKey) {
 	message := bytes.Join([][]byte{
 		[]byte(FreeCallPrefixSignature),
@@ -95,11 +123,12 @@ func (suite *ValidationTestSuite) SetupSuite() {
 	suite.mpeContractAddress = blockchain.HexToAddress("0xf25186b5081ff5ce73482ad761db0eb0d25abfbf")
 
 	suite.validator = ChannelPaymentValidator{
-		currentBlock:               func() (*big.Int, error) { return big.NewInt(99), nil },
+		blockProvider:              &FixedBlockProvider{Block: big.NewInt(99)},
 		paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) },
 	}
 	suite.freeCallPaymentValidator = FreeCallPaymentValidator{freeCallSigner:suite.signerAddress,
-		currentBlock:func() (*big.Int, error) { return big.NewInt(8308168), nil }}
+		currentBlock:func() (*big.Int, error) { return big.NewInt(8308168), nil },
+		allowedBlockSkew: authutils.AllowedBlockChainDifference}
 }
 
 func (suite *ValidationTestSuite) FreeCallPayment() *FreeCallPayment {
@@ -114,6 +143,13 @@ func (suite *ValidationTestSuite) FreeCallPayment() *FreeCallPayment {
 }
 
 
+func (suite *ValidationTestSuite) freeCallPaymentAtBlock(block int64) *FreeCallPayment {
+	payment := suite.FreeCallPayment()
+	payment.CurrentBlockNumber = big.NewInt(block)
+	SignFreeTestPayment(payment, suite.signerPrivateKey)
+	return payment
+}
+
 func (suite *ValidationTestSuite) payment() *Payment {
 	payment := &Payment{
 		Amount:             big.NewInt(12345),
@@ -148,6 +184,28 @@ func (suite *ValidationTestSuite) TestFreeCallPaymentIsValid(){
 	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
 }
 
+func (suite *ValidationTestSuite) TestFreeCallPaymentInsideBlockSkew() {
+	validator := suite.freeCallPaymentValidator
+	validator.allowedBlockSkew = 10
+
+	errBehind := validator.Validate(suite.freeCallPaymentAtBlock(8308158))
+	errAhead := validator.Validate(suite.freeCallPaymentAtBlock(8308178))
+
+	assert.Nil(suite.T(), errBehind, "Unexpected error: %v", errBehind)
+	assert.Nil(suite.T(), errAhead, "Unexpected error: %v", errAhead)
+}
+
+func (suite *ValidationTestSuite) TestFreeCallPaymentOutsideBlockSkew() {
+	validator := suite.freeCallPaymentValidator
+	validator.allowedBlockSkew = 10
+
+	errBehind := validator.Validate(suite.freeCallPaymentAtBlock(8308157))
+	errAhead := validator.Validate(suite.freeCallPaymentAtBlock(8308179))
+
+	assert.Equal(suite.T(), fmt.Errorf("authentication failed as the signature passed has expired"), errBehind)
+	assert.Equal(suite.T(), fmt.Errorf("authentication failed as the signature passed has expired"), errAhead)
+}
+
 func (suite *ValidationTestSuite) TestPaymentIsValid() {
 	payment := suite.payment()
 	channel := suite.channel()
@@ -198,7 +256,7 @@ func (suite *ValidationTestSuite) TestValidatePaymentIncorrectSigner() {
 
 func (suite *ValidationTestSuite) TestValidatePaymentChannelCannotGetCurrentBlock() {
 	validator := &ChannelPaymentValidator{
-		currentBlock: func() (*big.Int, error) { return nil, errors.New("blockchain error") },
+		blockProvider: &FixedBlockProvider{Err: errors.New("blockchain error")},
 	}
 
 	err := validator.Validate(suite.payment(), suite.channel())
@@ -208,7 +266,7 @@ func (suite *ValidationTestSuite) TestValidatePaymentChannelCannotGetCurrentBloc
 
 func (suite *ValidationTestSuite) TestValidatePaymentExpiredChannel() {
 	validator := &ChannelPaymentValidator{
-		currentBlock:               func() (*big.Int, error) { return big.NewInt(99), nil },
+		blockProvider:              &FixedBlockProvider{Block: big.NewInt(99)},
 		paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) },
 	}
 	channel := suite.channel()
@@ -216,12 +274,12 @@ func (suite *ValidationTestSuite) TestValidatePaymentExpiredChannel() {
 
 	err := validator.Validate(suite.payment(), channel)
 
-	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 99, current block: 99, expiration threshold: 0"), err)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 99, current block: 99, expiration threshold: 0 (group default)"), err)
 }
 
 func (suite *ValidationTestSuite) TestValidatePaymentChannelExpirationThreshold() {
 	validator := &ChannelPaymentValidator{
-		currentBlock:               func() (*big.Int, error) { return big.NewInt(98), nil },
+		blockProvider:              &FixedBlockProvider{Block: big.NewInt(98)},
 		paymentExpirationThreshold: func() *big.Int { return big.NewInt(1) },
 	}
 	channel := suite.channel()
@@ -229,7 +287,78 @@ func (suite *ValidationTestSuite) TestValidatePaymentChannelExpirationThreshold(
 
 	err := validator.Validate(suite.payment(), channel)
 
-	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 99, current block: 98, expiration threshold: 1"), err)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 99, current block: 98, expiration threshold: 1 (group default)"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentGroupExpirationThreshold() {
+	validator := &ChannelPaymentValidator{
+		blockProvider:              &FixedBlockProvider{Block: big.NewInt(90)},
+		paymentExpirationThreshold: func() *big.Int { return big.NewInt(1) },
+		groupExpirationThreshold: func(groupID [32]byte) (*big.Int, bool) {
+			return big.NewInt(10), groupID == [32]byte{123}
+		},
+	}
+	channel := suite.channel()
+	channel.Expiration = big.NewInt(99)
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 99, current block: 90, expiration threshold: 10 (group ewAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=)"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentGroupExpirationThresholdFallback() {
+	validator := &ChannelPaymentValidator{
+		blockProvider:              &FixedBlockProvider{Block: big.NewInt(90)},
+		paymentExpirationThreshold: func() *big.Int { return big.NewInt(1) },
+		groupExpirationThreshold: func(groupID [32]byte) (*big.Int, bool) {
+			return big.NewInt(10), groupID == [32]byte{124}
+		},
+	}
+	channel := suite.channel()
+	channel.Expiration = big.NewInt(99)
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestParseGroupExpirationThresholds() {
+	thresholds, err := ParseGroupExpirationThresholds([]GroupExpirationThreshold{
+		{GroupID: "ewAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", Threshold: 10},
+	})
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), map[[32]byte]*big.Int{{123}: big.NewInt(10)}, thresholds)
+}
+
+func (suite *ValidationTestSuite) TestParseGroupExpirationThresholdsIncorrectGroupId() {
+	_, err := ParseGroupExpirationThresholds([]GroupExpirationThreshold{
+		{GroupID: "ewAA", Threshold: 10},
+	})
+
+	assert.Equal(suite.T(), errors.New("incorrect group id of payment expiration threshold: \"ewAA\""), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentChannelMaxRemainingLifetime() {
+	validator := suite.validator
+	validator.maxRemainingLifetime = big.NewInt(1)
+	channel := suite.channel()
+	channel.Expiration = big.NewInt(100)
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentChannelExceedsMaxRemainingLifetime() {
+	validator := suite.validator
+	validator.maxRemainingLifetime = big.NewInt(1)
+	channel := suite.channel()
+	channel.Expiration = big.NewInt(101)
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel expiration time is too far, expiration time: 101, current block: 99, maximum remaining lifetime: 1"), err)
 }
 
 func (suite *ValidationTestSuite) TestValidatePaymentAmountIsTooBig() {
@@ -241,7 +370,451 @@ func (suite *ValidationTestSuite) TestValidatePaymentAmountIsTooBig() {
 
 	err := suite.validator.Validate(payment, suite.channel())
 
-	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345, payment amount: 12346"), err)
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12346 (0.00012346 AGIX)"), err)
+}
+
+func (suite *ValidationTestSuite) validatorWithGraceAmount(grace int64, onChainValue int64) ChannelPaymentValidator {
+	validator := suite.validator
+	validator.graceAmount = big.NewInt(grace)
+	validator.onChainChannel = func(channelID *big.Int) (*blockchain.MultiPartyEscrowChannel, bool, error) {
+		return &blockchain.MultiPartyEscrowChannel{Value: big.NewInt(onChainValue)}, true, nil
+	}
+	return validator
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentAmountWithinGrace() {
+	validator := suite.validatorWithGraceAmount(10, 20000)
+
+	err := validator.Validate(suite.paymentWithAmount(12355), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentAmountBeyondGrace() {
+	validator := suite.validatorWithGraceAmount(10, 20000)
+
+	err := validator.Validate(suite.paymentWithAmount(12356), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12356 (0.00012356 AGIX)"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentAmountWithinGraceOnChainValueIsNotEnough() {
+	validator := suite.validatorWithGraceAmount(10, 12354)
+
+	err := validator.Validate(suite.paymentWithAmount(12355), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12355 (0.00012355 AGIX)"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentAmountWithinGracePendingClaimIsNotCovered() {
+	validator := suite.validatorWithGraceAmount(10, 20000)
+	validator.pendingClaim = func(channelID *big.Int, nonce *big.Int) (*Payment, bool, error) {
+		return &Payment{ChannelID: channelID, ChannelNonce: nonce, Amount: big.NewInt(7646)}, true, nil
+	}
+
+	err := validator.Validate(suite.paymentWithAmount(12355), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12355 (0.00012355 AGIX)"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentAmountWithinGraceChannelIsNotFound() {
+	validator := suite.validatorWithGraceAmount(10, 20000)
+	validator.onChainChannel = func(channelID *big.Int) (*blockchain.MultiPartyEscrowChannel, bool, error) {
+		return nil, false, nil
+	}
+
+	err := validator.Validate(suite.paymentWithAmount(12346), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 12346 (0.00012346 AGIX)"), err)
+}
+
+func (suite *ValidationTestSuite) validateSignatureFormat(patch func(signature []byte)) error {
+	validator := suite.validator
+	validator.checkSignatureFormat = true
+	payment := suite.payment()
+	patch(payment.Signature)
+
+	return validator.Validate(payment, suite.channel())
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureZeroR() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		copy(signature[0:32], make([]byte, 32))
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: r is zero"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureZeroS() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		copy(signature[32:64], make([]byte, 32))
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: s is zero"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureAllZeros() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		copy(signature, make([]byte, 65))
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: r is zero"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureROutOfRange() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		copy(signature[0:32], crypto.S256().Params().N.Bytes())
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: r is out of range"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureSOutOfRange() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		copy(signature[32:64], bytes.Repeat([]byte{0xFF}, 32))
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: s is out of range"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureVOutOfRange() {
+	err := suite.validateSignatureFormat(func(signature []byte) {
+		signature[64] = 2
+	})
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: v is out of range: 2"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureFormatIsCorrect() {
+	err := suite.validateSignatureFormat(func(signature []byte) {})
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+// maxUint256ChannelId is the widest channel id supported by MPE v1
+var maxUint256ChannelId = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+func (suite *ValidationTestSuite) TestValidatePayment256BitChannelId() {
+	payment := suite.payment()
+	payment.ChannelID = maxUint256ChannelId
+	SignTestPayment(payment, suite.signerPrivateKey)
+	channel := suite.channel()
+	channel.ChannelID = maxUint256ChannelId
+
+	err := suite.validator.Validate(payment, channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentChannelIdWiderThanEncoding() {
+	payment := suite.payment()
+	payment.ChannelID = new(big.Int).Lsh(big.NewInt(1), 256)
+
+	err := suite.validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not supported by MPE protocol v1: channel id 115792089237316195423570985008687907853269984665640564039457584007913129639936 doesn't fit into 32 bytes"), err)
+}
+
+func writeFeatureFlagsFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "feature-flags")
+	if err != nil {
+		t.Fatalf("Cannot create feature flags file: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatalf("Cannot write feature flags file: %v", err)
+	}
+	return file.Name()
+}
+
+func (suite *ValidationTestSuite) validateSignatureFormatWithFlags(group string) error {
+	path := writeFeatureFlagsFile(suite.T(), `{"signature_format_check": {"enabled": true, "groups": {"disabled_group": false}}}`)
+	defer os.Remove(path)
+	validator := suite.validator
+	validator.checkSignatureFormat = true
+	validator.flags = featureflag.NewFileFlags(path, group, time.Minute)
+	payment := suite.payment()
+	copy(payment.Signature[0:32], make([]byte, 32))
+
+	return validator.Validate(payment, suite.channel())
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureFormatCheckDisabledForGroup() {
+	err := suite.validateSignatureFormatWithFlags("disabled_group")
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSignatureFormatCheckEnabledForGroup() {
+	err := suite.validateSignatureFormatWithFlags("default_group")
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment signature is not valid: r is zero"), err)
+}
+
+func (suite *ValidationTestSuite) TestExpiryWarningFarFromExpiration() {
+	validator := suite.validator
+	validator.expiryWarningBlocks = big.NewInt(5)
+
+	warning := validator.expiryWarning(suite.channel(), big.NewInt(90))
+
+	assert.Nil(suite.T(), warning)
+}
+
+func (suite *ValidationTestSuite) TestExpiryWarningNearExpiration() {
+	validator := suite.validator
+	validator.expiryWarningBlocks = big.NewInt(5)
+
+	warning := validator.expiryWarning(suite.channel(), big.NewInt(95))
+
+	assert.Equal(suite.T(), metadata.Pairs(
+		handler.PaymentChannelExpirationTrailer, "100",
+		handler.PaymentChannelRemainingBlocksTrailer, "5",
+	), warning.Trailer)
+}
+
+func (suite *ValidationTestSuite) TestExpiryWarningWithEstimatedExpirationTime() {
+	validator := suite.validator
+	validator.expiryWarningBlocks = big.NewInt(5)
+	validator.averageBlockTime = 15 * time.Second
+	validator.now = func() time.Time { return time.Unix(1000000, 0) }
+
+	warning := validator.expiryWarning(suite.channel(), big.NewInt(96))
+
+	assert.Equal(suite.T(), "payment channel will be expired in 4 blocks, expiration time: 100, estimated expiration time: 1970-01-12T13:47:40Z", warning.Message)
+	assert.Equal(suite.T(), []string{"1970-01-12T13:47:40Z"}, warning.Trailer.Get(handler.PaymentChannelExpirationTimeTrailer))
+}
+
+func (suite *ValidationTestSuite) TestValidateWithWarningsNearExpiration() {
+	validator := suite.validator
+	validator.blockProvider = &FixedBlockProvider{Block: big.NewInt(95)}
+	validator.expiryWarningBlocks = big.NewInt(5)
+
+	result, err := validator.ValidateWithWarnings(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), []ValidationWarning{{
+		Message: "payment channel will be expired in 5 blocks, expiration time: 100",
+		Trailer: metadata.Pairs(
+			handler.PaymentChannelExpirationTrailer, "100",
+			handler.PaymentChannelRemainingBlocksTrailer, "5",
+		),
+	}}, result.Warnings)
+}
+
+func (suite *ValidationTestSuite) TestValidateWithWarningsNoWarnings() {
+	result, err := suite.validator.ValidateWithWarnings(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Empty(suite.T(), result.Warnings)
+	assert.Nil(suite.T(), result.Trailer())
+}
+
+type sanctionsListMock struct {
+	addresses map[common.Address]bool
+}
+
+func (list *sanctionsListMock) IsSanctioned(address common.Address) (bool, error) {
+	return list.addresses[address], nil
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentSanctionedSender() {
+	validator := suite.validator
+	validator.sanctionsList = &sanctionsListMock{addresses: map[common.Address]bool{suite.senderAddress: true}}
+
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(PermissionDenied, "payments from address %v are not accepted", blockchain.AddressToHex(&suite.senderAddress)), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentUnflaggedSender() {
+	validator := suite.validator
+	validator.sanctionsList = &sanctionsListMock{addresses: map[common.Address]bool{suite.recipientAddress: true}}
+
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentChannelOfAnotherMpe() {
+	validator := suite.validator
+	validator.checkMpeContractAddress = true
+	channel := suite.channel()
+	channel.MpeContractAddress = blockchain.HexToAddress("0x5e592F9b1d303183d963635f895f0f0C48284f4e")
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel belongs to another MPE contract, channel MPE: 0x5e592F9b1d303183d963635f895f0f0C48284f4e, payment MPE: 0xf25186B5081Ff5cE73482AD761DB0eB0d25abfBF"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentChannelOfSameMpe() {
+	validator := suite.validator
+	validator.checkMpeContractAddress = true
+	channel := suite.channel()
+	channel.MpeContractAddress = suite.mpeContractAddress
+
+	err := validator.Validate(suite.payment(), channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidateContextCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := suite.validator.ValidateContext(ctx, suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(DeadlineExceeded, "payment validation is stopped: context canceled"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidateContextSlowCurrentBlock() {
+	validator := suite.validator
+	ctx, cancel := context.WithCancel(context.Background())
+	validator.blockProvider = BlockProviderFunc(func() (*big.Int, error) {
+		cancel()
+		return big.NewInt(99), nil
+	})
+
+	err := validator.ValidateContext(ctx, suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(DeadlineExceeded, "payment validation is stopped: context canceled"), err)
+}
+
+type contextBlockProviderMock struct {
+	FixedBlockProvider
+	ctx context.Context
+}
+
+func (provider *contextBlockProviderMock) CurrentBlockContext(ctx context.Context) (*big.Int, error) {
+	provider.ctx = ctx
+	return provider.CurrentBlock()
+}
+
+func (suite *ValidationTestSuite) TestValidateContextPassesContextToBlockProvider() {
+	validator := suite.validator
+	provider := &contextBlockProviderMock{FixedBlockProvider: FixedBlockProvider{Block: big.NewInt(99)}}
+	validator.blockProvider = provider
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := validator.ValidateContext(ctx, suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), ctx, provider.ctx)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentForUnknownMpe() {
+	validator := suite.validator
+	validator.mpeContractAddress = suite.mpeContractAddress
+	payment := suite.payment()
+	payment.MpeContractAddress = blockchain.HexToAddress("0x5e592F9b1d303183d963635f895f0f0C48284f4e")
+	SignTestPayment(payment, suite.signerPrivateKey)
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "unknown MPE contract address"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentForNetworkMpe() {
+	validator := suite.validator
+	validator.mpeContractAddress = suite.mpeContractAddress
+
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentBoundToDaemon() {
+	payment := suite.payment()
+	payment.DaemonId = "daemon-a"
+	SignTestPayment(payment, suite.signerPrivateKey)
+	validator := suite.validator
+	validator.daemonId = "daemon-a"
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentBoundToAnotherDaemon() {
+	payment := suite.payment()
+	payment.DaemonId = "daemon-a"
+	SignTestPayment(payment, suite.signerPrivateKey)
+	validator := suite.validator
+	validator.daemonId = "daemon-b"
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is bound to another daemon, expected daemon id: daemon-b, payment daemon id: daemon-a"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentDaemonIdIsSigned() {
+	payment := suite.payment()
+	payment.DaemonId = "daemon-a"
+	SignTestPayment(payment, suite.signerPrivateKey)
+	payment.DaemonId = "daemon-b"
+	validator := suite.validator
+	validator.daemonId = "daemon-b"
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRequestContentMatches() {
+	payment := suite.payment()
+	SignTestRequestContent(payment, []byte("request"), suite.signerPrivateKey)
+	payment.RequestHash = crypto.Keccak256([]byte("request"))
+	validator := suite.validator
+	validator.checkRequestContent = true
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRequestContentTampered() {
+	payment := suite.payment()
+	SignTestRequestContent(payment, []byte("request"), suite.signerPrivateKey)
+	payment.RequestHash = crypto.Keccak256([]byte("tampered request"))
+	validator := suite.validator
+	validator.checkRequestContent = true
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(RequestContentMismatch, "request content doesn't match the one signed by client"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRequestContentSignatureMissing() {
+	payment := suite.payment()
+	payment.RequestHash = crypto.Keccak256([]byte("request"))
+	validator := suite.validator
+	validator.checkRequestContent = true
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(RequestContentMismatch, "request content signature is missing"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentUsesCachedCurrentBlock() {
+	liveBlock := big.NewInt(99)
+	cache := blockchain.NewCurrentBlockCache(
+		func() (*big.Int, error) { return liveBlock, nil },
+		time.Hour, 50*time.Millisecond)
+	assert.Nil(suite.T(), cache.Refresh())
+	validator := &ChannelPaymentValidator{
+		blockProvider:              cache,
+		paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) },
+	}
+	liveBlock = big.NewInt(100)
+
+	err := validator.Validate(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+
+	time.Sleep(100 * time.Millisecond)
+	err = validator.Validate(suite.payment(), suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: 100, current block: 100, expiration threshold: 0 (group default)"), err)
 }
 
 func (suite *ValidationTestSuite) TestGetPublicKeyFromPayment() {
@@ -272,3 +845,245 @@ func (suite *ValidationTestSuite) TestGetPublicKeyFromPayment2() {
 	assert.Nil(suite.T(), err)
 	assert.Equal(suite.T(), blockchain.HexToAddress("0x6b1E951a2F9dE2480C613C1dCDDee4DD4CaE1e4e"), *address)
 }
+
+func (suite *ValidationTestSuite) paymentWithAmount(amount int64) *Payment {
+	payment := suite.payment()
+	payment.Amount = big.NewInt(amount)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	return payment
+}
+
+func (suite *ValidationTestSuite) TestValidateBatchReadsBlockOnce() {
+	blockCalls, thresholdCalls := 0, 0
+	validator := suite.validator
+	validator.blockProvider = BlockProviderFunc(func() (*big.Int, error) {
+		blockCalls++
+		return big.NewInt(99), nil
+	})
+	validator.paymentExpirationThreshold = func() *big.Int {
+		thresholdCalls++
+		return big.NewInt(0)
+	}
+
+	errs := validator.ValidateBatch([]*Payment{suite.paymentWithAmount(100), suite.paymentWithAmount(200), suite.paymentWithAmount(200)}, suite.channel())
+
+	assert.Equal(suite.T(), []error{nil, nil, nil}, errs)
+	assert.Equal(suite.T(), 1, blockCalls)
+	assert.Equal(suite.T(), 1, thresholdCalls)
+}
+
+func (suite *ValidationTestSuite) TestValidateBatchInvalidPaymentInTheMiddle() {
+	invalid := suite.paymentWithAmount(300)
+	invalid.Signature = blockchain.HexToBytes("0xa4d2ae6f3edd1f7fe77e4f6f78ba18d62e6093bcae01ef86d5de902d33662fa372011287ea2d8d8436d9db8a366f43480678df25453b484c67f80941ef2c05ef01")
+
+	errs := suite.validator.ValidateBatch([]*Payment{suite.paymentWithAmount(100), invalid, suite.paymentWithAmount(200), suite.paymentWithAmount(150), suite.paymentWithAmount(20000)}, suite.channel())
+
+	assert.Equal(suite.T(), []error{
+		nil,
+		NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"),
+		nil,
+		NewPaymentError(Unauthenticated, "payment amount 150 is less than amount of the previous payment 200"),
+		NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: 12345 (0.00012345 AGIX), payment amount: 20000 (0.0002 AGIX)"),
+	}, errs)
+}
+
+func (suite *ValidationTestSuite) TestValidateBatchCurrentBlockError() {
+	validator := suite.validator
+	validator.blockProvider = &FixedBlockProvider{Err: errors.New("blockchain error")}
+	invalid := suite.paymentWithAmount(100)
+	invalid.ChannelNonce = big.NewInt(2)
+
+	errs := validator.ValidateBatch([]*Payment{invalid, suite.paymentWithAmount(200)}, suite.channel())
+
+	assert.Equal(suite.T(), []error{
+		NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: 3, sent: 2"),
+		NewPaymentError(Internal, "cannot determine current block"),
+	}, errs)
+}
+
+// counterValues returns values of the counters gathered from the registry by
+// metric name and labels
+func counterValues(registry *prometheus.Registry) (values map[string]float64) {
+	values = make(map[string]float64)
+	families, _ := registry.Gather()
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				name += "/" + label.GetValue()
+			}
+			if metric.GetCounter() != nil {
+				values[name] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentMetrics() {
+	registry := prometheus.NewRegistry()
+	validationMetrics, err := metrics.NewPaymentValidationMetrics(registry, "test")
+	assert.Nil(suite.T(), err)
+	validator := suite.validator
+	validator.validationMetrics = validationMetrics
+	expired := suite.channel()
+	expired.Expiration = big.NewInt(99)
+
+	errA := validator.Validate(suite.payment(), suite.channel())
+	errB := validator.Validate(suite.payment(), expired)
+	errs := validator.ValidateBatch([]*Payment{suite.paymentWithAmount(100), suite.paymentWithAmount(90)}, suite.channel())
+
+	assert.Nil(suite.T(), errA, "Unexpected error: %v", errA)
+	assert.NotNil(suite.T(), errB)
+	assert.Nil(suite.T(), errs[0], "Unexpected error: %v", errs[0])
+	assert.NotNil(suite.T(), errs[1])
+	assert.Equal(suite.T(), map[string]float64{
+		"test_payment_validation_successes_total":                2,
+		"test_payment_validation_failures_total/Unauthenticated": 2,
+	}, counterValues(registry))
+}
+
+func (suite *ValidationTestSuite) TestPaymentErrorCodeString() {
+	assert.Equal(suite.T(), "Unauthenticated", Unauthenticated.String())
+	assert.Equal(suite.T(), "ChannelClosed", ChannelClosed.String())
+	assert.Equal(suite.T(), "PaymentErrorCode(100)", PaymentErrorCode(100).String())
+}
+
+func (suite *ValidationTestSuite) TestPaymentErrorMessage() {
+	assert.Equal(suite.T(), "InvalidArgument: payment metadata is malformed", NewPaymentError(InvalidArgument, "payment metadata is malformed").Error())
+	assert.Equal(suite.T(), "ResourceExhausted: rate limit exceeded", NewPaymentError(ResourceExhausted, "rate limit exceeded").Error())
+	assert.Equal(suite.T(), "PaymentErrorCode(100): unknown", NewPaymentError(PaymentErrorCode(100), "unknown").Error())
+}
+
+func (suite *ValidationTestSuite) TestPaymentErrorGRPCStatus() {
+	assert.Equal(suite.T(), status.New(codes.Unauthenticated, "payment signature is not valid"), NewPaymentError(Unauthenticated, "payment signature is not valid").GRPCStatus())
+	assert.Equal(suite.T(), status.New(codes.FailedPrecondition, "channel is closed"), NewPaymentError(ChannelClosed, "channel is closed").GRPCStatus())
+	assert.Equal(suite.T(), handler.IncorrectNonce, status.Code(NewPaymentError(IncorrectNonce, "incorrect nonce")))
+	assert.Equal(suite.T(), codes.Unavailable, status.Code(NewPaymentError(Unavailable, "daemon shutting down")))
+	assert.Equal(suite.T(), codes.DeadlineExceeded, status.Code(NewPaymentError(DeadlineExceeded, "payment validation is stopped")))
+	assert.Equal(suite.T(), codes.Internal, status.Code(NewPaymentError(PaymentErrorCode(100), "unknown")))
+}
+
+func (suite *ValidationTestSuite) TestValidateWithWarningsCallsExpiryWarningHook() {
+	validator := suite.validator
+	validator.blockProvider = &FixedBlockProvider{Block: big.NewInt(95)}
+	validator.paymentExpirationThreshold = func() *big.Int { return big.NewInt(2) }
+	validator.expiryWarningBlocks = big.NewInt(5)
+	var hookChannel *PaymentChannelData
+	var hookRemainingBlocks *big.Int
+	validator.expiryWarningHook = func(channel *PaymentChannelData, remainingBlocks *big.Int) {
+		hookChannel = channel
+		hookRemainingBlocks = remainingBlocks
+	}
+	channel := suite.channel()
+
+	result, err := validator.ValidateWithWarnings(suite.payment(), channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 1, len(result.Warnings))
+	assert.Equal(suite.T(), channel, hookChannel)
+	assert.Equal(suite.T(), big.NewInt(5), hookRemainingBlocks)
+}
+
+func (suite *ValidationTestSuite) TestValidateWithWarningsDoesNotCallExpiryWarningHookFarFromExpiration() {
+	validator := suite.validator
+	validator.blockProvider = &FixedBlockProvider{Block: big.NewInt(90)}
+	validator.expiryWarningBlocks = big.NewInt(5)
+	called := false
+	validator.expiryWarningHook = func(channel *PaymentChannelData, remainingBlocks *big.Int) {
+		called = true
+	}
+
+	_, err := validator.ValidateWithWarnings(suite.payment(), suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.False(suite.T(), called)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRefreshesChannelWhenNonceIsAhead() {
+	validator := suite.validator
+	refreshed := suite.channel()
+	refreshed.Nonce = big.NewInt(4)
+	refreshed.AuthorizedAmount = big.NewInt(0)
+	validator.refreshChannel = func(channelID *big.Int) (*PaymentChannelData, error) {
+		return refreshed, nil
+	}
+	payment := suite.payment()
+	payment.ChannelNonce = big.NewInt(4)
+	SignTestPayment(payment, suite.signerPrivateKey)
+	channel := suite.channel()
+
+	err := validator.Validate(payment, channel)
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), refreshed, channel)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRefreshedChannelNonceDoesNotMatch() {
+	validator := suite.validator
+	validator.refreshChannel = func(channelID *big.Int) (*PaymentChannelData, error) {
+		return suite.channel(), nil
+	}
+	payment := suite.payment()
+	payment.ChannelNonce = big.NewInt(4)
+	SignTestPayment(payment, suite.signerPrivateKey)
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: 3, sent: 4"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentRefreshChannelError() {
+	validator := suite.validator
+	validator.refreshChannel = func(channelID *big.Int) (*PaymentChannelData, error) {
+		return nil, errors.New("blockchain is not available")
+	}
+	payment := suite.payment()
+	payment.ChannelNonce = big.NewInt(4)
+	SignTestPayment(payment, suite.signerPrivateKey)
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Internal, "cannot refresh payment channel state"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentOfAnotherSignerDoesNotRefreshChannel() {
+	validator := suite.validator
+	called := false
+	validator.refreshChannel = func(channelID *big.Int) (*PaymentChannelData, error) {
+		called = true
+		return suite.channel(), nil
+	}
+	payment := suite.payment()
+	payment.ChannelNonce = big.NewInt(4)
+	SignTestPayment(payment, GenerateTestPrivateKey())
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender"), err)
+	assert.False(suite.T(), called)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentIncrementBelowMinimum() {
+	validator := suite.validator
+	validator.minPaymentIncrement = big.NewInt(2)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(12301)
+	SignTestPayment(payment, suite.signerPrivateKey)
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Equal(suite.T(), NewPaymentError(InvalidArgument, "payment increment below minimum"), err)
+}
+
+func (suite *ValidationTestSuite) TestValidatePaymentIncrementAboveMinimum() {
+	validator := suite.validator
+	validator.minPaymentIncrement = big.NewInt(1)
+	payment := suite.payment()
+	payment.Amount = big.NewInt(12301)
+	SignTestPayment(payment, suite.signerPrivateKey)
+
+	err := validator.Validate(payment, suite.channel())
+
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+}
@@ -0,0 +1,92 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const windowCounterMaxAttempts = 10
+
+// windowCounter sums values added within fixed time windows. Counter is kept
+// in the storage as "<window>:<sum>" and it is updated using compare and
+// swap, so it can be shared between daemon replicas.
+type windowCounter struct {
+	storage AtomicStorage
+	window  time.Duration
+	now     func() time.Time
+}
+
+func newWindowCounter(storage AtomicStorage, window time.Duration) *windowCounter {
+	return &windowCounter{
+		storage: storage,
+		window:  window,
+		now:     time.Now,
+	}
+}
+
+// Add increments counter of the key by value if the sum in the current
+// window doesn't exceed limit, returns false if the limit would be exceeded.
+func (counter *windowCounter) Add(key string, value *big.Int, limit *big.Int) (added bool, err error) {
+	window := counter.now().UnixNano() / int64(counter.window)
+
+	for attempt := 0; attempt < windowCounterMaxAttempts; attempt++ {
+		stored, ok, err := counter.storage.Get(key)
+		if err != nil {
+			return false, err
+		}
+
+		sum := big.NewInt(0)
+		if ok {
+			counterWindow, counterSum, e := parseWindowCounter(stored)
+			if e != nil {
+				log.WithError(e).WithField("value", stored).Warn("Incorrect window counter in storage, reset it")
+			} else if counterWindow == window {
+				sum = counterSum
+			}
+		}
+
+		sum.Add(sum, value)
+		if sum.Cmp(limit) > 0 {
+			return false, nil
+		}
+
+		if !ok {
+			ok, err = counter.storage.PutIfAbsent(key, formatWindowCounter(window, sum))
+		} else {
+			ok, err = counter.storage.CompareAndSwap(key, stored, formatWindowCounter(window, sum))
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+		log.WithField("key", key).Debug("Window counter is updated concurrently, retry")
+	}
+
+	return false, fmt.Errorf("cannot update window counter %v", key)
+}
+
+func formatWindowCounter(window int64, sum *big.Int) string {
+	return fmt.Sprintf("%v:%v", window, sum)
+}
+
+func parseWindowCounter(value string) (window int64, sum *big.Int, err error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, nil, fmt.Errorf("unexpected counter format: %v", value)
+	}
+	if window, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return
+	}
+	sum, ok := new(big.Int).SetString(parts[1], 10)
+	if !ok {
+		return 0, nil, fmt.Errorf("unexpected counter value: %v", parts[1])
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package etcddb
+
+import (
+	"sync"
+	"time"
+)
+
+// endpointHealth tracks etcd endpoints which recently failed. Failed
+// endpoint is not used until cooldown is passed, after that it is probed
+// again.
+type endpointHealth struct {
+	mutex     sync.Mutex
+	endpoints []string
+	cooldown  time.Duration
+	failedAt  map[string]time.Time
+	now       func() time.Time
+}
+
+func newEndpointHealth(endpoints []string, cooldown time.Duration) *endpointHealth {
+	return &endpointHealth{
+		endpoints: endpoints,
+		cooldown:  cooldown,
+		failedAt:  make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// markFailed excludes endpoint until cooldown is passed
+func (health *endpointHealth) markFailed(endpoint string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.failedAt[endpoint] = health.now()
+}
+
+// markHealthy returns endpoint back to use
+func (health *endpointHealth) markHealthy(endpoint string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	delete(health.failedAt, endpoint)
+}
+
+// toProbe returns endpoints which are not in cooldown, including failed
+// endpoints which cooldown is passed
+func (health *endpointHealth) toProbe() (endpoints []string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	for _, endpoint := range health.endpoints {
+		if !health.inCooldown(endpoint) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return
+}
+
+// reprobeRequired returns true if cooldown of any failed endpoint is passed
+func (health *endpointHealth) reprobeRequired() bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	for endpoint := range health.failedAt {
+		if !health.inCooldown(endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// available returns endpoints which didn't fail recently. If all endpoints
+// failed all of them are returned, so client can still try to reach the
+// cluster.
+func (health *endpointHealth) available() (endpoints []string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	for _, endpoint := range health.endpoints {
+		if _, failed := health.failedAt[endpoint]; !failed {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return health.endpoints
+	}
+	return
+}
+
+func (health *endpointHealth) inCooldown(endpoint string) bool {
+	failedAt, failed := health.failedAt[endpoint]
+	return failed && health.now().Sub(failedAt) < health.cooldown
+}
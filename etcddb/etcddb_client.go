@@ -11,13 +11,20 @@ import (
 	"time"
 
 	"github.com/singnet/snet-daemon/config"
+	"github.com/singnet/snet-daemon/retry"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// watchReconnectDelay is a delay before watch is restarted after disconnect
+const watchReconnectDelay = time.Second
+
 // EtcdClientMutex mutex struct for etcd client
 type EtcdClientMutex struct {
 	mutex *concurrency.Mutex
@@ -38,6 +45,28 @@ type EtcdClient struct {
 	timeout time.Duration
 	session *concurrency.Session
 	etcdv3  *clientv3.Client
+	// readOptions are added to the read requests to set read consistency,
+	// GetLatest reads are always linearizable
+	readOptions []clientv3.OpOption
+	// kv is used for the reads and for the writes which are retried
+	kv clientv3.KV
+	// maxRetries is a number of retries of the write failed because of
+	// transient error
+	maxRetries   int
+	retryBackoff time.Duration
+	sleep        func(time.Duration)
+	// maintenance is used to query status of the endpoints on health check
+	maintenance       clientv3.Maintenance
+	endpoints         []string
+	connectionTimeout time.Duration
+	// health keeps endpoints which recently returned connection errors,
+	// setEndpoints updates endpoints used by the client
+	health       *endpointHealth
+	setEndpoints func(endpoints ...string)
+	// probeRequests wakes up the background endpoint prober after
+	// transient error, requests sent while probe is running are coalesced
+	probeRequests chan struct{}
+	stopProbe     chan struct{}
 }
 
 // NewEtcdClient create new etcd storage client.
@@ -45,6 +74,51 @@ func NewEtcdClient(metaData *blockchain.OrganizationMetaData) (client *EtcdClien
 	return NewEtcdClientFromVip(config.Vip(),metaData)
 }
 
+// EtcdConnectionError is returned when etcd client cannot connect to the
+// cluster. Unlike configuration errors it can be transient, so caller can
+// retry connection.
+type EtcdConnectionError struct {
+	// Endpoints are the etcd endpoints client failed to connect to
+	Endpoints []string
+	// ConnectionTimeout is a timeout of the connection attempt
+	ConnectionTimeout time.Duration
+	// Cause is an underlying error
+	Cause error
+}
+
+func (err *EtcdConnectionError) Error() string {
+	return fmt.Sprintf("cannot connect to etcd endpoints %v within connection timeout %v: %v", err.Endpoints, err.ConnectionTimeout, err.Cause)
+}
+
+// NewEtcdClientWithRetry creates new etcd storage client making up to
+// attempts connection attempts. Delay between attempts starts from delay
+// and is doubled after each attempt. Errors other than EtcdConnectionError
+// are returned immediately.
+func NewEtcdClientWithRetry(metaData *blockchain.OrganizationMetaData, attempts int, delay time.Duration) (client *EtcdClient, err error) {
+	return retryConnection(attempts, delay, time.Sleep, func() (*EtcdClient, error) {
+		return NewEtcdClient(metaData)
+	})
+}
+
+func retryConnection(attempts int, delay time.Duration, sleep func(time.Duration), connect func() (*EtcdClient, error)) (client *EtcdClient, err error) {
+	err = retry.DoWithPolicy(context.Background(), retry.Policy{
+		MaxAttempts: attempts,
+		Delay:       delay,
+		Multiplier:  2,
+		Retryable: func(err error) bool {
+			_, isConnectionError := err.(*EtcdConnectionError)
+			return isConnectionError
+		},
+		Sleep: sleep,
+	}, func() (e error) {
+		if client, e = connect(); e != nil {
+			log.WithError(e).Warn("Unable to connect to etcd")
+		}
+		return
+	})
+	return
+}
+
 // NewEtcdClientFromVip create new etcd storage client from viper.
 func NewEtcdClientFromVip(vip *viper.Viper,metaData *blockchain.OrganizationMetaData) (client *EtcdClient, err error) {
 
@@ -54,6 +128,16 @@ func NewEtcdClientFromVip(vip *viper.Viper,metaData *blockchain.OrganizationMeta
 		return nil,err
 	}
 
+	return NewEtcdClientFromConf(conf)
+}
+
+// NewEtcdClientFromConf creates new etcd storage client using config built
+// without viper, config is validated before connection
+func NewEtcdClientFromConf(conf *EtcdClientConf) (client *EtcdClient, err error) {
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+
 	log.WithField("PaymentChannelStorageClient", fmt.Sprintf("%+v", conf)).Info()
 
 	var etcdv3 *clientv3.Client
@@ -62,42 +146,107 @@ func NewEtcdClientFromVip(vip *viper.Viper,metaData *blockchain.OrganizationMeta
 		return nil,err
 	}
 
-	if checkIfHttps(metaData.GetPaymentStorageEndPoints()) {
-		if tlsConfig,err := getTlsConfig();err == nil {
-			etcdv3, err = clientv3.New(clientv3.Config{
-				Endpoints:   metaData.GetPaymentStorageEndPoints(),
-				DialTimeout: conf.ConnectionTimeout,
-				TLS:         tlsConfig,
-			})
-		}else {
-			return nil,err
-		}
-
-	}else {
-		//Regular http call
-		etcdv3, err = clientv3.New(clientv3.Config{
-			Endpoints:   metaData.GetPaymentStorageEndPoints(),
-			DialTimeout: conf.ConnectionTimeout,
-
-		})
-		if err != nil {
-			return nil,err
+	clientConf := clientv3.Config{
+		Endpoints:   conf.Endpoints,
+		DialTimeout: conf.ConnectionTimeout,
+	}
+	if checkIfHttps(conf.Endpoints) {
+		if clientConf.TLS, err = getTlsConfig(); err != nil {
+			return nil, err
 		}
 	}
 
+	etcdv3, err = clientv3.New(clientConf)
+	if err != nil {
+		return nil, &EtcdConnectionError{Endpoints: conf.Endpoints, ConnectionTimeout: conf.ConnectionTimeout, Cause: err}
+	}
 
 	session, err := concurrency.NewSession(etcdv3)
 	if err != nil {
-		return
+		etcdv3.Close()
+		return nil, &EtcdConnectionError{Endpoints: conf.Endpoints, ConnectionTimeout: conf.ConnectionTimeout, Cause: err}
 	}
 
 	client = &EtcdClient{
-		timeout: conf.RequestTimeout,
-		session: session,
-		etcdv3:  etcdv3,
+		timeout:      conf.RequestTimeout,
+		session:      session,
+		etcdv3:       etcdv3,
+		readOptions:  readOptions(conf.ReadConsistency),
+		kv:           etcdv3.KV,
+		maxRetries:   conf.MaxRetries,
+		retryBackoff: conf.RetryBackoff,
+		sleep:        time.Sleep,
+
+		maintenance:       etcdv3.Maintenance,
+		endpoints:         conf.Endpoints,
+		connectionTimeout: conf.ConnectionTimeout,
+		health:            newEndpointHealth(conf.Endpoints, conf.EndpointCooldown),
+		setEndpoints:      etcdv3.SetEndpoints,
+		probeRequests:     make(chan struct{}, 1),
+		stopProbe:         make(chan struct{}),
 	}
+	go client.probeEndpoints(conf.EndpointCooldown)
 	return
 }
+
+// isTransientError returns true if request can succeed when it is retried,
+// for instance it failed because of leader election or timeout
+func isTransientError(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	if etcdErr, ok := err.(rpctypes.EtcdError); ok {
+		return etcdErr.Code() == codes.Unavailable
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// isPreCommitError returns true if request is rejected by etcd before it
+// is proposed, so it is known to be not applied. Other transient errors are
+// ambiguous: request could be committed while its response was lost.
+func isPreCommitError(err error) bool {
+	return err == rpctypes.ErrNoLeader || err == rpctypes.ErrTooManyRequests
+}
+
+// retryWrite calls write with a new request timeout until it succeeds, fails
+// with error which is not retryable or maxRetries is exceeded. Delay between
+// attempts starts from retryBackoff and is doubled after each retry. Only
+// idempotent writes can be retried after ambiguous error, see
+// isPreCommitError.
+func (client *EtcdClient) retryWrite(log *log.Entry, retryable func(err error) bool, write func(ctx context.Context) error) (err error) {
+	return retry.DoWithPolicy(context.Background(), retry.Policy{
+		MaxAttempts: client.maxRetries + 1,
+		Delay:       client.retryBackoff,
+		Multiplier:  2,
+		Retryable:   retryable,
+		Sleep:       client.sleep,
+	}, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+		defer cancel()
+		err := write(ctx)
+		client.reportError(err)
+		if err != nil && retryable(err) {
+			log.WithError(err).Warn("Transient etcd error, retry write")
+		}
+		return err
+	})
+}
+
+// readOptions returns options of the read requests for the consistency
+// level. Serializable reads can return stale payment channel state, for
+// instance outdated channel nonce, so the state which is going to be
+// updated, for instance channel read under the payment lock, is read using
+// GetLatest.
+func readOptions(consistency string) []clientv3.OpOption {
+	if consistency == ReadConsistencySerializable {
+		return []clientv3.OpOption{clientv3.WithSerializable()}
+	}
+	return nil
+}
 func getTlsConfig() (*tls.Config, error) {
 
 		log.Debug("enabling SSL support via X509 keypair")
@@ -128,17 +277,28 @@ func checkIfHttps(endpoints []string ) bool {
 	}
 	return false
 }
-// Get gets value from etcd by key
+// Get gets value from etcd by key using configured read consistency
 func (client *EtcdClient) Get(key string) (value string, ok bool, err error) {
+	return client.get(key, client.readOptions...)
+}
+
+// GetLatest gets the latest value from etcd by key using linearizable read
+// whatever read consistency is configured
+func (client *EtcdClient) GetLatest(key string) (value string, ok bool, err error) {
+	return client.get(key)
+}
+
+func (client *EtcdClient) get(key string, options ...clientv3.OpOption) (value string, ok bool, err error) {
 
 	log := log.WithField("func", "Get").WithField("key", key).WithField("client", client)
 
 	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
 	defer cancel()
 
-	response, err := client.etcdv3.Get(ctx, key)
+	response, err := client.kv.Get(ctx, key, options...)
 
 	if err != nil {
+		client.reportError(err)
 		log.WithError(err).Error("Unable to get value by key")
 		return
 	}
@@ -161,9 +321,10 @@ func (client *EtcdClient) GetByKeyPrefix(key string) (values []string, err error
 	defer cancel()
 
 	keyEnd := clientv3.GetPrefixRangeEnd(key)
-	response, err := client.etcdv3.Get(ctx, key, clientv3.WithRange(keyEnd))
+	response, err := client.kv.Get(ctx, key, append([]clientv3.OpOption{clientv3.WithRange(keyEnd)}, client.readOptions...)...)
 
 	if err != nil {
+		client.reportError(err)
 		log.WithError(err).Error("Unable to get value by key prefix")
 		return
 	}
@@ -176,15 +337,48 @@ func (client *EtcdClient) GetByKeyPrefix(key string) (values []string, err error
 	return
 }
 
-// Put puts key and value to etcd
-func (client *EtcdClient) Put(key string, value string) (err error) {
-	log := log.WithField("func", "Put").WithField("key", key).WithField("client", client)
+// GetByKeyRange returns up to limit keys and values which keys have given
+// prefix and are greater than startAfter, ordered by key. Empty startAfter
+// means reading from the beginning of the prefix.
+func (client *EtcdClient) GetByKeyRange(prefix string, startAfter string, limit int) (keys []string, values []string, err error) {
+	log := log.WithField("func", "GetByKeyRange").WithField("prefix", prefix).WithField("client", client)
 
-	etcdv3 := client.etcdv3
 	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
 	defer cancel()
 
-	_, err = etcdv3.Put(ctx, key, value)
+	start := prefix
+	if startAfter != "" {
+		start = startAfter + "\x00"
+	}
+	options := append([]clientv3.OpOption{
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		clientv3.WithLimit(int64(limit)),
+	}, client.readOptions...)
+	response, err := client.kv.Get(ctx, start, options...)
+	if err != nil {
+		client.reportError(err)
+		log.WithError(err).Error("Unable to get values by key range")
+		return
+	}
+
+	for _, kv := range response.Kvs {
+		keys = append(keys, string(kv.Key))
+		values = append(values, string(kv.Value))
+	}
+	return
+}
+
+// Put puts key and value to etcd
+func (client *EtcdClient) Put(key string, value string) (err error) {
+	log := log.WithField("func", "Put").WithField("key", key).WithField("client", client)
+
+	// put of the same value is idempotent, so it is retried after ambiguous
+	// errors as well
+	err = client.retryWrite(log, isTransientError, func(ctx context.Context) error {
+		_, err := client.kv.Put(ctx, key, value)
+		return err
+	})
 	if err != nil {
 		log.WithError(err).Error("Unable to put value by key")
 	}
@@ -223,15 +417,14 @@ func (client *EtcdClient) CompareAndSwap(key string, prevValue string, newValue
 	)
 }
 
-// Transaction uses CAS operation to compare and set multiple key values
+// Transaction uses CAS operation to compare and set multiple key values.
+// Transaction is retried only when it is rejected before commit, retry after
+// ambiguous error could apply the transaction twice or report committed
+// transaction as failed, so such error is returned to the caller.
 func (client *EtcdClient) Transaction(compare []EtcdKeyValue, swap []EtcdKeyValue) (ok bool, err error) {
 
 	log := log.WithField("func", "CompareAndSwap").WithField("client", client)
 
-	etcdv3 := client.etcdv3
-	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
-	defer cancel()
-
 	cmps := make([]clientv3.Cmp, len(compare))
 
 	for index, cmp := range compare {
@@ -243,7 +436,12 @@ func (client *EtcdClient) Transaction(compare []EtcdKeyValue, swap []EtcdKeyValu
 		ops[index] = clientv3.OpPut(op.key, op.value)
 	}
 
-	response, err := etcdv3.KV.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	// failed comparison is not an error, so it is never retried
+	var response *clientv3.TxnResponse
+	err = client.retryWrite(log, isPreCommitError, func(ctx context.Context) (err error) {
+		response, err = client.kv.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		return
+	})
 
 	if err != nil {
 		keys := []string{}
@@ -313,8 +511,149 @@ func (client *EtcdClient) NewMutex(key string) (mutex *EtcdClientMutex, err erro
 	return
 }
 
+// WatchKeyPrefix watches changes of the keys with the given prefix, see
+// escrow.StorageWatcher. Watch is restarted from the last seen revision after
+// disconnect, onReset is called if the revision is already compacted.
+func (client *EtcdClient) WatchKeyPrefix(prefix string, onChange func(key string), onReset func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.watchKeyPrefix(ctx, prefix, onChange, onReset)
+	return cancel
+}
+
+func (client *EtcdClient) watchKeyPrefix(ctx context.Context, prefix string, onChange func(key string), onReset func()) {
+	log := log.WithField("func", "watchKeyPrefix").WithField("prefix", prefix)
+
+	var revision int64
+	for {
+		watchCtx, watchCancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		// created notification brings revision to restart watch from even
+		// if there were no changes before disconnect
+		options := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCreatedNotify()}
+		if revision > 0 {
+			options = append(options, clientv3.WithRev(revision+1))
+		}
+
+		for response := range client.etcdv3.Watch(watchCtx, prefix, options...) {
+			if response.CompactRevision != 0 {
+				log.WithField("compactRevision", response.CompactRevision).Warn("Watched revision is compacted")
+				revision = response.CompactRevision - 1
+				onReset()
+				break
+			}
+			if err := response.Err(); err != nil {
+				log.WithError(err).Warn("Watch is interrupted")
+				break
+			}
+			for _, event := range response.Events {
+				onChange(string(event.Kv.Key))
+			}
+			revision = response.Header.Revision
+		}
+		watchCancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchReconnectDelay):
+			log.Info("Reconnect watch")
+		}
+	}
+}
+
+// HealthCheck queries status of the configured etcd endpoints within the
+// connection timeout. It returns nil if at least one endpoint responds,
+// otherwise it returns error which describes failure of each endpoint.
+func (client *EtcdClient) HealthCheck(ctx context.Context) error {
+	if client.connectionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.connectionTimeout)
+		defer cancel()
+	}
+
+	failures := make([]string, 0, len(client.endpoints))
+	for _, endpoint := range client.endpoints {
+		if _, err := client.maintenance.Status(ctx, endpoint); err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", endpoint, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("etcd endpoints are not available: %v", strings.Join(failures, "; "))
+}
+
+// reportError requests endpoint probe if err can be caused by unavailable
+// endpoint. Probe is made in background, so request is served by the
+// endpoints selected by the previous probe.
+func (client *EtcdClient) reportError(err error) {
+	if err == nil || !isTransientError(err) || client.probeRequests == nil {
+		return
+	}
+	select {
+	case client.probeRequests <- struct{}{}:
+	default:
+	}
+}
+
+// probeEndpoints rebalances endpoints when it is requested after transient
+// error and each interval when cooldown of a failed endpoint is passed, so
+// the endpoint is returned back to use without waiting for another error.
+func (client *EtcdClient) probeEndpoints(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultEndpointCooldown
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.probeRequests:
+			client.rebalance()
+		case <-ticker.C:
+			if client.health.reprobeRequired() {
+				client.rebalance()
+			}
+		case <-client.stopProbe:
+			return
+		}
+	}
+}
+
+// rebalance probes status of the endpoints which are not in cooldown and
+// makes client use only the endpoints which responded. Endpoint which failed
+// the probe is not used until cooldown is passed. It is called by the
+// endpoint prober only.
+func (client *EtcdClient) rebalance() {
+	if client.health == nil {
+		return
+	}
+	before := client.health.available()
+
+	for _, endpoint := range client.health.toProbe() {
+		ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+		_, err := client.maintenance.Status(ctx, endpoint)
+		cancel()
+		if err != nil {
+			log.WithError(err).WithField("endpoint", endpoint).Warn("etcd endpoint is not available")
+			client.health.markFailed(endpoint)
+			continue
+		}
+		client.health.markHealthy(endpoint)
+	}
+
+	after := client.health.available()
+	if strings.Join(before, ",") != strings.Join(after, ",") {
+		log.WithField("endpoints", after).Info("etcd endpoints are updated")
+		client.setEndpoints(after...)
+	}
+}
+
 // Close closes etcd client
 func (client *EtcdClient) Close() {
+	if client.stopProbe != nil {
+		close(client.stopProbe)
+	}
 	defer client.session.Close()
 	defer client.etcdv3.Close()
 }
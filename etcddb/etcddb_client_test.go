@@ -1,8 +1,14 @@
 package etcddb
 
 import (
+	"context"
+	"errors"
 	"github.com/magiconair/properties/assert"
 	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 )
 
 func Test_checkIfHttps(t *testing.T) {
@@ -13,3 +19,358 @@ func Test_checkIfHttps(t *testing.T) {
 
 
 }
+
+func TestEtcdConnectionErrorMessage(t *testing.T) {
+	err := &EtcdConnectionError{
+		Endpoints:         []string{"http://127.0.0.1:2379"},
+		ConnectionTimeout: 5 * time.Second,
+		Cause:             errors.New("context deadline exceeded"),
+	}
+
+	assert.Equal(t, err.Error(), "cannot connect to etcd endpoints [http://127.0.0.1:2379] within connection timeout 5s: context deadline exceeded")
+}
+
+func TestRetryConnectionRetriesConnectionErrors(t *testing.T) {
+	attempts := 0
+	var delays []time.Duration
+	client := &EtcdClient{}
+
+	result, err := retryConnection(3, time.Second, func(delay time.Duration) { delays = append(delays, delay) }, func() (*EtcdClient, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, &EtcdConnectionError{Cause: errors.New("connection refused")}
+		}
+		return client, nil
+	})
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result, client)
+	assert.Equal(t, attempts, 3)
+	assert.Equal(t, delays, []time.Duration{time.Second, 2 * time.Second})
+}
+
+func TestRetryConnectionStopsAfterAttempts(t *testing.T) {
+	attempts := 0
+	connectionErr := &EtcdConnectionError{Cause: errors.New("connection refused")}
+
+	_, err := retryConnection(2, time.Second, func(time.Duration) {}, func() (*EtcdClient, error) {
+		attempts++
+		return nil, connectionErr
+	})
+
+	assert.Equal(t, err, error(connectionErr))
+	assert.Equal(t, attempts, 2)
+}
+
+func TestRetryConnectionDoesNotRetryConfigurationErrors(t *testing.T) {
+	attempts := 0
+	configErr := errors.New("unexpected read consistency")
+
+	_, err := retryConnection(3, time.Second, func(time.Duration) {}, func() (*EtcdClient, error) {
+		attempts++
+		return nil, configErr
+	})
+
+	assert.Equal(t, err, configErr)
+	assert.Equal(t, attempts, 1)
+}
+
+// failingKV fails first failures requests with err
+type failingKV struct {
+	clientv3.KV
+	failures  int
+	err       error
+	succeeded bool
+	calls     int
+	committed int
+	// serializable records consistency of each read
+	serializable []bool
+}
+
+func (kv *failingKV) write() error {
+	kv.calls++
+	if kv.calls <= kv.failures {
+		return kv.err
+	}
+	kv.committed++
+	return nil
+}
+
+func (kv *failingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	if err := kv.write(); err != nil {
+		return nil, err
+	}
+	return &clientv3.PutResponse{}, nil
+}
+
+func (kv *failingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.serializable = append(kv.serializable, clientv3.OpGet(key, opts...).IsSerializable())
+	kv.calls++
+	if kv.calls <= kv.failures {
+		return nil, kv.err
+	}
+	return &clientv3.GetResponse{}, nil
+}
+
+func (kv *failingKV) Txn(ctx context.Context) clientv3.Txn {
+	return &failingTxn{kv: kv}
+}
+
+type failingTxn struct {
+	kv *failingKV
+}
+
+func (txn *failingTxn) If(cs ...clientv3.Cmp) clientv3.Txn   { return txn }
+func (txn *failingTxn) Then(ops ...clientv3.Op) clientv3.Txn { return txn }
+func (txn *failingTxn) Else(ops ...clientv3.Op) clientv3.Txn { return txn }
+
+func (txn *failingTxn) Commit() (*clientv3.TxnResponse, error) {
+	if err := txn.kv.write(); err != nil {
+		return nil, err
+	}
+	return &clientv3.TxnResponse{Succeeded: txn.kv.succeeded}, nil
+}
+
+func newRetryingClient(kv clientv3.KV, delays *[]time.Duration) *EtcdClient {
+	return &EtcdClient{
+		timeout:      time.Second,
+		kv:           kv,
+		maxRetries:   DefaultMaxRetries,
+		retryBackoff: DefaultRetryBackoff,
+		sleep:        func(delay time.Duration) { *delays = append(*delays, delay) },
+	}
+}
+
+func TestPutRetriesTransientErrors(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{failures: 2, err: rpctypes.ErrTimeout}
+	client := newRetryingClient(kv, &delays)
+
+	err := client.Put("key", "value")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, kv.calls, 3)
+	assert.Equal(t, kv.committed, 1)
+	assert.Equal(t, delays, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond})
+}
+
+func TestTransactionRetriesPreCommitErrors(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{failures: 2, err: rpctypes.ErrNoLeader, succeeded: true}
+	client := newRetryingClient(kv, &delays)
+
+	ok, err := client.CompareAndSwap("key", "prev", "value")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, kv.calls, 3)
+	assert.Equal(t, kv.committed, 1)
+}
+
+func TestTransactionDoesNotRetryAmbiguousErrors(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{failures: 1, err: rpctypes.ErrTimeout, succeeded: true}
+	client := newRetryingClient(kv, &delays)
+
+	ok, err := client.CompareAndSwap("key", "prev", "value")
+
+	assert.Equal(t, err, rpctypes.ErrTimeout)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, kv.calls, 1)
+	assert.Equal(t, len(delays), 0)
+}
+
+func TestGetUsesReadConsistency(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{}
+	client := newRetryingClient(kv, &delays)
+	client.readOptions = readOptions(ReadConsistencySerializable)
+
+	client.Get("key")
+	client.GetByKeyPrefix("key")
+	client.GetByKeyRange("key", "", 10)
+	client.GetLatest("key")
+
+	assert.Equal(t, kv.serializable, []bool{true, true, true, false})
+}
+
+func TestPutStopsAfterMaxRetries(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{failures: 10, err: rpctypes.ErrTimeout}
+	client := newRetryingClient(kv, &delays)
+
+	err := client.Put("key", "value")
+
+	assert.Equal(t, err, rpctypes.ErrTimeout)
+	assert.Equal(t, kv.calls, DefaultMaxRetries+1)
+	assert.Equal(t, kv.committed, 0)
+}
+
+func TestPutDoesNotRetryPermanentErrors(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{failures: 1, err: rpctypes.ErrKeyNotFound}
+	client := newRetryingClient(kv, &delays)
+
+	err := client.Put("key", "value")
+
+	assert.Equal(t, err, rpctypes.ErrKeyNotFound)
+	assert.Equal(t, kv.calls, 1)
+	assert.Equal(t, len(delays), 0)
+}
+
+func TestTransactionDoesNotRetryFailedComparison(t *testing.T) {
+	var delays []time.Duration
+	kv := &failingKV{succeeded: false}
+	client := newRetryingClient(kv, &delays)
+
+	ok, err := client.CompareAndSwap("key", "prev", "value")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, kv.calls, 1)
+	assert.Equal(t, len(delays), 0)
+}
+
+// endpointsMaintenance fails status requests to the unavailable endpoints
+type endpointsMaintenance struct {
+	clientv3.Maintenance
+	unavailable map[string]bool
+	requested   []string
+}
+
+func (maintenance *endpointsMaintenance) Status(ctx context.Context, endpoint string) (*clientv3.StatusResponse, error) {
+	maintenance.requested = append(maintenance.requested, endpoint)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if maintenance.unavailable[endpoint] {
+		return nil, errors.New("connection refused")
+	}
+	return &clientv3.StatusResponse{}, nil
+}
+
+func newHealthCheckClient(maintenance clientv3.Maintenance) *EtcdClient {
+	return &EtcdClient{
+		maintenance:       maintenance,
+		endpoints:         []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379"},
+		connectionTimeout: time.Second,
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true}}
+	client := newHealthCheckClient(maintenance)
+
+	err := client.HealthCheck(context.Background())
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, maintenance.requested, []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379"})
+}
+
+func TestHealthCheckEndpointsUnavailable(t *testing.T) {
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true, "http://127.0.0.2:2379": true}}
+	client := newHealthCheckClient(maintenance)
+
+	err := client.HealthCheck(context.Background())
+
+	assert.Equal(t, err.Error(), "etcd endpoints are not available: http://127.0.0.1:2379: connection refused; http://127.0.0.2:2379: connection refused")
+}
+
+func TestHealthCheckContextCancelled(t *testing.T) {
+	maintenance := &endpointsMaintenance{}
+	client := newHealthCheckClient(maintenance)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := client.HealthCheck(ctx)
+
+	assert.Equal(t, err.Error(), "etcd endpoints are not available: http://127.0.0.1:2379: context canceled")
+	assert.Equal(t, len(maintenance.requested), 1)
+}
+
+func newRebalancingClient(kv clientv3.KV, maintenance clientv3.Maintenance, now *time.Time, used *[][]string) *EtcdClient {
+	endpoints := []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379", "http://127.0.0.3:2379"}
+	health := newEndpointHealth(endpoints, time.Minute)
+	health.now = func() time.Time { return *now }
+	return &EtcdClient{
+		timeout:       time.Second,
+		kv:            kv,
+		maxRetries:    DefaultMaxRetries,
+		retryBackoff:  DefaultRetryBackoff,
+		sleep:         func(delay time.Duration) {},
+		maintenance:   maintenance,
+		endpoints:     endpoints,
+		health:        health,
+		setEndpoints:  func(endpoints ...string) { *used = append(*used, endpoints) },
+		probeRequests: make(chan struct{}, 1),
+	}
+}
+
+func TestPutRequestsEndpointProbeInBackground(t *testing.T) {
+	now := time.Unix(0, 0)
+	var used [][]string
+	kv := &failingKV{failures: 2, err: rpctypes.ErrTimeout}
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true}}
+	client := newRebalancingClient(kv, maintenance, &now, &used)
+
+	err := client.Put("key", "value")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(client.probeRequests), 1)
+	assert.Equal(t, len(maintenance.requested), 0)
+	assert.Equal(t, len(used), 0)
+}
+
+func TestGetRequestsEndpointProbeInBackground(t *testing.T) {
+	now := time.Unix(0, 0)
+	var used [][]string
+	kv := &failingKV{failures: 1, err: rpctypes.ErrTimeout}
+	client := newRebalancingClient(kv, &endpointsMaintenance{}, &now, &used)
+
+	_, _, err := client.Get("key")
+
+	assert.Equal(t, err, rpctypes.ErrTimeout)
+	assert.Equal(t, len(client.probeRequests), 1)
+}
+
+func TestRebalanceRoutesToHealthyEndpoints(t *testing.T) {
+	now := time.Unix(0, 0)
+	var used [][]string
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true}}
+	client := newRebalancingClient(&failingKV{}, maintenance, &now, &used)
+
+	client.rebalance()
+
+	assert.Equal(t, used, [][]string{{"http://127.0.0.2:2379", "http://127.0.0.3:2379"}})
+	now = now.Add(30 * time.Second)
+	assert.False(t, client.health.reprobeRequired())
+}
+
+func TestRebalanceReprobesEndpointAfterCooldown(t *testing.T) {
+	now := time.Unix(0, 0)
+	var used [][]string
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true}}
+	client := newRebalancingClient(&failingKV{}, maintenance, &now, &used)
+	client.rebalance()
+
+	maintenance.requested = nil
+	maintenance.unavailable = nil
+	now = now.Add(time.Minute)
+	reprobeRequired := client.health.reprobeRequired()
+	client.rebalance()
+
+	assert.True(t, reprobeRequired)
+	assert.Equal(t, maintenance.requested, []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379", "http://127.0.0.3:2379"})
+	assert.Equal(t, used[len(used)-1], []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379", "http://127.0.0.3:2379"})
+}
+
+func TestRebalanceUsesAllEndpointsWhenAllFailed(t *testing.T) {
+	now := time.Unix(0, 0)
+	var used [][]string
+	maintenance := &endpointsMaintenance{unavailable: map[string]bool{"http://127.0.0.1:2379": true, "http://127.0.0.2:2379": true, "http://127.0.0.3:2379": true}}
+	client := newRebalancingClient(&failingKV{}, maintenance, &now, &used)
+
+	client.rebalance()
+
+	assert.Equal(t, len(used), 0)
+}
@@ -1,6 +1,7 @@
 package etcddb
 
 import (
+	"fmt"
 	"github.com/singnet/snet-daemon/blockchain"
 	"strings"
 	"time"
@@ -11,14 +12,85 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	// ReadConsistencyLinearizable reads go through the cluster quorum and
+	// always return the latest value, it is a default
+	ReadConsistencyLinearizable = "linearizable"
+	// ReadConsistencySerializable reads are served by a single etcd member
+	// without quorum round trip, they can return stale values
+	ReadConsistencySerializable = "serializable"
+
+	// DefaultMaxRetries is a default number of retries of the write failed
+	// because of transient error
+	DefaultMaxRetries = 3
+	// DefaultRetryBackoff is a default delay before the first retry
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// DefaultEndpointCooldown is a default time during which endpoint which
+	// returned connection error is not used
+	DefaultEndpointCooldown = 30 * time.Second
+)
+
 // EtcdClientConf config
 // ConnectionTimeout - timeout for failing to establish a connection
 // RequestTimeout    - per request timeout
 // Endpoints         - cluster endpoints
+// ReadConsistency   - consistency level of the reads
+// MaxRetries        - number of retries of the writes failed because of
+//                     transient errors
+// RetryBackoff      - delay before the first retry, doubled before each
+//                     next retry
+// EndpointCooldown  - time during which endpoint which returned connection
+//                     error is not used, endpoint is probed again after it
 type EtcdClientConf struct {
 	ConnectionTimeout time.Duration `json:"connection_timeout" mapstructure:"connection_timeout"`
 	RequestTimeout    time.Duration `json:"request_timeout" mapstructure:"request_timeout"`
 	Endpoints         []string
+	ReadConsistency   string `json:"read_consistency" mapstructure:"read_consistency"`
+	MaxRetries        int `json:"max_retries" mapstructure:"max_retries"`
+	RetryBackoff      time.Duration `json:"retry_backoff" mapstructure:"retry_backoff"`
+	EndpointCooldown  time.Duration `json:"endpoint_cooldown" mapstructure:"endpoint_cooldown"`
+}
+
+// NewEtcdClientConf returns client config with default timeouts, read
+// consistency and retries, it allows creating client without viper
+func NewEtcdClientConf(endpoints ...string) *EtcdClientConf {
+	return &EtcdClientConf{
+		ConnectionTimeout: 5 * time.Second,
+		RequestTimeout:    3 * time.Second,
+		Endpoints:         endpoints,
+		ReadConsistency:   ReadConsistencyLinearizable,
+		MaxRetries:        DefaultMaxRetries,
+		RetryBackoff:      DefaultRetryBackoff,
+		EndpointCooldown:  DefaultEndpointCooldown,
+	}
+}
+
+// Validate returns error if client config cannot be used to connect to etcd
+func (conf *EtcdClientConf) Validate() error {
+	if len(conf.Endpoints) == 0 {
+		return fmt.Errorf("endpoints of payment channel storage client are not set")
+	}
+	if conf.ConnectionTimeout < 0 {
+		return fmt.Errorf("connection timeout of payment channel storage client should not be negative: %v", conf.ConnectionTimeout)
+	}
+	if conf.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout of payment channel storage client should not be negative: %v", conf.RequestTimeout)
+	}
+	if conf.MaxRetries < 0 {
+		return fmt.Errorf("max retries of payment channel storage client should not be negative: %v", conf.MaxRetries)
+	}
+	if conf.RetryBackoff < 0 {
+		return fmt.Errorf("retry backoff of payment channel storage client should not be negative: %v", conf.RetryBackoff)
+	}
+	if conf.EndpointCooldown < 0 {
+		return fmt.Errorf("endpoint cooldown of payment channel storage client should not be negative: %v", conf.EndpointCooldown)
+	}
+	switch conf.ReadConsistency {
+	case ReadConsistencyLinearizable, ReadConsistencySerializable:
+	default:
+		return fmt.Errorf("unexpected read consistency of payment channel storage client: \"%v\"", conf.ReadConsistency)
+	}
+	return nil
 }
 
 // GetEtcdClientConf gets EtcdServerConf from viper
@@ -31,8 +103,27 @@ func GetEtcdClientConf(vip *viper.Viper,metaData *blockchain.OrganizationMetaDat
 		ConnectionTimeout:metaData.GetConnectionTimeOut(),
 		RequestTimeout:metaData.GetRequestTimeOut(),
 		Endpoints:metaData.GetPaymentStorageEndPoints(),
+		ReadConsistency:metaData.GetReadConsistency(),
+		MaxRetries:DefaultMaxRetries,
+		RetryBackoff:metaData.GetRetryBackoff(),
+		EndpointCooldown:metaData.GetEndpointCooldown(),
+	}
+	if maxRetries, ok := metaData.GetMaxRetries(); ok {
+		conf.MaxRetries = maxRetries
+	}
+	if conf.RetryBackoff == 0 {
+		conf.RetryBackoff = DefaultRetryBackoff
+	}
+	if conf.EndpointCooldown == 0 {
+		conf.EndpointCooldown = DefaultEndpointCooldown
+	}
+	if conf.ReadConsistency == "" {
+		conf.ReadConsistency = ReadConsistencyLinearizable
 	}
 
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
 	return
 }
 
@@ -54,6 +145,11 @@ func normalizeDefaultConf(conf string) string {
 //         cross-cluster-interaction, which might corrupt the clusters.
 // StartupTimeout - time to wait the etcd server successfully started
 // Enabled - enable running embedded etcd server
+// CertFile - server certificate, required when Scheme is https
+// KeyFile - server certificate key, required when Scheme is https
+// TrustedCAFile - CA certificate to verify client and peer certificates
+// ClientCertAuth - require clients and peers to present certificate signed
+//                  by TrustedCAFile
 // For more details see etcd Clustering Guide link:
 // https://github.com/etcd-io/etcd/blob/master/Documentation/op-guide/clustering.md
 type EtcdServerConf struct {
@@ -68,6 +164,60 @@ type EtcdServerConf struct {
 	Enabled        bool
 	DataDir        string `json:"data_dir" mapstructure:"DATA_DIR"`
 	LogLevel       string `json:"log_level" mapstructure:"LOG_LEVEL"`
+	CertFile       string `json:"cert_file" mapstructure:"cert_file"`
+	KeyFile        string `json:"key_file" mapstructure:"key_file"`
+	TrustedCAFile  string `json:"trusted_ca_file" mapstructure:"trusted_ca_file"`
+	ClientCertAuth bool   `json:"client_cert_auth" mapstructure:"client_cert_auth"`
+}
+
+// NewEtcdServerConf returns enabled server config with the same defaults as
+// payment_channel_storage_server has, it allows starting server without
+// viper
+func NewEtcdServerConf() *EtcdServerConf {
+	return &EtcdServerConf{
+		ID:             "storage-1",
+		Scheme:         "http",
+		Host:           "127.0.0.1",
+		ClientPort:     2379,
+		PeerPort:       2380,
+		Token:          "unique-token",
+		Cluster:        "storage-1=http://127.0.0.1:2380",
+		StartupTimeout: time.Minute,
+		Enabled:        true,
+		DataDir:        "storage-data-dir-1.etcd",
+		LogLevel:       "info",
+	}
+}
+
+// Validate returns error if enabled server cannot be started using the
+// config, TLS settings are checked even if server is disabled
+func (conf *EtcdServerConf) Validate() error {
+	if err := checkEtcdServerTLSConf(conf); err != nil {
+		return err
+	}
+	if !conf.Enabled {
+		return nil
+	}
+
+	if conf.ID == "" {
+		return fmt.Errorf("%v id is not set", config.PaymentChannelStorageServerKey)
+	}
+	if !strings.EqualFold(conf.Scheme, "http") && !strings.EqualFold(conf.Scheme, "https") {
+		return fmt.Errorf("%v scheme should be http or https: \"%v\"", config.PaymentChannelStorageServerKey, conf.Scheme)
+	}
+	if conf.Host == "" {
+		return fmt.Errorf("%v host is not set", config.PaymentChannelStorageServerKey)
+	}
+	if conf.ClientPort <= 0 || conf.PeerPort <= 0 {
+		return fmt.Errorf("%v client_port and peer_port should be positive: %v, %v", config.PaymentChannelStorageServerKey, conf.ClientPort, conf.PeerPort)
+	}
+	if conf.StartupTimeout <= 0 {
+		return fmt.Errorf("%v startup_timeout should be positive: %v", config.PaymentChannelStorageServerKey, conf.StartupTimeout)
+	}
+	if _, err := capnslog.ParseLevel(strings.ToUpper(conf.LogLevel)); err != nil {
+		return fmt.Errorf("%v log_level: %v", config.PaymentChannelStorageServerKey, err)
+	}
+	return nil
 }
 
 // GetEtcdServerConf gets EtcdServerConf from viper
@@ -96,11 +246,30 @@ func GetEtcdServerConf(vip *viper.Viper) (conf *EtcdServerConf, err error) {
 		return
 	}
 
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+
 	err = initEtcdLogger(conf)
 
 	return
 }
 
+// checkEtcdServerTLSConf returns error if https scheme is set without
+// certificate, so server doesn't silently start insecure
+func checkEtcdServerTLSConf(conf *EtcdServerConf) error {
+	if !strings.EqualFold(conf.Scheme, "https") {
+		return nil
+	}
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return fmt.Errorf("%v scheme is https but cert_file or key_file is not set", config.PaymentChannelStorageServerKey)
+	}
+	if conf.ClientCertAuth && conf.TrustedCAFile == "" {
+		return fmt.Errorf("%v client_cert_auth is enabled but trusted_ca_file is not set", config.PaymentChannelStorageServerKey)
+	}
+	return nil
+}
+
 // capnslog to logrus formatter implementation
 // with methods Format and Flush
 type capnslogToLogrusLogFormatter struct {
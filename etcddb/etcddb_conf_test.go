@@ -161,3 +161,190 @@ func readConfig(t *testing.T, configJSON string) (vip *viper.Viper) {
 	assert.Nil(t, err)
 	return
 }
+
+func TestEtcdClientConfReadConsistency(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"read_consistency\": \"serializable\", \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ReadConsistencySerializable, conf.ReadConsistency)
+	assert.Equal(t, 1, len(readOptions(conf.ReadConsistency)))
+}
+
+func TestEtcdClientConfDefaultReadConsistency(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ReadConsistencyLinearizable, conf.ReadConsistency)
+	assert.Nil(t, readOptions(conf.ReadConsistency))
+}
+
+func TestEtcdClientConfIncorrectReadConsistency(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"read_consistency\": \"eventual\", \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, conf)
+	assert.Equal(t, "unexpected read consistency of payment channel storage client: \"eventual\"", err.Error())
+}
+
+func TestEtcdClientConfRetries(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"max_retries\": 5, \"retry_backoff_ms\": 50, \"endpoint_cooldown_ms\": 2000, \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, conf.MaxRetries)
+	assert.Equal(t, 50*time.Millisecond, conf.RetryBackoff)
+	assert.Equal(t, 2*time.Second, conf.EndpointCooldown)
+}
+
+func TestEtcdClientConfDefaultRetries(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultMaxRetries, conf.MaxRetries)
+	assert.Equal(t, DefaultRetryBackoff, conf.RetryBackoff)
+	assert.Equal(t, DefaultEndpointCooldown, conf.EndpointCooldown)
+}
+
+func TestEtcdClientConfNegativeMaxRetries(t *testing.T) {
+	var testJsonOrgGroupData = "{ \"org_name\": \"organization_name\", \"org_id\": \"org_id1\", \"groups\": [ { \"group_name\": \"default_group\", \"group_id\": \"99ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=\", \"payment\": { \"payment_address\": \"0x671276c61943A35D5F230d076bDFd91B0c47bF09\", \"payment_expiration_threshold\": 40320, \"payment_channel_storage_type\": \"etcd\", \"payment_channel_storage_client\": { \"connection_timeout\": \"5s\", \"request_timeout\": \"3s\", \"max_retries\": -1, \"endpoints\": [ \"http://127.0.0.1:2379\" ] } } } ] }"
+	metadata, err := blockchain.InitOrganizationMetaDataFromJson(testJsonOrgGroupData)
+	assert.Nil(t, err)
+
+	conf, err := GetEtcdClientConf(nil, metadata)
+
+	assert.Nil(t, conf)
+	assert.Equal(t, "max retries of payment channel storage client should not be negative: -1", err.Error())
+}
+
+func TestEtcdServerConfHttpsWithoutCertificate(t *testing.T) {
+	const confJSON = `
+	{
+		"payment_channel_storage_server": {
+			"scheme": "https",
+			"key_file": "server.key",
+			"enabled": true
+		}
+	}`
+
+	vip := readConfig(t, confJSON)
+
+	conf, err := GetEtcdServerConf(vip)
+
+	assert.Nil(t, conf)
+	assert.Equal(t, "payment_channel_storage_server scheme is https but cert_file or key_file is not set", err.Error())
+}
+
+func TestEtcdServerConfClientCertAuthWithoutTrustedCa(t *testing.T) {
+	const confJSON = `
+	{
+		"payment_channel_storage_server": {
+			"scheme": "https",
+			"cert_file": "server.crt",
+			"key_file": "server.key",
+			"client_cert_auth": true,
+			"enabled": true
+		}
+	}`
+
+	vip := readConfig(t, confJSON)
+
+	conf, err := GetEtcdServerConf(vip)
+
+	assert.Nil(t, conf)
+	assert.Equal(t, "payment_channel_storage_server client_cert_auth is enabled but trusted_ca_file is not set", err.Error())
+}
+
+func TestEtcdServerConfHttps(t *testing.T) {
+	const confJSON = `
+	{
+		"payment_channel_storage_server": {
+			"scheme": "https",
+			"cert_file": "server.crt",
+			"key_file": "server.key",
+			"trusted_ca_file": "ca.crt",
+			"client_cert_auth": true,
+			"enabled": true
+		}
+	}`
+
+	vip := readConfig(t, confJSON)
+
+	conf, err := GetEtcdServerConf(vip)
+	assert.Nil(t, err)
+
+	etcdConf := getEtcdConf(conf)
+
+	assert.Equal(t, "server.crt", etcdConf.ClientTLSInfo.CertFile)
+	assert.Equal(t, "server.key", etcdConf.ClientTLSInfo.KeyFile)
+	assert.Equal(t, "ca.crt", etcdConf.ClientTLSInfo.TrustedCAFile)
+	assert.True(t, etcdConf.ClientTLSInfo.ClientCertAuth)
+	assert.Equal(t, etcdConf.ClientTLSInfo, etcdConf.PeerTLSInfo)
+	assert.Equal(t, "https", etcdConf.LCUrls[0].Scheme)
+}
+
+func TestEtcdClientConfValidateZeroValue(t *testing.T) {
+	conf := EtcdClientConf{}
+
+	err := conf.Validate()
+
+	assert.Equal(t, "endpoints of payment channel storage client are not set", err.Error())
+}
+
+func TestEtcdClientConfValidate(t *testing.T) {
+	conf := NewEtcdClientConf("http://127.0.0.1:2379")
+
+	err := conf.Validate()
+
+	assert.Nil(t, err)
+}
+
+func TestEtcdClientConfValidateIncorrectReadConsistency(t *testing.T) {
+	conf := NewEtcdClientConf("http://127.0.0.1:2379")
+	conf.ReadConsistency = "eventual"
+
+	err := conf.Validate()
+
+	assert.Equal(t, "unexpected read consistency of payment channel storage client: \"eventual\"", err.Error())
+}
+
+func TestEtcdServerConfValidateZeroValue(t *testing.T) {
+	conf := EtcdServerConf{}
+
+	err := conf.Validate()
+
+	assert.Nil(t, err)
+}
+
+func TestEtcdServerConfValidateEnabledZeroValue(t *testing.T) {
+	conf := EtcdServerConf{Enabled: true}
+
+	err := conf.Validate()
+
+	assert.Equal(t, "payment_channel_storage_server id is not set", err.Error())
+}
+
+func TestEtcdServerConfValidate(t *testing.T) {
+	conf := NewEtcdServerConf()
+
+	err := conf.Validate()
+
+	assert.Nil(t, err)
+}
@@ -4,12 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/singnet/snet-daemon/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"go.etcd.io/etcd/embed"
+	"go.etcd.io/etcd/pkg/transport"
 )
 
 // EtcdServer struct has some useful methods to wolrk with etcd server
@@ -57,6 +59,18 @@ func GetEtcdServerFromVip(vip *viper.Viper) (server *EtcdServer, err error) {
 	return
 }
 
+// NewEtcdServer returns EtcdServer using config built without viper, server
+// should be started by Start
+func NewEtcdServer(conf *EtcdServerConf) (server *EtcdServer, err error) {
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	if err = initEtcdLogger(conf); err != nil {
+		return nil, err
+	}
+	return &EtcdServer{conf: conf}, nil
+}
+
 // Start starts etcd server
 func (server *EtcdServer) Start() (err error) {
 
@@ -137,5 +151,19 @@ func getEtcdConf(conf *EtcdServerConf) *embed.Config {
 	//  --initial-cluster-state
 	etcdConf.ClusterState = embed.ClusterStateFlagNew
 
+	if strings.EqualFold(conf.Scheme, "https") {
+		tlsInfo := transport.TLSInfo{
+			CertFile:       conf.CertFile,
+			KeyFile:        conf.KeyFile,
+			TrustedCAFile:  conf.TrustedCAFile,
+			ClientCertAuth: conf.ClientCertAuth,
+		}
+		// --cert-file, --key-file, --trusted-ca-file, --client-cert-auth
+		etcdConf.ClientTLSInfo = tlsInfo
+		// --peer-cert-file, --peer-key-file, --peer-trusted-ca-file,
+		// --peer-client-cert-auth
+		etcdConf.PeerTLSInfo = tlsInfo
+	}
+
 	return etcdConf
 }
@@ -138,6 +138,25 @@ func (suite *EtcdTestSuite) TestEtcdPutGet() {
 	}
 }
 
+func (suite *EtcdTestSuite) TestEtcdGetByKeyRange() {
+	t := suite.T()
+	client := suite.client
+
+	for _, key := range []string{"range-a", "range-c", "range-b", "range-d", "rangf"} {
+		assert.Nil(t, client.Put(key, "value-"+key))
+	}
+
+	keys, values, err := client.GetByKeyRange("range-", "", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"range-a", "range-b", "range-c"}, keys)
+	assert.Equal(t, []string{"value-range-a", "value-range-b", "value-range-c"}, values)
+
+	keys, values, err = client.GetByKeyRange("range-", "range-c", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"range-d"}, keys)
+	assert.Equal(t, []string{"value-range-d"}, values)
+}
+
 func (suite *EtcdTestSuite) TestEtcdCAS() {
 
 	t := suite.T()
@@ -342,6 +361,45 @@ func (suite *EtcdTestSuite) TestEtcdMutex() {
 	assert.Equal(t, res1, res2)
 }
 
+func (suite *EtcdTestSuite) TestEtcdWatchKeyPrefix() {
+
+	t := suite.T()
+
+	var mutex sync.Mutex
+	cache := map[string]string{"watched/key": "cached-value"}
+	invalidated := make(chan string, 1)
+
+	stop := suite.client.WatchKeyPrefix("watched/", func(key string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(cache, key)
+		invalidated <- key
+	}, func() {})
+	defer stop()
+	// give watch time to be established
+	time.Sleep(200 * time.Millisecond)
+
+	writer, err := NewEtcdClient(suite.metaData)
+	assert.Nil(t, err)
+	defer writer.Close()
+
+	err = writer.Put("not-watched/key", "value")
+	assert.Nil(t, err)
+	err = writer.Put("watched/key", "new-value")
+	assert.Nil(t, err)
+
+	select {
+	case key := <-invalidated:
+		assert.Equal(t, "watched/key", key)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "cache is not invalidated")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, ok := cache["watched/key"]
+	assert.False(t, ok)
+}
+
 func assertGet(suite *EtcdTestSuite, key string, value string) {
 	t := suite.T()
 	updateResult, ok, err := suite.client.Get(key)
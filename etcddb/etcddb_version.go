@@ -0,0 +1,76 @@
+package etcddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/etcd/version"
+	"github.com/coreos/go-semver/semver"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/singnet/snet-daemon/blockchain"
+)
+
+// CheckServerVersion checks that all etcd endpoints of the payment storage
+// run server version which is supported by the etcd client. minVersion is
+// inclusive and maxVersion is exclusive bound of the supported versions,
+// empty maxVersion means no upper bound.
+func CheckServerVersion(metaData *blockchain.OrganizationMetaData, minVersion string, maxVersion string) error {
+	httpClient := &http.Client{Timeout: metaData.GetConnectionTimeOut()}
+	if checkIfHttps(metaData.GetPaymentStorageEndPoints()) {
+		tlsConfig, err := getTlsConfig()
+		if err != nil {
+			return err
+		}
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return checkServerVersion(httpClient, metaData.GetPaymentStorageEndPoints(), minVersion, maxVersion)
+}
+
+func checkServerVersion(httpClient *http.Client, endpoints []string, minVersion string, maxVersion string) (err error) {
+	min, err := semver.NewVersion(minVersion)
+	if err != nil {
+		return fmt.Errorf("incorrect minimal etcd version: %v", err)
+	}
+	var max *semver.Version
+	if maxVersion != "" {
+		if max, err = semver.NewVersion(maxVersion); err != nil {
+			return fmt.Errorf("incorrect maximal etcd version: %v", err)
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		serverVersion, err := getServerVersion(httpClient, endpoint)
+		if err != nil {
+			return err
+		}
+		log.WithField("endpoint", endpoint).WithField("version", serverVersion).Debug("etcd server version")
+		if serverVersion.LessThan(*min) || (max != nil && !serverVersion.LessThan(*max)) {
+			return fmt.Errorf("etcd server %v version %v is not supported, supported versions: [%v, %v)",
+				endpoint, serverVersion, minVersion, maxVersion)
+		}
+	}
+	return nil
+}
+
+func getServerVersion(httpClient *http.Client, endpoint string) (serverVersion *semver.Version, err error) {
+	response, err := httpClient.Get(strings.TrimSuffix(endpoint, "/") + "/version")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get etcd server %v version: %v", endpoint, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot get etcd server %v version, status: %v", endpoint, response.Status)
+	}
+
+	versions := version.Versions{}
+	if err = json.NewDecoder(response.Body).Decode(&versions); err != nil {
+		return nil, fmt.Errorf("cannot parse etcd server %v version: %v", endpoint, err)
+	}
+	if serverVersion, err = semver.NewVersion(versions.Server); err != nil {
+		return nil, fmt.Errorf("incorrect etcd server %v version: %v", endpoint, err)
+	}
+	return serverVersion, nil
+}
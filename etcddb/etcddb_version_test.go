@@ -0,0 +1,65 @@
+package etcddb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newVersionServer(serverVersion string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/version" {
+			http.NotFound(resp, req)
+			return
+		}
+		fmt.Fprintf(resp, `{"etcdserver":"%v","etcdcluster":"%v"}`, serverVersion, serverVersion)
+	}))
+}
+
+func TestCheckServerVersionSupported(t *testing.T) {
+	server := newVersionServer("3.3.10")
+	defer server.Close()
+
+	err := checkServerVersion(http.DefaultClient, []string{server.URL}, "3.3.0", "3.4.0")
+
+	assert.Nil(t, err)
+}
+
+func TestCheckServerVersionTooOld(t *testing.T) {
+	server := newVersionServer("3.2.24")
+	defer server.Close()
+
+	err := checkServerVersion(http.DefaultClient, []string{server.URL}, "3.3.0", "3.4.0")
+
+	assert.Equal(t, fmt.Sprintf("etcd server %v version 3.2.24 is not supported, supported versions: [3.3.0, 3.4.0)", server.URL), err.Error())
+}
+
+func TestCheckServerVersionTooNew(t *testing.T) {
+	server := newVersionServer("3.4.0")
+	defer server.Close()
+
+	err := checkServerVersion(http.DefaultClient, []string{server.URL}, "3.3.0", "3.4.0")
+
+	assert.NotNil(t, err)
+}
+
+func TestCheckServerVersionNoUpperBound(t *testing.T) {
+	server := newVersionServer("3.5.1")
+	defer server.Close()
+
+	err := checkServerVersion(http.DefaultClient, []string{server.URL}, "3.3.0", "")
+
+	assert.Nil(t, err)
+}
+
+func TestCheckServerVersionUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	err := checkServerVersion(http.DefaultClient, []string{server.URL}, "3.3.0", "")
+
+	assert.Equal(t, fmt.Sprintf("cannot get etcd server %v version, status: 404 Not Found", server.URL), err.Error())
+}
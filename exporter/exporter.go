@@ -0,0 +1,200 @@
+// Package exporter contains batching exporter which publishes records to
+// the external systems via pluggable writers.
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	WriterKey        = "writer"
+	WriterTypeKey    = "type"
+	BatchSizeKey     = "batch_size"
+	FlushIntervalKey = "flush_interval"
+	QueueSizeKey     = "queue_size"
+	MaxRetriesKey    = "max_retries"
+	RetryDelayKey    = "retry_delay"
+
+	// NoneWriterType disables export
+	NoneWriterType = "none"
+)
+
+// Record is a single exported record
+type Record struct {
+	// Key is used to keep order of the records with the same key in the
+	// external system, for instance it is a Kafka message key
+	Key string
+	// Value is serialized to JSON by writer
+	Value interface{}
+}
+
+// Writer writes batch of records to the external system. Writer is not
+// called concurrently.
+type Writer interface {
+	Write(records []Record) error
+}
+
+// RegisterWriterType registers new writer type in the system.
+func RegisterWriterType(writerType string, writerFactoryMethod func(*viper.Viper) (Writer, error)) {
+	writerFactoryMethodsByType[writerType] = writerFactoryMethod
+}
+
+var writerFactoryMethodsByType = map[string]func(*viper.Viper) (Writer, error){}
+
+func init() {
+	RegisterWriterType(KafkaRestWriterType, newKafkaRestWriter)
+}
+
+// NewWriter returns writer by configuration, nil is returned if writer
+// type is "none"
+func NewWriter(config *viper.Viper) (Writer, error) {
+	if config == nil {
+		return nil, errors.New("no writer config")
+	}
+	writerType := config.GetString(WriterTypeKey)
+	if writerType == NoneWriterType || writerType == "" {
+		return nil, nil
+	}
+
+	factory, ok := writerFactoryMethodsByType[writerType]
+	if !ok {
+		return nil, fmt.Errorf("unexpected writer type: \"%v\"", writerType)
+	}
+	return factory(config)
+}
+
+// BatchingExporter collects records in the queue and writes them by batches
+// in background, so Export never blocks the caller. Records are written in
+// the order they are exported. Failed batches are retried, records are
+// dropped when the queue is full or retries are exhausted.
+type BatchingExporter struct {
+	writer        Writer
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryDelay    time.Duration
+
+	queue    chan Record
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewBatchingExporterFromConfig returns exporter by configuration, nil is
+// returned if export is disabled
+func NewBatchingExporterFromConfig(config *viper.Viper) (*BatchingExporter, error) {
+	if config == nil {
+		return nil, nil
+	}
+	writer, err := NewWriter(config.Sub(WriterKey))
+	if err != nil || writer == nil {
+		return nil, err
+	}
+	return NewBatchingExporter(writer, config.GetInt(BatchSizeKey), config.GetDuration(FlushIntervalKey),
+		config.GetInt(QueueSizeKey), config.GetInt(MaxRetriesKey), config.GetDuration(RetryDelayKey)), nil
+}
+
+// NewBatchingExporter returns new exporter and starts writing records in
+// background.
+func NewBatchingExporter(writer Writer, batchSize int, flushInterval time.Duration, queueSize int, maxRetries int, retryDelay time.Duration) *BatchingExporter {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	exporter := &BatchingExporter{
+		writer:        writer,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		maxRetries:    maxRetries,
+		retryDelay:    retryDelay,
+		queue:         make(chan Record, queueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go exporter.run()
+	return exporter
+}
+
+// Export adds record to the queue, it returns false if record is dropped
+// because queue is full
+func (exporter *BatchingExporter) Export(record Record) bool {
+	select {
+	case exporter.queue <- record:
+		return true
+	default:
+		log.WithField("key", record.Key).Warn("Export queue is full, record is dropped")
+		return false
+	}
+}
+
+// Close writes queued records and stops the exporter
+func (exporter *BatchingExporter) Close() {
+	exporter.stopOnce.Do(func() { close(exporter.stop) })
+	<-exporter.done
+}
+
+func (exporter *BatchingExporter) run() {
+	defer close(exporter.done)
+
+	var ticks <-chan time.Time
+	if exporter.flushInterval > 0 {
+		ticker := time.NewTicker(exporter.flushInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	batch := make([]Record, 0, exporter.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			exporter.write(batch)
+			batch = make([]Record, 0, exporter.batchSize)
+		}
+	}
+	for {
+		select {
+		case record := <-exporter.queue:
+			batch = append(batch, record)
+			if len(batch) >= exporter.batchSize {
+				flush()
+			}
+		case <-ticks:
+			flush()
+		case <-exporter.stop:
+			for {
+				select {
+				case record := <-exporter.queue:
+					batch = append(batch, record)
+					if len(batch) >= exporter.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (exporter *BatchingExporter) write(batch []Record) {
+	for attempt := 0; ; attempt++ {
+		err := exporter.writer.Write(batch)
+		if err == nil {
+			return
+		}
+		if attempt >= exporter.maxRetries {
+			log.WithError(err).WithField("records", len(batch)).Error("Unable to export records, records are dropped")
+			return
+		}
+		log.WithError(err).WithField("attempt", attempt+1).Warn("Unable to export records, retry")
+		time.Sleep(exporter.retryDelay)
+	}
+}
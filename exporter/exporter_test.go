@@ -0,0 +1,112 @@
+package exporter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func records(count int) (result []Record) {
+	for i := 0; i < count; i++ {
+		result = append(result, Record{Key: strconv.Itoa(i % 2), Value: i})
+	}
+	return
+}
+
+func TestBatchingExporterKeepsOrder(t *testing.T) {
+	writer := NewMemoryWriter()
+	exporter := NewBatchingExporter(writer, 3, time.Hour, 100, 0, 0)
+
+	for _, record := range records(10) {
+		assert.True(t, exporter.Export(record))
+	}
+	exporter.Close()
+
+	assert.Equal(t, records(10), writer.Records())
+}
+
+func TestBatchingExporterFlushesByInterval(t *testing.T) {
+	writer := NewMemoryWriter()
+	exporter := NewBatchingExporter(writer, 100, 10*time.Millisecond, 100, 0, 0)
+	defer exporter.Close()
+
+	exporter.Export(Record{Key: "1", Value: 1})
+
+	for i := 0; i < 100 && len(writer.Records()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []Record{{Key: "1", Value: 1}}, writer.Records())
+}
+
+func TestBatchingExporterRetriesFailedBatch(t *testing.T) {
+	writer := NewMemoryWriter()
+	writer.FailNext(2)
+	exporter := NewBatchingExporter(writer, 2, time.Hour, 100, 2, time.Millisecond)
+
+	for _, record := range records(2) {
+		exporter.Export(record)
+	}
+	exporter.Close()
+
+	assert.Equal(t, records(2), writer.Records())
+}
+
+func TestBatchingExporterDropsBatchAfterRetries(t *testing.T) {
+	writer := NewMemoryWriter()
+	writer.FailNext(2)
+	exporter := NewBatchingExporter(writer, 2, time.Hour, 100, 1, time.Millisecond)
+
+	for _, record := range records(4) {
+		exporter.Export(record)
+	}
+	exporter.Close()
+
+	assert.Equal(t, records(4)[2:], writer.Records())
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (writer *blockingWriter) Write(records []Record) error {
+	<-writer.unblock
+	return nil
+}
+
+func TestBatchingExporterDoesNotBlockWhenQueueIsFull(t *testing.T) {
+	writer := &blockingWriter{unblock: make(chan struct{})}
+	exporter := NewBatchingExporter(writer, 1, time.Hour, 1, 0, 0)
+
+	exported := 0
+	for _, record := range records(10) {
+		if exporter.Export(record) {
+			exported++
+		}
+	}
+	close(writer.unblock)
+	exporter.Close()
+
+	assert.True(t, exported < 10)
+}
+
+func TestNewBatchingExporterFromConfigNone(t *testing.T) {
+	config := viper.New()
+	config.Set(WriterKey, map[string]interface{}{WriterTypeKey: NoneWriterType})
+
+	exporter, err := NewBatchingExporterFromConfig(config)
+
+	assert.Nil(t, err)
+	assert.Nil(t, exporter)
+}
+
+func TestNewBatchingExporterFromConfigUnknownType(t *testing.T) {
+	config := viper.New()
+	config.Set(WriterKey, map[string]interface{}{WriterTypeKey: "unknown"})
+
+	_, err := NewBatchingExporterFromConfig(config)
+
+	assert.Equal(t, "unexpected writer type: \"unknown\"", err.Error())
+}
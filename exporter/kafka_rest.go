@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// KafkaRestWriterType is a writer which produces records to Kafka topic
+	// via Kafka REST Proxy
+	KafkaRestWriterType = "kafka_rest"
+
+	KafkaRestUrlKey     = "url"
+	KafkaRestTopicKey   = "topic"
+	KafkaRestTimeoutKey = "timeout"
+
+	kafkaRestContentType = "application/vnd.kafka.json.v2+json"
+)
+
+type kafkaRestWriter struct {
+	topicUrl string
+	client   *http.Client
+}
+
+type kafkaRestRecord struct {
+	Key   string      `json:"key,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+type kafkaRestRequest struct {
+	Records []kafkaRestRecord `json:"records"`
+}
+
+func newKafkaRestWriter(config *viper.Viper) (Writer, error) {
+	url := config.GetString(KafkaRestUrlKey)
+	topic := config.GetString(KafkaRestTopicKey)
+	if url == "" || topic == "" {
+		return nil, errors.New("url and topic are required for kafka_rest writer")
+	}
+	timeout := config.GetDuration(KafkaRestTimeoutKey)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &kafkaRestWriter{
+		topicUrl: strings.TrimSuffix(url, "/") + "/topics/" + topic,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Write produces records in one request, records with the same key are
+// sent to the same partition, so their order is kept
+func (writer *kafkaRestWriter) Write(records []Record) error {
+	request := kafkaRestRequest{Records: make([]kafkaRestRecord, 0, len(records))}
+	for _, record := range records {
+		request.Records = append(request.Records, kafkaRestRecord{Key: record.Key, Value: record.Value})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	response, err := writer.client.Post(writer.topicUrl, kafkaRestContentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("kafka REST proxy returned %v: %v", response.Status, string(message))
+	}
+	return nil
+}
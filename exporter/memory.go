@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemoryWriter keeps written records in memory, it is used in tests
+type MemoryWriter struct {
+	mutex   sync.Mutex
+	records []Record
+	// failures is a number of next writes which fail
+	failures int
+}
+
+// NewMemoryWriter returns new in-memory writer
+func NewMemoryWriter() *MemoryWriter {
+	return &MemoryWriter{}
+}
+
+// Write implements Writer interface
+func (writer *MemoryWriter) Write(records []Record) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.failures > 0 {
+		writer.failures--
+		return errors.New("write failure requested")
+	}
+	writer.records = append(writer.records, records...)
+	return nil
+}
+
+// FailNext makes next count writes fail
+func (writer *MemoryWriter) FailNext(count int) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.failures = count
+}
+
+// Records returns copy of the written records
+func (writer *MemoryWriter) Records() []Record {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	return append([]Record(nil), writer.records...)
+}
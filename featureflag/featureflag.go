@@ -0,0 +1,173 @@
+// Package featureflag allows enabling new behaviors of the daemon gradually:
+// per payment group or for a percentage of the traffic. Flags are read from
+// a JSON file which is re-read periodically, so flags can be switched
+// without daemon restart.
+package featureflag
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/singnet/snet-daemon/config"
+)
+
+const (
+	// SignatureFormatCheck is a check of payment signature values before
+	// signer is recovered
+	SignatureFormatCheck = "signature_format_check"
+	// MpeContractCheck is a check that payment channel belongs to the MPE
+	// contract payment is sent to
+	MpeContractCheck = "mpe_contract_check"
+	// SanctionsCheck is a check of channel sender and payment signer against
+	// the sanctions list
+	SanctionsCheck = "sanctions_check"
+	// RequestContentCheck is a check that payment is signed together with
+	// the request content
+	RequestContentCheck = "request_content_check"
+	// MetadataPresenceCheck is a check that all metadata required by the
+	// payment type is sent before payment is parsed
+	MetadataPresenceCheck = "metadata_presence_check"
+)
+
+// Flag describes which part of the traffic the behavior is enabled for.
+type Flag struct {
+	// Enabled switches the behavior on or off for all groups
+	Enabled bool `json:"enabled"`
+	// Groups overrides Enabled for the listed payment groups
+	Groups map[string]bool `json:"groups"`
+	// Percentage is a percentage of the traffic the behavior is enabled
+	// for, nil means all traffic
+	Percentage *int `json:"percentage"`
+}
+
+// Flags is consulted before the behavior controlled by the flag is applied.
+type Flags interface {
+	// Enabled returns true if the behavior is enabled for the call. key
+	// identifies the caller for the percentage rollout, for instance
+	// payment channel id, so the same caller gets the same result.
+	// Behavior without a flag is enabled.
+	Enabled(name string, key string) bool
+}
+
+type allEnabled struct{}
+
+func (allEnabled) Enabled(name string, key string) bool {
+	return true
+}
+
+// AllEnabled returns flags which enable all behaviors
+func AllEnabled() Flags {
+	return allEnabled{}
+}
+
+// NewFlagsFromConfig returns flags which are read from the file set in
+// config, if file is not set all behaviors are enabled.
+func NewFlagsFromConfig(cfg *viper.Viper) Flags {
+	path := cfg.GetString(config.FeatureFlagsFile)
+	if path == "" {
+		return AllEnabled()
+	}
+	return NewFileFlags(path, cfg.GetString(config.DaemonGroupName), cfg.GetDuration(config.FeatureFlagsRefreshInterval))
+}
+
+// fileFlags keeps the flags loaded from a file in memory and reloads them
+// when they are older than refreshInterval.
+type fileFlags struct {
+	path            string
+	group           string
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mutex  sync.Mutex
+	flags  map[string]Flag
+	loaded time.Time
+}
+
+// NewFileFlags returns flags of the payment group which are read from the
+// file. File contains JSON object which maps flag names to flags, for
+// example: {"signature_format_check": {"enabled": true, "groups":
+// {"default_group": false}, "percentage": 10}}.
+func NewFileFlags(path string, group string, refreshInterval time.Duration) Flags {
+	return &fileFlags{
+		path:            path,
+		group:           group,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+}
+
+func (flags *fileFlags) Enabled(name string, key string) bool {
+	flag, ok := flags.get(name)
+	if !ok {
+		return true
+	}
+	return flag.enabledFor(flags.group, name, key)
+}
+
+func (flags *fileFlags) get(name string) (flag Flag, ok bool) {
+	flags.mutex.Lock()
+	defer flags.mutex.Unlock()
+
+	if flags.flags == nil || flags.now().Sub(flags.loaded) >= flags.refreshInterval {
+		loaded, err := readFlagsFile(flags.path)
+		if err != nil {
+			log.WithError(err).WithField("path", flags.path).Warn("Unable to reload feature flags, previous version is used")
+			if flags.flags == nil {
+				flags.flags = make(map[string]Flag)
+			}
+		} else {
+			flags.flags = loaded
+		}
+		flags.loaded = flags.now()
+	}
+
+	flag, ok = flags.flags[name]
+	return
+}
+
+func (flag *Flag) enabledFor(group string, name string, key string) bool {
+	enabled := flag.Enabled
+	if groupEnabled, ok := flag.Groups[group]; ok {
+		enabled = groupEnabled
+	}
+	if !enabled || flag.Percentage == nil {
+		return enabled
+	}
+	return bucket(name, key) < *flag.Percentage
+}
+
+// bucket maps the key to the number in [0, 100), flag name is mixed in to
+// make rollouts of different flags independent
+func bucket(name string, key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % 100)
+}
+
+func readFlagsFile(path string) (flags map[string]Flag, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &flags); err != nil {
+		return nil, fmt.Errorf("cannot parse feature flags: %v", err)
+	}
+	for name, flag := range flags {
+		if flag.Percentage != nil && (*flag.Percentage < 0 || *flag.Percentage > 100) {
+			return nil, fmt.Errorf("percentage of feature flag %v is out of range: %v", name, *flag.Percentage)
+		}
+	}
+	if flags == nil {
+		flags = make(map[string]Flag)
+	}
+	return flags, nil
+}
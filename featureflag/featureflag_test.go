@@ -0,0 +1,103 @@
+package featureflag
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFlagsFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "feature-flags")
+	if err != nil {
+		t.Fatalf("Cannot create feature flags file: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatalf("Cannot write feature flags file: %v", err)
+	}
+	return file.Name()
+}
+
+func TestFileFlagsGroupOverride(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": true, "groups": {"group_b": false}}}`)
+	defer os.Remove(path)
+
+	assert.True(t, NewFileFlags(path, "group_a", time.Minute).Enabled("check", "1"))
+	assert.False(t, NewFileFlags(path, "group_b", time.Minute).Enabled("check", "1"))
+}
+
+func TestFileFlagsEnabledForGroupOnly(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": false, "groups": {"group_b": true}}}`)
+	defer os.Remove(path)
+
+	assert.False(t, NewFileFlags(path, "group_a", time.Minute).Enabled("check", "1"))
+	assert.True(t, NewFileFlags(path, "group_b", time.Minute).Enabled("check", "1"))
+}
+
+func TestFileFlagsUnknownFlagIsEnabled(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": false}}`)
+	defer os.Remove(path)
+
+	assert.True(t, NewFileFlags(path, "group_a", time.Minute).Enabled("another_check", "1"))
+}
+
+func TestFileFlagsPercentage(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": true, "percentage": 30}}`)
+	defer os.Remove(path)
+	flags := NewFileFlags(path, "group_a", time.Minute)
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if flags.Enabled("check", key) {
+			enabled++
+		}
+		assert.Equal(t, flags.Enabled("check", key), flags.Enabled("check", key))
+	}
+
+	assert.InDelta(t, 300, enabled, 60)
+}
+
+func TestFileFlagsZeroPercentage(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": true, "percentage": 0}}`)
+	defer os.Remove(path)
+
+	assert.False(t, NewFileFlags(path, "group_a", time.Minute).Enabled("check", "1"))
+}
+
+func TestFileFlagsReload(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": true}}`)
+	defer os.Remove(path)
+	flags := NewFileFlags(path, "group_a", time.Minute).(*fileFlags)
+	now := time.Now()
+	flags.now = func() time.Time { return now }
+	assert.True(t, flags.Enabled("check", "1"))
+
+	ioutil.WriteFile(path, []byte(`{"check": {"enabled": false}}`), 0600)
+	assert.True(t, flags.Enabled("check", "1"))
+
+	now = now.Add(time.Minute)
+	assert.False(t, flags.Enabled("check", "1"))
+}
+
+func TestFileFlagsKeepPreviousVersionOnError(t *testing.T) {
+	path := writeFlagsFile(t, `{"check": {"enabled": false}}`)
+	defer os.Remove(path)
+	flags := NewFileFlags(path, "group_a", time.Minute).(*fileFlags)
+	now := time.Now()
+	flags.now = func() time.Time { return now }
+	assert.False(t, flags.Enabled("check", "1"))
+
+	ioutil.WriteFile(path, []byte(`{"check": {"enabled": true, "percentage": 101}}`), 0600)
+	now = now.Add(time.Minute)
+
+	assert.False(t, flags.Enabled("check", "1"))
+}
+
+func TestAllEnabled(t *testing.T) {
+	assert.True(t, AllEnabled().Enabled("check", "1"))
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServices is a list of gRPC services which are used by service
+// provider to manage the daemon
+var AdminServices = []string{
+	"/escrow.ProviderControlService/",
+	"/configuration_service.ConfigurationService/",
+}
+
+// GrpcAdminClientCertInterceptor returns gRPC interceptor which allows calls
+// to AdminServices only if client presents TLS certificate with subject from
+// allowedSubjects. It is an additional check: admin methods still verify
+// admin signature. Empty allowedSubjects disables the check.
+func GrpcAdminClientCertInterceptor(allowedSubjects []string) grpc.UnaryServerInterceptor {
+	allowed := make(map[string]bool)
+	for _, subject := range allowedSubjects {
+		allowed[subject] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if len(allowed) == 0 || !isAdminMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		certificate := getPeerCertificate(ctx)
+		if certificate == nil {
+			log.WithField("method", info.FullMethod).Warn("Admin method is called without client certificate")
+			return nil, status.Newf(codes.PermissionDenied, "client certificate is required to call %v", info.FullMethod).Err()
+		}
+		if subject := certificate.Subject.String(); !allowed[subject] {
+			log.WithField("method", info.FullMethod).WithField("subject", subject).Warn("Admin method is called with certificate which is not allowed")
+			return nil, status.Newf(codes.PermissionDenied, "client certificate \"%v\" is not allowed to call %v", subject, info.FullMethod).Err()
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// GrpcPublicEndpointInterceptor returns gRPC interceptor which rejects calls
+// to AdminServices. It is used on the public listener when admin services are
+// served on the separate admin listener, otherwise such calls would be
+// passed to the service as unknown methods.
+func GrpcPublicEndpointInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isAdminMethod(info.FullMethod) {
+			log.WithField("method", info.FullMethod).Warn("Admin method is called on public endpoint")
+			return status.Newf(codes.Unimplemented, "method %v is not served on this endpoint", info.FullMethod).Err()
+		}
+		return handler(srv, ss)
+	}
+}
+
+func isAdminMethod(method string) bool {
+	for _, service := range AdminServices {
+		if strings.HasPrefix(method, service) {
+			return true
+		}
+	}
+	return false
+}
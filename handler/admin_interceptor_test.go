@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func contextWithClientCert(commonName string) context.Context {
+	certificate := &x509.Certificate{
+		Subject: pkix.Name{CommonName: commonName, Organization: []string{"Provider"}},
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}},
+	})
+}
+
+func callAdminInterceptor(ctx context.Context, method string) (handlerCalled bool, err error) {
+	interceptor := GrpcAdminClientCertInterceptor([]string{"CN=admin,O=Provider"})
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return nil, nil
+	})
+	return
+}
+
+func TestAdminClientCertAllowed(t *testing.T) {
+	called, err := callAdminInterceptor(contextWithClientCert("admin"), "/escrow.ProviderControlService/StartClaim")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestAdminClientCertNotAllowed(t *testing.T) {
+	called, err := callAdminInterceptor(contextWithClientCert("client"), "/escrow.ProviderControlService/StartClaim")
+
+	assert.Equal(t, status.Newf(codes.PermissionDenied, "client certificate \"CN=client,O=Provider\" is not allowed to call /escrow.ProviderControlService/StartClaim").Err(), err)
+	assert.False(t, called)
+}
+
+func TestAdminClientCertMissing(t *testing.T) {
+	called, err := callAdminInterceptor(context.Background(), "/configuration_service.ConfigurationService/UpdateConfiguration")
+
+	assert.Equal(t, status.Newf(codes.PermissionDenied, "client certificate is required to call /configuration_service.ConfigurationService/UpdateConfiguration").Err(), err)
+	assert.False(t, called)
+}
+
+func TestAdminClientCertNotAdminMethod(t *testing.T) {
+	called, err := callAdminInterceptor(context.Background(), "/escrow.PaymentChannelStateService/GetChannelState")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestPublicEndpointRejectsAdminMethod(t *testing.T) {
+	err := callWithChannel(GrpcPublicEndpointInterceptor(), "/configuration_service.ConfigurationService/GetConfiguration", "1")
+
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestPublicEndpointPassesServiceMethod(t *testing.T) {
+	err := callWithChannel(GrpcPublicEndpointInterceptor(), "/example_service.Calculator/add", "1")
+
+	assert.Nil(t, err)
+}
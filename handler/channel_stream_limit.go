@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcChannelStreamLimitInterceptor returns gRPC interceptor which limits
+// number of calls which are served concurrently using the same payment
+// channel. Unlike connection level stream limits it cannot be dodged by
+// opening many connections. Limit is kept in memory, so it is applied per
+// daemon replica. It should be chained before payment validation
+// interceptor, so rejected calls don't contend for the channel. Calls
+// without correct payment channel id are not limited. If maxStreams is not
+// positive then NoOpInterceptor is returned.
+func GrpcChannelStreamLimitInterceptor(maxStreams int) grpc.StreamServerInterceptor {
+	if maxStreams <= 0 {
+		return NoOpInterceptor
+	}
+
+	limiter := &channelStreamLimiter{
+		maxStreams: maxStreams,
+		streams:    make(map[string]int),
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		if len(md.Get(PaymentChannelIDHeader)) == 0 {
+			return handler(srv, ss)
+		}
+		// id is normalized, so the same channel cannot be passed as a
+		// different string; incorrect id is rejected by payment validation
+		channelIDValue, err := GetBigInt(md, PaymentChannelIDHeader)
+		if err != nil {
+			return handler(srv, ss)
+		}
+		channelID := channelIDValue.String()
+
+		if !limiter.acquire(channelID) {
+			log.WithField("channelID", channelID).WithField("method", info.FullMethod).Info("Limit of concurrent streams per channel is reached")
+			return status.Newf(codes.ResourceExhausted, "limit of %v concurrent streams per payment channel is reached", maxStreams).Err()
+		}
+		defer limiter.release(channelID)
+
+		return handler(srv, ss)
+	}
+}
+
+type channelStreamLimiter struct {
+	maxStreams int
+	mutex      sync.Mutex
+	streams    map[string]int
+}
+
+func (limiter *channelStreamLimiter) acquire(channelID string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.streams[channelID] >= limiter.maxStreams {
+		return false
+	}
+	limiter.streams[channelID]++
+	return true
+}
+
+func (limiter *channelStreamLimiter) release(channelID string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.streams[channelID] <= 1 {
+		delete(limiter.streams, channelID)
+		return
+	}
+	limiter.streams[channelID]--
+}
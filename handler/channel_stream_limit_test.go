@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startStream starts call which is served until returned function is called
+func startStream(interceptor grpc.StreamServerInterceptor, channelID string) (finish func() error) {
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	result := make(chan error, 1)
+	ss := &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(PaymentChannelIDHeader, channelID))}
+	go func() {
+		result <- interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/stream", IsServerStream: true},
+			func(srv interface{}, stream grpc.ServerStream) error {
+				close(started)
+				<-finished
+				return nil
+			})
+	}()
+
+	select {
+	case <-started:
+	case err := <-result:
+		return func() error { return err }
+	}
+	return func() error {
+		close(finished)
+		return <-result
+	}
+}
+
+func TestChannelStreamLimit(t *testing.T) {
+	interceptor := GrpcChannelStreamLimitInterceptor(2)
+
+	finishA := startStream(interceptor, "1")
+	finishB := startStream(interceptor, "1")
+	errC := startStream(interceptor, "1")()
+	finishOtherChannel := startStream(interceptor, "2")
+
+	assert.Equal(t, status.New(codes.ResourceExhausted, "limit of 2 concurrent streams per payment channel is reached").Err(), errC)
+	assert.Nil(t, finishA())
+	assert.Nil(t, startStream(interceptor, "1")())
+	assert.Nil(t, finishB())
+	assert.Nil(t, finishOtherChannel())
+}
+
+func TestChannelStreamLimitNormalizesChannelID(t *testing.T) {
+	interceptor := GrpcChannelStreamLimitInterceptor(1)
+
+	finishA := startStream(interceptor, "1")
+	errB := startStream(interceptor, "01")()
+	errC := startStream(interceptor, "0x1")()
+
+	assert.Equal(t, status.New(codes.ResourceExhausted, "limit of 1 concurrent streams per payment channel is reached").Err(), errB)
+	assert.Equal(t, status.New(codes.ResourceExhausted, "limit of 1 concurrent streams per payment channel is reached").Err(), errC)
+	assert.Nil(t, finishA())
+}
+
+func TestChannelStreamLimitIgnoresCallsWithoutChannel(t *testing.T) {
+	interceptor := GrpcChannelStreamLimitInterceptor(1)
+
+	finishA := startStream(interceptor, "")
+	errB := startStream(interceptor, "")()
+
+	assert.Nil(t, errB)
+	assert.Nil(t, finishA())
+}
+
+func TestChannelStreamLimitDisabled(t *testing.T) {
+	interceptor := GrpcChannelStreamLimitInterceptor(0)
+
+	finishA := startStream(interceptor, "1")
+	errB := startStream(interceptor, "1")()
+
+	assert.Nil(t, errB)
+	assert.Nil(t, finishA())
+}
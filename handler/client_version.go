@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ClientVersionHeader is a version of the client SDK which makes the
+	// call, for example "1.2.0".
+	ClientVersionHeader = "snet-client-version"
+	// ClientVersionWarningTrailer is added to the response trailer when
+	// client version is not supported and check is advisory. Value is a
+	// description of the problem.
+	ClientVersionWarningTrailer = "snet-client-version-warning"
+
+	// CLIENT_VERSION_CHECK_WARN logs unsupported versions and warns client
+	// via response trailer
+	CLIENT_VERSION_CHECK_WARN = "warn"
+	// CLIENT_VERSION_CHECK_ENFORCE rejects calls of unsupported versions
+	CLIENT_VERSION_CHECK_ENFORCE = "enforce"
+)
+
+// ClientVersionRule lists supported versions of the client of given type.
+type ClientVersionRule struct {
+	// ClientType is a value of ClientTypeHeader the rule is applied to
+	ClientType string `mapstructure:"client_type"`
+	// MinVersion is a minimal supported version, empty means any
+	MinVersion string `mapstructure:"min_version"`
+	// BlockedVersions is a list of versions with known issues
+	BlockedVersions []string `mapstructure:"blocked_versions"`
+	// RequireVersion makes calls without ClientVersionHeader unsupported,
+	// otherwise they are not checked
+	RequireVersion bool `mapstructure:"require_version"`
+}
+
+// GrpcClientVersionInterceptor returns gRPC interceptor which checks client
+// version against rules. Calls without client type are not checked, calls
+// without version are checked only if rule requires version. Depending on
+// mode unsupported version is rejected or call is proceeded with a warning.
+func GrpcClientVersionInterceptor(rules []ClientVersionRule, mode string) (interceptor grpc.StreamServerInterceptor, err error) {
+	if mode != CLIENT_VERSION_CHECK_WARN && mode != CLIENT_VERSION_CHECK_ENFORCE {
+		return nil, fmt.Errorf("unexpected client version check mode: \"%v\"", mode)
+	}
+	if len(rules) == 0 {
+		return NoOpInterceptor, nil
+	}
+
+	rulesByType := make(map[string]ClientVersionRule)
+	for _, rule := range rules {
+		if rule.MinVersion != "" {
+			if _, e := parseVersion(rule.MinVersion); e != nil {
+				return nil, fmt.Errorf("incorrect minimal version of client %v: %v", rule.ClientType, e)
+			}
+		}
+		rulesByType[rule.ClientType] = rule
+		log.WithField("rule", rule).WithField("mode", mode).Info("Client version rule is set")
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		clientType := firstValue(md, ClientTypeHeader)
+		version := firstValue(md, ClientVersionHeader)
+		rule, ok := rulesByType[clientType]
+		if !ok || (version == "" && !rule.RequireVersion) {
+			return handler(srv, ss)
+		}
+
+		problem := checkClientVersion(rule, version)
+		if problem == "" {
+			return handler(srv, ss)
+		}
+
+		log := log.WithField("clientType", clientType).WithField("clientVersion", version).WithField("method", info.FullMethod)
+		if mode == CLIENT_VERSION_CHECK_ENFORCE {
+			log.Warn("Call from unsupported client version is rejected")
+			return status.New(codes.FailedPrecondition, problem).Err()
+		}
+		log.Warn("Call from unsupported client version")
+		ss.SetTrailer(metadata.Pairs(ClientVersionWarningTrailer, problem))
+		return handler(srv, ss)
+	}, nil
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// checkClientVersion returns description of the problem with the version
+// or empty string if version is supported
+func checkClientVersion(rule ClientVersionRule, version string) string {
+	if version == "" {
+		return fmt.Sprintf("client %v doesn't pass its version in %v metadata, please upgrade", rule.ClientType, ClientVersionHeader)
+	}
+	for _, blocked := range rule.BlockedVersions {
+		if compareVersions(version, blocked) == 0 {
+			return fmt.Sprintf("client %v version %v has known issues and is not supported, please upgrade", rule.ClientType, version)
+		}
+	}
+	if rule.MinVersion != "" && compareVersions(version, rule.MinVersion) < 0 {
+		return fmt.Sprintf("client %v version %v is too old, please upgrade to %v or newer", rule.ClientType, version, rule.MinVersion)
+	}
+	return ""
+}
+
+// compareVersions compares dot separated numeric versions, incorrect
+// version is considered older than any correct one
+func compareVersions(a, b string) int {
+	partsA, errA := parseVersion(a)
+	partsB, errB := parseVersion(b)
+	if errA != nil || errB != nil {
+		switch {
+		case errA != nil && errB != nil:
+			return strings.Compare(a, b)
+		case errA != nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var partA, partB int
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+		if partA != partB {
+			if partA < partB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion parses version like "v1.2.3", pre-release and build suffixes
+// after "-" or "+" are ignored
+func parseVersion(version string) (parts []int, err error) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	for _, part := range strings.Split(version, ".") {
+		number, e := strconv.Atoi(part)
+		if e != nil || number < 0 {
+			return nil, fmt.Errorf("incorrect version format: %v", version)
+		}
+		parts = append(parts, number)
+	}
+	return parts, nil
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var clientVersionTestRules = []ClientVersionRule{
+	{ClientType: "snet-sdk", MinVersion: "1.2.0", BlockedVersions: []string{"1.3.1"}},
+}
+
+func callWithClientVersion(interceptor grpc.StreamServerInterceptor, clientType, version string) (ss *serverStreamMock, called bool, err error) {
+	ss = &serverStreamMock{context: metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(ClientTypeHeader, clientType, ClientVersionHeader, version))}
+	err = interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/add"}, func(srv interface{}, stream grpc.ServerStream) error {
+		called = true
+		return nil
+	})
+	return
+}
+
+func TestClientVersionAllowed(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor(clientVersionTestRules, CLIENT_VERSION_CHECK_ENFORCE)
+	assert.Nil(t, err)
+
+	ss, called, err := callWithClientVersion(interceptor, "snet-sdk", "v1.10.0")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+	assert.Nil(t, ss.trailer)
+}
+
+func TestClientVersionWarned(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor(clientVersionTestRules, CLIENT_VERSION_CHECK_WARN)
+	assert.Nil(t, err)
+
+	ss, called, err := callWithClientVersion(interceptor, "snet-sdk", "1.1.9")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+	assert.Equal(t, []string{"client snet-sdk version 1.1.9 is too old, please upgrade to 1.2.0 or newer"}, ss.trailer.Get(ClientVersionWarningTrailer))
+}
+
+func TestClientVersionBlocked(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor(clientVersionTestRules, CLIENT_VERSION_CHECK_ENFORCE)
+	assert.Nil(t, err)
+
+	_, called, err := callWithClientVersion(interceptor, "snet-sdk", "1.3.1")
+
+	assert.False(t, called)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, "client snet-sdk version 1.3.1 has known issues and is not supported, please upgrade", status.Convert(err).Message())
+}
+
+func TestClientVersionMissingNotChecked(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor(clientVersionTestRules, CLIENT_VERSION_CHECK_ENFORCE)
+	assert.Nil(t, err)
+
+	_, called, err := callWithClientVersion(interceptor, "snet-sdk", "")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestClientVersionMissingRequired(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor([]ClientVersionRule{
+		{ClientType: "snet-sdk", MinVersion: "1.2.0", RequireVersion: true},
+	}, CLIENT_VERSION_CHECK_ENFORCE)
+	assert.Nil(t, err)
+
+	_, called, err := callWithClientVersion(interceptor, "snet-sdk", "")
+
+	assert.False(t, called)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, "client snet-sdk doesn't pass its version in snet-client-version metadata, please upgrade", status.Convert(err).Message())
+}
+
+func TestClientVersionOtherClientType(t *testing.T) {
+	interceptor, err := GrpcClientVersionInterceptor(clientVersionTestRules, CLIENT_VERSION_CHECK_ENFORCE)
+	assert.Nil(t, err)
+
+	_, called, err := callWithClientVersion(interceptor, "snet-cli", "0.1.0")
+
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestClientVersionIncorrectMode(t *testing.T) {
+	_, err := GrpcClientVersionInterceptor(clientVersionTestRules, "ignore")
+
+	assert.Equal(t, "unexpected client version check mode: \"ignore\"", err.Error())
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("1.2", "1.2.0"))
+	assert.Equal(t, -1, compareVersions("1.2.0", "1.10.0"))
+	assert.Equal(t, 1, compareVersions("v2.0.0-beta", "1.9.9"))
+	assert.Equal(t, -1, compareVersions("unknown", "0.0.1"))
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/singnet/snet-daemon/config"
 	"github.com/singnet/snet-daemon/configuration_service"
+	"github.com/singnet/snet-daemon/featureflag"
 	"github.com/singnet/snet-daemon/metrics"
 	"github.com/singnet/snet-daemon/ratelimit"
 	log "github.com/sirupsen/logrus"
@@ -41,6 +44,40 @@ const (
 	// PaymentChannelSignatureHeader is a signature of the client to confirm
 	// amount withdrawing authorization. Value is an array of bytes.
 	PaymentChannelSignatureHeader = "snet-payment-channel-signature-bin"
+	// PaymentChannelExpirationTrailer is added to the response trailer when
+	// payment channel is near to be expired. Value is an expiration block
+	// number of the channel.
+	PaymentChannelExpirationTrailer = "snet-payment-channel-expiration"
+	// PaymentChannelRemainingBlocksTrailer is added to the response trailer
+	// together with PaymentChannelExpirationTrailer. Value is a number of
+	// blocks before channel expiration.
+	PaymentChannelRemainingBlocksTrailer = "snet-payment-channel-remaining-blocks"
+	// PaymentChannelExpirationTimeTrailer is added to the response trailer
+	// together with PaymentChannelExpirationTrailer when average block time
+	// is configured. Value is an estimated expiration time of the channel in
+	// RFC 3339 format.
+	PaymentChannelExpirationTimeTrailer = "snet-payment-channel-expiration-time"
+	// PaymentRequestSignatureHeader is a signature of the client which binds
+	// payment to the request content. It is required only when daemon checks
+	// request content. Value is an array of bytes.
+	PaymentRequestSignatureHeader = "snet-payment-request-signature-bin"
+	// PaymentDaemonIdHeader is an optional id of the daemon the payment is
+	// bound to. When it is passed the id is a part of the signed message.
+	// Value is a string.
+	PaymentDaemonIdHeader = "snet-payment-daemon-id"
+	// PaymentMpeContractAddressHeader is an optional address of the
+	// MultiPartyEscrow contract the payment is signed for. When it is not
+	// passed the MPE contract of the network is used. Payments signed for
+	// other contracts are rejected because daemon tracks channels of the
+	// network contract only. Value is a string.
+	PaymentMpeContractAddressHeader = "snet-payment-mpe-contract-address"
+	// PaymentMessageTypeHeader is an optional type of the message layout
+	// signed by client, "v1" is used when it is not passed. Value is a
+	// string.
+	PaymentMessageTypeHeader = "snet-payment-message-type"
+	// PaymentGroupIdHeader is an id of the payment group which is a part of
+	// the signed message of "v2" type. Value is a base64 encoded string.
+	PaymentGroupIdHeader = "snet-payment-group-id"
 
 	//Added for free call support in Daemon
 
@@ -59,10 +96,31 @@ const (
 type GrpcStreamContext struct {
 	MD   metadata.MD
 	Info *grpc.StreamServerInfo
+	// Context is a context of the gRPC call, it is done when call is
+	// cancelled or its deadline is exceeded
+	Context context.Context
+	// PeerIdentity is a subject and alternative names of the client TLS
+	// certificate, it is empty if client certificate is not used
+	PeerIdentity string
+	// PeerAddress is a network address of the client without port, it is
+	// empty if address is unknown
+	PeerAddress string
+	// RequestHash is a Keccak256 hash of the first request message, it is
+	// set only when request content check is enabled
+	RequestHash []byte
 }
 
 func (context *GrpcStreamContext) String() string {
-	return fmt.Sprintf("{MD: %v, Info: %v", context.MD, *context.Info)
+	return fmt.Sprintf("{MD: %v, Info: %v, PeerIdentity: %v, PeerAddress: %v, RequestHash: %x}", context.MD, context.Info, context.PeerIdentity, context.PeerAddress, context.RequestHash)
+}
+
+// RequestContext returns context of the gRPC call, background context if
+// it is not set
+func (grpcContext *GrpcStreamContext) RequestContext() context.Context {
+	if grpcContext.Context == nil {
+		return context.Background()
+	}
+	return grpcContext.Context
 }
 
 // Payment represents payment handler specific data which is validated
@@ -132,6 +190,23 @@ type PaymentHandler interface {
 	CompleteAfterError(payment Payment, result error) (err *GrpcError)
 }
 
+// RequiredMetadataProvider is an optional interface of PaymentHandler. When
+// payment handler implements it interceptor checks that all required
+// metadata keys are present before calling PaymentHandler.Payment().
+type RequiredMetadataProvider interface {
+	// RequiredMetadata returns list of metadata keys which should be passed
+	// by client to pay using this payment handler.
+	RequiredMetadata() []string
+}
+
+// TrailerProvider is an optional interface of Payment. When payment
+// implements it interceptor adds returned metadata to the response trailer.
+type TrailerProvider interface {
+	// Trailer returns metadata to add to the response trailer, nil if
+	// there is nothing to add
+	Trailer() metadata.MD
+}
+
 type rateLimitInterceptor struct {
 	rateLimiter           rate.Limiter
 	messageBroadcaster    *configuration_service.MessageBroadcaster
@@ -209,6 +284,9 @@ func GrpcPaymentValidationInterceptor(defaultPaymentHandler PaymentHandler, paym
 	interceptor := &paymentValidationInterceptor{
 		defaultPaymentHandler: defaultPaymentHandler,
 		paymentHandlers:       make(map[string]PaymentHandler),
+		checkRequiredMetadata: config.GetBool(config.PaymentMetadataPresenceCheckEnabled),
+		hashRequestContent:    config.GetBool(config.PaymentRequestContentCheckEnabled),
+		flags:                 featureflag.NewFlagsFromConfig(config.Vip()),
 	}
 
 	interceptor.paymentHandlers[defaultPaymentHandler.Type()] = defaultPaymentHandler
@@ -218,6 +296,14 @@ func GrpcPaymentValidationInterceptor(defaultPaymentHandler PaymentHandler, paym
 		log.WithField("paymentType", handler.Type()).Info("Payment handler for type registered")
 	}
 
+	var methodPaymentTypes []MethodPaymentTypes
+	if err := config.Vip().UnmarshalKey(config.MethodPaymentTypes, &methodPaymentTypes); err != nil {
+		log.WithError(err).Panic("error during method payment types parsing")
+	}
+	if err := interceptor.setMethodPaymentTypes(methodPaymentTypes); err != nil {
+		log.WithError(err).Panic("incorrect method payment types")
+	}
+
 	return interceptor.intercept
 
 }
@@ -225,6 +311,46 @@ func GrpcPaymentValidationInterceptor(defaultPaymentHandler PaymentHandler, paym
 type paymentValidationInterceptor struct {
 	defaultPaymentHandler PaymentHandler
 	paymentHandlers       map[string]PaymentHandler
+	checkRequiredMetadata bool
+	// hashRequestContent enables reading of the first request message
+	// before payment validation to pass its hash to the payment handler
+	hashRequestContent bool
+	// flags can disable metadata presence check for a part of the traffic
+	flags featureflag.Flags
+	// methodPaymentTypes keeps ordered list of payment handlers accepted
+	// by method, methods which are not listed accept any payment type
+	methodPaymentTypes map[string][]PaymentHandler
+}
+
+// MethodPaymentTypes is an ordered list of payment types accepted by the
+// service method. When client doesn't set payment type explicitly the
+// payment is validated by the first type client presented metadata for,
+// next types are tried if validation fails.
+type MethodPaymentTypes struct {
+	// Method is a full gRPC method name: /<package>.<service>/<method>
+	Method string `mapstructure:"method"`
+	// PaymentTypes are payment types in the order of priority
+	PaymentTypes []string `mapstructure:"payment_types"`
+}
+
+func (interceptor *paymentValidationInterceptor) setMethodPaymentTypes(methodPaymentTypes []MethodPaymentTypes) error {
+	interceptor.methodPaymentTypes = make(map[string][]PaymentHandler)
+	for _, method := range methodPaymentTypes {
+		if len(method.PaymentTypes) == 0 {
+			return fmt.Errorf("no payment types are set for method \"%v\"", method.Method)
+		}
+		handlers := make([]PaymentHandler, 0, len(method.PaymentTypes))
+		for _, paymentType := range method.PaymentTypes {
+			paymentHandler, ok := interceptor.paymentHandlers[paymentType]
+			if !ok {
+				return fmt.Errorf("unknown payment type \"%v\" of method \"%v\"", paymentType, method.Method)
+			}
+			handlers = append(handlers, paymentHandler)
+		}
+		interceptor.methodPaymentTypes[method.Method] = handlers
+		log.WithField("method", method.Method).WithField("paymentTypes", method.PaymentTypes).Info("Method payment types are set")
+	}
+	return nil
 }
 
 func (interceptor *paymentValidationInterceptor) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (e error) {
@@ -236,23 +362,47 @@ func (interceptor *paymentValidationInterceptor) intercept(srv interface{}, ss g
 	}
 	log.WithField("context", context).Debug("New gRPC call received")
 
-	paymentHandler, err := interceptor.getPaymentHandler(context)
+	paymentHandlers, fallback, err := interceptor.getPaymentHandlers(context)
 	if err != nil {
 		return err.Err()
 	}
 
-	payment, err := paymentHandler.Payment(context)
+	if !fallback && interceptor.checkRequiredMetadata &&
+		interceptor.flags.Enabled(featureflag.MetadataPresenceCheck, firstValue(context.MD, PaymentChannelIDHeader)) {
+		if err = checkRequiredMetadata(context, paymentHandlers[0]); err != nil {
+			return err.Err()
+		}
+	}
+
+	if interceptor.hashRequestContent {
+		if context.RequestHash, ss, err = readRequestContent(ss); err != nil {
+			return err.Err()
+		}
+	}
+
+	paymentHandler, payment, err := validatePayment(context, paymentHandlers)
 	if err != nil {
 		return err.Err()
 	}
 
+	metering, isMetering := paymentHandler.(MeteringPaymentHandler)
+	var meteredStream *meteringStream
+	if isMetering {
+		meteredStream = &meteringStream{ServerStream: ss}
+		ss = meteredStream
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.WithField("panicValue", r).Warn("Service handler called panic(panicValue)")
 			paymentHandler.CompleteAfterError(payment, fmt.Errorf("Service handler called panic(%v)", r))
 			panic("re-panic after payment handler error handling")
 		} else if e == nil {
-			err = paymentHandler.Complete(payment)
+			if isMetering {
+				err = metering.CompleteWithStats(payment, meteredStream.stats())
+			} else {
+				err = paymentHandler.Complete(payment)
+			}
 			if err != nil {
 				// return err.Err()
 				e = err.Err()
@@ -268,6 +418,12 @@ func (interceptor *paymentValidationInterceptor) intercept(srv interface{}, ss g
 
 	log.WithField("payment", payment).Debug("New payment received")
 
+	if provider, ok := payment.(TrailerProvider); ok {
+		if trailer := provider.Trailer(); len(trailer) > 0 {
+			ss.SetTrailer(trailer)
+		}
+	}
+
 	e = handler(srv, ss)
 	if e != nil {
 		log.WithError(e).Warn("gRPC handler returned error")
@@ -285,11 +441,73 @@ func getGrpcContext(serverStream grpc.ServerStream, info *grpc.StreamServerInfo)
 	}
 
 	return &GrpcStreamContext{
-		MD:   md,
-		Info: info,
+		MD:           md,
+		Info:         info,
+		Context:      serverStream.Context(),
+		PeerIdentity: getPeerIdentity(serverStream.Context()),
+		PeerAddress:  getPeerAddress(serverStream.Context()),
 	}, nil
 }
 
+// getPaymentHandlers returns payment handlers to try in order. When method
+// has payment types set and client doesn't choose payment type, fallback is
+// true and handlers client presented all required metadata for are returned.
+func (interceptor *paymentValidationInterceptor) getPaymentHandlers(context *GrpcStreamContext) (handlers []PaymentHandler, fallback bool, err *GrpcError) {
+	var accepted []PaymentHandler
+	if context.Info != nil {
+		accepted = interceptor.methodPaymentTypes[context.Info.FullMethod]
+	}
+	if len(accepted) == 0 {
+		handler, err := interceptor.getPaymentHandler(context)
+		if err != nil {
+			return nil, false, err
+		}
+		return []PaymentHandler{handler}, false, nil
+	}
+
+	if paymentType := firstValue(context.MD, PaymentTypeHeader); paymentType != "" {
+		for _, handler := range accepted {
+			if handler.Type() == paymentType {
+				return []PaymentHandler{handler}, false, nil
+			}
+		}
+		log.WithField("method", context.Info.FullMethod).WithField("paymentType", paymentType).Warn("Payment type is not accepted by method")
+		return nil, false, NewGrpcErrorf(codes.InvalidArgument, "payment type \"%v\" is not accepted by method %v", paymentType, context.Info.FullMethod)
+	}
+
+	for _, handler := range accepted {
+		if len(missingMetadata(context, handler)) == 0 {
+			handlers = append(handlers, handler)
+		}
+	}
+	if len(handlers) == 0 {
+		types := make([]string, 0, len(accepted))
+		for _, handler := range accepted {
+			types = append(types, handler.Type())
+		}
+		return nil, true, NewGrpcErrorf(codes.InvalidArgument, "no payment metadata for payment types accepted by method %v: %v", context.Info.FullMethod, strings.Join(types, ", "))
+	}
+	return handlers, true, nil
+}
+
+// validatePayment returns payment of the first handler which accepts it,
+// when all handlers reject payment error of the first one is returned
+func validatePayment(context *GrpcStreamContext, handlers []PaymentHandler) (handler PaymentHandler, payment Payment, err *GrpcError) {
+	var firstErr *GrpcError
+	for _, handler := range handlers {
+		payment, err = handler.Payment(context)
+		if err == nil {
+			return handler, payment, nil
+		}
+		log.WithField("paymentType", handler.Type()).WithField("peerIdentity", context.PeerIdentity).
+			WithField("status", err.Status).Warn("Payment is rejected")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, nil, firstErr
+}
+
 func (interceptor *paymentValidationInterceptor) getPaymentHandler(context *GrpcStreamContext) (handler PaymentHandler, err *GrpcError) {
 	paymentTypeMd, ok := context.MD[PaymentTypeHeader]
 	if !ok || len(paymentTypeMd) == 0 {
@@ -308,6 +526,33 @@ func (interceptor *paymentValidationInterceptor) getPaymentHandler(context *Grpc
 	return paymentHandler, nil
 }
 
+// checkRequiredMetadata returns error which lists all required payment
+// metadata keys missed in the request
+func checkRequiredMetadata(context *GrpcStreamContext, paymentHandler PaymentHandler) *GrpcError {
+	missing := missingMetadata(context, paymentHandler)
+	if len(missing) > 0 {
+		log.WithField("paymentType", paymentHandler.Type()).WithField("missing", missing).Warn("Required payment metadata is missing")
+		return NewGrpcErrorf(codes.InvalidArgument, "missing payment metadata for payment type \"%v\": %v", paymentHandler.Type(), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingMetadata returns required metadata keys of the payment handler
+// which are missed in the request
+func missingMetadata(context *GrpcStreamContext, paymentHandler PaymentHandler) (missing []string) {
+	provider, ok := paymentHandler.(RequiredMetadataProvider)
+	if !ok {
+		return nil
+	}
+
+	for _, key := range provider.RequiredMetadata() {
+		if len(context.MD.Get(key)) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	return
+}
+
 // GetBigInt gets big.Int value from gRPC metadata
 func GetBigInt(md metadata.MD, key string) (value *big.Int, err *GrpcError) {
 	str, err := GetSingleValue(md, key)
@@ -388,4 +633,5 @@ func setAdditionalDetails(context *GrpcStreamContext, stats *metrics.CommonStats
 	if str, err := GetSingleValue(md, FreeCallUserIdHeader); err == nil {
 		stats.UserName = str
 	}
+	stats.PeerIdentity = context.PeerIdentity
 }
@@ -2,20 +2,27 @@ package handler
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"math/big"
 	"testing"
 
+	"github.com/singnet/snet-daemon/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type serverStreamMock struct {
 	context context.Context
+	trailer metadata.MD
 }
 
 func (m *serverStreamMock) Context() context.Context {
@@ -30,7 +37,8 @@ func (m *serverStreamMock) SendHeader(metadata.MD) error {
 	return errors.New("not implemented in mock")
 }
 
-func (m *serverStreamMock) SetTrailer(metadata.MD) {
+func (m *serverStreamMock) SetTrailer(trailer metadata.MD) {
+	m.trailer = metadata.Join(m.trailer, trailer)
 }
 
 func (m *serverStreamMock) SendMsg(interface{}) error {
@@ -47,6 +55,11 @@ const (
 )
 
 type paymentMock struct {
+	trailer metadata.MD
+}
+
+func (payment *paymentMock) Trailer() metadata.MD {
+	return payment.trailer
 }
 
 type paymentHandlerMock struct {
@@ -56,6 +69,7 @@ type paymentHandlerMock struct {
 	completeResult           *GrpcError
 	completeAfterErrorResult *GrpcError
 	paymentResult            *GrpcError
+	paymentTrailer           metadata.MD
 	payment                  *paymentMock
 }
 
@@ -65,6 +79,7 @@ func (handler *paymentHandlerMock) reset() {
 	handler.completeResult = nil
 	handler.completeAfterErrorResult = nil
 	handler.paymentResult = nil
+	handler.paymentTrailer = nil
 	handler.payment = nil
 }
 
@@ -76,7 +91,7 @@ func (handler *paymentHandlerMock) Payment(context *GrpcStreamContext) (payment
 	if handler.paymentResult != nil {
 		return nil, handler.paymentResult
 	}
-	handler.payment = &paymentMock{}
+	handler.payment = &paymentMock{trailer: handler.paymentTrailer}
 	return handler.payment, nil
 }
 
@@ -96,6 +111,15 @@ func (handler *paymentHandlerMock) CompleteAfterError(payment Payment, result er
 	return handler.completeAfterErrorResult
 }
 
+type requiredMetadataPaymentHandlerMock struct {
+	paymentHandlerMock
+	requiredMetadata []string
+}
+
+func (handler *requiredMetadataPaymentHandlerMock) RequiredMetadata() []string {
+	return handler.requiredMetadata
+}
+
 type InterceptorsSuite struct {
 	suite.Suite
 
@@ -243,6 +267,25 @@ func (suite *InterceptorsSuite) TestCompleteOnHandlerSuccess() {
 	assert.False(suite.T(), suite.paymentHandler.completeAfterErrorCalled)
 }
 
+func (suite *InterceptorsSuite) TestPaymentTrailerIsAdded() {
+	suite.paymentHandler.paymentTrailer = metadata.Pairs(PaymentChannelExpirationTrailer, "100", PaymentChannelRemainingBlocksTrailer, "1")
+	serverStream := &serverStreamMock{context: suite.serverStream.Context()}
+
+	err := suite.interceptor(nil, serverStream, nil, suite.successHandler)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), metadata.Pairs(PaymentChannelExpirationTrailer, "100", PaymentChannelRemainingBlocksTrailer, "1"), serverStream.trailer)
+}
+
+func (suite *InterceptorsSuite) TestNoPaymentTrailer() {
+	serverStream := &serverStreamMock{context: suite.serverStream.Context()}
+
+	err := suite.interceptor(nil, serverStream, nil, suite.successHandler)
+
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), serverStream.trailer)
+}
+
 func (suite *InterceptorsSuite) TestCompleteReturnsError() {
 	suite.paymentHandler.completeResult = NewGrpcError(codes.Internal, "test error")
 
@@ -266,3 +309,201 @@ func (suite *InterceptorsSuite) TestPaymentReturnsError() {
 
 	assert.Equal(suite.T(), status.Newf(codes.Internal, "test error").Err(), err)
 }
+
+func (suite *InterceptorsSuite) requiredMetadataInterceptor() (grpc.StreamServerInterceptor, *requiredMetadataPaymentHandlerMock) {
+	paymentHandler := &requiredMetadataPaymentHandlerMock{
+		paymentHandlerMock: paymentHandlerMock{typ: testPaymentHandlerType},
+		requiredMetadata:   []string{PaymentChannelIDHeader, PaymentChannelNonceHeader, PaymentChannelSignatureHeader},
+	}
+	return GrpcPaymentValidationInterceptor(suite.defaultPaymentHandler, paymentHandler), paymentHandler
+}
+
+func (suite *InterceptorsSuite) TestRequiredMetadataMissingChannelId() {
+	interceptor, _ := suite.requiredMetadataInterceptor()
+	serverStream := &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		PaymentTypeHeader, testPaymentHandlerType,
+		PaymentChannelNonceHeader, "3",
+		PaymentChannelSignatureHeader, "signature"))}
+
+	err := interceptor(nil, serverStream, nil, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.InvalidArgument, "missing payment metadata for payment type \"test-payment-handler\": snet-payment-channel-id").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestRequiredMetadataMissingSignature() {
+	interceptor, _ := suite.requiredMetadataInterceptor()
+	serverStream := &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		PaymentTypeHeader, testPaymentHandlerType,
+		PaymentChannelIDHeader, "42",
+		PaymentChannelNonceHeader, "3"))}
+
+	err := interceptor(nil, serverStream, nil, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.InvalidArgument, "missing payment metadata for payment type \"test-payment-handler\": snet-payment-channel-signature-bin").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestRequiredMetadataAllMissing() {
+	interceptor, paymentHandler := suite.requiredMetadataInterceptor()
+
+	err := interceptor(nil, suite.serverStream, nil, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.InvalidArgument, "missing payment metadata for payment type \"test-payment-handler\": snet-payment-channel-id, snet-payment-channel-nonce, snet-payment-channel-signature-bin").Err(), err)
+	assert.Nil(suite.T(), paymentHandler.payment)
+}
+
+func (suite *InterceptorsSuite) TestRequiredMetadataPresent() {
+	interceptor, paymentHandler := suite.requiredMetadataInterceptor()
+	serverStream := &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		PaymentTypeHeader, testPaymentHandlerType,
+		PaymentChannelIDHeader, "42",
+		PaymentChannelNonceHeader, "3",
+		PaymentChannelSignatureHeader, "signature"))}
+
+	err := interceptor(nil, serverStream, nil, suite.successHandler)
+
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), paymentHandler.completeCalled)
+}
+
+const fallbackTestMethod = "/example_service.Calculator/add"
+
+// fallbackInterceptor returns interceptor which accepts free call first and
+// then payment via channel for fallbackTestMethod
+func (suite *InterceptorsSuite) fallbackInterceptor() (grpc.StreamServerInterceptor, *requiredMetadataPaymentHandlerMock, *requiredMetadataPaymentHandlerMock) {
+	freeCallHandler := &requiredMetadataPaymentHandlerMock{
+		paymentHandlerMock: paymentHandlerMock{typ: "test-free-call"},
+		requiredMetadata:   []string{FreeCallUserIdHeader, PaymentChannelSignatureHeader},
+	}
+	channelHandler := &requiredMetadataPaymentHandlerMock{
+		paymentHandlerMock: paymentHandlerMock{typ: "test-channel"},
+		requiredMetadata:   []string{PaymentChannelIDHeader, PaymentChannelNonceHeader, PaymentChannelSignatureHeader},
+	}
+	interceptor := &paymentValidationInterceptor{
+		defaultPaymentHandler: suite.defaultPaymentHandler,
+		paymentHandlers: map[string]PaymentHandler{
+			suite.defaultPaymentHandler.Type(): suite.defaultPaymentHandler,
+			freeCallHandler.Type():             freeCallHandler,
+			channelHandler.Type():              channelHandler,
+		},
+	}
+	err := interceptor.setMethodPaymentTypes([]MethodPaymentTypes{
+		{Method: fallbackTestMethod, PaymentTypes: []string{"test-free-call", "test-channel"}},
+	})
+	assert.Nil(suite.T(), err)
+	return interceptor.intercept, freeCallHandler, channelHandler
+}
+
+func fallbackServerStream(pairs ...string) *serverStreamMock {
+	return &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))}
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesFreeCall() {
+	interceptor, freeCallHandler, channelHandler := suite.fallbackInterceptor()
+	serverStream := fallbackServerStream(
+		FreeCallUserIdHeader, "user",
+		PaymentChannelSignatureHeader, "signature")
+
+	err := interceptor(nil, serverStream, &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), freeCallHandler.completeCalled)
+	assert.False(suite.T(), channelHandler.completeCalled)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesFallbackFromFreeCallToChannel() {
+	interceptor, freeCallHandler, channelHandler := suite.fallbackInterceptor()
+	freeCallHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "free call limit is exceeded")
+	serverStream := fallbackServerStream(
+		FreeCallUserIdHeader, "user",
+		PaymentChannelIDHeader, "42",
+		PaymentChannelNonceHeader, "3",
+		PaymentChannelSignatureHeader, "signature")
+
+	err := interceptor(nil, serverStream, &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Nil(suite.T(), err)
+	assert.False(suite.T(), freeCallHandler.completeCalled)
+	assert.True(suite.T(), channelHandler.completeCalled)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesAllFailed() {
+	interceptor, freeCallHandler, channelHandler := suite.fallbackInterceptor()
+	freeCallHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "free call limit is exceeded")
+	channelHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "incorrect payment channel signature")
+	serverStream := fallbackServerStream(
+		FreeCallUserIdHeader, "user",
+		PaymentChannelIDHeader, "42",
+		PaymentChannelNonceHeader, "3",
+		PaymentChannelSignatureHeader, "signature")
+
+	err := interceptor(nil, serverStream, &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.Unauthenticated, "free call limit is exceeded").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesSkipsTypeWithoutMetadata() {
+	interceptor, freeCallHandler, channelHandler := suite.fallbackInterceptor()
+	freeCallHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "free call limit is exceeded")
+	channelHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "incorrect payment channel signature")
+	serverStream := fallbackServerStream(
+		PaymentChannelIDHeader, "42",
+		PaymentChannelNonceHeader, "3",
+		PaymentChannelSignatureHeader, "signature")
+
+	err := interceptor(nil, serverStream, &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.Unauthenticated, "incorrect payment channel signature").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesNoMetadata() {
+	interceptor, _, _ := suite.fallbackInterceptor()
+
+	err := interceptor(nil, fallbackServerStream(), &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.InvalidArgument, "no payment metadata for payment types accepted by method /example_service.Calculator/add: test-free-call, test-channel").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesExplicitTypeIsNotAccepted() {
+	interceptor, _, _ := suite.fallbackInterceptor()
+
+	err := interceptor(nil, fallbackServerStream(PaymentTypeHeader, defaultPaymentHandlerType), &grpc.StreamServerInfo{FullMethod: fallbackTestMethod}, suite.successHandler)
+
+	assert.Equal(suite.T(), status.Newf(codes.InvalidArgument, "payment type \"test-default-payment-handler\" is not accepted by method /example_service.Calculator/add").Err(), err)
+}
+
+func (suite *InterceptorsSuite) TestMethodPaymentTypesUnknownType() {
+	interceptor := &paymentValidationInterceptor{paymentHandlers: map[string]PaymentHandler{}}
+
+	err := interceptor.setMethodPaymentTypes([]MethodPaymentTypes{
+		{Method: fallbackTestMethod, PaymentTypes: []string{"prepaid"}},
+	})
+
+	assert.Equal(suite.T(), errors.New("unknown payment type \"prepaid\" of method \"/example_service.Calculator/add\""), err)
+}
+
+func (suite *InterceptorsSuite) TestPeerIdentityIsAddedToStats() {
+	certificate := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client-1", Organization: []string{"Example Org"}},
+		DNSNames: []string{"client-1.example.com"},
+	}
+	ctx := peer.NewContext(suite.serverStream.Context(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}},
+	})
+	stats := &metrics.CommonStats{}
+
+	context, err := getGrpcContext(&serverStreamMock{context: ctx}, nil)
+	setAdditionalDetails(context, stats)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "subject=CN=client-1,O=Example Org; san=client-1.example.com", context.PeerIdentity)
+	assert.Equal(suite.T(), "subject=CN=client-1,O=Example Org; san=client-1.example.com", stats.PeerIdentity)
+}
+
+func (suite *InterceptorsSuite) TestPeerIdentityWithoutTls() {
+	ctx := peer.NewContext(suite.serverStream.Context(), &peer.Peer{})
+
+	context, err := getGrpcContext(&serverStreamMock{context: ctx}, nil)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "", context.PeerIdentity)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/singnet/snet-daemon/codec"
+)
+
+// MeteringUnitsTrailer is a trailer the service can set to report number of
+// output units (tokens, frames) delivered to the client. When it is set
+// several times the values are summed up. Value is a decimal string.
+const MeteringUnitsTrailer = "snet-metering-units"
+
+// StreamStats describes the output delivered to the client during the call.
+type StreamStats struct {
+	// Messages is a number of response messages sent to the client
+	Messages int64
+	// Bytes is a total size of the response messages sent to the client
+	Bytes int64
+	// Units is a number of output units reported by the service via
+	// MeteringUnitsTrailer, zero if service doesn't report it
+	Units int64
+}
+
+// MeteringPaymentHandler is an optional interface of PaymentHandler. When
+// payment handler implements it interceptor collects stats of the output
+// delivered to the client and calls CompleteWithStats instead of Complete
+// when the call is successful.
+type MeteringPaymentHandler interface {
+	// CompleteWithStats completes payment as Complete does, amount charged
+	// can depend on the delivered output.
+	CompleteWithStats(payment Payment, stats *StreamStats) (err *GrpcError)
+}
+
+// meteringStream counts response messages and output units reported by the
+// service. Messages are sent by the proxy goroutine, so counters are
+// updated atomically.
+type meteringStream struct {
+	grpc.ServerStream
+	messages int64
+	bytes    int64
+	units    int64
+}
+
+func (stream *meteringStream) SendMsg(m interface{}) error {
+	if err := stream.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	atomic.AddInt64(&stream.messages, 1)
+	if frame, ok := m.(*codec.GrpcFrame); ok {
+		atomic.AddInt64(&stream.bytes, int64(len(frame.Data)))
+	}
+	return nil
+}
+
+func (stream *meteringStream) SetTrailer(md metadata.MD) {
+	for _, value := range md.Get(MeteringUnitsTrailer) {
+		units, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || units < 0 {
+			log.WithField("value", value).Warn("Incorrect metering units are reported by service, ignore them")
+			continue
+		}
+		atomic.AddInt64(&stream.units, units)
+	}
+	stream.ServerStream.SetTrailer(md)
+}
+
+func (stream *meteringStream) stats() *StreamStats {
+	return &StreamStats{
+		Messages: atomic.LoadInt64(&stream.messages),
+		Bytes:    atomic.LoadInt64(&stream.bytes),
+		Units:    atomic.LoadInt64(&stream.units),
+	}
+}
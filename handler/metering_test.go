@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/singnet/snet-daemon/codec"
+)
+
+type sendingServerStreamMock struct {
+	serverStreamMock
+	sent int
+}
+
+func (m *sendingServerStreamMock) SendMsg(interface{}) error {
+	m.sent++
+	return nil
+}
+
+type meteringPaymentHandlerMock struct {
+	paymentHandlerMock
+	pricePerToken *big.Int
+	stats         *StreamStats
+	charged       *big.Int
+}
+
+func (handler *meteringPaymentHandlerMock) CompleteWithStats(payment Payment, stats *StreamStats) (err *GrpcError) {
+	handler.stats = stats
+	handler.charged = new(big.Int).Mul(big.NewInt(stats.Units), handler.pricePerToken)
+	return nil
+}
+
+// streamingTokensHandler sends each token as a separate response message
+// and reports number of tokens via trailer as the service does
+func streamingTokensHandler(tokens ...string) grpc.StreamHandler {
+	return func(srv interface{}, stream grpc.ServerStream) error {
+		for _, token := range tokens {
+			if err := stream.SendMsg(&codec.GrpcFrame{Data: []byte(token)}); err != nil {
+				return err
+			}
+		}
+		stream.SetTrailer(metadata.Pairs(MeteringUnitsTrailer, "3"))
+		stream.SetTrailer(metadata.Pairs(MeteringUnitsTrailer, "1"))
+		return nil
+	}
+}
+
+func TestPaymentValidationInterceptorMetersStreamingCall(t *testing.T) {
+	paymentHandler := &meteringPaymentHandlerMock{
+		paymentHandlerMock: paymentHandlerMock{typ: testPaymentHandlerType},
+		pricePerToken:      big.NewInt(7),
+	}
+	interceptor := GrpcPaymentValidationInterceptor(&paymentHandlerMock{typ: defaultPaymentHandlerType}, paymentHandler)
+	stream := &sendingServerStreamMock{serverStreamMock: serverStreamMock{
+		context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(PaymentTypeHeader, testPaymentHandlerType)),
+	}}
+
+	err := interceptor(nil, stream, nil, streamingTokensHandler("Hello", ",", " world", "!"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, stream.sent)
+	assert.Equal(t, &StreamStats{Messages: 4, Bytes: 13, Units: 4}, paymentHandler.stats)
+	assert.Equal(t, big.NewInt(28), paymentHandler.charged)
+	assert.False(t, paymentHandler.completeCalled)
+	assert.Equal(t, []string{"3", "1"}, stream.trailer.Get(MeteringUnitsTrailer))
+}
+
+func TestPaymentValidationInterceptorIgnoresIncorrectUnits(t *testing.T) {
+	paymentHandler := &meteringPaymentHandlerMock{
+		paymentHandlerMock: paymentHandlerMock{typ: testPaymentHandlerType},
+		pricePerToken:      big.NewInt(7),
+	}
+	interceptor := GrpcPaymentValidationInterceptor(&paymentHandlerMock{typ: defaultPaymentHandlerType}, paymentHandler)
+	stream := &sendingServerStreamMock{serverStreamMock: serverStreamMock{
+		context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(PaymentTypeHeader, testPaymentHandlerType)),
+	}}
+
+	err := interceptor(nil, stream, nil, func(srv interface{}, stream grpc.ServerStream) error {
+		stream.SetTrailer(metadata.Pairs(MeteringUnitsTrailer, "-5", MeteringUnitsTrailer, "abc", MeteringUnitsTrailer, "2"))
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), paymentHandler.stats.Units)
+}
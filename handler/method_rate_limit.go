@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterTrailer is added to the response trailer when call is rejected
+// because of the rate limit. Value is a number of milliseconds client should
+// wait before retrying the call.
+const RetryAfterTrailer = "snet-retry-after-ms"
+
+// MethodRateLimit is a global rate limit of calls to the service method
+// regardless of the payment channel used to pay for the call.
+type MethodRateLimit struct {
+	// Method is a full gRPC method name: /<package>.<service>/<method>
+	Method string `mapstructure:"method"`
+	// RatePerSecond is a number of calls per second allowed in average
+	RatePerSecond float64 `mapstructure:"rate_per_second"`
+	// Burst is a maximal number of calls allowed at once
+	Burst int `mapstructure:"burst"`
+}
+
+// GrpcMethodRateLimitInterceptor returns gRPC interceptor which limits calls
+// to each method listed in limits using token bucket. It should be chained
+// after payment validation interceptor, so rejected calls are not charged.
+func GrpcMethodRateLimitInterceptor(limits []MethodRateLimit) grpc.StreamServerInterceptor {
+	if len(limits) == 0 {
+		return NoOpInterceptor
+	}
+
+	limiters := make(map[string]*rate.Limiter)
+	for _, limit := range limits {
+		limiters[limit.Method] = rate.NewLimiter(rate.Limit(limit.RatePerSecond), limit.Burst)
+		log.WithField("limit", limit).Info("Method rate limit is set")
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		limiter, ok := limiters[info.FullMethod]
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		reservation := limiter.Reserve()
+		if !reservation.OK() {
+			log.WithField("method", info.FullMethod).Info("Method rate limit doesn't allow any calls")
+			return status.Newf(codes.ResourceExhausted, "rate limit of method %v is reached", info.FullMethod).Err()
+		}
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			retryAfter := delay.Round(time.Millisecond)
+			log.WithField("method", info.FullMethod).WithField("retryAfter", retryAfter).Info("Method rate limit is reached")
+			ss.SetTrailer(metadata.Pairs(RetryAfterTrailer, strconv.FormatInt(int64(retryAfter/time.Millisecond), 10)))
+			return status.Newf(codes.ResourceExhausted, "rate limit of method %v is reached, retry after %v", info.FullMethod, retryAfter).Err()
+		}
+
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func callWithChannel(interceptor grpc.StreamServerInterceptor, method string, channelID string) error {
+	ss := &serverStreamMock{context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(PaymentChannelIDHeader, channelID))}
+	return interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	})
+}
+
+func TestMethodRateLimitAcrossChannels(t *testing.T) {
+	interceptor := GrpcMethodRateLimitInterceptor([]MethodRateLimit{
+		{Method: "/example_service.Calculator/add", RatePerSecond: 0.001, Burst: 2},
+	})
+
+	errA := callWithChannel(interceptor, "/example_service.Calculator/add", "1")
+	errB := callWithChannel(interceptor, "/example_service.Calculator/add", "2")
+	errC := callWithChannel(interceptor, "/example_service.Calculator/add", "3")
+	errOtherMethod := callWithChannel(interceptor, "/example_service.Calculator/sub", "3")
+
+	assert.Nil(t, errA)
+	assert.Nil(t, errB)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errC))
+	assert.Contains(t, status.Convert(errC).Message(), "rate limit of method /example_service.Calculator/add is reached, retry after")
+	assert.Nil(t, errOtherMethod)
+}
+
+func TestMethodRateLimitNoLimits(t *testing.T) {
+	interceptor := GrpcMethodRateLimitInterceptor(nil)
+
+	err := callWithChannel(interceptor, "/example_service.Calculator/add", "1")
+
+	assert.Nil(t, err)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// getPeerIdentity returns subject and subject alternative names of the
+// client TLS certificate. It returns empty string when client doesn't use
+// TLS or doesn't present a certificate.
+func getPeerIdentity(ctx context.Context) string {
+	certificate := getPeerCertificate(ctx)
+	if certificate == nil {
+		return ""
+	}
+
+	names := make([]string, 0)
+	names = append(names, certificate.DNSNames...)
+	names = append(names, certificate.EmailAddresses...)
+	for _, ip := range certificate.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+
+	identity := "subject=" + certificate.Subject.String()
+	if len(names) > 0 {
+		identity += "; san=" + strings.Join(names, ",")
+	}
+	return identity
+}
+
+// getPeerAddress returns network address of the client without port, it
+// returns empty string when address is unknown.
+func getPeerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// getPeerCertificate returns client TLS certificate or nil if client doesn't
+// use TLS or doesn't present a certificate.
+func getPeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.PeerCertificates[0]
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"github.com/ethereum/go-ethereum/crypto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/codec"
+)
+
+// requestContentStream returns the first request message which was read by
+// interceptor to calculate request hash, the rest of messages are read from
+// the underlying stream.
+type requestContentStream struct {
+	grpc.ServerStream
+	first *codec.GrpcFrame
+}
+
+func (stream *requestContentStream) RecvMsg(m interface{}) error {
+	if stream.first == nil {
+		return stream.ServerStream.RecvMsg(m)
+	}
+
+	frame, ok := m.(*codec.GrpcFrame)
+	if !ok {
+		return NewGrpcErrorf(codes.Internal, "unexpected request message type: %T", m).Err()
+	}
+	frame.Data = stream.first.Data
+	stream.first = nil
+	return nil
+}
+
+// readRequestContent reads the first request message and returns its
+// Keccak256 hash together with the stream which returns the message to the
+// service handler again. Only the first message is hashed, so for client
+// streaming calls the following messages are not covered.
+func readRequestContent(ss grpc.ServerStream) (hash []byte, stream grpc.ServerStream, err *GrpcError) {
+	first := &codec.GrpcFrame{}
+	if e := ss.RecvMsg(first); e != nil {
+		return nil, nil, NewGrpcErrorf(codes.InvalidArgument, "cannot read request to check its content: %v", e)
+	}
+
+	return crypto.Keccak256(first.Data), &requestContentStream{ServerStream: ss, first: first}, nil
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/codec"
+)
+
+type recvStreamMock struct {
+	serverStreamMock
+	messages [][]byte
+}
+
+func (m *recvStreamMock) RecvMsg(msg interface{}) error {
+	if len(m.messages) == 0 {
+		return io.EOF
+	}
+	msg.(*codec.GrpcFrame).Data = m.messages[0]
+	m.messages = m.messages[1:]
+	return nil
+}
+
+func TestReadRequestContent(t *testing.T) {
+	ss := &recvStreamMock{messages: [][]byte{[]byte("first"), []byte("second")}}
+
+	hash, stream, err := readRequestContent(ss)
+
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.Keccak256([]byte("first")), hash)
+	frame := &codec.GrpcFrame{}
+	assert.Nil(t, stream.RecvMsg(frame))
+	assert.Equal(t, []byte("first"), frame.Data)
+	assert.Nil(t, stream.RecvMsg(frame))
+	assert.Equal(t, []byte("second"), frame.Data)
+	assert.Equal(t, io.EOF, stream.RecvMsg(frame))
+}
+
+func TestReadRequestContentNoMessage(t *testing.T) {
+	ss := &recvStreamMock{}
+
+	_, _, err := readRequestContent(ss)
+
+	assert.Equal(t, NewGrpcErrorf(codes.InvalidArgument, "cannot read request to check its content: EOF"), err)
+}
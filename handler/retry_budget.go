@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/singnet/snet-daemon/retry"
+	"google.golang.org/grpc"
+)
+
+// serverStreamWithContext is a grpc.ServerStream with replaced context
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStreamWithContext) Context() context.Context {
+	return stream.ctx
+}
+
+// GrpcRetryBudgetInterceptor returns gRPC interceptor which adds a new retry
+// budget to the context of each request. All stages of the request which
+// retry operations share this budget.
+func GrpcRetryBudgetInterceptor(maxAttempts int, maxDuration time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		budget := retry.NewBudget(maxAttempts, maxDuration)
+		return handler(srv, &serverStreamWithContext{
+			ServerStream: ss,
+			ctx:          retry.NewContext(ss.Context(), budget),
+		})
+	}
+}
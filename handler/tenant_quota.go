@@ -0,0 +1,42 @@
+package handler
+
+import (
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TenantQuota limits aggregate usage of all services of the tenant.
+type TenantQuota interface {
+	// Tenant returns name of the tenant
+	Tenant() string
+	// Acquire reserves one call, ok is false if the tenant limit is
+	// reached. release is called when the call is finished.
+	Acquire() (release func(), ok bool, err error)
+}
+
+// GrpcTenantQuotaInterceptor returns gRPC interceptor which rejects calls
+// exceeding tenant quota. It should be chained after payment validation
+// interceptor, so rejected calls are not charged. If quota is nil then
+// NoOpInterceptor is returned.
+func GrpcTenantQuotaInterceptor(quota TenantQuota) grpc.StreamServerInterceptor {
+	if quota == nil {
+		return NoOpInterceptor
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, ok, err := quota.Acquire()
+		if err != nil {
+			log.WithError(err).WithField("tenant", quota.Tenant()).Error("Unable to check tenant quota")
+			return status.New(codes.Internal, "cannot check tenant quota").Err()
+		}
+		if !ok {
+			log.WithField("tenant", quota.Tenant()).WithField("method", info.FullMethod).Info("Tenant quota is exceeded")
+			return status.Newf(codes.ResourceExhausted, "quota of tenant %v is exceeded", quota.Tenant()).Err()
+		}
+		defer release()
+
+		return handler(srv, ss)
+	}
+}
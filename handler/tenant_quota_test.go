@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type tenantQuotaMock struct {
+	ok       bool
+	err      error
+	released int
+}
+
+func (quota *tenantQuotaMock) Tenant() string {
+	return "tenant-a"
+}
+
+func (quota *tenantQuotaMock) Acquire() (release func(), ok bool, err error) {
+	if quota.err != nil || !quota.ok {
+		return nil, quota.ok, quota.err
+	}
+	return func() { quota.released++ }, true, nil
+}
+
+func TestTenantQuotaInterceptorAllowsCall(t *testing.T) {
+	quota := &tenantQuotaMock{ok: true}
+
+	err := callWithChannel(GrpcTenantQuotaInterceptor(quota), "/example_service.Calculator/add", "1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, quota.released)
+}
+
+func TestTenantQuotaInterceptorQuotaExceeded(t *testing.T) {
+	err := callWithChannel(GrpcTenantQuotaInterceptor(&tenantQuotaMock{ok: false}), "/example_service.Calculator/add", "1")
+
+	assert.Equal(t, status.New(codes.ResourceExhausted, "quota of tenant tenant-a is exceeded").Err(), err)
+}
+
+func TestTenantQuotaInterceptorStorageError(t *testing.T) {
+	err := callWithChannel(GrpcTenantQuotaInterceptor(&tenantQuotaMock{err: errors.New("storage error")}), "/example_service.Calculator/add", "1")
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/exporter"
+)
+
+// ValidationDecision is a result of the payment validation which is
+// exported for analytics
+type ValidationDecision struct {
+	Time        time.Time `json:"time"`
+	Accepted    bool      `json:"accepted"`
+	PaymentType string    `json:"paymentType"`
+	ChannelID   string    `json:"channelId,omitempty"`
+	Amount      string    `json:"amount,omitempty"`
+	Method      string    `json:"method"`
+	Code        string    `json:"code"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// ValidationDecisionExporter publishes validation decisions, Export should
+// not block the call.
+type ValidationDecisionExporter interface {
+	Export(decision *ValidationDecision)
+}
+
+type recordDecisionExporter struct {
+	exporter *exporter.BatchingExporter
+}
+
+// NewValidationDecisionExporter returns exporter which publishes decisions
+// as records keyed by payment channel id, so decisions about the same
+// channel are kept in order. If records is nil then nil is returned.
+func NewValidationDecisionExporter(records *exporter.BatchingExporter) ValidationDecisionExporter {
+	if records == nil {
+		return nil
+	}
+	return &recordDecisionExporter{exporter: records}
+}
+
+func (decisions *recordDecisionExporter) Export(decision *ValidationDecision) {
+	decisions.exporter.Export(exporter.Record{Key: decision.ChannelID, Value: decision})
+}
+
+type decisionExportingPaymentHandler struct {
+	PaymentHandler
+	exporter ValidationDecisionExporter
+	now      func() time.Time
+}
+
+// NewDecisionExportingPaymentHandler returns payment handler which exports
+// decision of the delegate about each payment. If exporter is nil then
+// delegate is returned.
+func NewDecisionExportingPaymentHandler(delegate PaymentHandler, exporter ValidationDecisionExporter) PaymentHandler {
+	if exporter == nil {
+		return delegate
+	}
+	return &decisionExportingPaymentHandler{
+		PaymentHandler: delegate,
+		exporter:       exporter,
+		now:            time.Now,
+	}
+}
+
+func (h *decisionExportingPaymentHandler) Payment(context *GrpcStreamContext) (payment Payment, err *GrpcError) {
+	payment, err = h.PaymentHandler.Payment(context)
+
+	decision := &ValidationDecision{
+		Time:        h.now(),
+		Accepted:    err == nil,
+		PaymentType: h.Type(),
+		ChannelID:   firstValue(context.MD, PaymentChannelIDHeader),
+		Amount:      firstValue(context.MD, PaymentChannelAmountHeader),
+		Code:        codes.OK.String(),
+	}
+	if context.Info != nil {
+		decision.Method = context.Info.FullMethod
+	}
+	if err != nil {
+		decision.Code = err.Status.Code().String()
+		decision.Message = err.Status.Message()
+	}
+	h.exporter.Export(decision)
+
+	return
+}
+
+// RequiredMetadata implements RequiredMetadataProvider if delegate does
+func (h *decisionExportingPaymentHandler) RequiredMetadata() []string {
+	if provider, ok := h.PaymentHandler.(RequiredMetadataProvider); ok {
+		return provider.RequiredMetadata()
+	}
+	return nil
+}
+
+// CompleteWithStats implements MeteringPaymentHandler if delegate does
+func (h *decisionExportingPaymentHandler) CompleteWithStats(payment Payment, stats *StreamStats) (err *GrpcError) {
+	if metering, ok := h.PaymentHandler.(MeteringPaymentHandler); ok {
+		return metering.CompleteWithStats(payment, stats)
+	}
+	return h.Complete(payment)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/singnet/snet-daemon/exporter"
+)
+
+type decisionExporterMock struct {
+	decisions []*ValidationDecision
+}
+
+func (exporter *decisionExporterMock) Export(decision *ValidationDecision) {
+	exporter.decisions = append(exporter.decisions, decision)
+}
+
+type rejectingPaymentHandlerMock struct {
+	paymentHandlerMock
+}
+
+func (h *rejectingPaymentHandlerMock) Payment(context *GrpcStreamContext) (Payment, *GrpcError) {
+	if firstValue(context.MD, PaymentChannelAmountHeader) == "0" {
+		return nil, NewGrpcErrorf(codes.Unauthenticated, "income 0 does not equal to price 10")
+	}
+	return &paymentMock{}, nil
+}
+
+func (h *rejectingPaymentHandlerMock) RequiredMetadata() []string {
+	return []string{PaymentChannelIDHeader}
+}
+
+func decisionContext(channelID, amount string) *GrpcStreamContext {
+	return &GrpcStreamContext{
+		MD:   metadata.Pairs(PaymentChannelIDHeader, channelID, PaymentChannelAmountHeader, amount),
+		Info: &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/add"},
+	}
+}
+
+func TestDecisionExportingPaymentHandler(t *testing.T) {
+	exporter := &decisionExporterMock{}
+	h := NewDecisionExportingPaymentHandler(&rejectingPaymentHandlerMock{paymentHandlerMock{typ: testPaymentHandlerType}}, exporter)
+	h.(*decisionExportingPaymentHandler).now = func() time.Time { return time.Unix(1000, 0) }
+
+	_, errA := h.Payment(decisionContext("1", "10"))
+	_, errB := h.Payment(decisionContext("2", "0"))
+
+	assert.Nil(t, errA)
+	assert.NotNil(t, errB)
+	assert.Equal(t, []*ValidationDecision{
+		{Time: time.Unix(1000, 0), Accepted: true, PaymentType: testPaymentHandlerType, ChannelID: "1", Amount: "10", Method: "/example_service.Calculator/add", Code: "OK"},
+		{Time: time.Unix(1000, 0), Accepted: false, PaymentType: testPaymentHandlerType, ChannelID: "2", Amount: "0", Method: "/example_service.Calculator/add", Code: "Unauthenticated", Message: "income 0 does not equal to price 10"},
+	}, exporter.decisions)
+	assert.Equal(t, []string{PaymentChannelIDHeader}, h.(RequiredMetadataProvider).RequiredMetadata())
+}
+
+func TestDecisionExportingPaymentHandlerWithoutExporter(t *testing.T) {
+	delegate := &rejectingPaymentHandlerMock{}
+
+	assert.Equal(t, delegate, NewDecisionExportingPaymentHandler(delegate, nil))
+}
+
+func TestValidationDecisionsArePublishedInOrder(t *testing.T) {
+	writer := exporter.NewMemoryWriter()
+	records := exporter.NewBatchingExporter(writer, 2, time.Hour, 10, 0, 0)
+	h := NewDecisionExportingPaymentHandler(&rejectingPaymentHandlerMock{paymentHandlerMock{typ: testPaymentHandlerType}},
+		NewValidationDecisionExporter(records))
+
+	h.Payment(decisionContext("1", "10"))
+	h.Payment(decisionContext("1", "0"))
+	h.Payment(decisionContext("2", "20"))
+	records.Close()
+
+	published := writer.Records()
+	assert.Equal(t, 3, len(published))
+	for i, expected := range []struct {
+		channelID string
+		amount    string
+		accepted  bool
+	}{{"1", "10", true}, {"1", "0", false}, {"2", "20", true}} {
+		decision := published[i].Value.(*ValidationDecision)
+		assert.Equal(t, expected.channelID, published[i].Key)
+		assert.Equal(t, expected.amount, decision.Amount)
+		assert.Equal(t, expected.accepted, decision.Accepted)
+	}
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// AMOUNT_UNIT_COGS exports amounts in base units (cogs) without
+	// precision loss
+	AMOUNT_UNIT_COGS = "cogs"
+	// AMOUNT_UNIT_TOKENS exports amounts in tokens, amounts are converted
+	// using token decimals and can lose precision
+	AMOUNT_UNIT_TOKENS = "tokens"
+)
+
+// AmountCounter is a Prometheus counter of token amounts which are exported
+// either in cogs or in tokens.
+type AmountCounter struct {
+	counter prometheus.Counter
+	// cogsPerUnit is a number of cogs in the exported unit
+	cogsPerUnit *big.Float
+}
+
+// NewAmountCounter returns not registered counter which exports amounts in
+// the unit, decimals is a number of decimals of the token.
+func NewAmountCounter(name string, help string, unit string, decimals int) (counter *AmountCounter, err error) {
+	cogsPerUnit := big.NewFloat(1)
+	switch unit {
+	case AMOUNT_UNIT_COGS:
+	case AMOUNT_UNIT_TOKENS:
+		if decimals < 0 {
+			return nil, fmt.Errorf("incorrect number of token decimals: %v", decimals)
+		}
+		cogsPerUnit.SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	default:
+		return nil, fmt.Errorf("unexpected amount unit: \"%v\"", unit)
+	}
+
+	return &AmountCounter{
+		counter: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Name:      name,
+			Help:      fmt.Sprintf("%v Unit: %v.", help, unit),
+		}),
+		cogsPerUnit: cogsPerUnit,
+	}, nil
+}
+
+// Add adds amount of cogs to the counter
+func (counter *AmountCounter) Add(amount *big.Int) {
+	if amount == nil || amount.Sign() <= 0 {
+		return
+	}
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), counter.cogsPerUnit).Float64()
+	counter.counter.Add(value)
+}
+
+var (
+	revenueMutex sync.RWMutex
+	revenue      *AmountCounter
+)
+
+const (
+	revenueName = "revenue_total"
+	revenueHelp = "Amount of tokens earned by completed paid calls."
+)
+
+func init() {
+	var err error
+	if revenue, err = NewAmountCounter(revenueName, revenueHelp, AMOUNT_UNIT_COGS, 0); err != nil {
+		panic(err)
+	}
+	prometheus.MustRegister(revenue.counter)
+}
+
+// InitAmountMetrics sets the unit amount based metrics are exported in.
+// Metrics collected before are dropped.
+func InitAmountMetrics(unit string, decimals int) (err error) {
+	counter, err := NewAmountCounter(revenueName, revenueHelp, unit, decimals)
+	if err != nil {
+		return
+	}
+
+	revenueMutex.Lock()
+	defer revenueMutex.Unlock()
+	prometheus.Unregister(revenue.counter)
+	if err = prometheus.Register(counter.counter); err != nil {
+		prometheus.MustRegister(revenue.counter)
+		return
+	}
+	revenue = counter
+	return nil
+}
+
+// Revenue returns counter of the amount earned by paid calls
+func Revenue() *AmountCounter {
+	revenueMutex.RLock()
+	defer revenueMutex.RUnlock()
+	return revenue
+}
+
+// DefaultTokenDecimals is a number of decimals of AGIX token
+const DefaultTokenDecimals = 8
+
+var (
+	amountFormatMutex sync.RWMutex
+	tokenDecimals     = DefaultTokenDecimals
+	tokenSymbol       = "AGIX"
+)
+
+// InitAmountFormat sets number of token decimals and token symbol which are
+// used by FormatAmount
+func InitAmountFormat(decimals int, symbol string) error {
+	if decimals < 0 {
+		return fmt.Errorf("incorrect number of token decimals: %v", decimals)
+	}
+
+	amountFormatMutex.Lock()
+	defer amountFormatMutex.Unlock()
+	tokenDecimals = decimals
+	tokenSymbol = symbol
+	return nil
+}
+
+// FormatAmount renders amount of cogs as human readable amount of tokens,
+// for example 12345 cogs of the token with 8 decimals is "0.00012345 AGIX".
+// Conversion is exact, trailing zeros of the fraction are dropped.
+func FormatAmount(amount *big.Int) string {
+	if amount == nil {
+		return "<nil>"
+	}
+
+	amountFormatMutex.RLock()
+	decimals, symbol := tokenDecimals, tokenSymbol
+	amountFormatMutex.RUnlock()
+
+	digits := new(big.Int).Abs(amount).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	integer, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+
+	formatted := integer
+	if fraction != "" {
+		formatted += "." + fraction
+	}
+	if amount.Sign() < 0 {
+		formatted = "-" + formatted
+	}
+	if symbol != "" {
+		formatted += " " + symbol
+	}
+	return formatted
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmountCounterInCogs(t *testing.T) {
+	counter, err := NewAmountCounter("test_amount_cogs_total", "Test amount.", AMOUNT_UNIT_COGS, 8)
+	assert.Nil(t, err)
+
+	counter.Add(big.NewInt(150000000))
+	counter.Add(big.NewInt(25))
+
+	assert.Equal(t, float64(150000025), testutil.ToFloat64(counter.counter))
+}
+
+func TestAmountCounterInTokens(t *testing.T) {
+	counter, err := NewAmountCounter("test_amount_tokens_total", "Test amount.", AMOUNT_UNIT_TOKENS, 8)
+	assert.Nil(t, err)
+
+	counter.Add(big.NewInt(150000000))
+	counter.Add(big.NewInt(25000000))
+
+	assert.Equal(t, 1.75, testutil.ToFloat64(counter.counter))
+}
+
+func TestAmountCounterUnitInHelp(t *testing.T) {
+	counter, err := NewAmountCounter("test_amount_help_total", "Test amount.", AMOUNT_UNIT_TOKENS, 8)
+	assert.Nil(t, err)
+
+	assert.Contains(t, counter.counter.Desc().String(), "Test amount. Unit: tokens.")
+}
+
+func TestAmountCounterIncorrectUnit(t *testing.T) {
+	_, err := NewAmountCounter("test_amount_total", "Test amount.", "wei", 8)
+
+	assert.Equal(t, "unexpected amount unit: \"wei\"", err.Error())
+}
+
+func TestInitAmountMetrics(t *testing.T) {
+	defer InitAmountMetrics(AMOUNT_UNIT_COGS, 0)
+
+	err := InitAmountMetrics(AMOUNT_UNIT_TOKENS, 2)
+	assert.Nil(t, err)
+
+	Revenue().Add(big.NewInt(250))
+	assert.Equal(t, 2.5, testutil.ToFloat64(Revenue().counter))
+}
+
+func TestFormatAmount(t *testing.T) {
+	assert.Equal(t, "0.00012345 AGIX", FormatAmount(big.NewInt(12345)))
+	assert.Equal(t, "1 AGIX", FormatAmount(big.NewInt(100000000)))
+	assert.Equal(t, "1.5 AGIX", FormatAmount(big.NewInt(150000000)))
+	assert.Equal(t, "0 AGIX", FormatAmount(big.NewInt(0)))
+	assert.Equal(t, "-0.00000001 AGIX", FormatAmount(big.NewInt(-1)))
+	assert.Equal(t, "<nil>", FormatAmount(nil))
+}
+
+func TestFormatAmountWithCustomDecimals(t *testing.T) {
+	assert.Nil(t, InitAmountFormat(2, "TKN"))
+	defer InitAmountFormat(DefaultTokenDecimals, "AGIX")
+
+	assert.Equal(t, "123.45 TKN", FormatAmount(big.NewInt(12345)))
+}
+
+func TestFormatAmountWithoutDecimals(t *testing.T) {
+	assert.Nil(t, InitAmountFormat(0, ""))
+	defer InitAmountFormat(DefaultTokenDecimals, "AGIX")
+
+	assert.Equal(t, "12345", FormatAmount(big.NewInt(12345)))
+}
+
+func TestInitAmountFormatNegativeDecimals(t *testing.T) {
+	err := InitAmountFormat(-1, "AGIX")
+
+	assert.Equal(t, "incorrect number of token decimals: -1", err.Error())
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HealthCheck returns nil if daemon component is healthy and error which
+// describes a problem otherwise. It should stop when context is cancelled.
+type HealthCheck func(ctx context.Context) error
+
+var (
+	healthChecksMutex sync.RWMutex
+	healthChecks      = map[string]HealthCheck{}
+)
+
+// RegisterHealthCheck adds health check of the daemon component, result of
+// check is reported in the daemon heartbeat
+func RegisterHealthCheck(component string, check HealthCheck) {
+	healthChecksMutex.Lock()
+	defer healthChecksMutex.Unlock()
+	healthChecks[component] = check
+}
+
+// UnregisterHealthCheck removes health check of the daemon component
+func UnregisterHealthCheck(component string) {
+	healthChecksMutex.Lock()
+	defer healthChecksMutex.Unlock()
+	delete(healthChecks, component)
+}
+
+// CheckHealth runs all registered health checks and returns status of each
+// component. Error is returned if any of the components is not healthy.
+func CheckHealth(ctx context.Context) (statuses map[string]string, err error) {
+	healthChecksMutex.RLock()
+	defer healthChecksMutex.RUnlock()
+
+	if len(healthChecks) == 0 {
+		return nil, nil
+	}
+
+	statuses = make(map[string]string, len(healthChecks))
+	failures := []string{}
+	for component, check := range healthChecks {
+		if checkErr := check(ctx); checkErr != nil {
+			statuses[component] = checkErr.Error()
+			failures = append(failures, fmt.Sprintf("%v: %v", component, checkErr))
+			continue
+		}
+		statuses[component] = "SERVING"
+	}
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return statuses, fmt.Errorf("daemon components are not healthy: %v", strings.Join(failures, "; "))
+	}
+	return statuses, nil
+}
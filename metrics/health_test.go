@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHealth(t *testing.T) {
+	RegisterHealthCheck("storage", func(ctx context.Context) error { return nil })
+	defer UnregisterHealthCheck("storage")
+
+	statuses, err := CheckHealth(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"storage": "SERVING"}, statuses)
+}
+
+func TestCheckHealthComponentFailed(t *testing.T) {
+	RegisterHealthCheck("storage", func(ctx context.Context) error { return nil })
+	defer UnregisterHealthCheck("storage")
+	RegisterHealthCheck("blockchain", func(ctx context.Context) error { return errors.New("connection refused") })
+	defer UnregisterHealthCheck("blockchain")
+
+	statuses, err := CheckHealth(context.Background())
+
+	assert.Equal(t, "daemon components are not healthy: blockchain: connection refused", err.Error())
+	assert.Equal(t, map[string]string{"storage": "SERVING", "blockchain": "connection refused"}, statuses)
+}
+
+func TestCheckHealthPassesContext(t *testing.T) {
+	RegisterHealthCheck("storage", func(ctx context.Context) error { return ctx.Err() })
+	defer UnregisterHealthCheck("storage")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := CheckHealth(ctx)
+
+	assert.Equal(t, "daemon components are not healthy: storage: context canceled", err.Error())
+}
+
+func TestGetHeartbeatComponentFailed(t *testing.T) {
+	RegisterHealthCheck("etcd", func(ctx context.Context) error { return errors.New("etcd is down") })
+	defer UnregisterHealthCheck("etcd")
+
+	heartbeat, err := GetHeartbeat("", "none", "SERVICE001")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, Warning.String(), heartbeat.Status)
+	assert.Equal(t, map[string]string{"etcd": "etcd is down"}, heartbeat.ComponentsHealth)
+}
@@ -39,6 +39,9 @@ type DaemonHeartbeat struct {
 	Timestamp        string `json:"timestamp"`
 	Status           string `json:"status"`
 	ServiceHeartbeat string `json:"serviceheartbeat"`
+	// ComponentsHealth contains status of each daemon component which has
+	// registered health check
+	ComponentsHealth map[string]string `json:"componentshealth,omitempty"`
 }
 
 // Converts the enum index into enum names
@@ -80,7 +83,13 @@ func ValidateHeartbeatConfig() error {
 
 // prepares the heartbeat, which includes calling to underlying service DAemon is serving
 func GetHeartbeat(serviceURL string, serviceType string, serviceID string) (heartbeat DaemonHeartbeat,err error) {
-	heartbeat = DaemonHeartbeat{GetDaemonID(), strconv.FormatInt(getEpochTime(), 10), Online.String(), "{}"}
+	return getHeartbeat(context.Background(), serviceURL, serviceType, serviceID)
+}
+
+// getHeartbeat prepares the heartbeat and checks health of the daemon
+// components, ctx limits the time of the components health checks
+func getHeartbeat(ctx context.Context, serviceURL string, serviceType string, serviceID string) (heartbeat DaemonHeartbeat, err error) {
+	heartbeat = DaemonHeartbeat{GetDaemonID(), strconv.FormatInt(getEpochTime(), 10), Online.String(), "{}", nil}
 	var curResp = `{"serviceID":"` + serviceID + `","status":"NOT_SERVING"}`
 	if serviceType == "none" || serviceType == "" || isNoHeartbeatURL {
 		curResp = `{"serviceID":"` + serviceID + `","status":"SERVING"}`
@@ -113,6 +122,16 @@ func GetHeartbeat(serviceURL string, serviceType string, serviceID string) (hear
 		}
 	}
 	heartbeat.ServiceHeartbeat = curResp
+
+	components, healthErr := CheckHealth(ctx)
+	heartbeat.ComponentsHealth = components
+	if healthErr != nil {
+		log.WithError(healthErr).Warn("Daemon components health check failed")
+		heartbeat.Status = Warning.String()
+		if err == nil {
+			err = healthErr
+		}
+	}
 	return heartbeat,err
 }
 
@@ -123,7 +142,7 @@ func HeartbeatHandler(rw http.ResponseWriter, r *http.Request) {
 	serviceType := config.GetString(config.ServiceHeartbeatType)
 	serviceURL := config.GetString(config.HeartbeatServiceEndpoint)
 	serviceID := config.GetString(config.ServiceId)
-	heartbeat,_ := GetHeartbeat(serviceURL, serviceType, serviceID)
+	heartbeat,_ := getHeartbeat(r.Context(), serviceURL, serviceType, serviceID)
 	err := json.NewEncoder(rw).Encode(heartbeat)
 	if err != nil {
 		log.WithError(err).Infof("Failed to write heartbeat message.")
@@ -133,7 +152,7 @@ func HeartbeatHandler(rw http.ResponseWriter, r *http.Request) {
 // Check implements `service Health`.
 func (service *DaemonHeartbeat) Check( ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
 
-	heartbeat,err := GetHeartbeat(config.GetString(config.HeartbeatServiceEndpoint), config.GetString(config.ServiceHeartbeatType),
+	heartbeat,err := getHeartbeat(ctx, config.GetString(config.HeartbeatServiceEndpoint), config.GetString(config.ServiceHeartbeatType),
 		config.GetString(config.ServiceId))
 
 	if strings.Compare(heartbeat.Status,Online.String()) == 0  {
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"math/big"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PaymentValidationMetrics counts outcomes of the payment channel payment
+// validations. Methods of the nil instance do nothing, so instrumented code
+// doesn't need a registry in tests.
+type PaymentValidationMetrics struct {
+	failed         *prometheus.CounterVec
+	succeeded      prometheus.Counter
+	amount         prometheus.Histogram
+	expiryWarnings prometheus.Counter
+}
+
+// NewPaymentValidationMetrics returns metrics registered in the registerer
+// under the namespace, nil registerer returns nil metrics.
+func NewPaymentValidationMetrics(registerer prometheus.Registerer, namespace string) (validationMetrics *PaymentValidationMetrics, err error) {
+	if registerer == nil {
+		return nil, nil
+	}
+
+	validationMetrics = &PaymentValidationMetrics{
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payment_validation_failures_total",
+			Help:      "Number of payments which failed validation by payment error type.",
+		}, []string{"error"}),
+		succeeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payment_validation_successes_total",
+			Help:      "Number of payments which passed validation.",
+		}),
+		amount: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "payment_validation_amount_cogs",
+			Help:      "Amounts of the validated payments in cogs.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 12),
+		}),
+		expiryWarnings: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payment_channel_expiry_warnings_total",
+			Help:      "Number of valid payments sent via channels which are near to expiration.",
+		}),
+	}
+	for _, collector := range []prometheus.Collector{validationMetrics.failed, validationMetrics.succeeded, validationMetrics.amount, validationMetrics.expiryWarnings} {
+		if err = registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return validationMetrics, nil
+}
+
+// Succeeded counts valid payment of the amount
+func (validationMetrics *PaymentValidationMetrics) Succeeded(amount *big.Int) {
+	if validationMetrics == nil {
+		return
+	}
+	validationMetrics.succeeded.Inc()
+	validationMetrics.observeAmount(amount)
+}
+
+// Failed counts payment of the amount which failed validation with the
+// errorType
+func (validationMetrics *PaymentValidationMetrics) Failed(errorType string, amount *big.Int) {
+	if validationMetrics == nil {
+		return
+	}
+	validationMetrics.failed.WithLabelValues(errorType).Inc()
+	validationMetrics.observeAmount(amount)
+}
+
+// ExpiryWarning counts valid payment sent via channel which is near to
+// expiration
+func (validationMetrics *PaymentValidationMetrics) ExpiryWarning() {
+	if validationMetrics == nil {
+		return
+	}
+	validationMetrics.expiryWarnings.Inc()
+}
+
+func (validationMetrics *PaymentValidationMetrics) observeAmount(amount *big.Int) {
+	if amount == nil {
+		return
+	}
+	value, _ := new(big.Float).SetInt(amount).Float64()
+	validationMetrics.amount.Observe(value)
+}
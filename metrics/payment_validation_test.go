@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentValidationMetricsNilRegisterer(t *testing.T) {
+	validationMetrics, err := NewPaymentValidationMetrics(nil, "test")
+
+	assert.Nil(t, err)
+	assert.Nil(t, validationMetrics)
+	validationMetrics.Succeeded(big.NewInt(1))
+	validationMetrics.Failed("Internal", big.NewInt(1))
+	validationMetrics.ExpiryWarning()
+}
+
+func TestPaymentValidationMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	validationMetrics, err := NewPaymentValidationMetrics(registry, "test")
+	assert.Nil(t, err)
+
+	validationMetrics.Succeeded(big.NewInt(10))
+	validationMetrics.Succeeded(big.NewInt(20))
+	validationMetrics.Failed("Unauthenticated", big.NewInt(30))
+	validationMetrics.ExpiryWarning()
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(validationMetrics.succeeded))
+	assert.Equal(t, float64(1), testutil.ToFloat64(validationMetrics.failed.WithLabelValues("Unauthenticated")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(validationMetrics.failed.WithLabelValues("Internal")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(validationMetrics.expiryWarnings))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == "test_payment_validation_amount_cogs" {
+			assert.Equal(t, uint64(3), family.GetMetric()[0].GetHistogram().GetSampleCount())
+			assert.Equal(t, float64(60), family.GetMetric()[0].GetHistogram().GetSampleSum())
+		}
+	}
+}
+
+func TestPaymentValidationMetricsRegisteredTwice(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	_, err := NewPaymentValidationMetrics(registry, "test")
+	assert.Nil(t, err)
+
+	_, err = NewPaymentValidationMetrics(registry, "test")
+
+	assert.NotNil(t, err)
+}
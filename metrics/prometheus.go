@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const prometheusNamespace = "snetd"
+
+var (
+	// FreeCallsGranted counts free calls which passed validation and quota
+	// check
+	FreeCallsGranted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "free_calls_granted_total",
+		Help:      "Number of free calls granted.",
+	}, []string{"method", "group"})
+
+	// FreeCallsRejected counts free calls rejected because user has exhausted
+	// free calls quota
+	FreeCallsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "free_calls_rejected_total",
+		Help:      "Number of free calls rejected because free calls quota is exhausted.",
+	}, []string{"method", "group"})
+
+	// CacheHits counts lookups of in-memory caches which found the entry
+	CacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "cache_hits_total",
+		Help:      "Number of cache lookups which found the entry.",
+	}, []string{"cache"})
+
+	// CacheMisses counts lookups of in-memory caches which didn't find the
+	// entry
+	CacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "cache_misses_total",
+		Help:      "Number of cache lookups which didn't find the entry.",
+	}, []string{"cache"})
+
+	// CacheEvictions counts entries evicted from in-memory caches because
+	// cache size limit is reached
+	CacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "cache_evictions_total",
+		Help:      "Number of entries evicted because cache is full.",
+	}, []string{"cache"})
+)
+
+func init() {
+	prometheus.MustRegister(FreeCallsGranted, FreeCallsRejected, CacheHits, CacheMisses, CacheEvictions)
+}
+
+// PrometheusHandler returns HTTP handler which exposes registered metrics in
+// Prometheus text format
+func PrometheusHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -25,6 +25,7 @@ type RequestStats struct {
 	UserDetails                string `json:"user_details"`
 	UserAgent                  string `json:"user_agent"`
 	ChannelId                  string `json:"channel_id"`
+	PeerIdentity               string `json:"peer_identity,omitempty"`
 }
 
 //Create a request Object and Publish this to a service end point
@@ -58,6 +59,7 @@ func createRequestStat(commonStat *CommonStats) *RequestStats {
 		UserDetails:                commonStat.UserDetails,
 		UserAgent:                  commonStat.UserAgent,
 		ChannelId:                  commonStat.ChannelId,
+		PeerIdentity:               commonStat.PeerIdentity,
 	}
 	return request
 }
@@ -25,6 +25,7 @@ type CommonStats struct {
 	UserAgent           string
 	ChannelId           string
 	UserName            string
+	PeerIdentity        string
 }
 
 func BuildCommonStats(receivedTime time.Time, methodName string) *CommonStats {
@@ -62,6 +63,7 @@ type ResponseStats struct {
 	UserAgent                  string `json:"user_agent"`
 	ChannelId                  string `json:"channel_id"`
 	UserName                   string `json:"username"`
+	PeerIdentity               string `json:"peer_identity,omitempty"`
 	Operation                  string `json:"operation"`
 	UsageType                  string `json:"usage_type"`
 	Status                     string `json:"status"`
@@ -102,6 +104,7 @@ func createResponseStats(commonStat *CommonStats, duration time.Duration, err er
 		UserAgent:                  commonStat.UserAgent,
 		ChannelId:                  commonStat.ChannelId,
 		UserName:commonStat.UserName,
+		PeerIdentity:               commonStat.PeerIdentity,
 		StartTime:commonStat.RequestReceivedTime,
 		EndTime:currentTime,
 		Status:getStatus(err),
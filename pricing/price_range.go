@@ -0,0 +1,124 @@
+package pricing
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/singnet/snet-daemon/handler"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PRICE_RANGE_CLAMP replaces out of range price by the nearest bound
+	PRICE_RANGE_CLAMP = "clamp"
+	// PRICE_RANGE_REJECT replaces out of range price by the last good price
+	// of the method, call is rejected if there is no such price
+	PRICE_RANGE_REJECT = "reject"
+)
+
+// PriceRange is a sanity range of the registry prices of the payment group.
+// It protects against registry data errors like zero or astronomically high
+// price.
+type PriceRange struct {
+	// GroupName is a name of the payment group the range is applied to
+	GroupName string `mapstructure:"group_name"`
+	// MinPriceInCogs is a minimal expected price
+	MinPriceInCogs int64 `mapstructure:"min_price_in_cogs"`
+	// MaxPriceInCogs is a maximal expected price, zero means no upper bound
+	MaxPriceInCogs int64 `mapstructure:"max_price_in_cogs"`
+	// Action is what to do with out of range price: "clamp" or "reject"
+	Action string `mapstructure:"action"`
+}
+
+// FindPriceRange returns the range of the group or nil if the group has no
+// range configured
+func FindPriceRange(ranges []PriceRange, groupName string) *PriceRange {
+	for i := range ranges {
+		if ranges[i].GroupName == groupName {
+			return &ranges[i]
+		}
+	}
+	return nil
+}
+
+// rangeCheckedPrice checks prices returned by delegate against the sanity
+// range
+type rangeCheckedPrice struct {
+	delegate PriceType
+	min      *big.Int
+	max      *big.Int
+	clamp    bool
+
+	mutex sync.Mutex
+	// lastGoodPrices keeps last in range price of each method
+	lastGoodPrices map[string]*big.Int
+}
+
+func newRangeCheckedPrice(delegate PriceType, priceRange *PriceRange) (price *rangeCheckedPrice, err error) {
+	if priceRange.Action != PRICE_RANGE_CLAMP && priceRange.Action != PRICE_RANGE_REJECT {
+		return nil, fmt.Errorf("unexpected price range action \"%v\" of group %v", priceRange.Action, priceRange.GroupName)
+	}
+	if priceRange.MaxPriceInCogs > 0 && priceRange.MaxPriceInCogs < priceRange.MinPriceInCogs {
+		return nil, fmt.Errorf("maximal price %v is less than minimal price %v in range of group %v",
+			priceRange.MaxPriceInCogs, priceRange.MinPriceInCogs, priceRange.GroupName)
+	}
+
+	price = &rangeCheckedPrice{
+		delegate:       delegate,
+		min:            big.NewInt(priceRange.MinPriceInCogs),
+		clamp:          priceRange.Action == PRICE_RANGE_CLAMP,
+		lastGoodPrices: make(map[string]*big.Int),
+	}
+	if priceRange.MaxPriceInCogs > 0 {
+		price.max = big.NewInt(priceRange.MaxPriceInCogs)
+	}
+	return price, nil
+}
+
+func (priceType *rangeCheckedPrice) GetPrice(GrpcContext *handler.GrpcStreamContext) (price *big.Int, err error) {
+	price, err = priceType.delegate.GetPrice(GrpcContext)
+	if err != nil {
+		return
+	}
+
+	method := ""
+	if GrpcContext != nil && GrpcContext.Info != nil {
+		method = GrpcContext.Info.FullMethod
+	}
+
+	priceType.mutex.Lock()
+	defer priceType.mutex.Unlock()
+
+	bound := priceType.min
+	if price.Cmp(priceType.min) >= 0 {
+		if priceType.max == nil || price.Cmp(priceType.max) <= 0 {
+			priceType.lastGoodPrices[method] = price
+			return price, nil
+		}
+		bound = priceType.max
+	}
+
+	log := log.WithField("method", method).WithField("price", price).
+		WithField("minPrice", priceType.min).WithField("maxPrice", priceType.max)
+	if priceType.clamp {
+		log.WithField("clampedPrice", bound).Error("Registry price is out of sanity range, price is clamped")
+		return bound, nil
+	}
+	if lastGood, ok := priceType.lastGoodPrices[method]; ok {
+		log.WithField("lastGoodPrice", lastGood).Error("Registry price is out of sanity range, last good price is used")
+		return lastGood, nil
+	}
+	log.Error("Registry price is out of sanity range and there is no last good price")
+	return nil, fmt.Errorf("price %v of method %v is out of sanity range", price, method)
+}
+
+// ApplyPriceRange makes all pricing types check prices against the range
+func (pricing *PricingStrategy) ApplyPriceRange(priceRange *PriceRange) (err error) {
+	for i, priceType := range pricing.pricingTypes {
+		if pricing.pricingTypes[i], err = newRangeCheckedPrice(priceType, priceRange); err != nil {
+			return
+		}
+	}
+	return nil
+}
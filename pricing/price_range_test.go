@@ -0,0 +1,98 @@
+package pricing
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/singnet/snet-daemon/handler"
+)
+
+type priceTypeMock struct {
+	price *big.Int
+}
+
+func (priceType *priceTypeMock) GetPrice(GrpcContext *handler.GrpcStreamContext) (price *big.Int, err error) {
+	return priceType.price, nil
+}
+
+var priceRangeTestContext = &handler.GrpcStreamContext{Info: &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/add"}}
+
+func newTestRangeCheckedPrice(t *testing.T, delegate PriceType, action string) *rangeCheckedPrice {
+	price, err := newRangeCheckedPrice(delegate, &PriceRange{GroupName: "default_group", MinPriceInCogs: 10, MaxPriceInCogs: 100, Action: action})
+	assert.Nil(t, err)
+	return price
+}
+
+func TestPriceRangeInRange(t *testing.T) {
+	priceType := newTestRangeCheckedPrice(t, &priceTypeMock{price: big.NewInt(50)}, PRICE_RANGE_REJECT)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(50), price)
+}
+
+func TestPriceRangeBelowMinClamped(t *testing.T) {
+	priceType := newTestRangeCheckedPrice(t, &priceTypeMock{price: big.NewInt(0)}, PRICE_RANGE_CLAMP)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+}
+
+func TestPriceRangeAboveMaxClamped(t *testing.T) {
+	priceType := newTestRangeCheckedPrice(t, &priceTypeMock{price: big.NewInt(1000000)}, PRICE_RANGE_CLAMP)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), price)
+}
+
+func TestPriceRangeBelowMinRejected(t *testing.T) {
+	priceType := newTestRangeCheckedPrice(t, &priceTypeMock{price: big.NewInt(0)}, PRICE_RANGE_REJECT)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, price)
+	assert.Equal(t, "price 0 of method /example_service.Calculator/add is out of sanity range", err.Error())
+}
+
+func TestPriceRangeAboveMaxFallsBackToLastGoodPrice(t *testing.T) {
+	delegate := &priceTypeMock{price: big.NewInt(50)}
+	priceType := newTestRangeCheckedPrice(t, delegate, PRICE_RANGE_REJECT)
+	priceType.GetPrice(priceRangeTestContext)
+	delegate.price = big.NewInt(1000000)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(50), price)
+}
+
+func TestPriceRangeNoUpperBound(t *testing.T) {
+	priceType, err := newRangeCheckedPrice(&priceTypeMock{price: big.NewInt(1000000)}, &PriceRange{MinPriceInCogs: 1, Action: PRICE_RANGE_REJECT})
+	assert.Nil(t, err)
+
+	price, err := priceType.GetPrice(priceRangeTestContext)
+
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(1000000), price)
+}
+
+func TestPriceRangeIncorrectAction(t *testing.T) {
+	_, err := newRangeCheckedPrice(&priceTypeMock{}, &PriceRange{GroupName: "default_group", Action: "ignore"})
+
+	assert.Equal(t, "unexpected price range action \"ignore\" of group default_group", err.Error())
+}
+
+func TestFindPriceRange(t *testing.T) {
+	ranges := []PriceRange{{GroupName: "group_a"}, {GroupName: "group_b"}}
+
+	assert.Equal(t, "group_b", FindPriceRange(ranges, "group_b").GroupName)
+	assert.Nil(t, FindPriceRange(ranges, "group_c"))
+}
@@ -0,0 +1,138 @@
+// Package retry contains helpers to retry operations which may fail
+// temporarily. Retries of all stages of the single request are limited by
+// the shared retry budget which is passed via request context.
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBudgetExhausted is returned when operation cannot be retried because
+// retry budget of the request is exhausted
+var ErrBudgetExhausted = errors.New("retry budget is exhausted")
+
+// Budget limits total number of retries and total time spent on retries
+// across all stages of the single request. Budget is safe for concurrent
+// use.
+type Budget struct {
+	mutex    sync.Mutex
+	attempts int
+	deadline time.Time
+}
+
+// NewBudget returns new retry budget which allows maxAttempts retries in
+// total during maxDuration since now. Zero or negative value means no limit.
+func NewBudget(maxAttempts int, maxDuration time.Duration) *Budget {
+	budget := &Budget{attempts: maxAttempts}
+	if maxAttempts <= 0 {
+		budget.attempts = -1
+	}
+	if maxDuration > 0 {
+		budget.deadline = time.Now().Add(maxDuration)
+	}
+	return budget
+}
+
+// Take consumes one retry attempt, it returns false if budget is exhausted
+func (budget *Budget) Take() bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	if !budget.deadline.IsZero() && !time.Now().Before(budget.deadline) {
+		return false
+	}
+	if budget.attempts == 0 {
+		return false
+	}
+	if budget.attempts > 0 {
+		budget.attempts--
+	}
+	return true
+}
+
+// Remaining returns number of retry attempts left, -1 means no limit
+func (budget *Budget) Remaining() int {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	return budget.attempts
+}
+
+type budgetKey struct{}
+
+// NewContext returns new context which carries retry budget
+func NewContext(ctx context.Context, budget *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// FromContext returns retry budget from context, ok is false if context has
+// no budget
+func FromContext(ctx context.Context) (budget *Budget, ok bool) {
+	budget, ok = ctx.Value(budgetKey{}).(*Budget)
+	return
+}
+
+// Policy describes which errors are retried and how long to wait before
+// each retry
+type Policy struct {
+	// MaxAttempts is a maximal number of calls including the first one
+	MaxAttempts int
+	// Delay is a pause before the first retry
+	Delay time.Duration
+	// Multiplier increases the pause after each retry, values less than 2
+	// keep the pause constant
+	Multiplier int
+	// Retryable returns true if operation which failed with err can be
+	// retried, nil means that all errors are retried
+	Retryable func(err error) bool
+	// Sleep waits before the next call, nil means waiting for the pause or
+	// until context is done
+	Sleep func(delay time.Duration)
+}
+
+// Do calls operation until it succeeds or maxAttempts calls are made. Each
+// retry after the first call takes one attempt from the retry budget of the
+// context if it is set, so retries stop as soon as budget is exhausted. Delay
+// is a pause between calls.
+func Do(ctx context.Context, maxAttempts int, delay time.Duration, operation func() error) (err error) {
+	return DoWithPolicy(ctx, Policy{MaxAttempts: maxAttempts, Delay: delay}, operation)
+}
+
+// DoWithPolicy calls operation until it succeeds, fails with the error which
+// is not retryable or policy.MaxAttempts calls are made. Retries take
+// attempts from the retry budget of the context in the same way as in Do.
+func DoWithPolicy(ctx context.Context, policy Policy, operation func() error) (err error) {
+	budget, hasBudget := FromContext(ctx)
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		if err = operation(); err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || (policy.Retryable != nil && !policy.Retryable(err)) {
+			return err
+		}
+		if hasBudget && !budget.Take() {
+			log.WithError(err).WithField("attempt", attempt).Debug("Retry budget is exhausted")
+			return ErrBudgetExhausted
+		}
+		log.WithError(err).WithField("attempt", attempt).WithField("delay", delay).Debug("Operation failed, retrying")
+
+		if policy.Sleep != nil {
+			policy.Sleep(delay)
+		} else {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if policy.Multiplier > 1 {
+			delay *= time.Duration(policy.Multiplier)
+		}
+	}
+}
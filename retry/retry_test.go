@@ -0,0 +1,109 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTemporary = errors.New("temporary error")
+
+func failingOperation(calls *int) func() error {
+	return func() error {
+		*calls++
+		return errTemporary
+	}
+}
+
+func TestDoWithoutBudget(t *testing.T) {
+	calls := 0
+
+	err := Do(context.Background(), 3, time.Millisecond, failingOperation(&calls))
+
+	assert.Equal(t, errTemporary, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDoSucceedsAfterRetry(t *testing.T) {
+	calls := 0
+
+	err := Do(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		if calls < 2 {
+			return errTemporary
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestDoSharesBudgetAcrossStages(t *testing.T) {
+	ctx := NewContext(context.Background(), NewBudget(3, 0))
+	firstStageCalls, secondStageCalls := 0, 0
+
+	errFirst := Do(ctx, 3, time.Millisecond, failingOperation(&firstStageCalls))
+	errSecond := Do(ctx, 3, time.Millisecond, failingOperation(&secondStageCalls))
+
+	assert.Equal(t, errTemporary, errFirst)
+	assert.Equal(t, 3, firstStageCalls)
+	assert.Equal(t, ErrBudgetExhausted, errSecond)
+	assert.Equal(t, 2, secondStageCalls)
+}
+
+func TestDoStopsWhenBudgetTimeIsOver(t *testing.T) {
+	ctx := NewContext(context.Background(), NewBudget(0, 30*time.Millisecond))
+	firstStageCalls, secondStageCalls := 0, 0
+
+	errFirst := Do(ctx, 1000, 10*time.Millisecond, failingOperation(&firstStageCalls))
+	errSecond := Do(ctx, 1000, 10*time.Millisecond, failingOperation(&secondStageCalls))
+
+	assert.Equal(t, ErrBudgetExhausted, errFirst)
+	assert.True(t, firstStageCalls < 1000, "too many calls: %v", firstStageCalls)
+	assert.Equal(t, ErrBudgetExhausted, errSecond)
+	assert.Equal(t, 1, secondStageCalls)
+}
+
+func TestDoWithPolicyStopsOnNotRetryableError(t *testing.T) {
+	calls := 0
+	errPermanent := errors.New("permanent error")
+
+	err := DoWithPolicy(context.Background(), Policy{
+		MaxAttempts: 3,
+		Delay:       time.Millisecond,
+		Retryable:   func(err error) bool { return err == errTemporary },
+	}, func() error {
+		calls++
+		return errPermanent
+	})
+
+	assert.Equal(t, errPermanent, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoWithPolicyMultipliesDelay(t *testing.T) {
+	calls := 0
+	var delays []time.Duration
+
+	err := DoWithPolicy(context.Background(), Policy{
+		MaxAttempts: 4,
+		Delay:       time.Second,
+		Multiplier:  2,
+		Sleep:       func(delay time.Duration) { delays = append(delays, delay) },
+	}, failingOperation(&calls))
+
+	assert.Equal(t, errTemporary, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, delays)
+}
+
+func TestFromContextNoBudget(t *testing.T) {
+	budget, ok := FromContext(context.Background())
+
+	assert.False(t, ok)
+	assert.Nil(t, budget)
+}
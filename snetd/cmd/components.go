@@ -5,8 +5,11 @@ import (
 	"github.com/singnet/snet-daemon/configuration_service"
 	"github.com/singnet/snet-daemon/pricing"
 	"github.com/singnet/snet-daemon/metrics"
+	"math/big"
+	"net/http"
 	"os"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -16,6 +19,7 @@ import (
 	"github.com/singnet/snet-daemon/config"
 	"github.com/singnet/snet-daemon/escrow"
 	"github.com/singnet/snet-daemon/etcddb"
+	"github.com/singnet/snet-daemon/exporter"
 	"github.com/singnet/snet-daemon/handler"
 )
 
@@ -25,7 +29,24 @@ type Components struct {
 	etcdClient                 *etcddb.EtcdClient
 	etcdServer                 *etcddb.EtcdServer
 	atomicStorage              escrow.AtomicStorage
+	paymentChannelCache        *escrow.CachingAtomicStorage
+	claimIdempotency           *escrow.ClaimIdempotency
+	paymentHolds               *escrow.PaymentHolds
+	paymentHoldService         *escrow.PaymentHoldService
+	channelClosures            *escrow.ChannelClosures
+	channelCloseService        *escrow.ChannelCloseService
+	channelSpending            *escrow.ChannelSpending
+	persistHooks               *escrow.PersistHooks
+	validationDecisions        *exporter.BatchingExporter
+	tenantQuota                *escrow.TenantQuota
+	channelOperationLog        *escrow.ChannelOperationLog
+	paymentValidationMetrics   *metrics.PaymentValidationMetrics
+	blacklist                  *escrow.Blacklist
+	blacklistCache             *escrow.CachingAtomicStorage
+	blacklistWebhook           http.Handler
 	paymentChannelService      escrow.PaymentChannelService
+	shutdownCoordinator        *escrow.ShutdownCoordinator
+	readOnlyMode               *escrow.ReadOnlyMode
 	escrowPaymentHandler       handler.PaymentHandler
 	grpcInterceptor            grpc.StreamServerInterceptor
 	paymentChannelStateService *escrow.PaymentChannelStateService
@@ -78,6 +99,21 @@ func isFileExist(fileName string) bool {
 }
 
 func (components *Components) Close() {
+	if components.validationDecisions != nil {
+		components.validationDecisions.Close()
+	}
+	if components.tenantQuota != nil {
+		components.tenantQuota.Close()
+	}
+	if components.channelOperationLog != nil {
+		components.channelOperationLog.Close()
+	}
+	if components.paymentChannelCache != nil {
+		components.paymentChannelCache.Close()
+	}
+	if components.blacklistCache != nil {
+		components.blacklistCache.Close()
+	}
 	if components.etcdClient != nil {
 		components.etcdClient.Close()
 	}
@@ -152,7 +188,10 @@ func (components *Components) EtcdClient() *etcddb.EtcdClient {
 		return components.etcdClient
 	}
 
-	client, err := etcddb.NewEtcdClient(components.OrganizationMetaData())
+	checkEtcdServerVersion(components.OrganizationMetaData())
+
+	client, err := etcddb.NewEtcdClientWithRetry(components.OrganizationMetaData(),
+		config.GetInt(config.EtcdConnectionAttempts), config.GetDuration(config.EtcdConnectionRetryDelay))
 	if err != nil {
 		log.WithError(err).Panic("unable to create etcd client")
 	}
@@ -161,6 +200,28 @@ func (components *Components) EtcdClient() *etcddb.EtcdClient {
 	return components.etcdClient
 }
 
+// checkEtcdServerVersion refuses to start or warns when etcd server version
+// is not compatible with the etcd client
+func checkEtcdServerVersion(metaData *blockchain.OrganizationMetaData) {
+	mode := config.GetString(config.EtcdVersionCheckMode)
+	if mode == "disabled" {
+		return
+	}
+
+	err := etcddb.CheckServerVersion(metaData, config.GetString(config.EtcdMinVersion), config.GetString(config.EtcdMaxVersion))
+	if err == nil {
+		return
+	}
+	switch mode {
+	case "enforce":
+		log.WithError(err).Panic("incompatible etcd server version")
+	case "warn":
+		log.WithError(err).Warn("etcd server version may be incompatible")
+	default:
+		log.WithField("mode", mode).Panic("unexpected etcd version check mode")
+	}
+}
+
 func (components *Components) LockerStorage() *escrow.PrefixedAtomicStorage {
 	if components.etcdLockerStorage != nil {
 		return components.etcdLockerStorage
@@ -183,6 +244,30 @@ func (components *Components) AtomicStorage() escrow.AtomicStorage {
 	return components.atomicStorage
 }
 
+// PaymentChannelAtomicStorage returns storage for payment channel states,
+// it caches states when cache is enabled and etcd storage is used.
+func (components *Components) PaymentChannelAtomicStorage() escrow.AtomicStorage {
+	if components.paymentChannelCache != nil {
+		return components.paymentChannelCache
+	}
+
+	if !config.GetBool(config.PaymentChannelCacheEnabled) ||
+		config.GetString(config.PaymentChannelStorageTypeKey) != "etcd" {
+		return components.AtomicStorage()
+	}
+
+	components.paymentChannelCache = escrow.NewCachingAtomicStorage(components.AtomicStorage(), components.EtcdClient(),
+		escrow.PaymentChannelStorageKeyPrefix(components.ServiceMetaData())+"/", "payment_channel", config.GetInt(config.PaymentChannelCacheMaxEntries), false)
+	return components.paymentChannelCache
+}
+
+// PaymentChannelStorage returns storage of payment channel states which
+// compresses large values when compression threshold is set
+func (components *Components) PaymentChannelStorage() *escrow.PaymentChannelStorage {
+	return escrow.NewPaymentChannelStorageWithSerializer(components.PaymentChannelAtomicStorage(), components.ServiceMetaData(),
+		escrow.GobChannelSerializer{}, config.GetInt(config.PaymentChannelCompressionThreshold))
+}
+
 func (components *Components) PaymentStorage() *escrow.PaymentStorage {
 	if components.paymentStorage != nil {
 		return components.paymentStorage
@@ -193,50 +278,331 @@ func (components *Components) PaymentStorage() *escrow.PaymentStorage {
 	return components.paymentStorage
 }
 
+// refreshPaymentChannel is called by the payment validator under the
+// channel lock, service is already created at this point
+func (components *Components) refreshPaymentChannel(channelID *big.Int) (*escrow.PaymentChannelData, error) {
+	return components.PaymentChannelService().RefreshChannelState(channelID)
+}
+
 func (components *Components) PaymentChannelService() escrow.PaymentChannelService {
 	if components.paymentChannelService != nil {
 		return components.paymentChannelService
 	}
 
 	components.paymentChannelService = escrow.NewPaymentChannelService(
-		escrow.NewPaymentChannelStorage(components.AtomicStorage(),components.ServiceMetaData()),
+		components.PaymentChannelStorage(),
 		components.PaymentStorage(),
 		escrow.NewBlockchainChannelReader(components.Blockchain(), config.Vip(),components.OrganizationMetaData()),
 		escrow.NewEtcdLocker(components.AtomicStorage(),components.ServiceMetaData()),
-		escrow.NewChannelPaymentValidator(components.Blockchain(), config.Vip(), components.OrganizationMetaData()), func() ([32]byte, error) {
+		escrow.NewChannelPaymentValidator(components.Blockchain(), config.Vip(), components.OrganizationMetaData(), components.PaymentStorage(), components.SignatureCooldown(), components.Blacklist(), components.PaymentValidationMetrics(), components.ExpiryWarningHook(), components.refreshPaymentChannel), func() ([32]byte, error) {
 			s := components.OrganizationMetaData().GetGroupId()
 			return s, nil
 		},
+		components.ChannelOperationLog(),
+		components.ShutdownCoordinator(),
+		components.ReadOnlyMode(),
+		components.PersistHooks(),
 	)
 
 	return components.paymentChannelService
 }
 
+// Blacklist returns nil if blacklist webhook is disabled. When etcd storage
+// is used blacklist entries and their absence are cached and invalidated by
+// watching changes made via other replicas.
+func (components *Components) Blacklist() *escrow.Blacklist {
+	if components.blacklist != nil || config.GetString(config.PaymentBlacklistWebhookSecret) == "" {
+		return components.blacklist
+	}
+
+	storage := components.AtomicStorage()
+	if config.GetString(config.PaymentChannelStorageTypeKey) == "etcd" {
+		components.blacklistCache = escrow.NewCachingAtomicStorage(storage, components.EtcdClient(),
+			escrow.BlacklistKeyPrefix(components.ServiceMetaData())+"/", "blacklist", config.GetInt(config.PaymentChannelCacheMaxEntries), true)
+		storage = components.blacklistCache
+	}
+	components.blacklist = escrow.NewBlacklist(storage, components.ServiceMetaData())
+	return components.blacklist
+}
+
+// BlacklistWebhook returns nil if blacklist webhook is disabled
+func (components *Components) BlacklistWebhook() http.Handler {
+	if components.blacklistWebhook != nil || components.Blacklist() == nil {
+		return components.blacklistWebhook
+	}
+
+	components.blacklistWebhook = escrow.NewBlacklistWebhook(components.Blacklist(), config.GetString(config.PaymentBlacklistWebhookSecret))
+	return components.blacklistWebhook
+}
+
+// PaymentValidationMetrics returns nil if Prometheus metrics are disabled
+func (components *Components) PaymentValidationMetrics() *metrics.PaymentValidationMetrics {
+	if components.paymentValidationMetrics != nil || !config.GetBool(config.PrometheusMetricsEnabled) {
+		return components.paymentValidationMetrics
+	}
+
+	validationMetrics, err := metrics.NewPaymentValidationMetrics(prometheus.DefaultRegisterer, config.GetString(config.PaymentValidationMetricsNamespace))
+	if err != nil {
+		log.WithError(err).Panic("unable to register payment validation metrics")
+	}
+	components.paymentValidationMetrics = validationMetrics
+	return components.paymentValidationMetrics
+}
+
+// ExpiryWarningHook counts payments via channels which are near to
+// expiration, returns nil if payment validation metrics are disabled
+func (components *Components) ExpiryWarningHook() escrow.ExpiryWarningHook {
+	validationMetrics := components.PaymentValidationMetrics()
+	if validationMetrics == nil {
+		return nil
+	}
+	return func(channel *escrow.PaymentChannelData, remainingBlocks *big.Int) {
+		validationMetrics.ExpiryWarning()
+	}
+}
+
+// SignatureCooldown returns nil if cooldown of the clients sending invalid
+// signatures is disabled
+func (components *Components) SignatureCooldown() *escrow.SignatureCooldown {
+	threshold := int64(config.GetInt(config.PaymentInvalidSignatureThreshold))
+	if threshold <= 0 {
+		return nil
+	}
+
+	return escrow.NewSignatureCooldown(components.AtomicStorage(), components.ServiceMetaData(), threshold,
+		config.GetDuration(config.PaymentInvalidSignatureWindow), config.GetDuration(config.PaymentInvalidSignatureCooldown))
+}
+
+// ChannelOperationLog returns log of accepted payments or nil if it is
+// disabled
+func (components *Components) ChannelOperationLog() *escrow.ChannelOperationLog {
+	if components.channelOperationLog != nil || !config.GetBool(config.PaymentChannelOperationLogEnabled) {
+		return components.channelOperationLog
+	}
+
+	components.channelOperationLog = escrow.NewChannelOperationLog(components.AtomicStorage(), components.ServiceMetaData(),
+		config.GetInt(config.PaymentChannelOperationLogMaxLength), config.GetDuration(config.PaymentChannelOperationLogTTL),
+		config.GetInt(config.PaymentChannelOperationLogQueueSize))
+	return components.channelOperationLog
+}
+
+func (components *Components) ClaimIdempotency() *escrow.ClaimIdempotency {
+	if components.claimIdempotency != nil || !config.GetBool(config.PaymentClaimIdempotencyEnabled) {
+		return components.claimIdempotency
+	}
+
+	components.claimIdempotency = escrow.NewClaimIdempotency(components.AtomicStorage(), components.ServiceMetaData(),
+		config.GetDuration(config.PaymentClaimIdempotencyPendingTimeout), config.GetDuration(config.PaymentClaimIdempotencyRetention))
+	return components.claimIdempotency
+}
+
+func (components *Components) PaymentHolds() *escrow.PaymentHolds {
+	if components.paymentHolds != nil || !config.GetBool(config.PaymentHoldEnabled) {
+		return components.paymentHolds
+	}
+
+	components.paymentHolds = escrow.NewPaymentHolds(components.PaymentChannelService(), components.AtomicStorage(),
+		components.ServiceMetaData(), config.GetDuration(config.PaymentHoldMaxTTL))
+	return components.paymentHolds
+}
+
+// PaymentHoldService returns nil if payment holds are disabled
+func (components *Components) PaymentHoldService() *escrow.PaymentHoldService {
+	if components.paymentHoldService != nil || components.PaymentHolds() == nil {
+		return components.paymentHoldService
+	}
+
+	components.paymentHoldService = escrow.NewPaymentHoldService(components.PaymentHolds(), components.ServiceMetaData())
+	return components.paymentHoldService
+}
+
 func (components *Components) EscrowPaymentHandler() handler.PaymentHandler {
 	if components.escrowPaymentHandler != nil {
 		return components.escrowPaymentHandler
 	}
 
+	incomeValidator := escrow.NewIncomeValidator(components.PricingStrategy())
+	if components.ChannelSpending() != nil {
+		incomeValidator = escrow.NewDiscountIncomeValidator(incomeValidator, discountTiers(), components.ChannelSpending())
+	}
+
 	components.escrowPaymentHandler = escrow.NewPaymentHandler(
 		components.PaymentChannelService(),
 		components.Blockchain(),
-		escrow.NewIncomeValidator(components.PricingStrategy()),
+		incomeValidator,
+		components.ChannelRateLimiter(),
+		components.SenderRateLimiter(),
+		components.SenderSpendingLimiter(),
+		components.PaymentHolds(),
+		components.MeteringHook(),
+		components.ChannelClosures(),
+		components.ChannelSpending(),
 	)
 
 	return components.escrowPaymentHandler
 }
 
+// PersistHooks returns hooks which are called around writing of the payment
+// channel state, hooks can be registered before the daemon is started
+func (components *Components) PersistHooks() *escrow.PersistHooks {
+	if components.persistHooks != nil {
+		return components.persistHooks
+	}
+
+	components.persistHooks = escrow.NewPersistHooks()
+	return components.persistHooks
+}
+
+// ChannelSpending returns nil if discounts are not configured, so channel
+// spending is not tracked
+func (components *Components) ChannelSpending() *escrow.ChannelSpending {
+	if components.channelSpending != nil || len(discountTiers()) == 0 {
+		return components.channelSpending
+	}
+
+	components.channelSpending = escrow.NewChannelSpending(components.AtomicStorage(), components.ServiceMetaData())
+	return components.channelSpending
+}
+
+// discountTiers expects that discount tiers are validated on startup
+func discountTiers() (tiers []escrow.DiscountTier) {
+	if err := config.Vip().UnmarshalKey(config.PaymentDiscountTiers, &tiers); err != nil {
+		log.WithError(err).Panic("payment discount tiers are not validated")
+	}
+	return
+}
+
+// ChannelClosures returns nil if channel close acknowledgements are disabled
+func (components *Components) ChannelClosures() *escrow.ChannelClosures {
+	if components.channelClosures != nil || !config.GetBool(config.PaymentChannelCloseEnabled) {
+		return components.channelClosures
+	}
+
+	components.channelClosures = escrow.NewChannelClosures(components.PaymentChannelService(), components.AtomicStorage(),
+		escrow.NewEtcdLocker(components.AtomicStorage(), components.ServiceMetaData()), components.ServiceMetaData())
+	return components.channelClosures
+}
+
+// ChannelCloseService returns nil if channel close acknowledgements are
+// disabled
+func (components *Components) ChannelCloseService() *escrow.ChannelCloseService {
+	if components.channelCloseService != nil || components.ChannelClosures() == nil {
+		return components.channelCloseService
+	}
+
+	components.channelCloseService = escrow.NewChannelCloseService(components.ChannelClosures())
+	return components.channelCloseService
+}
+
+// MeteringHook returns nil if the whole authorized amount is charged for
+// the call
+func (components *Components) MeteringHook() escrow.MeteringHook {
+	switch hookType := config.GetString(config.PaymentMeteringHook); hookType {
+	case escrow.FlatPriceMeteringHook:
+		return nil
+	case escrow.PerUnitMeteringHook:
+		return escrow.NewPerUnitMeteringHook(config.GetBigInt(config.PaymentMeteringPricePerUnit))
+	default:
+		log.WithField("hookType", hookType).Panic("unexpected payment metering hook type")
+		return nil
+	}
+}
+
+func (components *Components) ChannelRateLimiter() escrow.ChannelRateLimiter {
+	callsPerMinute := config.GetInt(config.PaymentChannelRateLimitPerMinute)
+	if callsPerMinute <= 0 {
+		return nil
+	}
+
+	switch storageType := config.GetString(config.PaymentChannelRateLimitStorage); storageType {
+	case escrow.LocalChannelRateLimiter:
+		return escrow.NewLocalChannelRateLimiter(callsPerMinute)
+	case escrow.EtcdChannelRateLimiter:
+		return escrow.NewEtcdChannelRateLimiter(components.AtomicStorage(), components.ServiceMetaData(), callsPerMinute)
+	default:
+		log.WithField("storageType", storageType).Panic("unexpected payment channel rate limiter storage type")
+		return nil
+	}
+}
+
+// SenderRateLimiter returns nil if calls per sender are not limited
+func (components *Components) SenderRateLimiter() *escrow.SenderRateLimiter {
+	callsPerMinute := config.GetInt(config.PaymentSenderRateLimitPerMinute)
+	if callsPerMinute <= 0 {
+		return nil
+	}
+
+	return escrow.NewSenderRateLimiter(callsPerMinute, config.GetInt(config.PaymentSenderRateLimitBurst),
+		config.GetDuration(config.PaymentSenderRateLimitIdleTimeout))
+}
+
+func (components *Components) SenderSpendingLimiter() escrow.SenderSpendingLimiter {
+	amountPerMinute := config.GetBigInt(config.PaymentSenderSpendingLimitPerMinute)
+	if amountPerMinute.Sign() <= 0 {
+		return nil
+	}
+
+	return escrow.NewSenderSpendingLimiter(components.AtomicStorage(), components.ServiceMetaData(), amountPerMinute)
+}
+
+// DrainingSlot returns nil if coordination of replicas draining is disabled
+func (components *Components) DrainingSlot() *escrow.DrainingSlot {
+	if !config.GetBool(config.DrainingSlotEnabled) {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.WithError(err).Panic("unable to get hostname")
+	}
+	holder := hostname + "/" + config.GetString(config.DaemonEndPoint)
+	return escrow.NewDrainingSlot(components.AtomicStorage(), components.ServiceMetaData(), holder,
+		config.GetDuration(config.DrainingSlotLeaseTTL))
+}
+
+// ShutdownCoordinator tracks in-flight payments to drain them on shutdown
+func (components *Components) ShutdownCoordinator() *escrow.ShutdownCoordinator {
+	if components.shutdownCoordinator != nil {
+		return components.shutdownCoordinator
+	}
+
+	components.shutdownCoordinator = escrow.NewShutdownCoordinator()
+	return components.shutdownCoordinator
+}
+
+// ReadOnlyMode is initialized from config and can be switched at runtime
+func (components *Components) ReadOnlyMode() *escrow.ReadOnlyMode {
+	if components.readOnlyMode != nil {
+		return components.readOnlyMode
+	}
+
+	components.readOnlyMode = escrow.NewReadOnlyMode(config.GetBool(config.ReadOnlyModeEnabled))
+	return components.readOnlyMode
+}
+
 func (components *Components) FreeCallPaymentHandler() handler.PaymentHandler {
 	if components.freeCallPaymentHandler != nil {
 		return components.freeCallPaymentHandler
 	}
 
 	components.freeCallPaymentHandler = escrow.FreeCallPaymentHandler(
-		components.Blockchain(),components.OrganizationMetaData())
+		components.Blockchain(),components.OrganizationMetaData(), components.FreeCallQuota())
 
 	return components.freeCallPaymentHandler
 }
 
+// FreeCallQuota returns daemon side quota of free calls or nil if free calls
+// are counted by metering service
+func (components *Components) FreeCallQuota() *escrow.FreeCallQuota {
+	limit := int64(config.GetInt(config.FreeCallQuota))
+	if limit <= 0 {
+		return nil
+	}
+
+	return escrow.NewFreeCallQuota(components.AtomicStorage(), config.GetString(config.OrganizationId),
+		config.GetString(config.ServiceId), limit, config.GetDuration(config.FreeCallQuotaWindow))
+}
+
 //Add a chain of interceptors
 func (components *Components) GrpcInterceptor() grpc.StreamServerInterceptor {
 	if components.grpcInterceptor != nil {
@@ -247,22 +613,107 @@ func (components *Components) GrpcInterceptor() grpc.StreamServerInterceptor {
 	if components.Blockchain().Enabled() {
 
 		components.grpcInterceptor = grpc_middleware.ChainStreamServer(
-			handler.GrpcMonitoringInterceptor(), handler.GrpcRateLimitInterceptor(components.ChannelBroadcast()),
-			components.GrpcPaymentValidationInterceptor())
+			handler.GrpcMonitoringInterceptor(), components.GrpcClientVersionInterceptor(),
+			handler.GrpcRateLimitInterceptor(components.ChannelBroadcast()),
+			components.GrpcRetryBudgetInterceptor(), components.GrpcChannelStreamLimitInterceptor(),
+			components.GrpcPaymentValidationInterceptor(),
+			components.GrpcMethodRateLimitInterceptor(), components.GrpcTenantQuotaInterceptor())
 	} else {
-		components.grpcInterceptor = grpc_middleware.ChainStreamServer(handler.GrpcRateLimitInterceptor(components.ChannelBroadcast()),
-			components.GrpcPaymentValidationInterceptor())
+		components.grpcInterceptor = grpc_middleware.ChainStreamServer(components.GrpcClientVersionInterceptor(),
+			handler.GrpcRateLimitInterceptor(components.ChannelBroadcast()),
+			components.GrpcRetryBudgetInterceptor(), components.GrpcChannelStreamLimitInterceptor(),
+			components.GrpcPaymentValidationInterceptor(),
+			components.GrpcMethodRateLimitInterceptor(), components.GrpcTenantQuotaInterceptor())
 	}
 	return components.grpcInterceptor
 }
 
+func (components *Components) GrpcUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return handler.GrpcAdminClientCertInterceptor(config.Vip().GetStringSlice(config.AdminClientCertSubjects))
+}
+
+func (components *Components) GrpcRetryBudgetInterceptor() grpc.StreamServerInterceptor {
+	return handler.GrpcRetryBudgetInterceptor(config.GetInt(config.RetryBudgetMaxAttempts),
+		config.GetDuration(config.RetryBudgetMaxDuration))
+}
+
+func (components *Components) GrpcClientVersionInterceptor() grpc.StreamServerInterceptor {
+	var rules []handler.ClientVersionRule
+	if err := config.Vip().UnmarshalKey(config.ClientVersionRules, &rules); err != nil {
+		log.WithError(err).Panic("error during client version rules parsing")
+	}
+	interceptor, err := handler.GrpcClientVersionInterceptor(rules, config.GetString(config.ClientVersionCheckMode))
+	if err != nil {
+		log.WithError(err).Panic("incorrect client version check configuration")
+	}
+	return interceptor
+}
+
+func (components *Components) GrpcChannelStreamLimitInterceptor() grpc.StreamServerInterceptor {
+	return handler.GrpcChannelStreamLimitInterceptor(config.GetInt(config.PaymentChannelMaxConcurrentStreams))
+}
+
+func (components *Components) GrpcMethodRateLimitInterceptor() grpc.StreamServerInterceptor {
+	var limits []handler.MethodRateLimit
+	if err := config.Vip().UnmarshalKey(config.MethodRateLimits, &limits); err != nil {
+		log.WithError(err).Panic("error during method rate limits parsing")
+	}
+	return handler.GrpcMethodRateLimitInterceptor(limits)
+}
+
+// TenantQuota returns nil if the service doesn't belong to any tenant
+func (components *Components) TenantQuota() *escrow.TenantQuota {
+	if components.tenantQuota != nil {
+		return components.tenantQuota
+	}
+
+	var tenants []escrow.Tenant
+	if err := config.Vip().UnmarshalKey(config.Tenants, &tenants); err != nil {
+		log.WithError(err).Panic("error during tenants parsing")
+	}
+	tenant := escrow.FindTenant(tenants, config.GetString(config.OrganizationId), config.GetString(config.ServiceId),
+		config.GetString(config.DaemonGroupName))
+	if tenant == nil {
+		return nil
+	}
+
+	log.WithField("tenant", tenant).Info("Tenant quota is set")
+	components.tenantQuota = escrow.NewTenantQuota(*tenant, components.AtomicStorage(),
+		config.GetDuration(config.TenantQuotaStaleTimeout))
+	return components.tenantQuota
+}
+
+// ValidationDecisionExporter returns nil if export of validation decisions
+// is disabled
+func (components *Components) ValidationDecisionExporter() handler.ValidationDecisionExporter {
+	if components.validationDecisions == nil {
+		decisions, err := exporter.NewBatchingExporterFromConfig(config.SubWithDefault(config.Vip(), config.ValidationDecisionsExportKey))
+		if err != nil {
+			log.WithError(err).Panic("unable to initialize validation decisions export")
+		}
+		components.validationDecisions = decisions
+	}
+	return handler.NewValidationDecisionExporter(components.validationDecisions)
+}
+
+func (components *Components) GrpcTenantQuotaInterceptor() grpc.StreamServerInterceptor {
+	quota := components.TenantQuota()
+	if quota == nil {
+		return handler.NoOpInterceptor
+	}
+	return handler.GrpcTenantQuotaInterceptor(quota)
+}
+
 func (components *Components) GrpcPaymentValidationInterceptor() grpc.StreamServerInterceptor {
 	if !components.Blockchain().Enabled() {
 		log.Info("Blockchain is disabled: no payment validation")
 		return handler.NoOpInterceptor
 	} else {
 		log.Info("Blockchain is enabled: instantiate payment validation interceptor")
-		return handler.GrpcPaymentValidationInterceptor(components.EscrowPaymentHandler(),components.FreeCallPaymentHandler())
+		decisions := components.ValidationDecisionExporter()
+		return handler.GrpcPaymentValidationInterceptor(
+			handler.NewDecisionExportingPaymentHandler(components.EscrowPaymentHandler(), decisions),
+			handler.NewDecisionExportingPaymentHandler(components.FreeCallPaymentHandler(), decisions))
 	}
 }
 
@@ -278,7 +729,9 @@ func (components *Components) PaymentChannelStateService() (service escrow.Payme
 	components.paymentChannelStateService = escrow.NewPaymentChannelStateService(
 		components.PaymentChannelService(),
 		components.PaymentStorage(),
-		components.ServiceMetaData())
+		components.ServiceMetaData(),
+		components.Blockchain().CurrentBlock,
+		config.GetDuration(config.AverageBlockTime))
 
 	return components.paymentChannelStateService
 }
@@ -295,7 +748,8 @@ func (components *Components) ProviderControlService() (service escrow.ProviderC
 	}
 
 	components.providerControlService = escrow.NewProviderControlService(components.PaymentChannelService(),
-		components.ServiceMetaData(),components.OrganizationMetaData())
+		components.ServiceMetaData(),components.OrganizationMetaData(), components.ChannelOperationLog(),
+		components.ClaimIdempotency())
 	return components.providerControlService
 }
 
@@ -317,6 +771,18 @@ func (components *Components) PricingStrategy() *pricing.PricingStrategy {
 
 	components.priceStrategy,_ = pricing.InitPricingStrategy(components.ServiceMetaData())
 
+	var ranges []pricing.PriceRange
+	if err := config.Vip().UnmarshalKey(config.PriceSanityRanges, &ranges); err != nil {
+		log.WithError(err).Panic("error during price sanity ranges parsing")
+	}
+	groupName := config.GetString(config.DaemonGroupName)
+	if priceRange := pricing.FindPriceRange(ranges, groupName); priceRange != nil && components.priceStrategy != nil {
+		if err := components.priceStrategy.ApplyPriceRange(priceRange); err != nil {
+			log.WithError(err).Panic("incorrect price sanity range")
+		}
+		log.WithField("priceRange", priceRange).Info("Price sanity range is set")
+	}
+
 	return components.priceStrategy
 }
 
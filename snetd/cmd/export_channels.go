@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/escrow"
+)
+
+// ExportChannelsCmd prints payment channels from shared storage as JSON Lines
+var ExportChannelsCmd = &cobra.Command{
+	Use:   "export-channels",
+	Short: "Export payment channels as JSON Lines",
+	Long: "Prints payment channels from shared storage to the standard output," +
+		" one JSON object per line. Amounts are printed as decimal strings." +
+		" Output can be used to back up or inspect channels state.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newExportChannelsCommand)
+	},
+}
+
+type exportChannelsCommand struct {
+	storage *escrow.PaymentChannelStorage
+}
+
+func newExportChannelsCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	command = &exportChannelsCommand{
+		storage: components.PaymentChannelStorage(),
+	}
+
+	return
+}
+
+func (command *exportChannelsCommand) Run() (err error) {
+	return escrow.ExportChannels(os.Stdout, command.storage)
+}
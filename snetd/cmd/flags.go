@@ -91,6 +91,8 @@ func init() {
 
 	RootCmd.AddCommand(ListCmd)
 	RootCmd.AddCommand(ChannelCmd)
+	RootCmd.AddCommand(MigrateStorageCmd)
+	RootCmd.AddCommand(ExportChannelsCmd)
 	RootCmd.AddCommand(VersionCmd)
 
 	ListCmd.AddCommand(ListChannelsCmd)
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/escrow"
+)
+
+// MigrateStorageCmd rewrites payment channels in shared storage using the
+// latest schema version
+var MigrateStorageCmd = &cobra.Command{
+	Use:   "migrate-storage",
+	Short: "Upgrade payment channels in storage to the latest schema",
+	Long: "Rewrites payment channels which were stored by previous daemon versions" +
+		" using the latest schema. Old values are upgraded on read anyway, so the" +
+		" command is needed only to make the storage content uniform.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newMigrateStorageCommand)
+	},
+}
+
+type migrateStorageCommand struct {
+	storage *escrow.PaymentChannelStorage
+}
+
+func newMigrateStorageCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	command = &migrateStorageCommand{
+		storage: escrow.NewPaymentChannelStorage(components.AtomicStorage(), components.ServiceMetaData()),
+	}
+
+	return
+}
+
+func (command *migrateStorageCommand) Run() (err error) {
+	migrated, err := command.storage.Migrate()
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("%v payment channels are migrated\n", migrated)
+	return nil
+}
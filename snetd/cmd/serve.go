@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"github.com/singnet/snet-daemon/configuration_service"
 	"github.com/singnet/snet-daemon/metrics"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -14,6 +17,7 @@ import (
 	"syscall"
 
 	"github.com/gorilla/handlers"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/pkg/errors"
 	"github.com/singnet/snet-daemon/blockchain"
@@ -61,12 +65,47 @@ var ServeCmd = &cobra.Command{
 		}
 
 		d.start()
-		defer d.stop()
+
+		readOnlyMode := components.ReadOnlyMode()
+		readOnlyChan := make(chan os.Signal, 1)
+		signal.Notify(readOnlyChan, syscall.SIGUSR1, syscall.SIGUSR2)
+		go func() {
+			for sig := range readOnlyChan {
+				readOnlyMode.Set(sig == syscall.SIGUSR1)
+			}
+		}()
 
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 		<-sigChan
 
+		drainingSlot := components.DrainingSlot()
+		if drainingSlot != nil {
+			acquired, err := drainingSlot.Acquire(config.GetDuration(config.DrainingSlotTimeout))
+			if err != nil {
+				log.WithError(err).Warn("Unable to acquire draining slot, start draining")
+			} else if !acquired {
+				log.Warn("Draining slot is not acquired before timeout, start draining")
+			}
+			if !acquired {
+				drainingSlot = nil
+			}
+		}
+
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.GetDuration(config.PaymentDrainTimeout))
+		if err := components.ShutdownCoordinator().Drain(drainCtx); err != nil {
+			log.WithError(err).Warn("Unable to drain in-flight payments")
+		}
+		cancelDrain()
+
+		d.stop()
+
+		if drainingSlot != nil {
+			if err := drainingSlot.Release(); err != nil {
+				log.WithError(err).Warn("Unable to release draining slot")
+			}
+		}
+
 		log.Debug("exiting")
 	},
 }
@@ -75,12 +114,33 @@ type daemon struct {
 	autoSSLDomain string
 	acmeListener  net.Listener
 	grpcServer    *grpc.Server
+	// adminLis and adminGrpcServer are set when admin services are served
+	// on the separate admin endpoint
+	adminLis        net.Listener
+	adminGrpcServer *grpc.Server
 	blockProc     blockchain.Processor
 	lis           net.Listener
 	sslCert       *tls.Certificate
 	components    *Components
 }
 
+// normalizeConfigAddresses validates Ethereum addresses set by config keys
+// and replaces them by EIP-55 checksum addresses
+func normalizeConfigAddresses(keys ...string) error {
+	for _, key := range keys {
+		address := config.GetString(key)
+		if address == "" {
+			continue
+		}
+		normalized, err := blockchain.NormalizeAddress(address)
+		if err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+		config.Vip().Set(key, normalized)
+	}
+	return nil
+}
+
 func newDaemon(components *Components) (daemon, error) {
 	d := daemon{}
 
@@ -98,6 +158,47 @@ func newDaemon(components *Components) (daemon, error) {
 		return d, err
 	}
 
+	if err := metrics.InitAmountMetrics(config.GetString(config.MetricsAmountUnit), config.GetInt(config.MetricsTokenDecimals)); err != nil {
+		return d, err
+	}
+	if err := metrics.InitAmountFormat(config.GetInt(config.MetricsTokenDecimals), config.GetString(config.TokenSymbol)); err != nil {
+		return d, err
+	}
+
+	var signatureEncodings []escrow.SignatureEncoding
+	if err := config.Vip().UnmarshalKey(config.PaymentSignatureEncodings, &signatureEncodings); err != nil {
+		return d, err
+	}
+	if _, err := escrow.GetSignatureEncoding(config.GetString(config.PaymentSignatureProtocolVersion), signatureEncodings); err != nil {
+		return d, err
+	}
+	if _, err := escrow.GetSignatureScheme(config.GetString(config.PaymentSignatureScheme)); err != nil {
+		return d, err
+	}
+	var discountTiers []escrow.DiscountTier
+	if err := config.Vip().UnmarshalKey(config.PaymentDiscountTiers, &discountTiers); err != nil {
+		return d, err
+	}
+	if err := escrow.ValidateDiscountTiers(discountTiers); err != nil {
+		return d, err
+	}
+	var expirationThresholds []escrow.GroupExpirationThreshold
+	if err := config.Vip().UnmarshalKey(config.PaymentExpirationThresholds, &expirationThresholds); err != nil {
+		return d, err
+	}
+	if _, err := escrow.ParseGroupExpirationThresholds(expirationThresholds); err != nil {
+		return d, err
+	}
+	if err := normalizeConfigAddresses(config.AuthenticationAddress, config.FreeCallSignerAddress); err != nil {
+		return d, err
+	}
+	if config.GetInt(config.PaymentSenderRateLimitPerMinute) > 0 && config.GetInt(config.PaymentSenderRateLimitBurst) <= 0 {
+		return d, fmt.Errorf("%v should be positive when sender rate limit is enabled", config.PaymentSenderRateLimitBurst)
+	}
+	if config.GetString(config.PaymentAuditLogFile) != "" && config.GetString(config.PaymentAuditLogKey) == "" {
+		return d, fmt.Errorf("%v should be set when %v is set", config.PaymentAuditLogKey, config.PaymentAuditLogFile)
+	}
+
 	d.components = components
 
 	var err error
@@ -106,6 +207,13 @@ func newDaemon(components *Components) (daemon, error) {
 		return d, errors.Wrap(err, "Expected format of daemon_end_point is <host>:<port>.Error binding to the endpoint:"+config.GetString(config.DaemonEndPoint))
 	}
 
+	if adminEndpoint := config.GetString(config.AdminEndPoint); adminEndpoint != "" {
+		d.adminLis, err = net.Listen("tcp", adminEndpoint)
+		if err != nil {
+			return d, errors.Wrap(err, "Expected format of admin_end_point is <host>:<port>.Error binding to the endpoint:"+adminEndpoint)
+		}
+	}
+
 	d.autoSSLDomain = config.GetString(config.AutoSSLDomainKey)
 	// In order to perform the LetsEncrypt (ACME) http-01 challenge-response, we need to bind
 	// port 80 (privileged) to listen for the challenge.
@@ -118,6 +226,13 @@ func newDaemon(components *Components) (daemon, error) {
 
 	d.blockProc = *components.Blockchain()
 
+	if components.Blockchain().Enabled() {
+		metrics.RegisterHealthCheck("blockchain", components.Blockchain().HealthCheck)
+	}
+	if config.GetString(config.PaymentChannelStorageTypeKey) == "etcd" {
+		metrics.RegisterHealthCheck("etcd", components.EtcdClient().HealthCheck)
+	}
+
 	if sslKey := config.GetString(config.SSLKeyPathKey); sslKey != "" {
 		cert, err := tls.LoadX509KeyPair(config.GetString(config.SSLCertPathKey), sslKey)
 		if err != nil {
@@ -165,25 +280,49 @@ func (d *daemon) start() {
 	}
 
 	if tlsConfig != nil {
+		if err := configureClientCertificates(tlsConfig); err != nil {
+			log.WithError(err).Fatal("Unable to configure client certificates verification")
+		}
+
 		// See: https://gist.github.com/soheilhy/bb272c000f1987f17063
 		tlsConfig.NextProtos = []string{"http/1.1", http2.NextProtoTLS, "h2-14"}
 
 		// Wrap underlying listener with a TLS listener
 		d.lis = tls.NewListener(d.lis, tlsConfig)
+		if d.adminLis != nil {
+			d.adminLis = tls.NewListener(d.adminLis, tlsConfig)
+		}
 	}
 
 	if config.GetString(config.DaemonTypeKey) == "grpc" {
 
 		maxsizeOpt := grpc.MaxRecvMsgSize(config.GetInt(config.MaxMessageSizeInMB) * 1024 * 1024)
-		d.grpcServer = grpc.NewServer(
-			grpc.UnknownServiceHandler(handler.NewGrpcHandler(d.components.ServiceMetaData())),
-			grpc.StreamInterceptor(d.components.GrpcInterceptor()),
+		options := []grpc.ServerOption{
+			grpc.UnaryInterceptor(d.components.GrpcUnaryInterceptor()),
 			maxsizeOpt,
-		)
+		}
+		if tlsConfig != nil {
+			options = append(options, grpc.Creds(&listenerTLSCredentials{}))
+		}
+		publicOptions := append([]grpc.ServerOption{
+			grpc.UnknownServiceHandler(handler.NewGrpcHandler(d.components.ServiceMetaData())),
+			grpc.StreamInterceptor(publicStreamInterceptor(d.components.GrpcInterceptor(), d.adminLis != nil)),
+		}, options...)
+		d.grpcServer = grpc.NewServer(publicOptions...)
 		escrow.RegisterPaymentChannelStateServiceServer(d.grpcServer, d.components.PaymentChannelStateService())
-		escrow.RegisterProviderControlServiceServer(d.grpcServer,d.components.ProviderControlService())
 		grpc_health_v1.RegisterHealthServer(d.grpcServer,d.components.DaemonHeartBeat())
-		configuration_service.RegisterConfigurationServiceServer(d.grpcServer,d.components.ConfigurationService())
+		if config.GetBool(config.BlockchainEnabledKey) && d.components.PaymentHoldService() != nil {
+			escrow.RegisterPaymentHoldServiceServer(d.grpcServer, d.components.PaymentHoldService())
+		}
+		if config.GetBool(config.BlockchainEnabledKey) && d.components.ChannelCloseService() != nil {
+			escrow.RegisterChannelCloseServiceServer(d.grpcServer, d.components.ChannelCloseService())
+		}
+		if d.adminLis != nil {
+			d.adminGrpcServer = grpc.NewServer(options...)
+			registerAdminServices(d.adminGrpcServer, d.components.ProviderControlService(), d.components.ConfigurationService())
+		} else {
+			registerAdminServices(d.grpcServer, d.components.ProviderControlService(), d.components.ConfigurationService())
+		}
 		mux := cmux.New(d.lis)
 		// Use "prefix" matching to support "application/grpc*" e.g. application/grpc+proto or +json
 		// Use SendSettings for compatibility with Java gRPC clients:
@@ -203,6 +342,10 @@ func (d *daemon) start() {
 				} else if strings.Split(req.URL.Path, "/")[1] == "heartbeat" {
 					resp.Header().Set("Access-Control-Allow-Origin", "*")
 					metrics.HeartbeatHandler(resp, req)
+				} else if strings.Split(req.URL.Path, "/")[1] == "metrics" && config.GetBool(config.PrometheusMetricsEnabled) {
+					metrics.PrometheusHandler().ServeHTTP(resp, req)
+				} else if strings.Split(req.URL.Path, "/")[1] == "blacklist" && d.adminLis == nil && d.components.BlacklistWebhook() != nil {
+					d.components.BlacklistWebhook().ServeHTTP(resp, req)
 				} else {
 					http.NotFound(resp, req)
 				}
@@ -212,6 +355,15 @@ func (d *daemon) start() {
 		log.Debug("starting daemon")
 
 		go d.grpcServer.Serve(grpcL)
+		if d.adminGrpcServer != nil {
+			log.WithField("endpoint", d.adminLis.Addr()).Debug("starting admin endpoint")
+			adminMux := cmux.New(d.adminLis)
+			adminGrpcL := adminMux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+			adminHttpL := adminMux.Match(cmux.HTTP1Fast())
+			go d.adminGrpcServer.Serve(adminGrpcL)
+			go http.Serve(adminHttpL, d.adminHttpHandler())
+			go adminMux.Serve()
+		}
 		go http.Serve(httpL, httpHandler)
 		go mux.Serve()
 	} else {
@@ -222,6 +374,57 @@ func (d *daemon) start() {
 
 }
 
+// adminHttpHandler serves HTTP requests of the separate admin endpoint
+func (d *daemon) adminHttpHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if strings.Split(req.URL.Path, "/")[1] == "blacklist" && d.components.BlacklistWebhook() != nil {
+			d.components.BlacklistWebhook().ServeHTTP(resp, req)
+		} else {
+			http.NotFound(resp, req)
+		}
+	})
+}
+
+// registerAdminServices registers services which are used by service
+// provider to manage the daemon, see handler.AdminServices
+func registerAdminServices(server *grpc.Server, controlService escrow.ProviderControlServiceServer,
+	configurationService configuration_service.ConfigurationServiceServer) {
+	escrow.RegisterProviderControlServiceServer(server, controlService)
+	configuration_service.RegisterConfigurationServiceServer(server, configurationService)
+}
+
+// publicStreamInterceptor returns interceptor of the public endpoint. When
+// admin services are served on the separate endpoint the calls to them are
+// rejected before they reach the unknown service handler.
+func publicStreamInterceptor(interceptor grpc.StreamServerInterceptor, separateAdminEndpoint bool) grpc.StreamServerInterceptor {
+	if !separateAdminEndpoint {
+		return interceptor
+	}
+	return grpc_middleware.ChainStreamServer(handler.GrpcPublicEndpointInterceptor(), interceptor)
+}
+
+// configureClientCertificates makes TLS listener request and verify client
+// certificates when CA to verify them is configured. Certificate is optional
+// on TLS level, admin methods check it on gRPC level.
+func configureClientCertificates(tlsConfig *tls.Config) error {
+	caPath := config.GetString(config.AdminClientCaPath)
+	if caPath == "" {
+		return nil
+	}
+
+	caCert, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("no certificates found in %v", caPath)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
 func (d *daemon) stop() {
 
 	if d.grpcServer != nil {
@@ -230,6 +433,13 @@ func (d *daemon) stop() {
 
 	d.lis.Close()
 
+	if d.adminGrpcServer != nil {
+		d.adminGrpcServer.GracefulStop()
+	}
+	if d.adminLis != nil {
+		d.adminLis.Close()
+	}
+
 	if d.acmeListener != nil {
 		d.acmeListener.Close()
 	}
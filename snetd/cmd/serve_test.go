@@ -1,11 +1,76 @@
 package cmd
 
 import (
+	"context"
+	"net"
+	"testing"
+
 	"github.com/singnet/snet-daemon/config"
+	"github.com/singnet/snet-daemon/configuration_service"
+	"github.com/singnet/snet-daemon/escrow"
+	"github.com/singnet/snet-daemon/handler"
 	"github.com/stretchr/testify/assert"
-	"testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 //todo
 func TestDaemonPort(t *testing.T) {
 assert.Equal(t,config.GetString(config.DaemonEndPoint),"127.0.0.1:8080")
 }
+
+type providerControlServiceMock struct {
+	escrow.ProviderControlServiceServer
+}
+
+func (service *providerControlServiceMock) GetListUnclaimed(ctx context.Context, request *escrow.GetPaymentsListRequest) (*escrow.PaymentsListReply, error) {
+	return &escrow.PaymentsListReply{}, nil
+}
+
+type configurationServiceMock struct {
+	configuration_service.ConfigurationServiceServer
+}
+
+func serveOnLocalPort(t *testing.T, server *grpc.Server) *grpc.ClientConn {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	return conn
+}
+
+func TestAdminServicesAreServedOnAdminEndpointOnly(t *testing.T) {
+	publicServer := grpc.NewServer(
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			return status.New(codes.Unknown, "passed to service").Err()
+		}),
+		grpc.StreamInterceptor(publicStreamInterceptor(handler.NoOpInterceptor, true)),
+	)
+	defer publicServer.Stop()
+	adminServer := grpc.NewServer()
+	registerAdminServices(adminServer, &providerControlServiceMock{}, &configurationServiceMock{})
+	defer adminServer.Stop()
+
+	publicConn := serveOnLocalPort(t, publicServer)
+	defer publicConn.Close()
+	adminConn := serveOnLocalPort(t, adminServer)
+	defer adminConn.Close()
+
+	_, publicErr := escrow.NewProviderControlServiceClient(publicConn).GetListUnclaimed(context.Background(), &escrow.GetPaymentsListRequest{})
+	reply, adminErr := escrow.NewProviderControlServiceClient(adminConn).GetListUnclaimed(context.Background(), &escrow.GetPaymentsListRequest{})
+
+	assert.Equal(t, codes.Unimplemented, status.Code(publicErr))
+	assert.Nil(t, adminErr)
+	assert.NotNil(t, reply)
+}
+
+func TestPublicStreamInterceptorWithoutAdminEndpoint(t *testing.T) {
+	interceptor := publicStreamInterceptor(handler.NoOpInterceptor, false)
+
+	err := interceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: "/escrow.ProviderControlService/GetListUnclaimed"},
+		func(srv interface{}, stream grpc.ServerStream) error { return nil })
+
+	assert.Nil(t, err)
+}
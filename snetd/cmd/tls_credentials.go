@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
+)
+
+// listenerTLSCredentials passes TLS state of the connections accepted by
+// TLS listener to gRPC, so handlers can get client certificate from the
+// peer. TLS handshake itself is done by the listener, because the same
+// listener serves both gRPC and HTTP.
+type listenerTLSCredentials struct {
+}
+
+func (creds *listenerTLSCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tlsConn := unwrapTLSConn(conn)
+	if tlsConn == nil {
+		return conn, nil, nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: tlsConn.ConnectionState()}, nil
+}
+
+func (creds *listenerTLSCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("listener TLS credentials cannot be used by client")
+}
+
+func (creds *listenerTLSCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (creds *listenerTLSCredentials) Clone() credentials.TransportCredentials {
+	return &listenerTLSCredentials{}
+}
+
+func (creds *listenerTLSCredentials) OverrideServerName(serverName string) error {
+	return nil
+}
+
+func unwrapTLSConn(conn net.Conn) *tls.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return c
+		case *cmux.MuxConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}